@@ -0,0 +1,23 @@
+//go:build fake
+// +build fake
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// The fake platform keeps its instances in memory, it is only registered in test and demo builds
+import _ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/fake"
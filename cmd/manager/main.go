@@ -20,10 +20,26 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/apis"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/actuators/cluster"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/actuators/machine"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/aws"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/azure"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/digitalocean"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/docker"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/gce"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/hcloud"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/libvirt"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/openstack"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/packet"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/redfish"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/vsphere"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/webhook"
 	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
 	"sigs.k8s.io/cluster-api/pkg/apis/cluster/common"
 	capicluster "sigs.k8s.io/cluster-api/pkg/controller/cluster"
@@ -34,12 +50,28 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
 )
 
+// pluginFlags collects repeated --provisioner-plugin flags
+type pluginFlags []string
+
+func (p *pluginFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pluginFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
 func main() {
 	cfg := config.GetConfigOrDie()
 	if cfg == nil {
 		panic(fmt.Errorf("GetConfigOrDie didn't die"))
 	}
 
+	var plugins pluginFlags
+	flag.Var(&plugins, "provisioner-plugin", "Serve a platform from an external provisioner plugin, as <platform>=<target>. "+
+		"The target is a gRPC address such as unix:///var/run/plugin.sock or localhost:9090, or exec://<binary> [args] to launch the plugin. May be repeated.")
+
 	flag.Parse()
 	log := logf.Log.WithName("talos-controller-manager")
 	logf.SetLogger(logf.ZapLogger(false))
@@ -52,6 +84,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	for _, spec := range plugins {
+		name, target, err := plugin.ParseSpec(spec)
+		if err == nil {
+			err = plugin.Register(name, target)
+		}
+		if err != nil {
+			entryLog.Error(err, "unable to register provisioner plugin", "plugin", spec)
+			os.Exit(1)
+		}
+	}
+
+	entryLog.Info("registered provisioners", "platforms", provisioners.Platforms())
+
 	clusterActuator, err := cluster.NewClusterActuator(mgr, cluster.ClusterActuatorParams{})
 	if err != nil {
 		panic(err)
@@ -75,6 +120,25 @@ func main() {
 	capimachine.AddWithActuator(mgr, machineActuator)
 	capicluster.AddWithActuator(mgr, clusterActuator)
 
+	if err := webhook.AddToManager(mgr); err != nil {
+		entryLog.Error(err, "unable to register webhooks")
+		os.Exit(1)
+	}
+
+	// The BMCs of Redfish hosts download the userdata of the hosts from the manager
+	if os.Getenv(redfish.MediaURLEnv) != "" {
+		clientset, err := utils.CreateK8sClientSet()
+		if err != nil {
+			panic(err)
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+			return redfish.ServeMedia(clientset, stop)
+		})); err != nil {
+			entryLog.Error(err, "unable to serve the redfish config images")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
 		entryLog.Error(err, "unable to run manager")
 		os.Exit(1)
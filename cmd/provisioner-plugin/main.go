@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command provisioner-plugin serves one of the in-tree provisioners over gRPC.
+// It is the reference for out-of-tree plugins, which replace the registry
+// lookup below with their own provisioners.Provisioner implementation.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/aws"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/azure"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/digitalocean"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/docker"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/gce"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/hcloud"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/libvirt"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/openstack"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/packet"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/redfish"
+	_ "github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/vsphere"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+)
+
+func main() {
+	platform := flag.String("platform", "", "The in-tree platform to serve.")
+	listen := flag.String("listen", os.Getenv(plugin.ListenAddressEnv), "The address to serve on, either host:port or unix:///path/to/socket.")
+	flag.Parse()
+
+	if *listen == "" {
+		log.Fatal("no listen address given")
+	}
+
+	p, err := provisioners.NewProvisioner(*platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Plugins run next to the manager, so the in-cluster config is usually available
+	clientset, err := utils.CreateK8sClientSet()
+	if err != nil {
+		log.Printf("Serving without a kubernetes client: %v", err)
+		clientset = nil
+	}
+
+	lis, err := plugin.Listen(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Serving provisioner %q on %s", *platform, lis.Addr())
+	log.Fatal(plugin.Serve(lis, p, clientset))
+}
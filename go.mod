@@ -9,12 +9,22 @@ require (
 	github.com/Azure/go-autorest/autorest/to v0.3.0
 	github.com/Azure/go-autorest/autorest/validation v0.2.0 // indirect
 	github.com/aws/aws-sdk-go v1.25.8
+	github.com/coreos/etcd v3.3.15+incompatible
+	github.com/digitalocean/go-libvirt v0.0.0-20190715144809-7b622097a793
+	github.com/digitalocean/godo v1.22.0
+	github.com/docker/docker v1.13.1
+	github.com/golang/protobuf v1.3.2
+	github.com/gophercloud/gophercloud v0.6.0
+	github.com/hetznercloud/hcloud-go v1.30.0
 	github.com/onsi/gomega v1.5.0
 	github.com/packethost/packngo v0.2.0
 	github.com/talos-systems/talos v0.3.0-alpha.0.0.20191009201711-edc21ea9109e
+	github.com/vmware/govmomi v0.21.0
 	golang.org/x/net v0.0.0-20190813141303-74dc4d7220e7
+	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a
 	google.golang.org/api v0.4.0
-	gopkg.in/yaml.v2 v2.2.2
+	google.golang.org/grpc v1.23.0
+	gopkg.in/yaml.v2 v2.2.4
 	k8s.io/api v0.0.0-20190918155943-95b840bb6a1f
 	k8s.io/apimachinery v0.0.0-20190913080033-27d36303b655
 	k8s.io/client-go v10.0.0+incompatible
@@ -23,6 +33,7 @@ require (
 	sigs.k8s.io/controller-runtime v0.1.12
 	sigs.k8s.io/controller-tools v0.1.11
 	sigs.k8s.io/testing_frameworks v0.1.1
+	sigs.k8s.io/yaml v1.1.0
 )
 
 replace (
@@ -0,0 +1,302 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DiskSpec defines the disks attached to an instance
+type DiskSpec struct {
+	// Size of the boot disk in GB
+	Size int `json:"size,omitempty"`
+}
+
+// AWSClusterConfig defines the AWS configuration of a cluster
+type AWSClusterConfig struct {
+	Region string `json:"region,omitempty"`
+}
+
+// AWSMachineConfig defines the AWS configuration of a machine
+type AWSMachineConfig struct {
+	Region    string          `json:"region,omitempty"`
+	Instances AWSInstanceSpec `json:"instances,omitempty"`
+}
+
+// AWSInstanceSpec defines the EC2 instances to create
+type AWSInstanceSpec struct {
+	Type           string   `json:"type,omitempty"`
+	AMI            string   `json:"ami,omitempty"`
+	Keypair        string   `json:"keypair,omitempty"`
+	SecurityGroups []string `json:"securityGroups,omitempty"`
+	Disks          DiskSpec `json:"disks,omitempty"`
+}
+
+// AzureClusterConfig defines the Azure configuration of a cluster
+type AzureClusterConfig struct {
+	Location      string `json:"location,omitempty"`
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+}
+
+// AzureMachineConfig defines the Azure configuration of a machine
+type AzureMachineConfig struct {
+	Location      string            `json:"location,omitempty"`
+	ResourceGroup string            `json:"resourceGroup,omitempty"`
+	Instances     AzureInstanceSpec `json:"instances,omitempty"`
+}
+
+// AzureInstanceSpec defines the Azure VMs to create
+type AzureInstanceSpec struct {
+	Type    string   `json:"type,omitempty"`
+	Image   string   `json:"image,omitempty"`
+	Network string   `json:"network,omitempty"`
+	Subnet  string   `json:"subnet,omitempty"`
+	Disks   DiskSpec `json:"disks,omitempty"`
+}
+
+// DigitalOceanClusterConfig defines the DigitalOcean configuration of a cluster
+type DigitalOceanClusterConfig struct {
+	// Region is the slug of the region the reserved IPs of the control plane are created in, e.g. nyc3
+	Region string `json:"region,omitempty"`
+}
+
+// DigitalOceanMachineConfig defines the DigitalOcean configuration of a machine
+type DigitalOceanMachineConfig struct {
+	Region    string                   `json:"region,omitempty"`
+	Instances DigitalOceanInstanceSpec `json:"instances,omitempty"`
+}
+
+// DigitalOceanInstanceSpec defines the droplets to create
+type DigitalOceanInstanceSpec struct {
+	// Size is the slug of the droplet size, e.g. s-2vcpu-4gb
+	Size string `json:"size,omitempty"`
+	// Image is the ID or name of the custom Talos image
+	Image string `json:"image,omitempty"`
+	// VPC is the UUID of the VPC of the droplets, the default VPC of the region is used when empty
+	VPC  string   `json:"vpc,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// DockerClusterConfig defines the Docker configuration of a cluster
+type DockerClusterConfig struct {
+	// Host is the address of the Docker daemon, e.g. unix:///var/run/docker.sock. DOCKER_HOST and the other Docker environment variables are used when empty
+	Host string `json:"host,omitempty"`
+	// Network is the user-defined Docker network the nodes are attached to. Its IPAM config needs an IPv4 subnet
+	Network string `json:"network,omitempty"`
+}
+
+// DockerMachineConfig defines the Docker configuration of a machine
+type DockerMachineConfig struct {
+	Host      string             `json:"host,omitempty"`
+	Network   string             `json:"network,omitempty"`
+	Instances DockerInstanceSpec `json:"instances,omitempty"`
+}
+
+// DockerInstanceSpec defines the Talos containers to create
+type DockerInstanceSpec struct {
+	// Image is the Talos container image, e.g. docker.io/autonomy/talos:v0.3.0-alpha.0
+	Image string `json:"image,omitempty"`
+	// CPUs is the share of CPUs of a container, e.g. 1.5. The containers are not limited when empty
+	CPUs string `json:"cpus,omitempty"`
+	// MemoryMiB is the memory limit of a container. The containers are not limited when zero
+	MemoryMiB int64 `json:"memoryMiB,omitempty"`
+}
+
+// GCEClusterConfig defines the GCE configuration of a cluster
+type GCEClusterConfig struct {
+	Region  string `json:"region,omitempty"`
+	Project string `json:"project,omitempty"`
+}
+
+// GCEMachineConfig defines the GCE configuration of a machine
+type GCEMachineConfig struct {
+	Zone      string          `json:"zone,omitempty"`
+	Project   string          `json:"project,omitempty"`
+	Instances GCEInstanceSpec `json:"instances,omitempty"`
+}
+
+// GCEInstanceSpec defines the GCE instances to create
+type GCEInstanceSpec struct {
+	Type  string   `json:"type,omitempty"`
+	Image string   `json:"image,omitempty"`
+	Disks DiskSpec `json:"disks,omitempty"`
+}
+
+// HcloudClusterConfig defines the Hetzner Cloud configuration of a cluster
+type HcloudClusterConfig struct {
+	// Location is the name of the location the floating IPs of the control plane are created in, e.g. nbg1
+	Location string `json:"location,omitempty"`
+}
+
+// HcloudMachineConfig defines the Hetzner Cloud configuration of a machine
+type HcloudMachineConfig struct {
+	Location  string             `json:"location,omitempty"`
+	Instances HcloudInstanceSpec `json:"instances,omitempty"`
+}
+
+// HcloudInstanceSpec defines the Hetzner Cloud servers to create.
+// Images, networks and placement groups are given by name or ID.
+type HcloudInstanceSpec struct {
+	// Type is the name of the server type, e.g. cx21
+	Type string `json:"type,omitempty"`
+	// Image is the Talos snapshot the servers are created from
+	Image string `json:"image,omitempty"`
+	// Networks are the private networks the servers are attached to
+	Networks []string `json:"networks,omitempty"`
+	// PlacementGroup is the placement group the servers are spread with
+	PlacementGroup string            `json:"placementGroup,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// PacketClusterConfig defines the Packet configuration of a cluster
+type PacketClusterConfig struct {
+	ProjectID string `json:"projectID,omitempty"`
+	// IPBlock is the CIDR of a pre-existing IP reservation used for the control plane
+	IPBlock string `json:"ipBlock,omitempty"`
+}
+
+// PacketMachineConfig defines the Packet configuration of a machine
+type PacketMachineConfig struct {
+	ProjectID string             `json:"projectID,omitempty"`
+	Instances PacketInstanceSpec `json:"instances,omitempty"`
+}
+
+// PacketInstanceSpec defines the Packet devices to create
+type PacketInstanceSpec struct {
+	Plan     string `json:"plan,omitempty"`
+	Facility string `json:"facility,omitempty"`
+	PXEURL   string `json:"pxeURL,omitempty"`
+	// Install replaces the install section of the generated userdata
+	Install *runtime.RawExtension `json:"install,omitempty"`
+}
+
+// VSphereClusterConfig defines the vSphere configuration of a cluster
+type VSphereClusterConfig struct {
+	// Server is the address of the vCenter or ESXi host
+	Server string `json:"server,omitempty"`
+	// Insecure skips the verification of the server certificate
+	Insecure   bool   `json:"insecure,omitempty"`
+	Datacenter string `json:"datacenter,omitempty"`
+	// ControlPlaneIPs are the static addresses of the control plane nodes in CIDR form, e.g. 192.168.1.10/24
+	ControlPlaneIPs []string `json:"controlPlaneIPs,omitempty"`
+	// Gateway is the default gateway of the control plane nodes
+	Gateway string `json:"gateway,omitempty"`
+	// VIP is a virtual IP in front of the control plane, used as the API endpoint instead of the first control plane IP
+	VIP string `json:"vip,omitempty"`
+}
+
+// VSphereMachineConfig defines the vSphere configuration of a machine
+type VSphereMachineConfig struct {
+	Server       string              `json:"server,omitempty"`
+	Insecure     bool                `json:"insecure,omitempty"`
+	Datacenter   string              `json:"datacenter,omitempty"`
+	Folder       string              `json:"folder,omitempty"`
+	Datastore    string              `json:"datastore,omitempty"`
+	ResourcePool string              `json:"resourcePool,omitempty"`
+	Network      string              `json:"network,omitempty"`
+	Instances    VSphereInstanceSpec `json:"instances,omitempty"`
+}
+
+// VSphereInstanceSpec defines the vSphere VMs to create
+type VSphereInstanceSpec struct {
+	// Template is the Talos VM template the instances are cloned from
+	Template  string `json:"template,omitempty"`
+	CPUs      int32  `json:"cpus,omitempty"`
+	MemoryMiB int64  `json:"memoryMiB,omitempty"`
+}
+
+// OpenStackClusterConfig defines the OpenStack configuration of a cluster
+type OpenStackClusterConfig struct {
+	Region string `json:"region,omitempty"`
+	// FloatingIPNetwork is the name or ID of the external network the floating IPs of the control plane are allocated from
+	FloatingIPNetwork string `json:"floatingIPNetwork,omitempty"`
+}
+
+// OpenStackMachineConfig defines the OpenStack configuration of a machine
+type OpenStackMachineConfig struct {
+	Region    string                `json:"region,omitempty"`
+	Instances OpenStackInstanceSpec `json:"instances,omitempty"`
+}
+
+// OpenStackInstanceSpec defines the OpenStack servers to create.
+// Flavors, images and networks are given by name or ID.
+type OpenStackInstanceSpec struct {
+	Flavor           string   `json:"flavor,omitempty"`
+	Image            string   `json:"image,omitempty"`
+	Networks         []string `json:"networks,omitempty"`
+	SecurityGroups   []string `json:"securityGroups,omitempty"`
+	AvailabilityZone string   `json:"availabilityZone,omitempty"`
+	Keypair          string   `json:"keypair,omitempty"`
+}
+
+// LibvirtClusterConfig defines the libvirt configuration of a cluster
+type LibvirtClusterConfig struct {
+	// URI is the address of the libvirt daemon, e.g. unix:///var/run/libvirt/libvirt-sock or tcp://host:16509
+	URI string `json:"uri,omitempty"`
+	// Network is the libvirt network the DHCP leases of the control plane are reserved on
+	Network string `json:"network,omitempty"`
+}
+
+// LibvirtMachineConfig defines the libvirt configuration of a machine
+type LibvirtMachineConfig struct {
+	URI     string `json:"uri,omitempty"`
+	Network string `json:"network,omitempty"`
+	// Pool is the storage pool holding the Talos disk image and the volumes of the instances
+	Pool      string              `json:"pool,omitempty"`
+	Instances LibvirtInstanceSpec `json:"instances,omitempty"`
+}
+
+// LibvirtInstanceSpec defines the libvirt domains to create
+type LibvirtInstanceSpec struct {
+	// Image is the volume of the Talos disk image the boot disks are backed by
+	Image     string   `json:"image,omitempty"`
+	CPUs      int32    `json:"cpus,omitempty"`
+	MemoryMiB int64    `json:"memoryMiB,omitempty"`
+	Disks     DiskSpec `json:"disks,omitempty"`
+}
+
+// RedfishClusterConfig defines the Redfish configuration of a cluster
+type RedfishClusterConfig struct {
+	// Hosts is the pool of bare-metal hosts the machines are claimed from.
+	// The first hosts, one per control plane node, are reserved for the control plane in order.
+	Hosts []RedfishHost `json:"hosts,omitempty"`
+}
+
+// RedfishHost defines a bare-metal host managed through its BMC
+type RedfishHost struct {
+	// Name identifies the host in the pool
+	Name string `json:"name,omitempty"`
+	// Endpoint is the address of the BMC, e.g. https://10.0.0.10
+	Endpoint string `json:"endpoint,omitempty"`
+	// SystemID is the ID of the computer system on the BMC, the first one is used when empty
+	SystemID string `json:"systemID,omitempty"`
+	// Insecure skips the verification of the BMC certificate
+	Insecure bool `json:"insecure,omitempty"`
+	// CredentialsSecretRef references the secret holding the username and password of the BMC, in the namespace of the cluster
+	CredentialsSecretRef *corev1.SecretReference `json:"credentialsSecretRef,omitempty"`
+	// Address is the IP the host gets on boot, required for the control plane hosts
+	Address string `json:"address,omitempty"`
+}
+
+// RedfishMachineConfig defines the Redfish configuration of a machine
+type RedfishMachineConfig struct {
+	// Boot is the one-time boot source of the host, pxe or virtualMedia
+	Boot string `json:"boot,omitempty"`
+	// ImageURL is the Talos ISO inserted as virtual media, required when booting from virtual media
+	ImageURL string `json:"imageURL,omitempty"`
+}
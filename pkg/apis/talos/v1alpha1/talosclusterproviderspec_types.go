@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -28,8 +29,26 @@ type TalosClusterControlPlaneSpec struct {
 
 //TalosClusterPlatformSpec defines info about platform configs
 type TalosClusterPlatformSpec struct {
-	Type   string `json:"type,omitempty"`
+	Type string `json:"type,omitempty"`
+	// Config is a free-form YAML configuration, used by plugin platforms.
+	// In-tree platforms still accept it in place of their typed field, but decode it strictly.
 	Config string `json:"config,omitempty"`
+
+	// CredentialsSecretRef references the secret holding the platform credentials used for this cluster and its machines.
+	// The secret has to be in the namespace of the cluster. Without it, the credentials of the manager are used.
+	CredentialsSecretRef *corev1.SecretReference `json:"credentialsSecretRef,omitempty"`
+
+	AWS          *AWSClusterConfig          `json:"aws,omitempty"`
+	Azure        *AzureClusterConfig        `json:"azure,omitempty"`
+	DigitalOcean *DigitalOceanClusterConfig `json:"digitalocean,omitempty"`
+	Docker       *DockerClusterConfig       `json:"docker,omitempty"`
+	GCE          *GCEClusterConfig          `json:"gce,omitempty"`
+	Hcloud       *HcloudClusterConfig       `json:"hcloud,omitempty"`
+	Libvirt      *LibvirtClusterConfig      `json:"libvirt,omitempty"`
+	OpenStack    *OpenStackClusterConfig    `json:"openstack,omitempty"`
+	Packet       *PacketClusterConfig       `json:"packet,omitempty"`
+	Redfish      *RedfishClusterConfig      `json:"redfish,omitempty"`
+	VSphere      *VSphereClusterConfig      `json:"vsphere,omitempty"`
 }
 
 // TalosClusterProviderSpecStatus defines the observed state of TalosClusterProviderSpec
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,8 +32,40 @@ type TalosClusterProviderStatusSpec struct {
 
 // TalosClusterProviderStatusStatus defines the observed state of TalosClusterProviderStatus
 type TalosClusterProviderStatusStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ControlPlaneIPs are the external IPs allocated for the control plane nodes, ordered by control plane index
+	ControlPlaneIPs []string `json:"controlPlaneIPs,omitempty"`
+	// APIEndpoint is the endpoint of the Kubernetes API server, in host:port form
+	APIEndpoint string `json:"apiEndpoint,omitempty"`
+	// TalosConfigTarget is the node targeted by the generated talosconfig
+	TalosConfigTarget string `json:"talosConfigTarget,omitempty"`
+	// Ready is true once the control plane IPs are allocated and the machine configs are generated
+	Ready bool `json:"ready,omitempty"`
+	// LegacyObjectsMigrated is true once the config objects earlier versions created for the cluster were moved next to it
+	LegacyObjectsMigrated bool `json:"legacyObjectsMigrated,omitempty"`
+	// LegacyResourceNames is true for clusters created by earlier versions, which named platform resources after the cluster and machine names only
+	LegacyResourceNames bool `json:"legacyResourceNames,omitempty"`
+	// Conditions are the latest observations of the state of the cluster, e.g. of a scale down waiting for etcd
+	Conditions []TalosClusterCondition `json:"conditions,omitempty"`
+}
+
+// TalosClusterConditionType is the type of a condition of a cluster
+type TalosClusterConditionType string
+
+// EtcdMemberRemoved is False while the etcd member of a control plane node being removed can't be removed
+const EtcdMemberRemoved TalosClusterConditionType = "EtcdMemberRemoved"
+
+// TalosClusterCondition is an observation of the state of a cluster
+type TalosClusterCondition struct {
+	// Type is the type of the condition
+	Type TalosClusterConditionType `json:"type"`
+	// Status is True, False or Unknown
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is when the status last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one-word CamelCase reason for the last transition
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the last transition
+	Message string `json:"message,omitempty"`
 }
 
 // +genclient
@@ -20,10 +20,36 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// MachineRole is the role of a machine in a Talos cluster
+type MachineRole string
+
+const (
+	// MachineRoleInit is the control plane machine that bootstraps the cluster
+	MachineRoleInit MachineRole = "init"
+	// MachineRoleControlPlane is a control plane machine joining the cluster
+	MachineRoleControlPlane MachineRole = "controlplane"
+	// MachineRoleWorker is a worker machine
+	MachineRoleWorker MachineRole = "worker"
+)
+
 //TalosMachinePlatformSpec defines info about platform configs
 type TalosMachinePlatformSpec struct {
-	Type   string `json:"type,omitempty"`
+	Type string `json:"type,omitempty"`
+	// Config is a free-form YAML configuration, used by plugin platforms.
+	// In-tree platforms still accept it in place of their typed field, but decode it strictly.
 	Config string `json:"config,omitempty"`
+
+	AWS          *AWSMachineConfig          `json:"aws,omitempty"`
+	Azure        *AzureMachineConfig        `json:"azure,omitempty"`
+	DigitalOcean *DigitalOceanMachineConfig `json:"digitalocean,omitempty"`
+	Docker       *DockerMachineConfig       `json:"docker,omitempty"`
+	GCE          *GCEMachineConfig          `json:"gce,omitempty"`
+	Hcloud       *HcloudMachineConfig       `json:"hcloud,omitempty"`
+	Libvirt      *LibvirtMachineConfig      `json:"libvirt,omitempty"`
+	OpenStack    *OpenStackMachineConfig    `json:"openstack,omitempty"`
+	Packet       *PacketMachineConfig       `json:"packet,omitempty"`
+	Redfish      *RedfishMachineConfig      `json:"redfish,omitempty"`
+	VSphere      *VSphereMachineConfig      `json:"vsphere,omitempty"`
 }
 
 // TalosMachineProviderSpecStatus defines the observed state of TalosMachineProviderSpec
@@ -41,6 +67,13 @@ type TalosMachineProviderSpec struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
+	// Role of the machine: init, controlplane or worker.
+	// Machines without a role fall back to the deprecated detection from their name.
+	Role MachineRole `json:"role,omitempty"`
+	// ControlPlaneIndex is the position of a control plane machine, which selects its config and external IP.
+	// The init machine is always 0, other control plane machines start at 1.
+	ControlPlaneIndex int `json:"controlPlaneIndex,omitempty"`
+
 	Platform TalosMachinePlatformSpec       `json:"platform,omitempty"`
 	Status   TalosMachineProviderSpecStatus `json:"status,omitempty"`
 }
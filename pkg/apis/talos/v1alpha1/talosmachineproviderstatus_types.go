@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,8 +32,16 @@ type TalosMachineProviderStatusSpec struct {
 
 // TalosMachineProviderStatusStatus defines the observed state of TalosMachineProviderStatus
 type TalosMachineProviderStatusStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// InstanceID is the ID of the instance in the platform
+	InstanceID string `json:"instanceID,omitempty"`
+	// InstanceState is the state of the instance as reported by the platform
+	InstanceState string `json:"instanceState,omitempty"`
+	// Addresses are the private (InternalIP) and public (ExternalIP) addresses of the instance
+	Addresses []corev1.NodeAddress `json:"addresses,omitempty"`
+	// Zone is the zone, location or facility the instance runs in
+	Zone string `json:"zone,omitempty"`
+	// ConfigVersion is the resource version of the machine config the instance was booted with
+	ConfigVersion string `json:"configVersion,omitempty"`
 }
 
 // +genclient
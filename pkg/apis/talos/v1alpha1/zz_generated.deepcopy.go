@@ -20,9 +20,585 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSClusterConfig) DeepCopyInto(out *AWSClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSClusterConfig.
+func (in *AWSClusterConfig) DeepCopy() *AWSClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSInstanceSpec) DeepCopyInto(out *AWSInstanceSpec) {
+	*out = *in
+	if in.SecurityGroups != nil {
+		in, out := &in.SecurityGroups, &out.SecurityGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.Disks = in.Disks
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSInstanceSpec.
+func (in *AWSInstanceSpec) DeepCopy() *AWSInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSMachineConfig) DeepCopyInto(out *AWSMachineConfig) {
+	*out = *in
+	in.Instances.DeepCopyInto(&out.Instances)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSMachineConfig.
+func (in *AWSMachineConfig) DeepCopy() *AWSMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureClusterConfig) DeepCopyInto(out *AzureClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureClusterConfig.
+func (in *AzureClusterConfig) DeepCopy() *AzureClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureInstanceSpec) DeepCopyInto(out *AzureInstanceSpec) {
+	*out = *in
+	out.Disks = in.Disks
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureInstanceSpec.
+func (in *AzureInstanceSpec) DeepCopy() *AzureInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureMachineConfig) DeepCopyInto(out *AzureMachineConfig) {
+	*out = *in
+	out.Instances = in.Instances
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureMachineConfig.
+func (in *AzureMachineConfig) DeepCopy() *AzureMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DigitalOceanClusterConfig) DeepCopyInto(out *DigitalOceanClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DigitalOceanClusterConfig.
+func (in *DigitalOceanClusterConfig) DeepCopy() *DigitalOceanClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DigitalOceanClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DigitalOceanInstanceSpec) DeepCopyInto(out *DigitalOceanInstanceSpec) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DigitalOceanInstanceSpec.
+func (in *DigitalOceanInstanceSpec) DeepCopy() *DigitalOceanInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DigitalOceanInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DigitalOceanMachineConfig) DeepCopyInto(out *DigitalOceanMachineConfig) {
+	*out = *in
+	in.Instances.DeepCopyInto(&out.Instances)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DigitalOceanMachineConfig.
+func (in *DigitalOceanMachineConfig) DeepCopy() *DigitalOceanMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DigitalOceanMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiskSpec) DeepCopyInto(out *DiskSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiskSpec.
+func (in *DiskSpec) DeepCopy() *DiskSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DiskSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DockerClusterConfig) DeepCopyInto(out *DockerClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DockerClusterConfig.
+func (in *DockerClusterConfig) DeepCopy() *DockerClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DockerClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DockerInstanceSpec) DeepCopyInto(out *DockerInstanceSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DockerInstanceSpec.
+func (in *DockerInstanceSpec) DeepCopy() *DockerInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DockerInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DockerMachineConfig) DeepCopyInto(out *DockerMachineConfig) {
+	*out = *in
+	out.Instances = in.Instances
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DockerMachineConfig.
+func (in *DockerMachineConfig) DeepCopy() *DockerMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DockerMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCEClusterConfig) DeepCopyInto(out *GCEClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCEClusterConfig.
+func (in *GCEClusterConfig) DeepCopy() *GCEClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(GCEClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCEInstanceSpec) DeepCopyInto(out *GCEInstanceSpec) {
+	*out = *in
+	out.Disks = in.Disks
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCEInstanceSpec.
+func (in *GCEInstanceSpec) DeepCopy() *GCEInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GCEInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCEMachineConfig) DeepCopyInto(out *GCEMachineConfig) {
+	*out = *in
+	out.Instances = in.Instances
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCEMachineConfig.
+func (in *GCEMachineConfig) DeepCopy() *GCEMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(GCEMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HcloudClusterConfig) DeepCopyInto(out *HcloudClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HcloudClusterConfig.
+func (in *HcloudClusterConfig) DeepCopy() *HcloudClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(HcloudClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HcloudInstanceSpec) DeepCopyInto(out *HcloudInstanceSpec) {
+	*out = *in
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HcloudInstanceSpec.
+func (in *HcloudInstanceSpec) DeepCopy() *HcloudInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HcloudInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HcloudMachineConfig) DeepCopyInto(out *HcloudMachineConfig) {
+	*out = *in
+	in.Instances.DeepCopyInto(&out.Instances)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HcloudMachineConfig.
+func (in *HcloudMachineConfig) DeepCopy() *HcloudMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(HcloudMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LibvirtClusterConfig) DeepCopyInto(out *LibvirtClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LibvirtClusterConfig.
+func (in *LibvirtClusterConfig) DeepCopy() *LibvirtClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(LibvirtClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LibvirtInstanceSpec) DeepCopyInto(out *LibvirtInstanceSpec) {
+	*out = *in
+	out.Disks = in.Disks
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LibvirtInstanceSpec.
+func (in *LibvirtInstanceSpec) DeepCopy() *LibvirtInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LibvirtInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LibvirtMachineConfig) DeepCopyInto(out *LibvirtMachineConfig) {
+	*out = *in
+	out.Instances = in.Instances
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LibvirtMachineConfig.
+func (in *LibvirtMachineConfig) DeepCopy() *LibvirtMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(LibvirtMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OpenStackClusterConfig) DeepCopyInto(out *OpenStackClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenStackClusterConfig.
+func (in *OpenStackClusterConfig) DeepCopy() *OpenStackClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(OpenStackClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OpenStackInstanceSpec) DeepCopyInto(out *OpenStackInstanceSpec) {
+	*out = *in
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SecurityGroups != nil {
+		in, out := &in.SecurityGroups, &out.SecurityGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenStackInstanceSpec.
+func (in *OpenStackInstanceSpec) DeepCopy() *OpenStackInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OpenStackInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OpenStackMachineConfig) DeepCopyInto(out *OpenStackMachineConfig) {
+	*out = *in
+	in.Instances.DeepCopyInto(&out.Instances)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenStackMachineConfig.
+func (in *OpenStackMachineConfig) DeepCopy() *OpenStackMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(OpenStackMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PacketClusterConfig) DeepCopyInto(out *PacketClusterConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PacketClusterConfig.
+func (in *PacketClusterConfig) DeepCopy() *PacketClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PacketClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PacketInstanceSpec) DeepCopyInto(out *PacketInstanceSpec) {
+	*out = *in
+	if in.Install != nil {
+		in, out := &in.Install, &out.Install
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PacketInstanceSpec.
+func (in *PacketInstanceSpec) DeepCopy() *PacketInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PacketInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PacketMachineConfig) DeepCopyInto(out *PacketMachineConfig) {
+	*out = *in
+	in.Instances.DeepCopyInto(&out.Instances)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PacketMachineConfig.
+func (in *PacketMachineConfig) DeepCopy() *PacketMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PacketMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedfishClusterConfig) DeepCopyInto(out *RedfishClusterConfig) {
+	*out = *in
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]RedfishHost, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedfishClusterConfig.
+func (in *RedfishClusterConfig) DeepCopy() *RedfishClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RedfishClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedfishHost) DeepCopyInto(out *RedfishHost) {
+	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedfishHost.
+func (in *RedfishHost) DeepCopy() *RedfishHost {
+	if in == nil {
+		return nil
+	}
+	out := new(RedfishHost)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedfishMachineConfig) DeepCopyInto(out *RedfishMachineConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedfishMachineConfig.
+func (in *RedfishMachineConfig) DeepCopy() *RedfishMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RedfishMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TalosClusterCondition) DeepCopyInto(out *TalosClusterCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TalosClusterCondition.
+func (in *TalosClusterCondition) DeepCopy() *TalosClusterCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(TalosClusterCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TalosClusterControlPlaneSpec) DeepCopyInto(out *TalosClusterControlPlaneSpec) {
 	*out = *in
@@ -42,6 +618,66 @@ func (in *TalosClusterControlPlaneSpec) DeepCopy() *TalosClusterControlPlaneSpec
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TalosClusterPlatformSpec) DeepCopyInto(out *TalosClusterPlatformSpec) {
 	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSClusterConfig)
+		**out = **in
+	}
+	if in.Azure != nil {
+		in, out := &in.Azure, &out.Azure
+		*out = new(AzureClusterConfig)
+		**out = **in
+	}
+	if in.DigitalOcean != nil {
+		in, out := &in.DigitalOcean, &out.DigitalOcean
+		*out = new(DigitalOceanClusterConfig)
+		**out = **in
+	}
+	if in.Docker != nil {
+		in, out := &in.Docker, &out.Docker
+		*out = new(DockerClusterConfig)
+		**out = **in
+	}
+	if in.GCE != nil {
+		in, out := &in.GCE, &out.GCE
+		*out = new(GCEClusterConfig)
+		**out = **in
+	}
+	if in.Hcloud != nil {
+		in, out := &in.Hcloud, &out.Hcloud
+		*out = new(HcloudClusterConfig)
+		**out = **in
+	}
+	if in.Libvirt != nil {
+		in, out := &in.Libvirt, &out.Libvirt
+		*out = new(LibvirtClusterConfig)
+		**out = **in
+	}
+	if in.OpenStack != nil {
+		in, out := &in.OpenStack, &out.OpenStack
+		*out = new(OpenStackClusterConfig)
+		**out = **in
+	}
+	if in.Packet != nil {
+		in, out := &in.Packet, &out.Packet
+		*out = new(PacketClusterConfig)
+		**out = **in
+	}
+	if in.Redfish != nil {
+		in, out := &in.Redfish, &out.Redfish
+		*out = new(RedfishClusterConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VSphere != nil {
+		in, out := &in.VSphere, &out.VSphere
+		*out = new(VSphereClusterConfig)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -61,7 +697,7 @@ func (in *TalosClusterProviderSpec) DeepCopyInto(out *TalosClusterProviderSpec)
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.ControlPlane = in.ControlPlane
-	out.Platform = in.Platform
+	in.Platform.DeepCopyInto(&out.Platform)
 	out.Status = in.Status
 	return
 }
@@ -139,7 +775,7 @@ func (in *TalosClusterProviderStatus) DeepCopyInto(out *TalosClusterProviderStat
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -213,6 +849,18 @@ func (in *TalosClusterProviderStatusSpec) DeepCopy() *TalosClusterProviderStatus
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TalosClusterProviderStatusStatus) DeepCopyInto(out *TalosClusterProviderStatusStatus) {
 	*out = *in
+	if in.ControlPlaneIPs != nil {
+		in, out := &in.ControlPlaneIPs, &out.ControlPlaneIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]TalosClusterCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -229,6 +877,61 @@ func (in *TalosClusterProviderStatusStatus) DeepCopy() *TalosClusterProviderStat
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TalosMachinePlatformSpec) DeepCopyInto(out *TalosMachinePlatformSpec) {
 	*out = *in
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSMachineConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Azure != nil {
+		in, out := &in.Azure, &out.Azure
+		*out = new(AzureMachineConfig)
+		**out = **in
+	}
+	if in.DigitalOcean != nil {
+		in, out := &in.DigitalOcean, &out.DigitalOcean
+		*out = new(DigitalOceanMachineConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Docker != nil {
+		in, out := &in.Docker, &out.Docker
+		*out = new(DockerMachineConfig)
+		**out = **in
+	}
+	if in.GCE != nil {
+		in, out := &in.GCE, &out.GCE
+		*out = new(GCEMachineConfig)
+		**out = **in
+	}
+	if in.Hcloud != nil {
+		in, out := &in.Hcloud, &out.Hcloud
+		*out = new(HcloudMachineConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Libvirt != nil {
+		in, out := &in.Libvirt, &out.Libvirt
+		*out = new(LibvirtMachineConfig)
+		**out = **in
+	}
+	if in.OpenStack != nil {
+		in, out := &in.OpenStack, &out.OpenStack
+		*out = new(OpenStackMachineConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Packet != nil {
+		in, out := &in.Packet, &out.Packet
+		*out = new(PacketMachineConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Redfish != nil {
+		in, out := &in.Redfish, &out.Redfish
+		*out = new(RedfishMachineConfig)
+		**out = **in
+	}
+	if in.VSphere != nil {
+		in, out := &in.VSphere, &out.VSphere
+		*out = new(VSphereMachineConfig)
+		**out = **in
+	}
 	return
 }
 
@@ -247,7 +950,7 @@ func (in *TalosMachineProviderSpec) DeepCopyInto(out *TalosMachineProviderSpec)
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Platform = in.Platform
+	in.Platform.DeepCopyInto(&out.Platform)
 	out.Status = in.Status
 	return
 }
@@ -325,7 +1028,7 @@ func (in *TalosMachineProviderStatus) DeepCopyInto(out *TalosMachineProviderStat
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -399,6 +1102,11 @@ func (in *TalosMachineProviderStatusSpec) DeepCopy() *TalosMachineProviderStatus
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TalosMachineProviderStatusStatus) DeepCopyInto(out *TalosMachineProviderStatusStatus) {
 	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]v1.NodeAddress, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -411,3 +1119,57 @@ func (in *TalosMachineProviderStatusStatus) DeepCopy() *TalosMachineProviderStat
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VSphereClusterConfig) DeepCopyInto(out *VSphereClusterConfig) {
+	*out = *in
+	if in.ControlPlaneIPs != nil {
+		in, out := &in.ControlPlaneIPs, &out.ControlPlaneIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereClusterConfig.
+func (in *VSphereClusterConfig) DeepCopy() *VSphereClusterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(VSphereClusterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VSphereInstanceSpec) DeepCopyInto(out *VSphereInstanceSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereInstanceSpec.
+func (in *VSphereInstanceSpec) DeepCopy() *VSphereInstanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VSphereInstanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VSphereMachineConfig) DeepCopyInto(out *VSphereMachineConfig) {
+	*out = *in
+	out.Instances = in.Instances
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereMachineConfig.
+func (in *VSphereMachineConfig) DeepCopy() *VSphereMachineConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(VSphereMachineConfig)
+	in.DeepCopyInto(out)
+	return out
+}
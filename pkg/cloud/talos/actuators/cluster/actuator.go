@@ -21,16 +21,21 @@ import (
 	"encoding/base64"
 	"errors"
 	"log"
+	"net"
 	"strconv"
+	"strings"
 
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
 	"github.com/talos-systems/talos/cmd/osctl/pkg/helpers"
 	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -39,15 +44,23 @@ import (
 
 const (
 	ClusterAPIProviderTalosNamespace = "cluster-api-provider-talos-system"
+	// APIServerPort is the port the Kubernetes API server listens on in Talos
+	APIServerPort = 6443
+	// controlPlaneIPsAnnotation records the control plane IPs a userdata secret was generated for
+	controlPlaneIPsAnnotation = "talos.dev/control-plane-ips"
 )
 
 // ClusterActuator is responsible for performing machine reconciliation
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;create;update;delete;deletecollection
 // Add RBAC rules to access cluster-api resources
-//+kubebuilder:rbac:groups=cluster.k8s.io,resources=clusters;clusters/status,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cluster.k8s.io,resources=clusters;clusters/status,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cluster.k8s.io,resources=machines,verbs=get;list;watch;delete
 type ClusterActuator struct {
 	Clientset        *kubernetes.Clientset
 	controllerClient client.Client
+	// kubeClient manages the config objects of clusters, it is Clientset outside of tests
+	kubeClient kubernetes.Interface
 }
 
 // ClusterActuatorParams holds parameter information for Actuator
@@ -73,11 +86,10 @@ func NewClusterActuator(mgr manager.Manager, params ClusterActuatorParams) (*Clu
 		return nil, err
 	}
 
-	return &ClusterActuator{Clientset: clientset, controllerClient: mgr.GetClient()}, nil
+	return &ClusterActuator{Clientset: clientset, controllerClient: mgr.GetClient(), kubeClient: clientset}, nil
 }
 
 // Reconcile reconciles a cluster and is invoked by the Cluster Controller
-// TODO: This needs to be idempotent. Check if these certs and stuff already exist
 func (a *ClusterActuator) Reconcile(cluster *clusterv1.Cluster) error {
 	log.Printf("Reconciling cluster %v.", cluster.Name)
 
@@ -86,32 +98,94 @@ func (a *ClusterActuator) Reconcile(cluster *clusterv1.Cluster) error {
 		return err
 	}
 
-	//Generate external IPs depending on provisioner
 	provisioner, err := provisioners.NewProvisioner(spec.Platform.Type)
 	if err != nil {
 		return err
 	}
 
-	masterIPs, err := provisioner.AllocateExternalIPs(cluster, a.Clientset)
+	//Move the config objects of earlier versions next to the cluster before looking them up, along with its platform resources
+	if err = a.migrateLegacyObjectsOnce(cluster); err != nil {
+		return err
+	}
+
+	status, err := utils.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
 	if err != nil {
 		return err
 	}
 
+	//Reuse the IPs recorded by a previous reconcile, only asking the provisioner for more when the control plane grew
+	masterIPs := status.Status.ControlPlaneIPs
+	if len(masterIPs) < spec.ControlPlane.Count {
+		//Generate external IPs depending on provisioner
+		allocated, err := provisioner.AllocateExternalIPs(cluster, a.Clientset)
+		if err != nil {
+			return err
+		}
+		masterIPs, err = mergeControlPlaneIPs(masterIPs, allocated, spec.ControlPlane.Count)
+		if err != nil {
+			return err
+		}
+	}
+	if len(masterIPs) == 0 {
+		return errors.New("no control plane IPs were allocated")
+	}
+
 	//Create machine config, using IPs allocated above
 	input, err := generate.NewInput(cluster.ObjectMeta.Name, masterIPs, spec.ControlPlane.K8sVersion)
 	if err != nil {
 		return err
 	}
 
-	err = createMasterConfigMaps(cluster, a.Clientset, input)
+	//Swap the freshly generated certs and tokens for the ones persisted on the first reconcile
+	if err = loadOrStorePKI(cluster, a.kubeClient, input); err != nil {
+		return err
+	}
+
+	//Remove the nodes past the control plane size before generating configs for the remaining IPs
+	if len(masterIPs) > spec.ControlPlane.Count {
+		err = a.scaleDown(cluster, provisioner, input, masterIPs, spec.ControlPlane.Count)
+		switch {
+		case err == errScaleDownBlocked:
+			//Keep reconciling the remaining nodes, the condition tells the operator how to unblock the scale down
+			log.Printf("Scale down of cluster %s is blocked, see its %s condition.", cluster.ObjectMeta.Name, talosv1.EtcdMemberRemoved)
+		case err != nil:
+			return err
+		default:
+			masterIPs = masterIPs[:spec.ControlPlane.Count]
+			input.MasterIPs = masterIPs
+		}
+	}
+
+	//Point the cluster at the endpoint of the provisioner, e.g. a VIP, if it has one
+	endpoint := masterIPs[0]
+	if endpointProvisioner, ok := provisioner.(provisioners.EndpointProvisioner); ok {
+		controlPlaneEndpoint, err := endpointProvisioner.ControlPlaneEndpoint(cluster)
+		if err != nil {
+			return err
+		}
+		if controlPlaneEndpoint != "" {
+			endpoint = controlPlaneEndpoint
+			input.ControlPlaneEndpoint = controlPlaneEndpoint
+			input.AdditionalSubjectAltNames = append(input.AdditionalSubjectAltNames, controlPlaneEndpoint)
+		}
+	}
+
+	err = createMasterSecrets(cluster, a.kubeClient, input)
 	if err != nil {
 		return err
 	}
-	err = createWorkerConfigMaps(cluster, a.Clientset, input)
+	err = createWorkerSecrets(cluster, a.kubeClient, input)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	err = a.updateStatus(cluster, masterIPs, endpoint)
+	if err != nil {
+		return err
+	}
+
+	//Publish the admin kubeconfig once the init node serves it
+	return reconcileKubeconfig(cluster, a.kubeClient, input, masterIPs[0])
 }
 
 // Delete deletes a cluster and is invoked by the Cluster Controller
@@ -133,6 +207,12 @@ func (a *ClusterActuator) Delete(cluster *clusterv1.Cluster) error {
 		return err
 	}
 
+	//Move anything left by earlier versions first, so that it is cleaned up too
+	err = a.migrateLegacyObjectsOnce(cluster)
+	if err != nil {
+		return err
+	}
+
 	//Clean up external IPs depending on provisioner
 	provisioner, err := provisioners.NewProvisioner(spec.Platform.Type)
 	if err != nil {
@@ -144,8 +224,8 @@ func (a *ClusterActuator) Delete(cluster *clusterv1.Cluster) error {
 		return err
 	}
 
-	//Clean up configmaps we create a cluster creation time
-	err = deleteConfigMaps(cluster, a.Clientset)
+	//Clean up secrets we create a cluster creation time
+	err = deleteClusterSecrets(cluster, a.kubeClient)
 	if err != nil {
 		return err
 	}
@@ -153,8 +233,30 @@ func (a *ClusterActuator) Delete(cluster *clusterv1.Cluster) error {
 	return nil
 }
 
-// createMasterConfigMaps generates certs and creates configmaps that define the userdata for each node
-func createMasterConfigMaps(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, input *generate.Input) error {
+// updateStatus records the allocated IPs and the API endpoint in the cluster status
+func (a *ClusterActuator) updateStatus(cluster *clusterv1.Cluster, masterIPs []string, endpoint string) error {
+	original := cluster.DeepCopy()
+
+	err := utils.UpdateClusterProviderStatus(cluster, func(status *talosv1.TalosClusterProviderStatusStatus) {
+		status.ControlPlaneIPs = masterIPs
+		status.APIEndpoint = net.JoinHostPort(endpoint, strconv.Itoa(APIServerPort))
+		status.TalosConfigTarget = masterIPs[0]
+		status.Ready = true
+	})
+	if err != nil {
+		return err
+	}
+	cluster.Status.APIEndpoints = []clusterv1.APIEndpoint{{Host: endpoint, Port: APIServerPort}}
+
+	if equality.Semantic.DeepEqual(original.Status, cluster.Status) {
+		return nil
+	}
+
+	return a.controllerClient.Status().Update(context.Background(), cluster)
+}
+
+// createMasterSecrets creates secrets that define the userdata and talosconfig for each master
+func createMasterSecrets(cluster *clusterv1.Cluster, clientset kubernetes.Interface, input *generate.Input) error {
 
 	talosConfig := &talosConfig{
 		Context: input.ClusterName,
@@ -190,10 +292,13 @@ func createMasterConfigMaps(cluster *clusterv1.Cluster, clientset *kubernetes.Cl
 	}
 
 	for index, userdata := range allData {
-		name := cluster.ObjectMeta.Name + "-master-" + strconv.Itoa(index)
+		role := talosv1.MachineRoleControlPlane
+		if index == 0 {
+			role = talosv1.MachineRoleInit
+		}
 		data := map[string]string{"userdata": userdata, "talosconfig": string(talosConfigBytes)}
 
-		if err := createConfigMap(clientset, name, data); err != nil {
+		if err := createUserdataSecret(cluster, clientset, input, role, index, data); err != nil {
 			return err
 		}
 	}
@@ -201,64 +306,78 @@ func createMasterConfigMaps(cluster *clusterv1.Cluster, clientset *kubernetes.Cl
 	return nil
 }
 
-// createWorkerConfigMaps creates a configmap for a machineset of workers
-func createWorkerConfigMaps(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, input *generate.Input) error {
+// createWorkerSecrets creates a secret for a machineset of workers
+func createWorkerSecrets(cluster *clusterv1.Cluster, clientset kubernetes.Interface, input *generate.Input) error {
 	workerData, err := generate.Config(generate.TypeJoin, input)
 	if err != nil {
 		return err
 	}
 
-	name := cluster.ObjectMeta.Name + "-workers"
 	data := map[string]string{"userdata": workerData}
 
-	return createConfigMap(clientset, name, data)
+	return createUserdataSecret(cluster, clientset, input, talosv1.MachineRoleWorker, 0, data)
 }
 
-// createConfigMap creates a config map in kubernetes with given data
-func createConfigMap(clientset *kubernetes.Clientset, name string, data map[string]string) error {
-	cm := &v1.ConfigMap{
+// createUserdataSecret creates the userdata secret of a role in the namespace of the cluster, labelled and owned by it.
+// An existing secret is regenerated when the control plane IPs changed, so that new nodes get the current SANs and endpoints.
+// One without IPs, e.g. migrated from an earlier version, is adopted as is and only stamped with the current IPs.
+func createUserdataSecret(cluster *clusterv1.Cluster, clientset kubernetes.Interface, input *generate.Input, role talosv1.MachineRole, index int, data map[string]string) error {
+	ips := strings.Join(input.MasterIPs, ",")
+
+	secret := &v1.Secret{
 		TypeMeta: metav1.TypeMeta{
-			Kind:       "ConfigMap",
+			Kind:       "Secret",
 			APIVersion: "v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: ClusterAPIProviderTalosNamespace,
+			Name:            utils.UserdataSecretName(cluster, role, index),
+			Namespace:       cluster.ObjectMeta.Namespace,
+			Labels:          utils.UserdataLabels(cluster, role, index),
+			Annotations:     map[string]string{controlPlaneIPsAnnotation: ips},
+			OwnerReferences: []metav1.OwnerReference{utils.ClusterOwnerReference(cluster)},
 		},
-		Data: data,
+		Type:       utils.UserdataSecretType,
+		StringData: data,
 	}
 
-	_, err := clientset.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).Create(cm)
-	// Essentially no-op if CMs are already there
-	if k8serrors.IsAlreadyExists(err) {
-		return nil
-		//_, err = clientset.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).Update(cm)
+	secrets := clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace)
+	_, err := secrets.Create(secret)
+	if err == nil || !k8serrors.IsAlreadyExists(err) {
+		return err
 	}
 
-	return err
-}
-
-// deleteConfigMaps cleans up all configmaps associated with this cluster
-func deleteConfigMaps(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
-	// TODO(andrewrynhard): We should add labels to the ConfigMaps and use
-	// the lables to find the ConfigMaps.
-	spec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	existing, err := secrets.Get(secret.ObjectMeta.Name, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
-
-	for index := 0; index < spec.ControlPlane.Count; index++ {
-		name := cluster.ObjectMeta.Name + "-master-" + strconv.Itoa(index)
-		err = clientset.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).Delete(name, nil)
-		if err != nil && !k8serrors.IsNotFound(err) {
-			return err
-		}
+	// Essentially no-op if secrets are already there for these IPs
+	stamped, ok := existing.ObjectMeta.Annotations[controlPlaneIPsAnnotation]
+	if stamped == ips {
+		return nil
 	}
 
-	err = clientset.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).Delete(cluster.ObjectMeta.Name+"-workers", nil)
-	if err != nil && !k8serrors.IsNotFound(err) {
+	if existing.ObjectMeta.Annotations == nil {
+		existing.ObjectMeta.Annotations = map[string]string{}
+	}
+	existing.ObjectMeta.Annotations[controlPlaneIPsAnnotation] = ips
+	if ok {
+		existing.StringData = data
+	}
+	_, err = secrets.Update(existing)
+	if err != nil {
 		return err
 	}
 
+	if ok {
+		log.Printf("Regenerated secret %s for control plane IPs %s.", existing.ObjectMeta.Name, ips)
+	} else {
+		log.Printf("Adopted secret %s for control plane IPs %s.", existing.ObjectMeta.Name, ips)
+	}
 	return nil
 }
+
+// deleteClusterSecrets cleans up all secrets generated for this cluster, found by their labels
+func deleteClusterSecrets(cluster *clusterv1.Cluster, clientset kubernetes.Interface) error {
+	selector := labels.SelectorFromSet(utils.ClusterLabels(cluster)).String()
+	return clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace).DeleteCollection(nil, metav1.ListOptions{LabelSelector: selector})
+}
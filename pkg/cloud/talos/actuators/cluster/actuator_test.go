@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/fake"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+	yaml "gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+func TestReconcileLegacyCluster(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	fake.Shared().Reset()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	clusterSpec, err := utils.EncodeProviderObject(&talosv1.TalosClusterProviderSpec{
+		ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1, K8sVersion: "1.16.2"},
+		Platform:     talosv1.TalosClusterPlatformSpec{Type: "fake"},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "uid"},
+		Spec:       clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: clusterSpec}},
+	}
+
+	//The ConfigMaps a cluster got before its PKI and configs were kept in Secrets, and no status
+	ips, err := fake.Shared().AllocateExternalIPs(cluster, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	legacy, err := generate.NewInput("test", ips, "1.16.2")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	initData, err := generate.Config(generate.TypeInit, legacy)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	workerData, err := generate.Config(generate.TypeJoin, legacy)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	talosConfigBytes, err := yaml.Marshal(&talosConfig{
+		Context: "test",
+		Contexts: map[string]*talosConfigContext{"test": {
+			Target: ips[0],
+			CA:     base64.StdEncoding.EncodeToString(legacy.Certs.OS.Crt),
+			Crt:    base64.StdEncoding.EncodeToString(legacy.Certs.Admin.Crt),
+			Key:    base64.StdEncoding.EncodeToString(legacy.Certs.Admin.Key),
+		}},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//A published kubeconfig keeps the reconcile from reaching out to the init node
+	crt, key, err := signClientCertificate(legacy.Certs.K8s.Crt, legacy.Certs.K8s.Key, "kubernetes-admin", []string{"system:masters"}, time.Now().Add(kubeconfigValidity))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	kubeconfig, err := sigsyaml.Marshal(clientcmdv1.Config{
+		AuthInfos:      []clientcmdv1.NamedAuthInfo{{Name: "admin@test", AuthInfo: clientcmdv1.AuthInfo{ClientCertificateData: crt, ClientKeyData: key}}},
+		Contexts:       []clientcmdv1.NamedContext{{Name: "admin@test", Context: clientcmdv1.Context{AuthInfo: "admin@test"}}},
+		CurrentContext: "admin@test",
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	kubeClient := kubefake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-master-0", Namespace: ClusterAPIProviderTalosNamespace},
+			Data:       map[string]string{"userdata": initData, "talosconfig": string(talosConfigBytes)},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-workers", Namespace: ClusterAPIProviderTalosNamespace},
+			Data:       map[string]string{"userdata": workerData},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: utils.KubeconfigSecretName(cluster), Namespace: "default"},
+			Data:       map[string][]byte{utils.KubeconfigSecretKey: kubeconfig},
+		},
+	)
+	a := &ClusterActuator{controllerClient: fakeclient.NewFakeClientWithScheme(scheme, cluster.DeepCopy()), kubeClient: kubeClient}
+
+	g.Expect(a.Reconcile(cluster)).To(gomega.Succeed())
+
+	//The nodes keep trusting the configs generated from now on
+	pki, err := kubeClient.CoreV1().Secrets("default").Get(pkiSecretName(cluster), metav1.GetOptions{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	input := &generate.Input{}
+	g.Expect(applyPKISecretData(input, pki.Data)).To(gomega.Succeed())
+	g.Expect(input.Certs).To(gomega.Equal(legacy.Certs))
+	g.Expect(input.KubeadmTokens).To(gomega.Equal(legacy.KubeadmTokens))
+	g.Expect(input.TrustdInfo).To(gomega.Equal(legacy.TrustdInfo))
+
+	//The existing configs are adopted as they are, for the IPs they were generated for
+	for name, userdata := range map[string]string{"test-master-0": initData, "test-workers": workerData} {
+		secret, err := kubeClient.CoreV1().Secrets("default").Get(name, metav1.GetOptions{})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(string(secret.Data["userdata"])).To(gomega.Equal(userdata), name)
+		g.Expect(secret.StringData).To(gomega.BeEmpty(), name)
+		g.Expect(secret.ObjectMeta.Annotations).To(gomega.HaveKeyWithValue(controlPlaneIPsAnnotation, ips[0]), name)
+	}
+
+	//The migration is recorded, later reconciles don't look for legacy objects anymore
+	stored := &clusterv1.Cluster{}
+	g.Expect(a.controllerClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, stored)).To(gomega.Succeed())
+	status, err := utils.ClusterStatusFromProviderStatus(stored.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Status.LegacyObjectsMigrated).To(gomega.BeTrue())
+	g.Expect(status.Status.LegacyResourceNames).To(gomega.BeTrue())
+
+	kubeClient.ClearActions()
+	g.Expect(a.Reconcile(stored)).To(gomega.Succeed())
+	for _, action := range kubeClient.Actions() {
+		g.Expect(action.GetNamespace()).NotTo(gomega.Equal(ClusterAPIProviderTalosNamespace))
+	}
+}
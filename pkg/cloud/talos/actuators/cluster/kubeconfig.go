@@ -0,0 +1,234 @@
+package cluster
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/talos/cmd/osctl/pkg/client"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+	"github.com/talos-systems/talos/pkg/constants"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	controllerError "sigs.k8s.io/cluster-api/pkg/controller/error"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// kubeconfigRenewBefore is how long before its expiry the client certificate of the kubeconfig is renewed
+	kubeconfigRenewBefore = 30 * 24 * time.Hour
+	// kubeconfigValidity is how long renewed client certificates are valid
+	kubeconfigValidity = 365 * 24 * time.Hour
+	// kubeconfigRequeueAfter is how long to wait for the init node to serve its kubeconfig
+	kubeconfigRequeueAfter = 30 * time.Second
+)
+
+// reconcileKubeconfig publishes the admin kubeconfig of the cluster in its namespace.
+// It is fetched from the init node once it is up, and its client certificate is renewed against the Kubernetes CA when it nears expiry.
+func reconcileKubeconfig(cluster *clusterv1.Cluster, clientset kubernetes.Interface, input *generate.Input, target string) error {
+	secrets := clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace)
+
+	secret, err := secrets.Get(utils.KubeconfigSecretName(cluster), metav1.GetOptions{})
+	if err == nil {
+		expiry, err := kubeconfigExpiry(secret.Data[utils.KubeconfigSecretKey])
+		if err != nil {
+			return err
+		}
+		if time.Until(expiry) > kubeconfigRenewBefore {
+			return nil
+		}
+
+		kubeconfig, err := renewKubeconfig(secret.Data[utils.KubeconfigSecretKey], input.Certs.K8s.Crt, input.Certs.K8s.Key)
+		if err != nil {
+			return err
+		}
+		secret.Data = map[string][]byte{utils.KubeconfigSecretKey: kubeconfig}
+		_, err = secrets.Update(secret)
+		if err == nil {
+			log.Printf("Renewed the kubeconfig of cluster %s, it expired at %s.", cluster.ObjectMeta.Name, expiry)
+		}
+		return err
+	}
+	if !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	kubeconfig, err := fetchKubeconfig(input, target)
+	if err != nil {
+		//The init node is most likely still booting
+		log.Printf("Unable to fetch the kubeconfig of cluster %s from %s yet: %v", cluster.ObjectMeta.Name, target, err)
+		return &controllerError.RequeueAfterError{RequeueAfter: kubeconfigRequeueAfter}
+	}
+
+	secret = &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            utils.KubeconfigSecretName(cluster),
+			Namespace:       cluster.ObjectMeta.Namespace,
+			Labels:          utils.ClusterLabels(cluster),
+			OwnerReferences: []metav1.OwnerReference{utils.ClusterOwnerReference(cluster)},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{utils.KubeconfigSecretKey: kubeconfig},
+	}
+	_, err = secrets.Create(secret)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// fetchKubeconfig asks the Talos API of the init node for the admin kubeconfig, with the admin credentials of the talosconfig
+func fetchKubeconfig(input *generate.Input, target string) ([]byte, error) {
+	creds := client.NewClientCredentials(input.Certs.OS.Crt, input.Certs.Admin.Crt, input.Certs.Admin.Key)
+	c, err := client.NewClient(creds, target, constants.OsdPort)
+	if err != nil {
+		return nil, err
+	}
+	//nolint: errcheck
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return c.Kubeconfig(ctx)
+}
+
+// kubeconfigExpiry returns when the client certificate of the current context of a kubeconfig expires
+func kubeconfigExpiry(kubeconfig []byte) (time.Time, error) {
+	config := &clientcmdv1.Config{}
+	if err := yaml.Unmarshal(kubeconfig, config); err != nil {
+		return time.Time{}, err
+	}
+	authInfo, err := currentAuthInfo(config)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	crt, err := parseCertificate(authInfo.ClientCertificateData)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return crt.NotAfter, nil
+}
+
+// renewKubeconfig issues a new client certificate with the same subject for the current context of a kubeconfig, signed by the CA
+func renewKubeconfig(kubeconfig, caCrt, caKey []byte) ([]byte, error) {
+	config := &clientcmdv1.Config{}
+	if err := yaml.Unmarshal(kubeconfig, config); err != nil {
+		return nil, err
+	}
+	authInfo, err := currentAuthInfo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := parseCertificate(authInfo.ClientCertificateData)
+	if err != nil {
+		return nil, err
+	}
+
+	crt, key, err := signClientCertificate(caCrt, caKey, current.Subject.CommonName, current.Subject.Organization, time.Now().Add(kubeconfigValidity))
+	if err != nil {
+		return nil, err
+	}
+	authInfo.ClientCertificateData = crt
+	authInfo.ClientKeyData = key
+
+	return yaml.Marshal(config)
+}
+
+// currentAuthInfo returns the credentials of the current context of a kubeconfig
+func currentAuthInfo(config *clientcmdv1.Config) (*clientcmdv1.AuthInfo, error) {
+	for _, named := range config.Contexts {
+		if named.Name != config.CurrentContext {
+			continue
+		}
+		for i := range config.AuthInfos {
+			if config.AuthInfos[i].Name == named.Context.AuthInfo {
+				return &config.AuthInfos[i].AuthInfo, nil
+			}
+		}
+	}
+	return nil, errors.New("kubeconfig has no credentials for its current context")
+}
+
+// signClientCertificate returns a PEM encoded client certificate and key for the subject, signed by the CA
+func signClientCertificate(caCrt, caKey []byte, commonName string, organization []string, notAfter time.Time) ([]byte, []byte, error) {
+	ca, err := parseCertificate(caCrt)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := parsePrivateKey(caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: organization},
+		NotBefore:    time.Now().Add(-5 * time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", block.Type)
+	}
+}
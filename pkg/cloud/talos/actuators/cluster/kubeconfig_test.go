@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRenewKubeconfig(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	input, err := generate.NewInput("test", []string{"10.0.0.1"}, "1.16.2")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//An admin kubeconfig about to expire, like the one of an init node a year in
+	crt, key, err := signClientCertificate(input.Certs.K8s.Crt, input.Certs.K8s.Key, "kubernetes-admin", []string{"system:masters"}, time.Now().Add(24*time.Hour))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	kubeconfig, err := yaml.Marshal(clientcmdv1.Config{
+		Kind:           "Config",
+		APIVersion:     "v1",
+		Clusters:       []clientcmdv1.NamedCluster{{Name: "test", Cluster: clientcmdv1.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: input.Certs.K8s.Crt}}},
+		AuthInfos:      []clientcmdv1.NamedAuthInfo{{Name: "admin@test", AuthInfo: clientcmdv1.AuthInfo{ClientCertificateData: crt, ClientKeyData: key}}},
+		Contexts:       []clientcmdv1.NamedContext{{Name: "admin@test", Context: clientcmdv1.Context{Cluster: "test", AuthInfo: "admin@test"}}},
+		CurrentContext: "admin@test",
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	expiry, err := kubeconfigExpiry(kubeconfig)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(time.Until(expiry)).To(gomega.BeNumerically("<", kubeconfigRenewBefore))
+
+	renewed, err := renewKubeconfig(kubeconfig, input.Certs.K8s.Crt, input.Certs.K8s.Key)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	expiry, err = kubeconfigExpiry(renewed)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(time.Until(expiry)).To(gomega.BeNumerically(">", kubeconfigRenewBefore))
+
+	//The new certificate keeps the subject and is trusted by the cluster CA, the rest of the kubeconfig is untouched
+	config := &clientcmdv1.Config{}
+	g.Expect(yaml.Unmarshal(renewed, config)).To(gomega.Succeed())
+	g.Expect(config.Clusters[0].Cluster.Server).To(gomega.Equal("https://10.0.0.1:6443"))
+
+	authInfo, err := currentAuthInfo(config)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	renewedCrt, err := parseCertificate(authInfo.ClientCertificateData)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(renewedCrt.Subject.CommonName).To(gomega.Equal("kubernetes-admin"))
+	g.Expect(renewedCrt.Subject.Organization).To(gomega.Equal([]string{"system:masters"}))
+
+	ca, err := parseCertificate(input.Certs.K8s.Crt)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err = renewedCrt.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	_, err = kubeconfigExpiry([]byte("apiVersion: v1\nkind: Config\n"))
+	g.Expect(err).To(gomega.MatchError("kubeconfig has no credentials for its current context"))
+}
@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// migrateLegacyObjectsOnce migrates the legacy objects of a cluster unless its status records it was done already.
+// The status also records whether there were any, in which case the platform resources of the cluster have legacy names as well.
+func (a *ClusterActuator) migrateLegacyObjectsOnce(cluster *clusterv1.Cluster) error {
+	status, err := utils.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	if status.Status.LegacyObjectsMigrated {
+		return nil
+	}
+
+	migrated, err := a.migrateLegacyObjects(cluster)
+	if err != nil {
+		return err
+	}
+
+	err = utils.UpdateClusterProviderStatus(cluster, func(status *talosv1.TalosClusterProviderStatusStatus) {
+		status.LegacyObjectsMigrated = true
+		status.LegacyResourceNames = migrated
+	})
+	if err != nil {
+		return err
+	}
+	return a.controllerClient.Status().Update(context.Background(), cluster)
+}
+
+// migrateLegacyObjects moves the config objects earlier versions created in the provider namespace, found by name only,
+// into the namespace of the cluster, labelled and owned by it. ConfigMaps become secrets, their data is kept as is so that existing nodes keep their config.
+// As names don't tell which namespace a legacy object belongs to, nothing is adopted while several clusters share the name.
+// It returns whether the cluster had any legacy objects.
+func (a *ClusterActuator) migrateLegacyObjects(cluster *clusterv1.Cluster) (bool, error) {
+	secrets, err := a.kubeClient.CoreV1().Secrets(ClusterAPIProviderTalosNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	configMaps, err := a.kubeClient.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	legacySecrets := []v1.Secret{}
+	for _, legacy := range secrets.Items {
+		if _, migrated := legacy.ObjectMeta.Labels[utils.ClusterNameLabel]; migrated {
+			continue
+		}
+		if _, ok := legacyLabels(cluster, legacy.ObjectMeta.Name); ok {
+			legacySecrets = append(legacySecrets, legacy)
+		}
+	}
+	legacyConfigMaps := []v1.ConfigMap{}
+	for _, legacy := range configMaps.Items {
+		if _, ok := legacyLabels(cluster, legacy.ObjectMeta.Name); ok {
+			legacyConfigMaps = append(legacyConfigMaps, legacy)
+		}
+	}
+	if len(legacySecrets) == 0 && len(legacyConfigMaps) == 0 {
+		return false, nil
+	}
+
+	namesakes, err := a.namesakes(cluster)
+	if err != nil {
+		return false, err
+	}
+	if len(namesakes) > 0 {
+		return false, fmt.Errorf("config objects of an earlier version in %s may belong to cluster %s or to its namesakes in %s, move them to the namespace of their cluster",
+			ClusterAPIProviderTalosNamespace, cluster.ObjectMeta.Name, strings.Join(namesakes, ", "))
+	}
+
+	for i := range legacySecrets {
+		legacy := &legacySecrets[i]
+		labels, _ := legacyLabels(cluster, legacy.ObjectMeta.Name)
+		if err = adoptSecret(cluster, a.kubeClient, legacy, labels); err != nil {
+			return false, err
+		}
+		log.Printf("Migrated Secret %s/%s of cluster %s.", legacy.ObjectMeta.Namespace, legacy.ObjectMeta.Name, cluster.ObjectMeta.Name)
+	}
+
+	for i := range legacyConfigMaps {
+		legacy := &legacyConfigMaps[i]
+		labels, _ := legacyLabels(cluster, legacy.ObjectMeta.Name)
+
+		secret := legacySecret(cluster, legacy.ObjectMeta.Name, labels)
+		secret.Type = utils.UserdataSecretType
+		secret.Data = map[string][]byte{}
+		for key, value := range legacy.Data {
+			secret.Data[key] = []byte(value)
+		}
+
+		_, err = a.kubeClient.CoreV1().Secrets(cluster.ObjectMeta.Namespace).Create(secret)
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			return false, err
+		}
+
+		err = a.kubeClient.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).Delete(legacy.ObjectMeta.Name, nil)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return false, err
+		}
+		log.Printf("Migrated ConfigMap %s/%s of cluster %s to a Secret.", legacy.ObjectMeta.Namespace, legacy.ObjectMeta.Name, cluster.ObjectMeta.Name)
+	}
+
+	return true, nil
+}
+
+// namesakes returns the namespaces of the other clusters named like the cluster
+func (a *ClusterActuator) namesakes(cluster *clusterv1.Cluster) ([]string, error) {
+	clusters := &clusterv1.ClusterList{}
+	listOptions := &client.ListOptions{}
+	listOptions.InNamespace("")
+	if err := a.controllerClient.List(context.Background(), listOptions, clusters); err != nil {
+		return nil, err
+	}
+
+	namespaces := []string{}
+	for _, other := range clusters.Items {
+		if other.ObjectMeta.Name == cluster.ObjectMeta.Name && other.ObjectMeta.Namespace != cluster.ObjectMeta.Namespace {
+			namespaces = append(namespaces, other.ObjectMeta.Namespace)
+		}
+	}
+	return namespaces, nil
+}
+
+// adoptSecret labels and owns a legacy secret, moving it to the namespace of the cluster if it lives elsewhere
+func adoptSecret(cluster *clusterv1.Cluster, clientset kubernetes.Interface, legacy *v1.Secret, labels map[string]string) error {
+	secret := legacySecret(cluster, legacy.ObjectMeta.Name, labels)
+	secret.Type = legacy.Type
+	secret.Data = legacy.Data
+
+	if legacy.ObjectMeta.Namespace == cluster.ObjectMeta.Namespace {
+		secret.ObjectMeta.ResourceVersion = legacy.ObjectMeta.ResourceVersion
+		_, err := clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace).Update(secret)
+		return err
+	}
+
+	_, err := clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace).Create(secret)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	err = clientset.CoreV1().Secrets(legacy.ObjectMeta.Namespace).Delete(legacy.ObjectMeta.Name, nil)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// legacySecret returns the skeleton of the secret replacing a legacy object of a cluster
+func legacySecret(cluster *clusterv1.Cluster, name string, labels map[string]string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       cluster.ObjectMeta.Namespace,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{utils.ClusterOwnerReference(cluster)},
+		},
+	}
+}
+
+// legacyLabels returns the labels of a legacy object from its name, and false if it does not belong to the cluster
+func legacyLabels(cluster *clusterv1.Cluster, name string) (map[string]string, bool) {
+	if name == pkiSecretName(cluster) {
+		return utils.ClusterLabels(cluster), true
+	}
+
+	if name == utils.UserdataSecretName(cluster, talosv1.MachineRoleWorker, 0) {
+		return utils.UserdataLabels(cluster, talosv1.MachineRoleWorker, 0), true
+	}
+
+	prefix := cluster.ObjectMeta.Name + "-master-"
+	if !strings.HasPrefix(name, prefix) {
+		return nil, false
+	}
+
+	index, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil || index < 0 {
+		return nil, false
+	}
+
+	role := talosv1.MachineRoleControlPlane
+	if index == 0 {
+		role = talosv1.MachineRoleInit
+	}
+	return utils.UserdataLabels(cluster, role, index), true
+}
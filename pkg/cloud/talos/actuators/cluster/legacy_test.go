@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLegacyLabels(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant"}}
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+	}{
+		{name: "test-pki", labels: map[string]string{utils.ClusterNameLabel: "test", utils.ClusterNamespaceLabel: "tenant"}},
+		{name: "test-workers", labels: map[string]string{utils.ClusterNameLabel: "test", utils.ClusterNamespaceLabel: "tenant", utils.RoleLabel: "worker"}},
+		{name: "test-master-0", labels: map[string]string{utils.ClusterNameLabel: "test", utils.ClusterNamespaceLabel: "tenant", utils.RoleLabel: "init", utils.ControlPlaneIndexLabel: "0"}},
+		{name: "test-master-4", labels: map[string]string{utils.ClusterNameLabel: "test", utils.ClusterNamespaceLabel: "tenant", utils.RoleLabel: "controlplane", utils.ControlPlaneIndexLabel: "4"}},
+	} {
+		labels, ok := legacyLabels(cluster, tc.name)
+		g.Expect(ok).To(gomega.BeTrue(), tc.name)
+		g.Expect(labels).To(gomega.Equal(tc.labels), tc.name)
+	}
+
+	//Objects of other clusters, or not generated at all, are left alone
+	for _, name := range []string{"other-master-0", "test-master-x", "test-master-", "gce-credentials"} {
+		_, ok := legacyLabels(cluster, name)
+		g.Expect(ok).To(gomega.BeFalse(), name)
+	}
+}
+
+func TestMigrateLegacyObjects(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant", UID: "uid"}}
+	namesake := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "other", UID: "other-uid"}}
+	legacy := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-workers", Namespace: ClusterAPIProviderTalosNamespace},
+		Data:       map[string]string{"userdata": "version: v1alpha1"},
+	}
+
+	//The legacy objects of a name shared by several clusters are left alone
+	kubeClient := kubefake.NewSimpleClientset(legacy.DeepCopy())
+	a := &ClusterActuator{controllerClient: fakeclient.NewFakeClientWithScheme(scheme, cluster.DeepCopy(), namesake.DeepCopy()), kubeClient: kubeClient}
+	_, err := a.migrateLegacyObjects(cluster)
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("namesakes in other")))
+	_, err = kubeClient.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).Get("test-workers", metav1.GetOptions{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	_, err = kubeClient.CoreV1().Secrets("tenant").Get("test-workers", metav1.GetOptions{})
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	kubeClient = kubefake.NewSimpleClientset(legacy.DeepCopy())
+	a = &ClusterActuator{controllerClient: fakeclient.NewFakeClientWithScheme(scheme, cluster.DeepCopy()), kubeClient: kubeClient}
+	migrated, err := a.migrateLegacyObjects(cluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(migrated).To(gomega.BeTrue())
+	_, err = kubeClient.CoreV1().ConfigMaps(ClusterAPIProviderTalosNamespace).Get("test-workers", metav1.GetOptions{})
+	g.Expect(err).To(gomega.HaveOccurred())
+	secret, err := kubeClient.CoreV1().Secrets("tenant").Get("test-workers", metav1.GetOptions{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(secret.Type).To(gomega.Equal(utils.UserdataSecretType))
+	g.Expect(secret.Data).To(gomega.Equal(map[string][]byte{"userdata": []byte("version: v1alpha1")}))
+	g.Expect(secret.ObjectMeta.OwnerReferences).To(gomega.Equal([]metav1.OwnerReference{utils.ClusterOwnerReference(cluster)}))
+
+	migrated, err = a.migrateLegacyObjects(cluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(migrated).To(gomega.BeFalse())
+}
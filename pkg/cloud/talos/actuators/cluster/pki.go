@@ -0,0 +1,204 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+	"github.com/talos-systems/talos/pkg/crypto/x509"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// Keys of the PKI secret of a cluster
+const (
+	osCrtKey                  = "os.crt"
+	osKeyKey                  = "os.key"
+	k8sCrtKey                 = "k8s.crt"
+	k8sKeyKey                 = "k8s.key"
+	etcdCrtKey                = "etcd.crt"
+	etcdKeyKey                = "etcd.key"
+	adminCrtKey               = "admin.crt"
+	adminKeyKey               = "admin.key"
+	bootstrapTokenKey         = "bootstrap-token"
+	aescbcEncryptionSecretKey = "aescbc-encryption-secret"
+	certificateKeyKey         = "certificate-key"
+	trustdTokenKey            = "trustd-token"
+)
+
+// pkiSecretName returns the name of the secret holding the PKI of a cluster
+func pkiSecretName(cluster *clusterv1.Cluster) string {
+	return cluster.ObjectMeta.Name + "-pki"
+}
+
+// loadOrStorePKI replaces the CAs and tokens of a freshly generated input with the ones persisted for the cluster,
+// persisting them on the first reconcile. Every config of a cluster is thus generated against the same CAs.
+// Clusters created before the PKI was persisted get the one their masters were configured with, only new clusters get the generated one.
+func loadOrStorePKI(cluster *clusterv1.Cluster, clientset kubernetes.Interface, input *generate.Input) error {
+	secrets := clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace)
+
+	secret, err := secrets.Get(pkiSecretName(cluster), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		data, err := existingPKISecretData(cluster, clientset)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			data = pkiSecretData(input)
+		}
+
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            pkiSecretName(cluster),
+				Namespace:       cluster.ObjectMeta.Namespace,
+				Labels:          utils.ClusterLabels(cluster),
+				OwnerReferences: []metav1.OwnerReference{utils.ClusterOwnerReference(cluster)},
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: data,
+		}
+		_, err = secrets.Create(secret)
+		if !k8serrors.IsAlreadyExists(err) {
+			return err
+		}
+		//Lost a race with another reconcile, use what it stored
+		secret, err = secrets.Get(pkiSecretName(cluster), metav1.GetOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	return applyPKISecretData(input, secret.Data)
+}
+
+// existingPKISecretData recovers the CAs and tokens of a cluster from the userdata and talosconfig of its masters, the init one first.
+// Nil is returned if the cluster has no master config yet.
+func existingPKISecretData(cluster *clusterv1.Cluster, clientset kubernetes.Interface) (map[string][]byte, error) {
+	selector := labels.SelectorFromSet(utils.ClusterLabels(cluster)).String()
+	list, err := clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	masters := []v1.Secret{}
+	for _, secret := range list.Items {
+		if secret.Type == utils.UserdataSecretType && utils.IsControlPlane(talosv1.MachineRole(secret.ObjectMeta.Labels[utils.RoleLabel])) {
+			masters = append(masters, secret)
+		}
+	}
+	sort.Slice(masters, func(i, j int) bool {
+		first, _ := strconv.Atoi(masters[i].ObjectMeta.Labels[utils.ControlPlaneIndexLabel])
+		second, _ := strconv.Atoi(masters[j].ObjectMeta.Labels[utils.ControlPlaneIndexLabel])
+		return first < second
+	})
+
+	var lastErr error
+	for _, secret := range masters {
+		data, err := pkiFromUserdata(secret.Data["userdata"], secret.Data["talosconfig"])
+		if err == nil {
+			log.Printf("Recovered the PKI of cluster %s from secret %s.", cluster.ObjectMeta.Name, secret.ObjectMeta.Name)
+			return data, nil
+		}
+		lastErr = fmt.Errorf("unable to recover the PKI of cluster %s from secret %s: %v", cluster.ObjectMeta.Name, secret.ObjectMeta.Name, err)
+	}
+	return nil, lastErr
+}
+
+// pkiFromUserdata returns the CAs and tokens of the userdata of a master as secret data, and the admin certificate of its talosconfig
+func pkiFromUserdata(userdata, talosconfig []byte) (map[string][]byte, error) {
+	config := &v1alpha1.Config{}
+	if err := yaml.Unmarshal(userdata, config); err != nil {
+		return nil, err
+	}
+	machine, cluster := config.MachineConfig, config.ClusterConfig
+	if machine == nil || machine.MachineCA == nil || cluster == nil || cluster.ClusterCA == nil || cluster.EtcdConfig == nil || cluster.EtcdConfig.RootCA == nil {
+		return nil, errors.New("userdata has no CAs")
+	}
+
+	tc := &talosConfig{}
+	if err := yaml.Unmarshal(talosconfig, tc); err != nil {
+		return nil, err
+	}
+	current, ok := tc.Contexts[tc.Context]
+	if !ok || current == nil {
+		return nil, errors.New("talosconfig has no current context")
+	}
+	adminCrt, err := base64.StdEncoding.DecodeString(current.Crt)
+	if err != nil {
+		return nil, err
+	}
+	adminKey, err := base64.StdEncoding.DecodeString(current.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pkiSecretData(&generate.Input{
+		Certs: &generate.Certs{
+			OS:    machine.MachineCA,
+			K8s:   cluster.ClusterCA,
+			Etcd:  cluster.EtcdConfig.RootCA,
+			Admin: &x509.PEMEncodedCertificateAndKey{Crt: adminCrt, Key: adminKey},
+		},
+		KubeadmTokens: &generate.KubeadmTokens{
+			BootstrapToken:         cluster.BootstrapToken,
+			AESCBCEncryptionSecret: cluster.ClusterAESCBCEncryptionSecret,
+			CertificateKey:         cluster.CertificateKey,
+		},
+		TrustdInfo: &generate.TrustdInfo{Token: machine.MachineToken},
+	})
+	return data, applyPKISecretData(&generate.Input{}, data)
+}
+
+// pkiSecretData returns the CAs and tokens of an input as secret data
+func pkiSecretData(input *generate.Input) map[string][]byte {
+	return map[string][]byte{
+		osCrtKey:                  input.Certs.OS.Crt,
+		osKeyKey:                  input.Certs.OS.Key,
+		k8sCrtKey:                 input.Certs.K8s.Crt,
+		k8sKeyKey:                 input.Certs.K8s.Key,
+		etcdCrtKey:                input.Certs.Etcd.Crt,
+		etcdKeyKey:                input.Certs.Etcd.Key,
+		adminCrtKey:               input.Certs.Admin.Crt,
+		adminKeyKey:               input.Certs.Admin.Key,
+		bootstrapTokenKey:         []byte(input.KubeadmTokens.BootstrapToken),
+		aescbcEncryptionSecretKey: []byte(input.KubeadmTokens.AESCBCEncryptionSecret),
+		certificateKeyKey:         []byte(input.KubeadmTokens.CertificateKey),
+		trustdTokenKey:            []byte(input.TrustdInfo.Token),
+	}
+}
+
+// applyPKISecretData sets the CAs and tokens of an input from secret data
+func applyPKISecretData(input *generate.Input, data map[string][]byte) error {
+	for _, key := range []string{osCrtKey, osKeyKey, k8sCrtKey, k8sKeyKey, etcdCrtKey, etcdKeyKey, adminCrtKey, adminKeyKey, bootstrapTokenKey, aescbcEncryptionSecretKey, certificateKeyKey, trustdTokenKey} {
+		if len(data[key]) == 0 {
+			return fmt.Errorf("PKI secret is missing %s", key)
+		}
+	}
+
+	input.Certs = &generate.Certs{
+		OS:    &x509.PEMEncodedCertificateAndKey{Crt: data[osCrtKey], Key: data[osKeyKey]},
+		K8s:   &x509.PEMEncodedCertificateAndKey{Crt: data[k8sCrtKey], Key: data[k8sKeyKey]},
+		Etcd:  &x509.PEMEncodedCertificateAndKey{Crt: data[etcdCrtKey], Key: data[etcdKeyKey]},
+		Admin: &x509.PEMEncodedCertificateAndKey{Crt: data[adminCrtKey], Key: data[adminKeyKey]},
+	}
+	input.KubeadmTokens = &generate.KubeadmTokens{
+		BootstrapToken:         string(data[bootstrapTokenKey]),
+		AESCBCEncryptionSecret: string(data[aescbcEncryptionSecretKey]),
+		CertificateKey:         string(data[certificateKeyKey]),
+	}
+	input.TrustdInfo = &generate.TrustdInfo{Token: string(data[trustdTokenKey])}
+
+	return nil
+}
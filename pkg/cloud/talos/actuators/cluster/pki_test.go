@@ -0,0 +1,30 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+)
+
+func TestPKISecretData(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	stored, err := generate.NewInput("test", []string{"10.0.0.1"}, "1.16.2")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	data := pkiSecretData(stored)
+
+	//A later reconcile generates new CAs and tokens, which the stored ones replace
+	input, err := generate.NewInput("test", []string{"10.0.0.1", "10.0.0.2"}, "1.16.2")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(input.Certs.OS.Crt).NotTo(gomega.Equal(stored.Certs.OS.Crt))
+
+	g.Expect(applyPKISecretData(input, data)).To(gomega.Succeed())
+	g.Expect(input.Certs).To(gomega.Equal(stored.Certs))
+	g.Expect(input.KubeadmTokens).To(gomega.Equal(stored.KubeadmTokens))
+	g.Expect(input.TrustdInfo).To(gomega.Equal(stored.TrustdInfo))
+	g.Expect(input.MasterIPs).To(gomega.Equal([]string{"10.0.0.1", "10.0.0.2"}))
+
+	delete(data, trustdTokenKey)
+	g.Expect(applyPKISecretData(input, data)).To(gomega.MatchError("PKI secret is missing trustd-token"))
+}
@@ -0,0 +1,378 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	controllerError "sigs.k8s.io/cluster-api/pkg/controller/error"
+	"sigs.k8s.io/cluster-api/pkg/controller/remote"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// etcdClientPort is the port etcd serves clients on, on every control plane node
+	etcdClientPort = "2379"
+	// scaleDownRequeueAfter is how often the deletion of the machine of a removed control plane node is checked
+	scaleDownRequeueAfter = 15 * time.Second
+	// etcdMemberRemovalTimeout is how long the removal of an etcd member is retried before the scale down waits for an operator
+	etcdMemberRemovalTimeout = 10 * time.Minute
+	// skipEtcdMemberRemovalAnnotation lists the control plane indexes whose etcd members were removed by hand, comma separated
+	skipEtcdMemberRemovalAnnotation = "talos.dev/skip-etcd-member-removal"
+)
+
+// errScaleDownBlocked stops a scale down whose etcd member removal timed out, until the member is removed by hand
+var errScaleDownBlocked = errors.New("scale down is blocked on the removal of an etcd member")
+
+// mergeControlPlaneIPs grows the control plane IPs of a cluster to count, keeping the existing IPs at their index
+// and appending the allocated ones that aren't used yet, as provisioners may return the existing IPs along with the new ones.
+func mergeControlPlaneIPs(existing, allocated []string, count int) ([]string, error) {
+	ips := append([]string{}, existing...)
+	used := map[string]bool{}
+	for _, ip := range ips {
+		used[ip] = true
+	}
+
+	for _, ip := range allocated {
+		if len(ips) >= count {
+			break
+		}
+		if !used[ip] {
+			ips = append(ips, ip)
+			used[ip] = true
+		}
+	}
+
+	if len(ips) < count {
+		return nil, fmt.Errorf("only %d of %d control plane IPs were allocated", len(ips), count)
+	}
+	return ips, nil
+}
+
+// scaleDown removes the control plane nodes past count, one at a time from the highest index.
+// Each node leaves etcd before its machine is deleted, once the machine is gone its node, IP and config are released.
+// The init node is never removed.
+// Failures to remove an etcd member are recorded in the EtcdMemberRemoved condition and retried for etcdMemberRemovalTimeout,
+// then errScaleDownBlocked is returned until the index is listed in the skipEtcdMemberRemovalAnnotation of the cluster.
+func (a *ClusterActuator) scaleDown(cluster *clusterv1.Cluster, provisioner provisioners.Provisioner, input *generate.Input, masterIPs []string, count int) error {
+	if count < 1 {
+		return fmt.Errorf("cluster %s needs at least one control plane node", cluster.ObjectMeta.Name)
+	}
+
+	for index := len(masterIPs) - 1; index >= count; index-- {
+		ip := masterIPs[index]
+
+		machine, err := a.controlPlaneMachine(cluster, index)
+		if err != nil {
+			return err
+		}
+		if machine != nil && machine.ObjectMeta.DeletionTimestamp != nil {
+			return &controllerError.RequeueAfterError{RequeueAfter: scaleDownRequeueAfter}
+		}
+
+		nodes := a.listWorkloadNodes(cluster)
+		ids := nodeIdentities(machine, nodes, ip)
+		removed := talosv1.TalosClusterCondition{
+			Type:    talosv1.EtcdMemberRemoved,
+			Status:  corev1.ConditionTrue,
+			Reason:  "Removed",
+			Message: fmt.Sprintf("the etcd member of control plane node %d is removed", index),
+		}
+		if skipEtcdMemberRemoval(cluster, index) {
+			log.Printf("Skipping the removal of the etcd member of control plane node %d of cluster %s.", index, cluster.ObjectMeta.Name)
+			removed.Reason = "Skipped"
+			removed.Message = fmt.Sprintf("the etcd member of control plane node %d was removed by hand", index)
+		} else if err = removeEtcdMember(input, masterIPs[0], ids); err != nil {
+			return a.etcdMemberRemovalFailed(cluster, index, err, time.Now())
+		}
+		if err = a.setCondition(cluster, removed, time.Now()); err != nil {
+			return err
+		}
+
+		if machine != nil {
+			log.Printf("Removing control plane machine %s of cluster %s.", machine.ObjectMeta.Name, cluster.ObjectMeta.Name)
+			if err = a.controllerClient.Delete(context.Background(), machine); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+			return &controllerError.RequeueAfterError{RequeueAfter: scaleDownRequeueAfter}
+		}
+
+		//The machine controller deletes the node of a machine, unless it never got linked to it
+		a.deleteWorkloadNodes(cluster, nodes, ids)
+
+		if releaser, ok := provisioner.(provisioners.ExternalIPReleaser); ok {
+			if err = releaser.ReleaseExternalIP(cluster, a.Clientset, index, ip); err != nil {
+				return err
+			}
+		}
+
+		selector := labels.SelectorFromSet(utils.UserdataLabels(cluster, talosv1.MachineRoleControlPlane, index)).String()
+		err = a.kubeClient.CoreV1().Secrets(cluster.ObjectMeta.Namespace).DeleteCollection(nil, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return err
+		}
+		log.Printf("Removed control plane node %d (%s) of cluster %s.", index, ip, cluster.ObjectMeta.Name)
+	}
+
+	return nil
+}
+
+// skipEtcdMemberRemoval returns whether the skipEtcdMemberRemovalAnnotation of the cluster lists the control plane index
+func skipEtcdMemberRemoval(cluster *clusterv1.Cluster, index int) bool {
+	for _, value := range strings.Split(cluster.ObjectMeta.Annotations[skipEtcdMemberRemovalAnnotation], ",") {
+		if strings.TrimSpace(value) == strconv.Itoa(index) {
+			return true
+		}
+	}
+	return false
+}
+
+// etcdMemberRemovalFailed records the failure to remove the etcd member of a control plane node in the EtcdMemberRemoved condition.
+// It returns the failure while the removal is retried, and errScaleDownBlocked once it has failed for etcdMemberRemovalTimeout.
+func (a *ClusterActuator) etcdMemberRemovalFailed(cluster *clusterv1.Cluster, index int, removalErr error, now time.Time) error {
+	status, err := utils.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+
+	since := now
+	if condition := findCondition(status.Status.Conditions, talosv1.EtcdMemberRemoved); condition != nil && condition.Status == corev1.ConditionFalse {
+		since = condition.LastTransitionTime.Time
+	}
+
+	condition := talosv1.TalosClusterCondition{
+		Type:    talosv1.EtcdMemberRemoved,
+		Status:  corev1.ConditionFalse,
+		Reason:  "EtcdUnreachable",
+		Message: fmt.Sprintf("unable to remove the etcd member of control plane node %d: %v", index, removalErr),
+	}
+	blocked := now.Sub(since) >= etcdMemberRemovalTimeout
+	if blocked {
+		condition.Reason = "TimedOut"
+		condition.Message = fmt.Sprintf("gave up removing the etcd member of control plane node %d after %v: %v. Remove it by hand, then add %d to the %s annotation of the cluster",
+			index, etcdMemberRemovalTimeout, removalErr, index, skipEtcdMemberRemovalAnnotation)
+	}
+	if err = a.setCondition(cluster, condition, now); err != nil {
+		return err
+	}
+
+	if blocked {
+		return errScaleDownBlocked
+	}
+	return removalErr
+}
+
+// setCondition records a condition in the cluster status, keeping the transition time of the previous one of the same type and status
+func (a *ClusterActuator) setCondition(cluster *clusterv1.Cluster, condition talosv1.TalosClusterCondition, now time.Time) error {
+	original := cluster.DeepCopy()
+
+	err := utils.UpdateClusterProviderStatus(cluster, func(status *talosv1.TalosClusterProviderStatusStatus) {
+		condition.LastTransitionTime = metav1.NewTime(now)
+		if existing := findCondition(status.Conditions, condition.Type); existing != nil {
+			if existing.Status == condition.Status {
+				condition.LastTransitionTime = existing.LastTransitionTime
+			}
+			*existing = condition
+			return
+		}
+		status.Conditions = append(status.Conditions, condition)
+	})
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(original.Status, cluster.Status) {
+		return nil
+	}
+	return a.controllerClient.Status().Update(context.Background(), cluster)
+}
+
+// findCondition returns the condition of the given type, or nil if there is none
+func findCondition(conditions []talosv1.TalosClusterCondition, conditionType talosv1.TalosClusterConditionType) *talosv1.TalosClusterCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// controlPlaneMachine returns the machine of a control plane index of the cluster, or nil if there is none
+func (a *ClusterActuator) controlPlaneMachine(cluster *clusterv1.Cluster, index int) (*clusterv1.Machine, error) {
+	machines := &clusterv1.MachineList{}
+	listOptions := &client.ListOptions{}
+	listOptions.MatchingLabels(map[string]string{"cluster.k8s.io/cluster-name": cluster.ObjectMeta.Name})
+	listOptions.InNamespace(cluster.ObjectMeta.Namespace)
+	if err := a.controllerClient.List(context.Background(), listOptions, machines); err != nil {
+		return nil, err
+	}
+
+	for i := range machines.Items {
+		role, machineIndex, err := utils.MachineRole(&machines.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		if role != talosv1.MachineRoleWorker && machineIndex == index {
+			return &machines.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// workloadNodes returns the client of the nodes of the workload cluster, with the admin kubeconfig published for it
+func (a *ClusterActuator) workloadNodes(cluster *clusterv1.Cluster) (corev1client.NodeInterface, error) {
+	clusterClient, err := remote.NewClusterClient(a.controllerClient, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	coreClient, err := clusterClient.CoreV1()
+	if err != nil {
+		return nil, err
+	}
+	return coreClient.Nodes(), nil
+}
+
+// listWorkloadNodes lists the nodes of the workload cluster, or none if it can't be reached
+func (a *ClusterActuator) listWorkloadNodes(cluster *clusterv1.Cluster) []corev1.Node {
+	nodes, err := a.workloadNodes(cluster)
+	if err != nil {
+		log.Printf("Unable to list the nodes of cluster %s: %v", cluster.ObjectMeta.Name, err)
+		return nil
+	}
+
+	list, err := nodes.List(metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Unable to list the nodes of cluster %s: %v", cluster.ObjectMeta.Name, err)
+		return nil
+	}
+	return list.Items
+}
+
+// deleteWorkloadNodes deletes the nodes known by one of the identities from the workload cluster, logging failures
+func (a *ClusterActuator) deleteWorkloadNodes(cluster *clusterv1.Cluster, list []corev1.Node, ids map[string]bool) {
+	for _, node := range list {
+		if !ids[node.ObjectMeta.Name] {
+			continue
+		}
+
+		nodes, err := a.workloadNodes(cluster)
+		if err == nil {
+			err = nodes.Delete(node.ObjectMeta.Name, nil)
+		}
+		if err != nil && !k8serrors.IsNotFound(err) {
+			log.Printf("Unable to delete node %s of cluster %s: %v", node.ObjectMeta.Name, cluster.ObjectMeta.Name, err)
+		}
+	}
+}
+
+// nodeIdentities returns the names and addresses a control plane node is known by.
+// Its control plane IP may well be a floating IP the node itself doesn't know about, so its machine and Kubernetes node add the others.
+func nodeIdentities(machine *clusterv1.Machine, nodes []corev1.Node, ip string) map[string]bool {
+	ids := map[string]bool{ip: true}
+	if machine != nil {
+		for _, address := range machine.Status.Addresses {
+			ids[address.Address] = true
+		}
+		if machine.Status.NodeRef != nil {
+			ids[machine.Status.NodeRef.Name] = true
+		}
+	}
+
+	for _, node := range nodes {
+		known := ids[node.ObjectMeta.Name]
+		for _, address := range node.Status.Addresses {
+			if address.Type != corev1.NodeHostName && ids[address.Address] {
+				known = true
+			}
+		}
+		if !known {
+			continue
+		}
+
+		ids[node.ObjectMeta.Name] = true
+		for _, address := range node.Status.Addresses {
+			ids[address.Address] = true
+		}
+	}
+	return ids
+}
+
+// removeEtcdMember removes the etcd member of a control plane node through the etcd of the init node.
+// The client certificate is signed by the etcd CA of the cluster, and the node being already gone from etcd isn't an error.
+func removeEtcdMember(input *generate.Input, target string, ids map[string]bool) error {
+	crt, key, err := signClientCertificate(input.Certs.Etcd.Crt, input.Certs.Etcd.Key, "cluster-api-provider-talos", nil, time.Now().Add(time.Hour))
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(input.Certs.Etcd.Crt)
+
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{"https://" + net.JoinHostPort(target, etcdClientPort)},
+		DialTimeout: 5 * time.Second,
+		TLS:         &tls.Config{Certificates: []tls.Certificate{certificate}, RootCAs: roots},
+	})
+	if err != nil {
+		return err
+	}
+	//nolint: errcheck
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	members, err := c.MemberList(ctx)
+	if err != nil {
+		return err
+	}
+
+	member := etcdMember(members.Members, ids)
+	if member == nil {
+		return nil
+	}
+
+	log.Printf("Removing etcd member %s (%x) through %s.", member.Name, member.ID, target)
+	_, err = c.MemberRemove(ctx, member.ID)
+	return err
+}
+
+// etcdMember returns the member named after one of the identities or with a URL on one of them, or nil if there is none
+func etcdMember(members []*etcdserverpb.Member, ids map[string]bool) *etcdserverpb.Member {
+	for _, member := range members {
+		if ids[member.Name] {
+			return member
+		}
+		for _, raw := range append(append([]string{}, member.PeerURLs...), member.ClientURLs...) {
+			if u, err := url.Parse(raw); err == nil && ids[u.Hostname()] {
+				return member
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMergeControlPlaneIPs(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	//Provisioners returning the whole set, like the index based ones, only add the missing IPs
+	ips, err := mergeControlPlaneIPs([]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, 3)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+
+	//The existing IPs keep their index whatever order the provisioner returns its IPs in
+	ips, err = mergeControlPlaneIPs([]string{"10.0.0.2"}, []string{"10.0.0.3", "10.0.0.2"}, 2)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"10.0.0.2", "10.0.0.3"}))
+
+	ips, err = mergeControlPlaneIPs(nil, []string{"10.0.0.1"}, 1)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"10.0.0.1"}))
+
+	_, err = mergeControlPlaneIPs([]string{"10.0.0.1"}, []string{"10.0.0.1", "10.0.0.2"}, 3)
+	g.Expect(err).To(gomega.MatchError("only 2 of 3 control plane IPs were allocated"))
+}
+
+func TestEtcdMember(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	//The node behind the floating IP 203.0.113.12 only knows its private address and hostname
+	machine := &clusterv1.Machine{Status: clusterv1.MachineStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.12"}}}}
+	nodes := []corev1.Node{
+		{Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.10"}, {Type: corev1.NodeHostName, Address: "master-0"}}}},
+		{Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.12"}, {Type: corev1.NodeHostName, Address: "master-2"}}}},
+	}
+	nodes[0].ObjectMeta.Name = "master-0"
+	nodes[1].ObjectMeta.Name = "master-2"
+
+	ids := nodeIdentities(machine, nodes, "203.0.113.12")
+	g.Expect(ids).To(gomega.Equal(map[string]bool{"203.0.113.12": true, "10.0.0.12": true, "master-2": true}))
+
+	members := []*etcdserverpb.Member{
+		{ID: 1, Name: "master-0", PeerURLs: []string{"https://10.0.0.10:2380"}},
+		{ID: 2, Name: "master-1", PeerURLs: []string{"https://10.0.0.11:2380"}},
+		{ID: 3, Name: "master-2", PeerURLs: []string{"https://10.0.0.12:2380"}},
+	}
+	g.Expect(etcdMember(members, ids).ID).To(gomega.Equal(uint64(3)))
+
+	//Without a machine or node the member is still found by its URLs, and a missing one is no member at all
+	g.Expect(etcdMember(members, nodeIdentities(nil, nil, "10.0.0.11")).ID).To(gomega.Equal(uint64(2)))
+	g.Expect(etcdMember(members, nodeIdentities(nil, nil, "10.0.0.13"))).To(gomega.BeNil())
+}
+
+func TestEtcdMemberRemovalFailed(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	a := &ClusterActuator{controllerClient: fakeclient.NewFakeClientWithScheme(scheme, cluster.DeepCopy())}
+
+	condition := func() *talosv1.TalosClusterCondition {
+		stored := &clusterv1.Cluster{}
+		g.Expect(a.controllerClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, stored)).To(gomega.Succeed())
+		status, err := utils.ClusterStatusFromProviderStatus(stored.Status.ProviderStatus)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		return findCondition(status.Status.Conditions, talosv1.EtcdMemberRemoved)
+	}
+
+	//Failures are retried until the timeout, which counts from the first one
+	unreachable := errors.New("context deadline exceeded")
+	start := time.Now().Truncate(time.Second)
+	g.Expect(a.etcdMemberRemovalFailed(cluster, 2, unreachable, start)).To(gomega.Equal(unreachable))
+	g.Expect(a.etcdMemberRemovalFailed(cluster, 2, unreachable, start.Add(etcdMemberRemovalTimeout/2))).To(gomega.Equal(unreachable))
+	g.Expect(condition().Status).To(gomega.Equal(corev1.ConditionFalse))
+	g.Expect(condition().Reason).To(gomega.Equal("EtcdUnreachable"))
+	g.Expect(condition().LastTransitionTime.Time.Equal(start)).To(gomega.BeTrue())
+
+	//Past the timeout the scale down waits for the operator
+	g.Expect(a.etcdMemberRemovalFailed(cluster, 2, unreachable, start.Add(etcdMemberRemovalTimeout))).To(gomega.Equal(errScaleDownBlocked))
+	g.Expect(condition().Reason).To(gomega.Equal("TimedOut"))
+	g.Expect(condition().Message).To(gomega.ContainSubstring(skipEtcdMemberRemovalAnnotation))
+
+	//A removal starts the next timeout over
+	removed := talosv1.TalosClusterCondition{Type: talosv1.EtcdMemberRemoved, Status: corev1.ConditionTrue, Reason: "Removed"}
+	g.Expect(a.setCondition(cluster, removed, start.Add(etcdMemberRemovalTimeout*2))).To(gomega.Succeed())
+	g.Expect(condition().Status).To(gomega.Equal(corev1.ConditionTrue))
+	g.Expect(a.etcdMemberRemovalFailed(cluster, 1, unreachable, start.Add(etcdMemberRemovalTimeout*3))).To(gomega.Equal(unreachable))
+}
+
+func TestSkipEtcdMemberRemoval(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{}
+	g.Expect(skipEtcdMemberRemoval(cluster, 0)).To(gomega.BeFalse())
+
+	cluster.ObjectMeta.Annotations = map[string]string{skipEtcdMemberRemovalAnnotation: "2, 4"}
+	g.Expect(skipEtcdMemberRemoval(cluster, 2)).To(gomega.BeTrue())
+	g.Expect(skipEtcdMemberRemoval(cluster, 4)).To(gomega.BeTrue())
+	g.Expect(skipEtcdMemberRemoval(cluster, 3)).To(gomega.BeFalse())
+}
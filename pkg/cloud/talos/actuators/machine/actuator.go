@@ -18,12 +18,21 @@ package machine
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"time"
 
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/defaults"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/validation"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	controllerError "sigs.k8s.io/cluster-api/pkg/controller/error"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
@@ -36,11 +45,14 @@ const (
 //+kubebuilder:rbac:groups=cluster.k8s.io,resources=machines;machines/status;machinedeployments;machinedeployments/status;machinesets;machinesets/status;machineclasses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cluster.k8s.io,resources=clusters;clusters/status,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=nodes;events,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 // MachineActuator is responsible for performing machine reconciliation
 type MachineActuator struct {
 	Clientset        *kubernetes.Clientset
 	controllerClient client.Client
+	// nodes watches the nodes of the workload clusters, it is nil outside of the manager
+	nodes *nodeWatcher
 }
 
 // MachineActuatorParams holds parameter information for Actuator
@@ -54,17 +66,25 @@ func NewMachineActuator(mgr manager.Manager, params MachineActuatorParams) (*Mac
 		return nil, err
 	}
 
-	return &MachineActuator{Clientset: clientset, controllerClient: mgr.GetClient()}, nil
+	a := &MachineActuator{Clientset: clientset, controllerClient: mgr.GetClient(), nodes: newNodeWatcher(mgr.GetClient())}
+	if err = addNodeController(mgr, a); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
 // Create creates a machine and is invoked by the Machine Controller
 func (a *MachineActuator) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
 	log.Printf("Creating machine %v for cluster %v.", machine.Name, cluster.Name)
 
-	spec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	original := machine.DeepCopy()
+	spec, err := inheritClusterSettings(cluster, machine)
 	if err != nil {
 		return err
 	}
+	if allErrs := validation.ValidateMachineLocation(spec, field.NewPath("spec", "providerSpec", "value")); len(allErrs) != 0 {
+		return fmt.Errorf("machine %s lacks settings cluster %s does not provide: %v", machine.Name, cluster.Name, allErrs.ToAggregate())
+	}
 
 	provisioner, err := provisioners.NewProvisioner(spec.Platform.Type)
 	if err != nil {
@@ -73,17 +93,21 @@ func (a *MachineActuator) Create(ctx context.Context, cluster *clusterv1.Cluster
 
 	err = provisioner.Create(ctx, cluster, machine, a.Clientset)
 	if err != nil {
+		//Keep what the provisioner recorded before failing, e.g. the ID of an instance that is still starting
+		if statusErr := a.updateStatus(ctx, original, machine); statusErr != nil {
+			log.Printf("Unable to record the status of machine %v: %v", machine.Name, statusErr)
+		}
 		return err
 	}
 
-	return nil
+	return a.updateStatus(ctx, original, machine)
 }
 
 // Delete deletes a machine and is invoked by the Machine Controller
 func (a *MachineActuator) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
 	log.Printf("Deleting machine %v for cluster %v.", machine.Name, cluster.Name)
 
-	spec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	spec, err := inheritClusterSettings(cluster, machine)
 	if err != nil {
 		return err
 	}
@@ -105,7 +129,8 @@ func (a *MachineActuator) Delete(ctx context.Context, cluster *clusterv1.Cluster
 func (a *MachineActuator) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
 	log.Printf("Updating machine %v for cluster %v.", machine.Name, cluster.Name)
 
-	spec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	original := machine.DeepCopy()
+	spec, err := inheritClusterSettings(cluster, machine)
 	if err != nil {
 		return err
 	}
@@ -120,6 +145,18 @@ func (a *MachineActuator) Update(ctx context.Context, cluster *clusterv1.Cluster
 		return err
 	}
 
+	//Link the machine to its node in the workload cluster, checking again with backoff until the node has joined.
+	//Afterwards the node conditions are refreshed whenever the node changes, or the machine is reconciled.
+	a.linkNodeRef(cluster, machine)
+
+	err = a.updateStatus(ctx, original, machine)
+	if err != nil {
+		return err
+	}
+
+	if machine.Status.NodeRef == nil {
+		return &controllerError.RequeueAfterError{RequeueAfter: nodeRequeueAfter(machine, time.Now())}
+	}
 	return nil
 }
 
@@ -127,7 +164,7 @@ func (a *MachineActuator) Update(ctx context.Context, cluster *clusterv1.Cluster
 func (a *MachineActuator) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) (bool, error) {
 	log.Printf("Checking if machine %v for cluster %v exists.", machine.Name, cluster.Name)
 
-	spec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	spec, err := inheritClusterSettings(cluster, machine)
 	if err != nil {
 		return true, err
 	}
@@ -144,3 +181,58 @@ func (a *MachineActuator) Exists(ctx context.Context, cluster *clusterv1.Cluster
 
 	return exists, nil
 }
+
+// inheritClusterSettings decodes the provider spec of a machine, filling in the settings it inherits from the spec of its cluster, e.g. its region.
+// Machines admitted before the defaulting webhook knew their cluster lack them, they are persisted along with the provider ID by updateStatus.
+func inheritClusterSettings(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*talosv1.TalosMachineProviderSpec, error) {
+	spec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		clusterSpec = nil
+	}
+
+	inherited := spec.DeepCopy()
+	defaults.SetMachineSpecDefaults(inherited, clusterSpec)
+	if equality.Semantic.DeepEqual(spec, inherited) {
+		return spec, nil
+	}
+
+	machine.Spec.ProviderSpec.Value, err = utils.EncodeProviderObject(inherited)
+	if err != nil {
+		return nil, err
+	}
+	return inherited, nil
+}
+
+// updateStatus persists the provider ID and the provider status recorded by the provisioner, and mirrors the addresses to the machine status.
+// Settings inherited from the cluster are persisted along with the provider ID.
+func (a *MachineActuator) updateStatus(ctx context.Context, original *clusterv1.Machine, machine *clusterv1.Machine) error {
+	status, err := utils.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	if len(status.Status.Addresses) > 0 {
+		machine.Status.Addresses = status.Status.Addresses
+	}
+
+	if !equality.Semantic.DeepEqual(original.Spec.ProviderID, machine.Spec.ProviderID) || !equality.Semantic.DeepEqual(original.Spec.ProviderSpec, machine.Spec.ProviderSpec) {
+		// Updating the machine replaces it with the stored object, which still has the old status
+		machineStatus := machine.Status.DeepCopy()
+		if err := a.controllerClient.Update(ctx, machine); err != nil {
+			return err
+		}
+		machine.Status = *machineStatus
+	}
+
+	if equality.Semantic.DeepEqual(original.Status, machine.Status) {
+		return nil
+	}
+
+	now := metav1.Now()
+	machine.Status.LastUpdated = &now
+	return a.controllerClient.Status().Update(ctx, machine)
+}
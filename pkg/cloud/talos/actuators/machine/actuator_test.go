@@ -0,0 +1,167 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/fake"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	controllerError "sigs.k8s.io/cluster-api/pkg/controller/error"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCreateWithFakeProvisioner(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx := context.Background()
+	fake.Shared().Reset()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	clusterSpec, err := utils.EncodeProviderObject(&talosv1.TalosClusterProviderSpec{
+		ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1},
+		Platform:     talosv1.TalosClusterPlatformSpec{Type: "fake"},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: clusterSpec}},
+	}
+
+	machineSpec, err := utils.EncodeProviderObject(&talosv1.TalosMachineProviderSpec{
+		Role:     talosv1.MachineRoleInit,
+		Platform: talosv1.TalosMachinePlatformSpec{Type: "fake"},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-master-0", Namespace: "default"},
+		Spec:       clusterv1.MachineSpec{ProviderSpec: clusterv1.ProviderSpec{Value: machineSpec}},
+	}
+
+	c := fakeclient.NewFakeClientWithScheme(scheme, machine.DeepCopy())
+	a := &MachineActuator{controllerClient: c}
+
+	_, err = fake.Shared().AllocateExternalIPs(cluster, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	exists, err := a.Exists(ctx, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeFalse())
+
+	g.Expect(a.Create(ctx, cluster, machine)).To(gomega.Succeed())
+
+	stored := &clusterv1.Machine{}
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "test-master-0"}, stored)).To(gomega.Succeed())
+	g.Expect(stored.Spec.ProviderID).NotTo(gomega.BeNil())
+	g.Expect(*stored.Spec.ProviderID).To(gomega.HavePrefix("fake://"))
+	g.Expect(stored.Status.Addresses).To(gomega.HaveLen(2))
+	g.Expect(stored.Status.ProviderStatus).NotTo(gomega.BeNil())
+
+	exists, err = a.Exists(ctx, cluster, stored)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeTrue())
+
+	//Without a kubeconfig for the cluster, the node can't be linked yet
+	err = a.Update(ctx, cluster, stored)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&controllerError.RequeueAfterError{}))
+	g.Expect(stored.Status.NodeRef).To(gomega.BeNil())
+
+	g.Expect(a.Delete(ctx, cluster, stored)).To(gomega.Succeed())
+	g.Expect(fake.Shared().Instances()).To(gomega.BeEmpty())
+}
+
+// startingProvisioner records an instance, then fails while waiting for it to start
+type startingProvisioner struct {
+	fake.Fake
+}
+
+func (p *startingProvisioner) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	providerID := utils.ProviderID("starting", "instance-1")
+	machine.Spec.ProviderID = &providerID
+	return errors.New("timed out waiting for running instance")
+}
+
+var registerStarting sync.Once
+
+func TestCreateFailureKeepsProviderID(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx := context.Background()
+	registerStarting.Do(func() {
+		provisioners.Register("starting", func() (provisioners.Provisioner, error) { return &startingProvisioner{}, nil })
+	})
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	machineSpec, err := utils.EncodeProviderObject(&talosv1.TalosMachineProviderSpec{
+		Role:     talosv1.MachineRoleWorker,
+		Platform: talosv1.TalosMachinePlatformSpec{Type: "starting"},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde", Namespace: "default"},
+		Spec:       clusterv1.MachineSpec{ProviderSpec: clusterv1.ProviderSpec{Value: machineSpec}},
+	}
+	c := fakeclient.NewFakeClientWithScheme(scheme, machine.DeepCopy())
+	a := &MachineActuator{controllerClient: c}
+
+	//The instance is found by its ID from then on, instead of being created again
+	g.Expect(a.Create(ctx, cluster, machine)).To(gomega.MatchError("timed out waiting for running instance"))
+	stored := &clusterv1.Machine{}
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "test-workers-abcde"}, stored)).To(gomega.Succeed())
+	g.Expect(stored.Spec.ProviderID).NotTo(gomega.BeNil())
+	g.Expect(*stored.Spec.ProviderID).To(gomega.Equal(utils.ProviderID("starting", "instance-1")))
+}
+
+func TestInheritClusterSettings(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	clusterSpec, err := utils.EncodeProviderObject(&talosv1.TalosClusterProviderSpec{
+		ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1},
+		Platform:     talosv1.TalosClusterPlatformSpec{Type: "aws", AWS: &talosv1.AWSClusterConfig{Region: "us-west-2"}},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: clusterSpec}},
+	}
+
+	//The machine was admitted before its cluster, so the webhook couldn't default its region
+	machineSpec, err := utils.EncodeProviderObject(&talosv1.TalosMachineProviderSpec{
+		Role:     talosv1.MachineRoleWorker,
+		Platform: talosv1.TalosMachinePlatformSpec{Type: "aws"},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-worker-0", Namespace: "default"},
+		Spec:       clusterv1.MachineSpec{ProviderSpec: clusterv1.ProviderSpec{Value: machineSpec}},
+	}
+
+	spec, err := inheritClusterSettings(cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(spec.Platform.AWS).NotTo(gomega.BeNil())
+	g.Expect(spec.Platform.AWS.Region).To(gomega.Equal("us-west-2"))
+
+	stored, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(stored.Platform.AWS.Region).To(gomega.Equal("us-west-2"))
+
+	//Without a cluster spec to inherit from, the region stays empty
+	orphan := machine.DeepCopy()
+	orphan.Spec.ProviderSpec.Value = machineSpec
+	spec, err = inheritClusterSettings(&clusterv1.Cluster{}, orphan)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(spec.Platform.AWS.Region).To(gomega.BeEmpty())
+}
@@ -0,0 +1,124 @@
+package machine
+
+import (
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/cluster-api/pkg/controller/noderefutil"
+	"sigs.k8s.io/cluster-api/pkg/controller/remote"
+)
+
+const (
+	// nodeRequeueMin and nodeRequeueMax bound how often a machine is checked until its node has joined the workload cluster
+	nodeRequeueMin = 10 * time.Second
+	nodeRequeueMax = 5 * time.Minute
+)
+
+// nodeRequeueAfter backs off the checks for the node of a machine, waiting half as long as the machine has existed.
+// It doesn't depend on any state of the actuator, so the backoff survives restarts of the manager.
+// The node watch usually links the node first, the checks cover clusters whose kubeconfig isn't published yet.
+func nodeRequeueAfter(machine *clusterv1.Machine, now time.Time) time.Duration {
+	after := now.Sub(machine.ObjectMeta.CreationTimestamp.Time) / 2
+	if after < nodeRequeueMin {
+		return nodeRequeueMin
+	}
+	if after > nodeRequeueMax {
+		return nodeRequeueMax
+	}
+	return after
+}
+
+// listNodes lists the nodes of the workload cluster with the admin kubeconfig published by the cluster actuator
+func (a *MachineActuator) listNodes(cluster *clusterv1.Cluster) ([]corev1.Node, error) {
+	clusterClient, err := remote.NewClusterClient(a.controllerClient, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	coreClient, err := clusterClient.CoreV1()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := coreClient.Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+// linkNode points the machine at its node, with the node conditions.
+// Nodes are matched by provider ID, and by address as Talos nodes usually run without a cloud provider setting one.
+func linkNode(machine *clusterv1.Machine, nodes []corev1.Node) {
+	node := matchNode(machine, nodes)
+	if node == nil {
+		machine.Status.NodeRef = nil
+		machine.Status.Conditions = nil
+		return
+	}
+
+	machine.Status.NodeRef = &corev1.ObjectReference{
+		Kind:       "Node",
+		APIVersion: corev1.SchemeGroupVersion.String(),
+		Name:       node.ObjectMeta.Name,
+		UID:        node.ObjectMeta.UID,
+	}
+	machine.Status.Conditions = node.Status.Conditions
+}
+
+// matchNode returns the node of a machine, or nil if it hasn't joined yet
+func matchNode(machine *clusterv1.Machine, nodes []corev1.Node) *corev1.Node {
+	if machine.Spec.ProviderID != nil {
+		providerID, err := noderefutil.NewProviderID(*machine.Spec.ProviderID)
+		if err == nil {
+			for i := range nodes {
+				nodeProviderID, err := noderefutil.NewProviderID(nodes[i].Spec.ProviderID)
+				if err == nil && providerID.Equals(nodeProviderID) {
+					return &nodes[i]
+				}
+			}
+		}
+	}
+
+	addresses := map[string]bool{}
+	for _, address := range machine.Status.Addresses {
+		addresses[address.Address] = true
+	}
+	for i := range nodes {
+		for _, address := range nodes[i].Status.Addresses {
+			if address.Type != corev1.NodeHostName && addresses[address.Address] {
+				return &nodes[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// linkNodeRef links the machine to its node in the workload cluster, and makes sure the nodes of the cluster are watched.
+// The nodes are listed until the watch has caught up with them.
+// Failing to reach the workload cluster isn't an error, its API server may well be starting.
+func (a *MachineActuator) linkNodeRef(cluster *clusterv1.Cluster, machine *clusterv1.Machine) {
+	if a.nodes != nil {
+		if err := a.nodes.watch(cluster); err != nil {
+			log.Printf("Unable to watch the nodes of cluster %v yet: %v", cluster.Name, err)
+		}
+		key := types.NamespacedName{Namespace: cluster.ObjectMeta.Namespace, Name: cluster.ObjectMeta.Name}
+		if nodes, ok := a.nodes.nodes(key); ok {
+			linkNode(machine, nodes)
+			return
+		}
+	}
+
+	nodes, err := a.listNodes(cluster)
+	if err != nil {
+		log.Printf("Unable to list the nodes of cluster %v yet: %v", cluster.Name, err)
+		return
+	}
+
+	linkNode(machine, nodes)
+}
@@ -0,0 +1,65 @@
+package machine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestLinkNode(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ready := []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	nodes := []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "talos-10-0-0-2", UID: "uid-2"},
+			Status: corev1.NodeStatus{
+				Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}, {Type: corev1.NodeHostName, Address: "talos-10-0-0-2"}},
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "ip-10-0-0-3", UID: "uid-3"},
+			Spec:       corev1.NodeSpec{ProviderID: "aws:///us-east-1a/i-0123"},
+			Status:     corev1.NodeStatus{Conditions: ready},
+		},
+	}
+
+	//By provider ID, ignoring the zone
+	providerID := "aws:///us-east-1b/i-0123"
+	machine := &clusterv1.Machine{Spec: clusterv1.MachineSpec{ProviderID: &providerID}}
+	linkNode(machine, nodes)
+	g.Expect(machine.Status.NodeRef).To(gomega.Equal(&corev1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: "ip-10-0-0-3", UID: "uid-3"}))
+	g.Expect(machine.Status.Conditions).To(gomega.Equal(ready))
+
+	//By address, for nodes without a provider ID
+	machine = &clusterv1.Machine{Status: clusterv1.MachineStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeExternalIP, Address: "203.0.113.4"}, {Type: corev1.NodeInternalIP, Address: "10.0.0.2"}}}}
+	linkNode(machine, nodes)
+	g.Expect(machine.Status.NodeRef.Name).To(gomega.Equal("talos-10-0-0-2"))
+	g.Expect(machine.Status.Conditions).To(gomega.Equal(nodes[0].Status.Conditions))
+
+	//A node gone from the cluster is unlinked
+	linkNode(machine, nodes[1:])
+	g.Expect(machine.Status.NodeRef).To(gomega.BeNil())
+	g.Expect(machine.Status.Conditions).To(gomega.BeNil())
+
+	//Host names are not addresses
+	machine = &clusterv1.Machine{Status: clusterv1.MachineStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeHostName, Address: "talos-10-0-0-2"}}}}
+	linkNode(machine, nodes)
+	g.Expect(machine.Status.NodeRef).To(gomega.BeNil())
+}
+
+func TestNodeRequeueAfter(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	created := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)}}
+
+	g.Expect(nodeRequeueAfter(machine, created.Add(5*time.Second))).To(gomega.Equal(nodeRequeueMin))
+	g.Expect(nodeRequeueAfter(machine, created.Add(2*time.Minute))).To(gomega.Equal(time.Minute))
+	g.Expect(nodeRequeueAfter(machine, created.Add(time.Hour))).To(gomega.Equal(nodeRequeueMax))
+}
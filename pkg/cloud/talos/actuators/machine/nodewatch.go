@@ -0,0 +1,222 @@
+package machine
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/cluster-api/pkg/controller/remote"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// nodeResync is how often the nodes of a workload cluster are listed again, in case a watch event was missed
+const nodeResync = 10 * time.Minute
+
+// nodeWatcher watches the nodes of the workload clusters, and enqueues the machines of the nodes that change.
+// It is the source of the node controller, the machine controller of cluster-api only watches objects of the management cluster.
+type nodeWatcher struct {
+	client client.Client
+
+	mu       sync.Mutex
+	queue    workqueue.RateLimitingInterface
+	clusters map[types.NamespacedName]*clusterNodes
+}
+
+// clusterNodes holds the nodes of a workload cluster, as seen by its informer
+type clusterNodes struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+}
+
+func newNodeWatcher(c client.Client) *nodeWatcher {
+	return &nodeWatcher{client: c, clusters: map[types.NamespacedName]*clusterNodes{}}
+}
+
+// addNodeController adds the controller linking machines to their nodes whenever the nodes change.
+// It leaves the machines being deleted to the machine controller, and stops watching the nodes of deleted clusters.
+func addNodeController(mgr manager.Manager, a *MachineActuator) error {
+	c, err := controller.New("talos-node-controller", mgr, controller.Options{Reconciler: &nodeReconciler{actuator: a}})
+	if err != nil {
+		return err
+	}
+
+	if err = c.Watch(a.nodes, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+
+	return c.Watch(&source.Kind{Type: &clusterv1.Cluster{}}, handler.Funcs{
+		DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			a.nodes.unwatch(types.NamespacedName{Namespace: e.Meta.GetNamespace(), Name: e.Meta.GetName()})
+		},
+	})
+}
+
+// Start implements source.Source, the machines of changed nodes are added to the queue of the node controller
+func (w *nodeWatcher) Start(h handler.EventHandler, queue workqueue.RateLimitingInterface, prct ...predicate.Predicate) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.queue = queue
+	return nil
+}
+
+// watch starts watching the nodes of a cluster with the admin kubeconfig published by the cluster actuator, unless it is already
+func (w *nodeWatcher) watch(cluster *clusterv1.Cluster) error {
+	key := types.NamespacedName{Namespace: cluster.ObjectMeta.Namespace, Name: cluster.ObjectMeta.Name}
+	w.mu.Lock()
+	_, ok := w.clusters[key]
+	w.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	clusterClient, err := remote.NewClusterClient(w.client, cluster)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(clusterClient.RESTConfig())
+	if err != nil {
+		return err
+	}
+
+	w.start(key, clientset)
+	return nil
+}
+
+// start runs an informer on the nodes of a cluster
+func (w *nodeWatcher) start(key types.NamespacedName, clientset kubernetes.Interface) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.clusters[key]; ok {
+		return
+	}
+
+	nodes := &clusterNodes{
+		informer: coreinformers.NewNodeInformer(clientset, nodeResync, cache.Indexers{}),
+		stop:     make(chan struct{}),
+	}
+	nodes.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.enqueue(key, obj) },
+		UpdateFunc: func(old, obj interface{}) { w.enqueue(key, obj) },
+		DeleteFunc: func(obj interface{}) { w.enqueue(key, obj) },
+	})
+	w.clusters[key] = nodes
+
+	log.Printf("Watching the nodes of cluster %v.", key)
+	go nodes.informer.Run(nodes.stop)
+}
+
+// unwatch stops watching the nodes of a cluster
+func (w *nodeWatcher) unwatch(key types.NamespacedName) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if nodes, ok := w.clusters[key]; ok {
+		close(nodes.stop)
+		delete(w.clusters, key)
+	}
+}
+
+// nodes returns the nodes of a cluster, or false if they aren't watched or haven't been listed yet
+func (w *nodeWatcher) nodes(key types.NamespacedName) ([]corev1.Node, bool) {
+	w.mu.Lock()
+	nodes, ok := w.clusters[key]
+	w.mu.Unlock()
+	if !ok || !nodes.informer.HasSynced() {
+		return nil, false
+	}
+
+	list := []corev1.Node{}
+	for _, obj := range nodes.informer.GetStore().List() {
+		list = append(list, *obj.(*corev1.Node))
+	}
+	return list, true
+}
+
+// enqueue adds the machines of the cluster a node belongs to, or belonged to, to the queue
+func (w *nodeWatcher) enqueue(key types.NamespacedName, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	queue := w.queue
+	w.mu.Unlock()
+	if queue == nil {
+		return
+	}
+
+	machines := &clusterv1.MachineList{}
+	opts := client.InNamespace(key.Namespace).MatchingLabels(map[string]string{clusterv1.MachineClusterLabelName: key.Name})
+	if err := w.client.List(context.Background(), opts, machines); err != nil {
+		log.Printf("Unable to list the machines of cluster %v: %v", key, err)
+		return
+	}
+
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		linked := machine.Status.NodeRef != nil && machine.Status.NodeRef.Name == node.ObjectMeta.Name
+		if linked || matchNode(machine, []corev1.Node{*node}) != nil {
+			queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: machine.ObjectMeta.Namespace, Name: machine.ObjectMeta.Name}})
+		}
+	}
+}
+
+// nodeReconciler links the machines enqueued by the node watcher to their nodes
+type nodeReconciler struct {
+	actuator *MachineActuator
+}
+
+// Reconcile implements reconcile.Reconciler
+func (r *nodeReconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+	a := r.actuator
+
+	machine := &clusterv1.Machine{}
+	if err := a.controllerClient.Get(ctx, req.NamespacedName, machine); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if machine.ObjectMeta.DeletionTimestamp != nil {
+		return reconcile.Result{}, nil
+	}
+
+	key := types.NamespacedName{Namespace: machine.ObjectMeta.Namespace, Name: machine.ObjectMeta.Labels[clusterv1.MachineClusterLabelName]}
+	if key.Name == "" {
+		return reconcile.Result{}, nil
+	}
+	cluster := &clusterv1.Cluster{}
+	if err := a.controllerClient.Get(ctx, key, cluster); err != nil {
+		if k8serrors.IsNotFound(err) {
+			a.nodes.unwatch(key)
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	original := machine.DeepCopy()
+	a.linkNodeRef(cluster, machine)
+	return reconcile.Result{}, a.updateStatus(ctx, original, machine)
+}
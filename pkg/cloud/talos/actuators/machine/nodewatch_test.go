@@ -0,0 +1,83 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/workqueue"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestNodeWatcher(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	labels := map[string]string{clusterv1.MachineClusterLabelName: "test"}
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-master-0", Namespace: "default", Labels: labels},
+		Status:     clusterv1.MachineStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}}},
+	}
+	other := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde", Namespace: "default", Labels: labels},
+		Status:     clusterv1.MachineStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.3"}}},
+	}
+	c := fakeclient.NewFakeClientWithScheme(scheme, cluster, machine, other)
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	w := newNodeWatcher(c)
+	g.Expect(w.Start(nil, queue)).To(gomega.Succeed())
+
+	key := types.NamespacedName{Namespace: "default", Name: "test"}
+	clientset := kubefake.NewSimpleClientset()
+	w.start(key, clientset)
+	defer w.unwatch(key)
+	g.Eventually(func() bool { _, ok := w.nodes(key); return ok }).Should(gomega.BeTrue())
+
+	//Only the machine of the node that joined is enqueued
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "talos-10-0-0-2", UID: "uid-2"},
+		Status: corev1.NodeStatus{
+			Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	_, err := clientset.CoreV1().Nodes().Create(node)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Eventually(queue.Len, time.Second).Should(gomega.Equal(1))
+	item, _ := queue.Get()
+	queue.Done(item)
+	g.Expect(item).To(gomega.Equal(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test-master-0"}}))
+
+	//The node controller links it from the watched nodes, without reaching the workload cluster itself
+	r := &nodeReconciler{actuator: &MachineActuator{controllerClient: c, nodes: w}}
+	g.Eventually(func() int { nodes, _ := w.nodes(key); return len(nodes) }).Should(gomega.Equal(1))
+	_, err = r.Reconcile(item.(reconcile.Request))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	stored := &clusterv1.Machine{}
+	g.Expect(c.Get(ctx, item.(reconcile.Request).NamespacedName, stored)).To(gomega.Succeed())
+	g.Expect(stored.Status.NodeRef).NotTo(gomega.BeNil())
+	g.Expect(stored.Status.NodeRef.Name).To(gomega.Equal("talos-10-0-0-2"))
+	g.Expect(stored.Status.Conditions).To(gomega.Equal(node.Status.Conditions))
+
+	//Machines of deleted clusters stop the watch
+	g.Expect(c.Delete(ctx, cluster)).To(gomega.Succeed())
+	_, err = r.Reconcile(item.(reconcile.Request))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	_, ok := w.nodes(key)
+	g.Expect(ok).To(gomega.BeFalse())
+}
@@ -0,0 +1,277 @@
+package defaults
+
+import (
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/talos/pkg/constants"
+)
+
+const (
+	// ControlPlaneCount is the default number of control plane nodes
+	ControlPlaneCount = 3
+	// DiskSize is the default boot disk size in GB
+	DiskSize = 10
+
+	// AWSInstanceType is the default EC2 instance type
+	AWSInstanceType = "t3.small"
+	// AzureInstanceType is the default Azure VM size
+	AzureInstanceType = "Standard_D2_v3"
+	// DigitalOceanSize is the default droplet size
+	DigitalOceanSize = "s-2vcpu-4gb"
+	// GCEInstanceType is the default GCE machine type
+	GCEInstanceType = "n1-standard-1"
+	// HcloudServerType is the default Hetzner Cloud server type
+	HcloudServerType = "cx21"
+	// LibvirtURI is the default address of the libvirt daemon
+	LibvirtURI = "unix:///var/run/libvirt/libvirt-sock"
+	// LibvirtNetwork is the default libvirt network
+	LibvirtNetwork = "default"
+	// LibvirtPool is the default libvirt storage pool
+	LibvirtPool = "default"
+	// LibvirtCPUs is the default number of vCPUs of a libvirt domain
+	LibvirtCPUs = 2
+	// LibvirtMemoryMiB is the default memory of a libvirt domain in MiB
+	LibvirtMemoryMiB = 2048
+	// PacketPlan is the default Packet device plan
+	PacketPlan = "t1.small.x86"
+	// RedfishBoot is the default one-time boot source of a Redfish host
+	RedfishBoot = "pxe"
+	// VSphereCPUs is the default number of vCPUs of a vSphere VM
+	VSphereCPUs = 2
+	// VSphereMemoryMiB is the default memory of a vSphere VM in MiB
+	VSphereMemoryMiB = 2048
+)
+
+// SetClusterSpecDefaults fills in the unset fields of a Talos cluster provider spec
+func SetClusterSpecDefaults(spec *talosv1.TalosClusterProviderSpec) {
+	if spec.ControlPlane.Count == 0 {
+		spec.ControlPlane.Count = ControlPlaneCount
+	}
+	if spec.ControlPlane.K8sVersion == "" {
+		// Match the version the vendored Talos config generator was built for
+		spec.ControlPlane.K8sVersion = constants.DefaultKubernetesVersion
+	}
+
+	platform := &spec.Platform
+	if platform.Type == "libvirt" {
+		if platform.Libvirt == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.Libvirt = &talosv1.LibvirtClusterConfig{}
+		}
+		config := platform.Libvirt
+		if config.URI == "" {
+			config.URI = LibvirtURI
+		}
+		if config.Network == "" {
+			config.Network = LibvirtNetwork
+		}
+	}
+}
+
+// SetMachineSpecDefaults fills in the unset fields of a Talos machine provider spec.
+// Location settings are inherited from the spec of the owning cluster when it is known and on the same platform.
+// Free-form platform configs are left untouched.
+func SetMachineSpecDefaults(spec *talosv1.TalosMachineProviderSpec, clusterSpec *talosv1.TalosClusterProviderSpec) {
+	platform := &spec.Platform
+
+	var clusterPlatform talosv1.TalosClusterPlatformSpec
+	if clusterSpec != nil && clusterSpec.Platform.Type == platform.Type {
+		clusterPlatform = clusterSpec.Platform
+	}
+
+	switch platform.Type {
+	case "aws":
+		if platform.AWS == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.AWS = &talosv1.AWSMachineConfig{}
+		}
+		config := platform.AWS
+		if config.Region == "" && clusterPlatform.AWS != nil {
+			config.Region = clusterPlatform.AWS.Region
+		}
+		if config.Instances.Type == "" {
+			config.Instances.Type = AWSInstanceType
+		}
+		if config.Instances.Disks.Size == 0 {
+			config.Instances.Disks.Size = DiskSize
+		}
+	case "azure":
+		if platform.Azure == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.Azure = &talosv1.AzureMachineConfig{}
+		}
+		config := platform.Azure
+		if clusterPlatform.Azure != nil {
+			if config.Location == "" {
+				config.Location = clusterPlatform.Azure.Location
+			}
+			if config.ResourceGroup == "" {
+				config.ResourceGroup = clusterPlatform.Azure.ResourceGroup
+			}
+		}
+		if config.Instances.Type == "" {
+			config.Instances.Type = AzureInstanceType
+		}
+		if config.Instances.Disks.Size == 0 {
+			config.Instances.Disks.Size = DiskSize
+		}
+	case "digitalocean":
+		if platform.DigitalOcean == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.DigitalOcean = &talosv1.DigitalOceanMachineConfig{}
+		}
+		config := platform.DigitalOcean
+		if config.Region == "" && clusterPlatform.DigitalOcean != nil {
+			config.Region = clusterPlatform.DigitalOcean.Region
+		}
+		if config.Instances.Size == "" {
+			config.Instances.Size = DigitalOceanSize
+		}
+	case "docker":
+		if platform.Docker == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.Docker = &talosv1.DockerMachineConfig{}
+		}
+		config := platform.Docker
+		if clusterPlatform.Docker != nil {
+			if config.Host == "" {
+				config.Host = clusterPlatform.Docker.Host
+			}
+			if config.Network == "" {
+				config.Network = clusterPlatform.Docker.Network
+			}
+		}
+	case "gce":
+		if platform.GCE == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.GCE = &talosv1.GCEMachineConfig{}
+		}
+		config := platform.GCE
+		if config.Project == "" && clusterPlatform.GCE != nil {
+			config.Project = clusterPlatform.GCE.Project
+		}
+		if config.Instances.Type == "" {
+			config.Instances.Type = GCEInstanceType
+		}
+		if config.Instances.Disks.Size == 0 {
+			config.Instances.Disks.Size = DiskSize
+		}
+	case "hcloud":
+		if platform.Hcloud == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.Hcloud = &talosv1.HcloudMachineConfig{}
+		}
+		config := platform.Hcloud
+		if config.Location == "" && clusterPlatform.Hcloud != nil {
+			config.Location = clusterPlatform.Hcloud.Location
+		}
+		if config.Instances.Type == "" {
+			config.Instances.Type = HcloudServerType
+		}
+	case "libvirt":
+		if platform.Libvirt == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.Libvirt = &talosv1.LibvirtMachineConfig{}
+		}
+		config := platform.Libvirt
+		if clusterPlatform.Libvirt != nil {
+			if config.URI == "" {
+				config.URI = clusterPlatform.Libvirt.URI
+			}
+			if config.Network == "" {
+				config.Network = clusterPlatform.Libvirt.Network
+			}
+		}
+		if config.URI == "" {
+			config.URI = LibvirtURI
+		}
+		if config.Network == "" {
+			config.Network = LibvirtNetwork
+		}
+		if config.Pool == "" {
+			config.Pool = LibvirtPool
+		}
+		if config.Instances.CPUs == 0 {
+			config.Instances.CPUs = LibvirtCPUs
+		}
+		if config.Instances.MemoryMiB == 0 {
+			config.Instances.MemoryMiB = LibvirtMemoryMiB
+		}
+		if config.Instances.Disks.Size == 0 {
+			config.Instances.Disks.Size = DiskSize
+		}
+	case "openstack":
+		if platform.OpenStack == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.OpenStack = &talosv1.OpenStackMachineConfig{}
+		}
+		config := platform.OpenStack
+		if config.Region == "" && clusterPlatform.OpenStack != nil {
+			config.Region = clusterPlatform.OpenStack.Region
+		}
+	case "packet":
+		if platform.Packet == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.Packet = &talosv1.PacketMachineConfig{}
+		}
+		config := platform.Packet
+		if config.ProjectID == "" && clusterPlatform.Packet != nil {
+			config.ProjectID = clusterPlatform.Packet.ProjectID
+		}
+		if config.Instances.Plan == "" {
+			config.Instances.Plan = PacketPlan
+		}
+	case "redfish":
+		if platform.Redfish == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.Redfish = &talosv1.RedfishMachineConfig{}
+		}
+		if platform.Redfish.Boot == "" {
+			platform.Redfish.Boot = RedfishBoot
+		}
+	case "vsphere":
+		if platform.VSphere == nil {
+			if platform.Config != "" {
+				return
+			}
+			platform.VSphere = &talosv1.VSphereMachineConfig{}
+		}
+		config := platform.VSphere
+		if clusterPlatform.VSphere != nil {
+			if config.Server == "" {
+				config.Server = clusterPlatform.VSphere.Server
+				config.Insecure = clusterPlatform.VSphere.Insecure
+			}
+			if config.Datacenter == "" {
+				config.Datacenter = clusterPlatform.VSphere.Datacenter
+			}
+		}
+		if config.Instances.CPUs == 0 {
+			config.Instances.CPUs = VSphereCPUs
+		}
+		if config.Instances.MemoryMiB == 0 {
+			config.Instances.MemoryMiB = VSphereMemoryMiB
+		}
+	}
+}
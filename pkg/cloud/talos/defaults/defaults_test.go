@@ -0,0 +1,132 @@
+package defaults
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/talos/pkg/constants"
+)
+
+func TestSetClusterSpecDefaults(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	spec := &talosv1.TalosClusterProviderSpec{}
+	SetClusterSpecDefaults(spec)
+	g.Expect(spec.ControlPlane.Count).To(gomega.Equal(ControlPlaneCount))
+	g.Expect(spec.ControlPlane.K8sVersion).To(gomega.Equal(constants.DefaultKubernetesVersion))
+
+	spec = &talosv1.TalosClusterProviderSpec{ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1, K8sVersion: "1.15.3"}}
+	SetClusterSpecDefaults(spec)
+	g.Expect(spec.ControlPlane.Count).To(gomega.Equal(1))
+	g.Expect(spec.ControlPlane.K8sVersion).To(gomega.Equal("1.15.3"))
+
+	spec = &talosv1.TalosClusterProviderSpec{Platform: talosv1.TalosClusterPlatformSpec{Type: "libvirt"}}
+	SetClusterSpecDefaults(spec)
+	g.Expect(spec.Platform.Libvirt).To(gomega.Equal(&talosv1.LibvirtClusterConfig{URI: LibvirtURI, Network: LibvirtNetwork}))
+}
+
+func TestSetMachineSpecDefaults(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	clusterSpec := &talosv1.TalosClusterProviderSpec{Platform: talosv1.TalosClusterPlatformSpec{
+		Type:  "azure",
+		Azure: &talosv1.AzureClusterConfig{Location: "centralus", ResourceGroup: "talos"},
+	}}
+
+	spec := &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{
+		Type:  "azure",
+		Azure: &talosv1.AzureMachineConfig{Location: "westus", Instances: talosv1.AzureInstanceSpec{Image: "talos"}},
+	}}
+	SetMachineSpecDefaults(spec, clusterSpec)
+	g.Expect(spec.Platform.Azure).To(gomega.Equal(&talosv1.AzureMachineConfig{
+		Location:      "westus",
+		ResourceGroup: "talos",
+		Instances: talosv1.AzureInstanceSpec{
+			Type:  AzureInstanceType,
+			Image: "talos",
+			Disks: talosv1.DiskSpec{Size: DiskSize},
+		},
+	}))
+
+	// Cluster settings of another platform are not inherited
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{Type: "aws"}}
+	SetMachineSpecDefaults(spec, clusterSpec)
+	g.Expect(spec.Platform.AWS).To(gomega.Equal(&talosv1.AWSMachineConfig{
+		Instances: talosv1.AWSInstanceSpec{Type: AWSInstanceType, Disks: talosv1.DiskSpec{Size: DiskSize}},
+	}))
+
+	// Connection settings are inherited together
+	clusterSpec = &talosv1.TalosClusterProviderSpec{Platform: talosv1.TalosClusterPlatformSpec{
+		Type:    "vsphere",
+		VSphere: &talosv1.VSphereClusterConfig{Server: "vcenter.example.com", Insecure: true, Datacenter: "dc1"},
+	}}
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{Type: "vsphere"}}
+	SetMachineSpecDefaults(spec, clusterSpec)
+	g.Expect(spec.Platform.VSphere).To(gomega.Equal(&talosv1.VSphereMachineConfig{
+		Server:     "vcenter.example.com",
+		Insecure:   true,
+		Datacenter: "dc1",
+		Instances:  talosv1.VSphereInstanceSpec{CPUs: VSphereCPUs, MemoryMiB: VSphereMemoryMiB},
+	}))
+
+	clusterSpec = &talosv1.TalosClusterProviderSpec{Platform: talosv1.TalosClusterPlatformSpec{
+		Type:    "libvirt",
+		Libvirt: &talosv1.LibvirtClusterConfig{URI: "tcp://hypervisor:16509", Network: "talos"},
+	}}
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{
+		Type:    "libvirt",
+		Libvirt: &talosv1.LibvirtMachineConfig{Instances: talosv1.LibvirtInstanceSpec{Image: "talos.raw"}},
+	}}
+	SetMachineSpecDefaults(spec, clusterSpec)
+	g.Expect(spec.Platform.Libvirt).To(gomega.Equal(&talosv1.LibvirtMachineConfig{
+		URI:     "tcp://hypervisor:16509",
+		Network: "talos",
+		Pool:    LibvirtPool,
+		Instances: talosv1.LibvirtInstanceSpec{
+			Image:     "talos.raw",
+			CPUs:      LibvirtCPUs,
+			MemoryMiB: LibvirtMemoryMiB,
+			Disks:     talosv1.DiskSpec{Size: DiskSize},
+		},
+	}))
+
+	clusterSpec = &talosv1.TalosClusterProviderSpec{Platform: talosv1.TalosClusterPlatformSpec{
+		Type:   "docker",
+		Docker: &talosv1.DockerClusterConfig{Network: "talos"},
+	}}
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{Type: "docker"}}
+	SetMachineSpecDefaults(spec, clusterSpec)
+	g.Expect(spec.Platform.Docker).To(gomega.Equal(&talosv1.DockerMachineConfig{Network: "talos"}))
+
+	clusterSpec = &talosv1.TalosClusterProviderSpec{Platform: talosv1.TalosClusterPlatformSpec{
+		Type:         "digitalocean",
+		DigitalOcean: &talosv1.DigitalOceanClusterConfig{Region: "nyc3"},
+	}}
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{Type: "digitalocean"}}
+	SetMachineSpecDefaults(spec, clusterSpec)
+	g.Expect(spec.Platform.DigitalOcean).To(gomega.Equal(&talosv1.DigitalOceanMachineConfig{
+		Region:    "nyc3",
+		Instances: talosv1.DigitalOceanInstanceSpec{Size: "s-2vcpu-4gb"},
+	}))
+
+	clusterSpec = &talosv1.TalosClusterProviderSpec{Platform: talosv1.TalosClusterPlatformSpec{
+		Type:   "hcloud",
+		Hcloud: &talosv1.HcloudClusterConfig{Location: "nbg1"},
+	}}
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{Type: "hcloud", Hcloud: &talosv1.HcloudMachineConfig{Location: "fsn1"}}}
+	SetMachineSpecDefaults(spec, clusterSpec)
+	g.Expect(spec.Platform.Hcloud).To(gomega.Equal(&talosv1.HcloudMachineConfig{
+		Location:  "fsn1",
+		Instances: talosv1.HcloudInstanceSpec{Type: "cx21"},
+	}))
+
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{Type: "redfish"}}
+	SetMachineSpecDefaults(spec, nil)
+	g.Expect(spec.Platform.Redfish).To(gomega.Equal(&talosv1.RedfishMachineConfig{Boot: "pxe"}))
+
+	// Free-form configs are left alone
+	spec = &talosv1.TalosMachineProviderSpec{Platform: talosv1.TalosMachinePlatformSpec{Type: "gce", Config: "zone: us-central1-c"}}
+	SetMachineSpecDefaults(spec, nil)
+	g.Expect(spec.Platform.GCE).To(gomega.BeNil())
+}
@@ -5,15 +5,18 @@ import (
 	"encoding/base64"
 	"errors"
 	"log"
-	"strconv"
-	"strings"
+	"path"
 	"time"
 
 	awspkg "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
-	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
@@ -22,29 +25,10 @@ import (
 type AWS struct {
 }
 
-// ClusterInfo holds data about desired config in cluster object
-type ClusterInfo struct {
-	Region string
-}
-
-// MachineInfo holds data about desired config in cluster object
-type MachineInfo struct {
-	Region    string
-	Instances InstanceInfo
-}
-
-// InstanceInfo holds data about the instances we'll create
-type InstanceInfo struct {
-	Type           string
-	AMI            string
-	Keypair        string
-	SecurityGroups []string
-	Disks          DiskInfo
-}
-
-// DiskInfo holds disk info data
-type DiskInfo struct {
-	Size int
+func init() {
+	provisioners.Register("aws", func() (provisioners.Provisioner, error) {
+		return NewAWS()
+	})
 }
 
 //NewAWS returns an instance of the AWS provisioner
@@ -60,20 +44,27 @@ func (aws *AWS) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return err
 	}
 
-	awsConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), awsConfig)
+	awsConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	ec2client, err := client(cluster, clientset, awsConfig.Region)
+	if err != nil {
+		return err
+	}
 
-	ec2client, err := client(awsConfig.Region)
+	role, index, err := utils.MachineRole(machine)
 	if err != nil {
 		return err
 	}
 
-	//fetch floating IP if master and userdata based on machine name
+	//fetch floating IP for control plane machines
 	natIP := ""
-	if strings.Contains(machine.ObjectMeta.Name, "master") {
+	if utils.IsControlPlane(role) {
 
 		// Find public ip
-		address, err := getPublicIPByName(ec2client, machine.ObjectMeta.Name+"-ip")
+		address, err := getControlPlaneIP(ec2client, cluster, index)
 		if err != nil {
 			return err
 		}
@@ -83,14 +74,14 @@ func (aws *AWS) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 		natIP = *address.PublicIp
 	}
 
-	udConfigMap, err := utils.FetchConfigMap(cluster, machine, clientset)
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
 	if err != nil {
 		return err
 	}
-	ud := udConfigMap.Data["userdata"]
+	ud := string(udSecret.Data["userdata"])
 	udb64 := base64.StdEncoding.EncodeToString([]byte(ud))
 
-	// Create our ec2 instance and wait for it to be running
+	// Create our ec2 instance, tagged from the start so that it is found again if anything below fails
 	instanceInput := &ec2.RunInstancesInput{
 		ImageId:      awspkg.String(awsConfig.Instances.AMI),
 		InstanceType: awspkg.String(awsConfig.Instances.Type),
@@ -98,62 +89,113 @@ func (aws *AWS) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 		MaxCount:     awspkg.Int64(1),
 		KeyName:      awspkg.String(awsConfig.Instances.Keypair),
 		UserData:     awspkg.String(udb64),
+		TagSpecifications: []*ec2.TagSpecification{
+			{
+				ResourceType: awspkg.String(ec2.ResourceTypeInstance),
+				Tags: []*ec2.Tag{
+					{
+						Key:   awspkg.String("Name"),
+						Value: awspkg.String(machine.ObjectMeta.Name),
+					},
+					{
+						Key:   awspkg.String("TalosClusterName"),
+						Value: awspkg.String(cluster.ObjectMeta.Name),
+					},
+					{
+						Key:   awspkg.String("TalosClusterNamespace"),
+						Value: awspkg.String(cluster.ObjectMeta.Namespace),
+					},
+				},
+			},
+		},
 	}
 
 	res, err := ec2client.RunInstances(instanceInput)
 	if err != nil {
 		return err
 	}
-	instanceID := *res.Instances[0].InstanceId
+	instance := res.Instances[0]
+	instanceID := *instance.InstanceId
 
-	//Wait for instance to be running, then fetch and associate pre-existing Elastic IP if needed
-	if natIP != "" {
-		//TODO: Should probably attempt to call delete if running status fails
-		err = waitForStatus(instanceID, "running", ec2client)
-		if err != nil {
-			return err
-		}
-		descAddrInput := &ec2.DescribeAddressesInput{PublicIps: []*string{awspkg.String(natIP)}}
-		addresses, err := ec2client.DescribeAddresses(descAddrInput)
-		if err != nil {
-			return err
-		}
+	log.Println("[AWS] Instance created with ID:" + instanceID)
 
-		assocAddrInput := &ec2.AssociateAddressInput{
-			AllocationId:       addresses.Addresses[0].AllocationId,
-			NetworkInterfaceId: res.Instances[0].NetworkInterfaces[0].NetworkInterfaceId,
-		}
-		_, err = ec2client.AssociateAddress(assocAddrInput)
-		if err != nil {
-			return err
-		}
+	//Record the instance before waiting on it, the machine actuator persists it even if Create fails from here on
+	setProviderID(machine, instance)
+	err = utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, instance)
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+	if err != nil {
+		return err
 	}
 
-	// Add tags to the created instance
-	_, err = ec2client.CreateTags(&ec2.CreateTagsInput{
-		Resources: []*string{res.Instances[0].InstanceId},
-		Tags: []*ec2.Tag{
-			{
-				Key:   awspkg.String("Name"),
-				Value: awspkg.String(machine.ObjectMeta.Name),
-			},
-			{
-				Key:   awspkg.String("TalosClusterName"),
-				Value: awspkg.String(cluster.ObjectMeta.Name),
-			},
-		},
-	})
+	//Wait for instance to be running, then associate pre-existing Elastic IP if needed
+	if natIP == "" {
+		return nil
+	}
+
+	err = waitForStatus(instanceID, "running", ec2client)
 	if err != nil {
 		return err
 	}
+	if err = associateControlPlaneIP(ec2client, natIP, instance); err != nil {
+		return err
+	}
 
-	log.Println("[AWS] Instance created with ID:" + instanceID)
-	return nil
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: natIP})
+	})
 }
 
 //Update updates a given AWS instance.
 func (aws *AWS) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
-	return nil
+	// Fish out configs and create an ec2 client
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	awsConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	ec2client, err := client(cluster, clientset, awsConfig.Region)
+	if err != nil {
+		return err
+	}
+
+	instance, err := fetchInstance(cluster, machine, ec2client)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		return nil
+	}
+
+	setProviderID(machine, instance)
+
+	//Associate the Elastic IP of control plane instances whose creation stopped before it was
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+	if utils.IsControlPlane(role) && instance.State != nil && awspkg.StringValue(instance.State.Name) == "running" {
+		address, err := getControlPlaneIP(ec2client, cluster, index)
+		if err != nil {
+			return err
+		}
+		if address != nil && awspkg.StringValue(address.InstanceId) != awspkg.StringValue(instance.InstanceId) {
+			if err = associateControlPlaneIP(ec2client, *address.PublicIp, instance); err != nil {
+				return err
+			}
+			instance.PublicIpAddress = address.PublicIp
+		}
+	}
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, instance)
+	})
 }
 
 // Delete deletes a AWS instance.
@@ -165,10 +207,12 @@ func (aws *AWS) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return err
 	}
 
-	awsConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), awsConfig)
+	awsConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
 
-	ec2client, err := client(awsConfig.Region)
+	ec2client, err := client(cluster, clientset, awsConfig.Region)
 	if err != nil {
 		return err
 	}
@@ -182,15 +226,15 @@ func (aws *AWS) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return nil
 	}
 
-	//Find instance id
-	id, err := fetchInstanceID(cluster.ObjectMeta.Name, machine.ObjectMeta.Name, ec2client)
+	//Find instance
+	instance, err := fetchInstance(cluster, machine, ec2client)
 	if err != nil {
 		return err
 	}
 
 	//Stop Instance
 	input := &ec2.TerminateInstancesInput{
-		InstanceIds: []*string{id},
+		InstanceIds: []*string{instance.InstanceId},
 	}
 	_, err = ec2client.TerminateInstances(input)
 	if err != nil {
@@ -207,20 +251,22 @@ func (aws *AWS) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return false, err
 	}
 
-	awsConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), awsConfig)
+	awsConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return false, err
+	}
 
-	ec2client, err := client(awsConfig.Region)
+	ec2client, err := client(cluster, clientset, awsConfig.Region)
 	if err != nil {
 		return false, err
 	}
 
-	id, err := fetchInstanceID(cluster.ObjectMeta.Name, machine.ObjectMeta.Name, ec2client)
+	instance, err := fetchInstance(cluster, machine, ec2client)
 	if err != nil {
 		return false, err
 	}
 
-	if id == nil {
+	if instance == nil {
 		return false, nil
 	}
 	return true, nil
@@ -234,10 +280,12 @@ func (aws *AWS) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kuber
 		return nil, err
 	}
 
-	awsConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), awsConfig)
+	awsConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
 
-	ec2client, err := client(awsConfig.Region)
+	ec2client, err := client(cluster, clientset, awsConfig.Region)
 	if err != nil {
 		return nil, err
 	}
@@ -246,7 +294,7 @@ func (aws *AWS) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kuber
 	for i := 0; i < clusterSpec.ControlPlane.Count; i++ {
 
 		// Check if ips already exist and add to list early if so
-		flip, err := getPublicIPByName(ec2client, cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip")
+		flip, err := getControlPlaneIP(ec2client, cluster, i)
 		if err != nil {
 			return nil, err
 		}
@@ -269,7 +317,7 @@ func (aws *AWS) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kuber
 			Tags: []*ec2.Tag{
 				{
 					Key:   awspkg.String("Name"),
-					Value: awspkg.String(cluster.ObjectMeta.Name + "-master-" + strconv.Itoa(i) + "-ip"),
+					Value: awspkg.String(utils.ControlPlaneIPName(cluster, i)),
 				},
 			},
 		})
@@ -291,10 +339,12 @@ func (aws *AWS) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kub
 		return err
 	}
 
-	awsConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), awsConfig)
+	awsConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
 
-	ec2client, err := client(awsConfig.Region)
+	ec2client, err := client(cluster, clientset, awsConfig.Region)
 	if err != nil {
 		return err
 	}
@@ -302,7 +352,7 @@ func (aws *AWS) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kub
 	for i := 0; i < clusterSpec.ControlPlane.Count; i++ {
 
 		// Check if ips already exist and add to list early if so
-		flip, err := getPublicIPByName(ec2client, cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip")
+		flip, err := getControlPlaneIP(ec2client, cluster, i)
 		if err != nil {
 			return err
 		}
@@ -323,6 +373,73 @@ func (aws *AWS) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kub
 	return nil
 }
 
+// ReleaseExternalIP releases the IP of a control plane node removed by scaling down
+func (aws *AWS) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	// Fish out configs and create an ec2 client
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	awsConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
+
+	ec2client, err := client(cluster, clientset, awsConfig.Region)
+	if err != nil {
+		return err
+	}
+
+	flip, err := getControlPlaneIP(ec2client, cluster, index)
+	if err != nil {
+		return err
+	}
+
+	// Ignore if already released
+	if flip == nil || *flip.PublicIp != ip {
+		return nil
+	}
+
+	_, err = ec2client.ReleaseAddress(&ec2.ReleaseAddressInput{
+		AllocationId: flip.AllocationId,
+	})
+	return err
+}
+
+// getControlPlaneIP finds the elastic IP of a control plane machine.
+// IPs named by earlier versions after the cluster name only are renamed if they belong to the cluster, so that they are found by their new name from then on.
+func getControlPlaneIP(ec2client *ec2.EC2, cluster *clusterv1.Cluster, index int) (*ec2.Address, error) {
+	address, err := getPublicIPByName(ec2client, utils.ControlPlaneIPName(cluster, index))
+	if err != nil || address != nil {
+		return address, err
+	}
+
+	address, err = getPublicIPByName(ec2client, utils.LegacyControlPlaneIPName(cluster, index))
+	if err != nil || address == nil {
+		return nil, err
+	}
+
+	owned, err := utils.OwnsLegacyControlPlaneIP(cluster, *address.PublicIp)
+	if err != nil || !owned {
+		return nil, err
+	}
+
+	_, err = ec2client.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{address.AllocationId},
+		Tags: []*ec2.Tag{
+			{
+				Key:   awspkg.String("Name"),
+				Value: awspkg.String(utils.ControlPlaneIPName(cluster, index)),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
 // getPublicIPbyName finds the public IP object from a list of all IP objects
 func getPublicIPByName(ec2client *ec2.EC2, name string) (*ec2.Address, error) {
 	result, err := ec2client.DescribeAddresses(&ec2.DescribeAddressesInput{
@@ -345,11 +462,29 @@ func getPublicIPByName(ec2client *ec2.EC2, name string) (*ec2.Address, error) {
 	return nil, nil
 }
 
-// client generates an ec2 client to use
-func client(region string) (*ec2.EC2, error) {
-	sess, err := session.NewSession(&awspkg.Config{
-		Region: awspkg.String(region)},
-	)
+// client generates an ec2 client to use, with the credentials referenced by the cluster if any
+func client(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, region string) (*ec2.EC2, error) {
+	config := &awspkg.Config{
+		Region: awspkg.String(region),
+	}
+
+	creds, err := utils.FetchCredentials(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		accessKeyID, err := utils.CredentialsValue(creds, "aws_access_key_id")
+		if err != nil {
+			return nil, err
+		}
+		secretAccessKey, err := utils.CredentialsValue(creds, "aws_secret_access_key")
+		if err != nil {
+			return nil, err
+		}
+		config.Credentials = credentials.NewStaticCredentials(string(accessKeyID), string(secretAccessKey), string(creds["aws_session_token"]))
+	}
+
+	sess, err := session.NewSession(config)
 	if err != nil {
 		return nil, err
 	}
@@ -358,44 +493,143 @@ func client(region string) (*ec2.EC2, error) {
 	return ec2client, nil
 }
 
-//fetchInstance ID searches AWS for instance name and the cluster name tags that we add during instance creation. Returns the instance ID.
-func fetchInstanceID(clusterName string, instanceName string, client *ec2.EC2) (*string, error) {
+//fetchInstance looks up the instance of a machine, by its provider ID if set.
+//Otherwise it searches AWS for instance name and the cluster name and namespace tags that we add during instance creation. Returns the instance.
+//Instances of clusters created by earlier versions have no namespace tag, they are found for those clusters only.
+func fetchInstance(cluster *clusterv1.Cluster, machine *clusterv1.Machine, client *ec2.EC2) (*ec2.Instance, error) {
+	providerID, err := utils.ParseProviderID(machine, "aws")
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := utils.HasLegacyResourceNames(cluster)
+	if err != nil {
+		return nil, err
+	}
 
 	instanceFilters := &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   awspkg.String("instance-state-name"),
+				Values: []*string{awspkg.String("running"), awspkg.String("pending"), awspkg.String("stopped")},
+			},
+		},
+	}
+	if providerID != "" {
+		//aws:///<zone>/<instance id>
+		instanceFilters.InstanceIds = []*string{awspkg.String(path.Base(providerID))}
+	} else {
+		instanceFilters.Filters = append(instanceFilters.Filters,
 			&ec2.Filter{
 				Name: awspkg.String("tag:Name"),
 				Values: []*string{
-					awspkg.String(instanceName),
+					awspkg.String(machine.ObjectMeta.Name),
 				},
 			},
 			&ec2.Filter{
 				Name: awspkg.String("tag:TalosClusterName"),
 				Values: []*string{
-					awspkg.String(clusterName),
+					awspkg.String(cluster.ObjectMeta.Name),
 				},
 			},
-			&ec2.Filter{
-				Name:   awspkg.String("instance-state-name"),
-				Values: []*string{awspkg.String("running"), awspkg.String("pending"), awspkg.String("stopped")},
-			},
-		},
+		)
+		if !legacy {
+			instanceFilters.Filters = append(instanceFilters.Filters,
+				&ec2.Filter{
+					Name: awspkg.String("tag:TalosClusterNamespace"),
+					Values: []*string{
+						awspkg.String(cluster.ObjectMeta.Namespace),
+					},
+				},
+			)
+		}
 	}
+
 	res, err := client.DescribeInstances(instanceFilters)
 	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidInstanceID.NotFound" {
+			return nil, nil
+		}
 		return nil, err
 	}
-	if len(res.Reservations) == 0 {
+
+	instances := []*ec2.Instance{}
+	for _, reservation := range res.Reservations {
+		for _, instance := range reservation.Instances {
+			if providerID != "" || ownedBy(instance, cluster) {
+				instances = append(instances, instance)
+			}
+		}
+	}
+	if len(instances) == 0 {
 		return nil, nil
 	}
-	if len(res.Reservations[0].Instances) > 1 {
+	if len(instances) > 1 {
 		return nil, errors.New("[AWS] Multiple instances with same filter info")
 	}
 
-	return res.Reservations[0].Instances[0].InstanceId, nil
+	return instances[0], nil
 
 }
 
+//ownedBy reports whether an instance is tagged with the namespace of the cluster, or with none as those of earlier versions
+func ownedBy(instance *ec2.Instance, cluster *clusterv1.Cluster) bool {
+	for _, tag := range instance.Tags {
+		if awspkg.StringValue(tag.Key) == "TalosClusterNamespace" {
+			return awspkg.StringValue(tag.Value) == cluster.ObjectMeta.Namespace
+		}
+	}
+	return true
+}
+
+//setProviderID sets the provider ID of a machine to aws:///<zone>/<instance id>
+func setProviderID(machine *clusterv1.Machine, instance *ec2.Instance) {
+	zone := ""
+	if instance.Placement != nil {
+		zone = awspkg.StringValue(instance.Placement.AvailabilityZone)
+	}
+	providerID := utils.ProviderID("aws", "/"+zone+"/"+awspkg.StringValue(instance.InstanceId))
+	machine.Spec.ProviderID = &providerID
+}
+
+//setStatus records the ID, state, addresses and zone of an instance in the machine provider status
+func setStatus(status *talosv1.TalosMachineProviderStatusStatus, instance *ec2.Instance) {
+	status.InstanceID = awspkg.StringValue(instance.InstanceId)
+	if instance.State != nil {
+		status.InstanceState = awspkg.StringValue(instance.State.Name)
+	}
+	if instance.Placement != nil {
+		status.Zone = awspkg.StringValue(instance.Placement.AvailabilityZone)
+	}
+
+	status.Addresses = nil
+	if instance.PrivateIpAddress != nil {
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: *instance.PrivateIpAddress})
+	}
+	if instance.PublicIpAddress != nil {
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: *instance.PublicIpAddress})
+	}
+}
+
+//associateControlPlaneIP associates the pre-existing Elastic IP of a control plane node with its running instance
+func associateControlPlaneIP(ec2client *ec2.EC2, ip string, instance *ec2.Instance) error {
+	addresses, err := ec2client.DescribeAddresses(&ec2.DescribeAddressesInput{PublicIps: []*string{awspkg.String(ip)}})
+	if err != nil {
+		return err
+	}
+	if len(addresses.Addresses) == 0 {
+		return errors.New("[AWS] Elastic IP not found: " + ip)
+	}
+	if len(instance.NetworkInterfaces) == 0 {
+		return errors.New("[AWS] Instance has no network interface: " + awspkg.StringValue(instance.InstanceId))
+	}
+
+	_, err = ec2client.AssociateAddress(&ec2.AssociateAddressInput{
+		AllocationId:       addresses.Addresses[0].AllocationId,
+		NetworkInterfaceId: instance.NetworkInterfaces[0].NetworkInterfaceId,
+	})
+	return err
+}
+
 //waitForStatus polls the AWS api for a certain instance status
 //needed for attaching elastic IP after boot
 func waitForStatus(instanceID string, desiredState string, client *ec2.EC2) error {
@@ -422,3 +656,29 @@ func waitForStatus(instanceID string, desiredState string, client *ec2.EC2) erro
 		}
 	}
 }
+
+// clusterConfig returns the AWS config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.AWSClusterConfig, error) {
+	if clusterSpec.Platform.AWS != nil {
+		return clusterSpec.Platform.AWS, nil
+	}
+
+	config := &talosv1.AWSClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the AWS config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.AWSMachineConfig, error) {
+	if machineSpec.Platform.AWS != nil {
+		return machineSpec.Platform.AWS, nil
+	}
+
+	config := &talosv1.AWSMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -5,11 +5,11 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-03-01/compute"
@@ -18,8 +18,10 @@ import (
 	azuresdk "github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/Azure/go-autorest/autorest/to"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
-	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
@@ -28,37 +30,17 @@ import (
 type Az struct {
 }
 
-// ClusterInfo holds data about desired config in cluster object
-type ClusterInfo struct {
-	Location      string
-	ResourceGroup string
-}
-
-// MachineInfo holds data about desired config in machine object
-type MachineInfo struct {
-	Location      string
-	ResourceGroup string
-	Instances     InstanceInfo
-}
-
-// InstanceInfo holds data about the instances we'll create
-type InstanceInfo struct {
-	Type    string
-	Image   string
-	Network string
-	Subnet  string
-	Disks   DiskInfo
-}
-
-// DiskInfo holds disk info data
-type DiskInfo struct {
-	Size int
-}
-
 // Session is an object representing session for subscription
 type Session struct {
 	SubscriptionID string
 	Authorizer     autorest.Authorizer
+	BaseURI        string
+}
+
+func init() {
+	provisioners.Register("azure", func() (provisioners.Provisioner, error) {
+		return NewAz()
+	})
 }
 
 // NewAz returns an instance of the Azure provisioner
@@ -74,11 +56,23 @@ func (azure *Az) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return err
 	}
 
-	azureConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), azureConfig)
+	azureConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	session, err := newSession(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
 
 	// Dig out the subnet for our nic
-	subnet, err := getSubnetByName(ctx, azureConfig)
+	subnet, err := getSubnetByName(ctx, session, azureConfig)
 	if err != nil {
 		return err
 	}
@@ -90,8 +84,8 @@ func (azure *Az) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 	}
 
 	// Find the public IP we want to use if necessary
-	if !strings.Contains(machine.ObjectMeta.Name, "worker") {
-		publicIPObject, err := getPublicIPByName(ctx, machine.ObjectMeta.Name+"-ip", azureConfig.ResourceGroup)
+	if utils.IsControlPlane(role) {
+		publicIPObject, err := getControlPlaneIP(ctx, session, cluster, index, azureConfig.ResourceGroup)
 		if err != nil {
 			return err
 		}
@@ -106,22 +100,20 @@ func (azure *Az) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 	}
 
 	// Create a network interface for our VM to use (with flip attached if necessary)
+	name := utils.MachineResourceName(cluster, machine)
 	nic := network.Interface{
-		Name:     to.StringPtr(machine.ObjectMeta.Name + "-nic"),
+		Name:     to.StringPtr(name + "-nic"),
 		Location: to.StringPtr(azureConfig.Location),
 		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
 			IPConfigurations: &[]network.InterfaceIPConfiguration{
 				{
-					Name:                                     to.StringPtr(machine.ObjectMeta.Name + "-ip-config"),
+					Name:                                     to.StringPtr(name + "-ip-config"),
 					InterfaceIPConfigurationPropertiesFormat: nicIPConfigProperties,
 				},
 			},
 		},
 	}
-	nicClient, err := nicclient()
-	if err != nil {
-		return err
-	}
+	nicClient := session.nicclient()
 	nicfuture, err := nicClient.CreateOrUpdate(ctx, azureConfig.ResourceGroup, *nic.Name, nic)
 	if err != nil {
 		return err
@@ -136,11 +128,11 @@ func (azure *Az) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 	}
 
 	// Pull down userdata and b64 encode it
-	udConfigMap, err := utils.FetchConfigMap(cluster, machine, clientset)
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
 	if err != nil {
 		return err
 	}
-	ud := udConfigMap.Data["userdata"]
+	ud := string(udSecret.Data["userdata"])
 	udb64 := base64.StdEncoding.EncodeToString([]byte(ud))
 
 	// Specify dummy val pass. We don't it anyways but it's required.
@@ -158,7 +150,7 @@ func (azure *Az) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 				ImageReference: &compute.ImageReference{ID: to.StringPtr(azureConfig.Instances.Image)},
 				OsDisk: &compute.OSDisk{
 					OsType:       compute.Linux,
-					Name:         to.StringPtr(machine.ObjectMeta.Name + "-os-disk"),
+					Name:         to.StringPtr(name + "-os-disk"),
 					CreateOption: compute.DiskCreateOptionTypesFromImage,
 					DiskSizeGB:   to.Int32Ptr(int32(azureConfig.Instances.Disks.Size)),
 				},
@@ -182,24 +174,89 @@ func (azure *Az) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 		},
 	}
 
-	vmClient, err := vmclient()
-	if err != nil {
-		return err
-	}
+	vmClient := session.vmclient()
 
-	_, err = vmClient.CreateOrUpdate(ctx, azureConfig.ResourceGroup, machine.ObjectMeta.Name, vm)
+	_, err = vmClient.CreateOrUpdate(ctx, azureConfig.ResourceGroup, name, vm)
 	if err != nil {
 		return err
 	}
 
-	log.Println("[Azure] Instance created: " + machine.ObjectMeta.Name)
+	log.Println("[Azure] Instance created: " + name)
 
-	return nil
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceState = "Creating"
+		status.Zone = azureConfig.Location
+		status.Addresses = nicAddresses(&nicObject, nicIPConfigProperties.PublicIPAddress)
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
 }
 
 //Update updates a given Azure instance.
 func (azure *Az) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
-	return nil
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	azureConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	session, err := newSession(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	resourceGroup, name, err := vmLocation(ctx, session, cluster, machine, azureConfig)
+	if err != nil {
+		return err
+	}
+
+	vmClient := session.vmclient()
+	vm, err := getVMByName(ctx, vmClient, resourceGroup, name)
+	if err != nil {
+		return err
+	}
+
+	// The VM is created asynchronously, so its resource ID is only known from here on
+	if vm.ID != nil {
+		providerID := utils.ProviderID("azure", *vm.ID)
+		machine.Spec.ProviderID = &providerID
+	}
+
+	nicClient := session.nicclient()
+	nic, err := nicClient.Get(ctx, resourceGroup, name+"-nic", "")
+	if err != nil {
+		return err
+	}
+
+	// The nic only references its public IP, so look up the address itself
+	var publicIP *network.PublicIPAddress
+	if nic.InterfacePropertiesFormat != nil && nic.IPConfigurations != nil {
+		for _, ipConfig := range *nic.IPConfigurations {
+			if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil || ipConfig.PublicIPAddress == nil || ipConfig.PublicIPAddress.ID == nil {
+				continue
+			}
+
+			ipClient := session.ipclient()
+			idSlice := strings.Split(*ipConfig.PublicIPAddress.ID, "/")
+			ip, err := ipClient.Get(ctx, resourceGroup, idSlice[len(idSlice)-1], "")
+			if err != nil {
+				return err
+			}
+			publicIP = &ip
+		}
+	}
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		if vm.VirtualMachineProperties != nil {
+			status.InstanceID = to.String(vm.VMID)
+			status.InstanceState = to.String(vm.ProvisioningState)
+		}
+		status.Zone = azureConfig.Location
+		status.Addresses = nicAddresses(&nic, publicIP)
+	})
 }
 
 // Delete deletes a Azure instance.
@@ -209,15 +266,24 @@ func (azure *Az) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return err
 	}
 
-	azureConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), azureConfig)
+	azureConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
 
-	// Cleanup VM
-	vmClient, err := vmclient()
+	session, err := newSession(cluster, clientset)
 	if err != nil {
 		return err
 	}
-	vmfuture, err := vmClient.Delete(ctx, azureConfig.ResourceGroup, machine.ObjectMeta.Name)
+
+	resourceGroup, name, err := vmLocation(ctx, session, cluster, machine, azureConfig)
+	if err != nil {
+		return err
+	}
+
+	// Cleanup VM
+	vmClient := session.vmclient()
+	vmfuture, err := vmClient.Delete(ctx, resourceGroup, name)
 	if err != nil {
 		return err
 	}
@@ -229,21 +295,15 @@ func (azure *Az) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine
 	}
 
 	// Cleanup os disk
-	disksClient, err := disksclient()
-	if err != nil {
-		return err
-	}
-	_, err = disksClient.Delete(ctx, azureConfig.ResourceGroup, machine.ObjectMeta.Name+"-os-disk")
+	disksClient := session.disksclient()
+	_, err = disksClient.Delete(ctx, resourceGroup, name+"-os-disk")
 	if err != nil {
 		return err
 	}
 
 	// Cleanup nic
-	nicClient, err := nicclient()
-	if err != nil {
-		return err
-	}
-	_, err = nicClient.Delete(ctx, azureConfig.ResourceGroup, machine.ObjectMeta.Name+"-nic")
+	nicClient := session.nicclient()
+	_, err = nicClient.Delete(ctx, resourceGroup, name+"-nic")
 	if err != nil {
 		return err
 	}
@@ -260,16 +320,25 @@ func (azure *Az) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return false, err
 	}
 
-	azureConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), azureConfig)
+	azureConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return false, err
+	}
 
-	vmClient, err := vmclient()
+	session, err := newSession(cluster, clientset)
 	if err != nil {
-		return true, err
+		return false, err
 	}
 
-	// If there's an error from retrieving the VM by name, assume it doesn't exist
-	_, err = getVMByName(ctx, vmClient, azureConfig.ResourceGroup, machine.ObjectMeta.Name)
+	resourceGroup, name, err := vmLocation(ctx, session, cluster, machine, azureConfig)
+	if err != nil {
+		return false, err
+	}
+
+	vmClient := session.vmclient()
+
+	// If there's an error from retrieving the VM, assume it doesn't exist
+	_, err = getVMByName(ctx, vmClient, resourceGroup, name)
 	if err != nil {
 		return false, nil
 	}
@@ -285,19 +354,23 @@ func (azure *Az) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kube
 		return nil, err
 	}
 
-	azureConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), azureConfig)
-	client, err := ipclient()
+	azureConfig, err := clusterConfig(clusterSpec)
 	if err != nil {
 		return nil, err
 	}
 
+	session, err := newSession(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+	client := session.ipclient()
+
 	ctx := context.Background()
 	floatingIPs := []string{}
 	for i := 0; i < clusterSpec.ControlPlane.Count; i++ {
 
 		// Check if ips already exist and add to list early if so
-		flip, err := getPublicIPByName(ctx, cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip", azureConfig.ResourceGroup)
+		flip, err := getControlPlaneIP(ctx, session, cluster, i, azureConfig.ResourceGroup)
 		if err != nil {
 			return nil, err
 		}
@@ -310,7 +383,7 @@ func (azure *Az) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kube
 		result, err := client.CreateOrUpdate(
 			ctx,
 			azureConfig.ResourceGroup,
-			cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip",
+			utils.ControlPlaneIPName(cluster, i),
 			network.PublicIPAddress{
 				Sku: &network.PublicIPAddressSku{
 					Name: network.PublicIPAddressSkuNameBasic,
@@ -345,17 +418,29 @@ func (azure *Az) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *ku
 		return err
 	}
 
-	azureConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), azureConfig)
-	client, err := ipclient()
+	azureConfig, err := clusterConfig(clusterSpec)
 	if err != nil {
 		return err
 	}
 
+	session, err := newSession(cluster, clientset)
+	if err != nil {
+		return err
+	}
+	client := session.ipclient()
+
 	ctx := context.Background()
 
 	for i := 0; i < clusterSpec.ControlPlane.Count; i++ {
-		_, err := client.Delete(ctx, azureConfig.ResourceGroup, cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip")
+		flip, err := getControlPlaneIP(ctx, session, cluster, i, azureConfig.ResourceGroup)
+		if err != nil {
+			return err
+		}
+		if flip == nil {
+			continue
+		}
+
+		_, err = client.Delete(ctx, azureConfig.ResourceGroup, *flip.Name)
 		if err != nil {
 			return err
 		}
@@ -363,13 +448,41 @@ func (azure *Az) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *ku
 	return nil
 }
 
-// getSubnetByName finds a given subnet (required for input along with network)
-func getSubnetByName(ctx context.Context, azureConfig *MachineInfo) (*network.Subnet, error) {
-	client, err := subnetclient()
+// ReleaseExternalIP deletes the IP of a control plane node removed by scaling down
+func (azure *Az) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	azureConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
+
+	session, err := newSession(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	flip, err := getControlPlaneIP(ctx, session, cluster, index, azureConfig.ResourceGroup)
+	if err != nil {
+		return err
+	}
+	if flip == nil || flip.PublicIPAddressPropertiesFormat == nil || flip.PublicIPAddressPropertiesFormat.IPAddress == nil || *flip.PublicIPAddressPropertiesFormat.IPAddress != ip {
+		return nil
 	}
 
+	_, err = session.ipclient().Delete(ctx, azureConfig.ResourceGroup, *flip.Name)
+	return err
+}
+
+// getSubnetByName finds a given subnet (required for input along with network)
+func getSubnetByName(ctx context.Context, session *Session, azureConfig *talosv1.AzureMachineConfig) (*network.Subnet, error) {
+	client := session.subnetclient()
+
 	subnet, err := client.Get(ctx, azureConfig.ResourceGroup, azureConfig.Instances.Network, azureConfig.Instances.Subnet, "")
 	if err != nil {
 		return nil, err
@@ -378,13 +491,30 @@ func getSubnetByName(ctx context.Context, azureConfig *MachineInfo) (*network.Su
 	return &subnet, nil
 }
 
-// getPublicIPbyName finds the public IP object from a list of all IP objects
-func getPublicIPByName(ctx context.Context, name string, resourceGroup string) (*network.PublicIPAddress, error) {
-	client, err := ipclient()
-	if err != nil {
+// getControlPlaneIP finds the public IP of a control plane machine.
+// IPs can't be renamed, so the ones of clusters created by earlier versions are found by their legacy name, as long as they belong to the cluster.
+func getControlPlaneIP(ctx context.Context, session *Session, cluster *clusterv1.Cluster, index int, resourceGroup string) (*network.PublicIPAddress, error) {
+	flip, err := getPublicIPByName(ctx, session, utils.ControlPlaneIPName(cluster, index), resourceGroup)
+	if err != nil || flip != nil {
+		return flip, err
+	}
+
+	flip, err = getPublicIPByName(ctx, session, utils.LegacyControlPlaneIPName(cluster, index), resourceGroup)
+	if err != nil || flip == nil || flip.PublicIPAddressPropertiesFormat == nil || flip.PublicIPAddressPropertiesFormat.IPAddress == nil {
 		return nil, err
 	}
 
+	owned, err := utils.OwnsLegacyControlPlaneIP(cluster, *flip.PublicIPAddressPropertiesFormat.IPAddress)
+	if err != nil || !owned {
+		return nil, err
+	}
+	return flip, nil
+}
+
+// getPublicIPbyName finds the public IP object from a list of all IP objects
+func getPublicIPByName(ctx context.Context, session *Session, name string, resourceGroup string) (*network.PublicIPAddress, error) {
+	client := session.ipclient()
+
 	//Dump all public IPs created and iterate to find our desired IP
 	list, err := client.ListComplete(ctx, resourceGroup)
 	if err != nil {
@@ -406,11 +536,8 @@ func getPublicIPByName(ctx context.Context, name string, resourceGroup string) (
 }
 
 // getPublicIPbyIP finds the public IP object from a list of all IP objects
-func getPublicIPbyIP(ctx context.Context, ipAddress string, resourceGroup string) (*network.PublicIPAddress, error) {
-	client, err := ipclient()
-	if err != nil {
-		return nil, err
-	}
+func getPublicIPbyIP(ctx context.Context, session *Session, ipAddress string, resourceGroup string) (*network.PublicIPAddress, error) {
+	client := session.ipclient()
 
 	//Dump all public IPs created and iterate to find our desired IP
 	list, err := client.ListComplete(ctx, resourceGroup)
@@ -432,6 +559,51 @@ func getPublicIPbyIP(ctx context.Context, ipAddress string, resourceGroup string
 	return nil, nil
 }
 
+// nicAddresses returns the private addresses of a nic, followed by the given public IP
+func nicAddresses(nic *network.Interface, publicIP *network.PublicIPAddress) []corev1.NodeAddress {
+	addresses := []corev1.NodeAddress{}
+	if nic.InterfacePropertiesFormat != nil && nic.IPConfigurations != nil {
+		for _, ipConfig := range *nic.IPConfigurations {
+			if ipConfig.InterfaceIPConfigurationPropertiesFormat != nil && ipConfig.PrivateIPAddress != nil {
+				addresses = append(addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: *ipConfig.PrivateIPAddress})
+			}
+		}
+	}
+	if publicIP != nil && publicIP.PublicIPAddressPropertiesFormat != nil && publicIP.IPAddress != nil {
+		addresses = append(addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: *publicIP.IPAddress})
+	}
+	return addresses
+}
+
+// vmLocation returns the resource group and name of the VM of a machine, its nic and disk being named after it.
+// They are parsed from the provider ID azure://<resource id> if set, and taken from the machine config otherwise.
+// VMs are then named after the machine and its cluster, or after the machine only for clusters created by earlier versions.
+func vmLocation(ctx context.Context, session *Session, cluster *clusterv1.Cluster, machine *clusterv1.Machine, azureConfig *talosv1.AzureMachineConfig) (string, string, error) {
+	providerID, err := utils.ParseProviderID(machine, "azure")
+	if err != nil {
+		return "", "", err
+	}
+	if providerID == "" {
+		name := utils.MachineResourceName(cluster, machine)
+		legacy, err := utils.HasLegacyResourceNames(cluster)
+		if err != nil {
+			return "", "", err
+		}
+		if legacy {
+			if _, err = getVMByName(ctx, session.vmclient(), azureConfig.ResourceGroup, name); err != nil {
+				name = machine.ObjectMeta.Name
+			}
+		}
+		return azureConfig.ResourceGroup, name, nil
+	}
+
+	resource, err := azuresdk.ParseResourceID(providerID)
+	if err != nil {
+		return "", "", err
+	}
+	return resource.ResourceGroup, resource.ResourceName, nil
+}
+
 // getVMByName finds the VM given a machine name
 func getVMByName(ctx context.Context, vmClient *compute.VirtualMachinesClient, resourceGroup string, vmName string) (*compute.VirtualMachine, error) {
 	vm, err := vmClient.Get(ctx, resourceGroup, vmName, "")
@@ -442,77 +614,113 @@ func getVMByName(ctx context.Context, vmClient *compute.VirtualMachinesClient, r
 	return &vm, nil
 }
 
-// fetchCreds creates a new authorizer and parses out the credentials file. Used by various clients for creation
-func fetchCreds() (autorest.Authorizer, map[string]interface{}, error) {
-	authorizer, err := auth.NewAuthorizerFromFile(azuresdk.PublicCloud.ResourceManagerEndpoint)
+// newSession creates the session used by the various clients, with the credentials referenced by the cluster if any.
+// Otherwise the credentials file at AZURE_AUTH_LOCATION is used.
+func newSession(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) (*Session, error) {
+	creds, err := utils.FetchCredentials(cluster, clientset)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	credFile, err := ioutil.ReadFile(os.Getenv("AZURE_AUTH_LOCATION"))
+	var credFile []byte
+	if creds != nil {
+		credFile, err = utils.CredentialsValue(creds, "azure-auth.json")
+	} else {
+		credFile, err = ioutil.ReadFile(os.Getenv("AZURE_AUTH_LOCATION"))
+	}
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	credMap := make(map[string]interface{})
-	err = json.Unmarshal(credFile, &credMap)
-	if err != nil {
-		return nil, nil, err
+	// The credentials file is the output of `az ad sp create-for-rbac --sdk-auth`, its endpoints select the Azure cloud
+	credMap := struct {
+		ClientID                string `json:"clientId"`
+		ClientSecret            string `json:"clientSecret"`
+		SubscriptionID          string `json:"subscriptionId"`
+		TenantID                string `json:"tenantId"`
+		ActiveDirectoryEndpoint string `json:"activeDirectoryEndpointUrl"`
+		ResourceManagerEndpoint string `json:"resourceManagerEndpointUrl"`
+	}{}
+	if err = json.Unmarshal(credFile, &credMap); err != nil {
+		return nil, fmt.Errorf("[Azure] Unable to parse credentials: %v", err)
+	}
+	if credMap.SubscriptionID == "" {
+		return nil, errors.New("[Azure] Credentials are missing the subscription ID")
 	}
-	return authorizer, credMap, nil
-}
 
-// Creates client for use in disk ops
-func disksclient() (*compute.DisksClient, error) {
-	authorizer, credMap, err := fetchCreds()
+	config := auth.NewClientCredentialsConfig(credMap.ClientID, credMap.ClientSecret, credMap.TenantID)
+	if credMap.ActiveDirectoryEndpoint != "" {
+		config.AADEndpoint = credMap.ActiveDirectoryEndpoint
+	}
+	if credMap.ResourceManagerEndpoint != "" {
+		config.Resource = credMap.ResourceManagerEndpoint
+	}
+
+	authorizer, err := config.Authorizer()
 	if err != nil {
 		return nil, err
 	}
-	disksClient := compute.NewDisksClient(credMap["subscriptionId"].(string))
-	disksClient.Authorizer = authorizer
-	return &disksClient, nil
+
+	return &Session{SubscriptionID: credMap.SubscriptionID, Authorizer: authorizer, BaseURI: config.Resource}, nil
+}
+
+// Creates client for use in disk ops
+func (session *Session) disksclient() *compute.DisksClient {
+	disksClient := compute.NewDisksClientWithBaseURI(session.BaseURI, session.SubscriptionID)
+	disksClient.Authorizer = session.Authorizer
+	return &disksClient
 }
 
 // Creates client for use in public IP ops
-func ipclient() (*network.PublicIPAddressesClient, error) {
-	authorizer, credMap, err := fetchCreds()
-	if err != nil {
-		return nil, err
-	}
-	ipClient := network.NewPublicIPAddressesClient(credMap["subscriptionId"].(string))
-	ipClient.Authorizer = authorizer
-	return &ipClient, nil
+func (session *Session) ipclient() *network.PublicIPAddressesClient {
+	ipClient := network.NewPublicIPAddressesClientWithBaseURI(session.BaseURI, session.SubscriptionID)
+	ipClient.Authorizer = session.Authorizer
+	return &ipClient
 }
 
 // Creates client for use in NIC ops
-func nicclient() (*network.InterfacesClient, error) {
-	authorizer, credMap, err := fetchCreds()
-	if err != nil {
-		return nil, err
-	}
-	nicClient := network.NewInterfacesClient(credMap["subscriptionId"].(string))
-	nicClient.Authorizer = authorizer
-	return &nicClient, nil
+func (session *Session) nicclient() *network.InterfacesClient {
+	nicClient := network.NewInterfacesClientWithBaseURI(session.BaseURI, session.SubscriptionID)
+	nicClient.Authorizer = session.Authorizer
+	return &nicClient
 }
 
 // Creates client for use in subnet ops
-func subnetclient() (*network.SubnetsClient, error) {
-	authorizer, credMap, err := fetchCreds()
-	if err != nil {
+func (session *Session) subnetclient() *network.SubnetsClient {
+	subnetClient := network.NewSubnetsClientWithBaseURI(session.BaseURI, session.SubscriptionID)
+	subnetClient.Authorizer = session.Authorizer
+	return &subnetClient
+}
+
+// Creates client for use in VM ops
+func (session *Session) vmclient() *compute.VirtualMachinesClient {
+	vmClient := compute.NewVirtualMachinesClientWithBaseURI(session.BaseURI, session.SubscriptionID)
+	vmClient.Authorizer = session.Authorizer
+	return &vmClient
+}
+
+// clusterConfig returns the Azure config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.AzureClusterConfig, error) {
+	if clusterSpec.Platform.Azure != nil {
+		return clusterSpec.Platform.Azure, nil
+	}
+
+	config := &talosv1.AzureClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
 		return nil, err
 	}
-	subnetClient := network.NewSubnetsClient(credMap["subscriptionId"].(string))
-	subnetClient.Authorizer = authorizer
-	return &subnetClient, nil
+	return config, nil
 }
 
-// Creates client for use in VM ops
-func vmclient() (*compute.VirtualMachinesClient, error) {
-	authorizer, credMap, err := fetchCreds()
-	if err != nil {
+// machineConfig returns the Azure config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.AzureMachineConfig, error) {
+	if machineSpec.Platform.Azure != nil {
+		return machineSpec.Platform.Azure, nil
+	}
+
+	config := &talosv1.AzureMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
 		return nil, err
 	}
-	vmClient := compute.NewVirtualMachinesClient(credMap["subscriptionId"].(string))
-	vmClient.Authorizer = authorizer
-	return &vmClient, nil
+	return config, nil
 }
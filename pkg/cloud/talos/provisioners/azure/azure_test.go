@@ -0,0 +1,62 @@
+package azure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	azuresdk "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestNewSessionEndpoints(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "azure")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	spec, err := utils.EncodeProviderObject(&talosv1.TalosClusterProviderSpec{
+		Platform: talosv1.TalosClusterPlatformSpec{Type: "azure"},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cluster := &clusterv1.Cluster{Spec: clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: spec}}}
+
+	for _, tc := range []struct {
+		name    string
+		file    string
+		baseURI string
+	}{
+		{
+			name:    "public cloud",
+			file:    `{"clientId": "id", "clientSecret": "secret", "subscriptionId": "sub", "tenantId": "tenant"}`,
+			baseURI: azuresdk.PublicCloud.ResourceManagerEndpoint,
+		},
+		{
+			name: "sovereign cloud",
+			file: `{"clientId": "id", "clientSecret": "secret", "subscriptionId": "sub", "tenantId": "tenant",
+				"activeDirectoryEndpointUrl": "https://login.microsoftonline.de",
+				"resourceManagerEndpointUrl": "https://management.microsoftazure.de/"}`,
+			baseURI: azuresdk.GermanCloud.ResourceManagerEndpoint,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+
+			path := filepath.Join(dir, "azure-auth.json")
+			g.Expect(ioutil.WriteFile(path, []byte(tc.file), 0600)).To(gomega.Succeed())
+			os.Setenv("AZURE_AUTH_LOCATION", path)
+			defer os.Unsetenv("AZURE_AUTH_LOCATION")
+
+			session, err := newSession(cluster, nil)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(session.SubscriptionID).To(gomega.Equal("sub"))
+			g.Expect(session.BaseURI).To(gomega.Equal(tc.baseURI))
+			g.Expect(session.vmclient().BaseURI).To(gomega.Equal(tc.baseURI))
+		})
+	}
+}
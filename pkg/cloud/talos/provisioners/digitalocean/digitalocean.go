@@ -0,0 +1,447 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/godo"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// activeTimeout is how long to wait for masters to become active before assigning their reserved IP
+const activeTimeout = 600 * time.Second
+
+// pollInterval is how often the droplet status is checked while waiting
+var pollInterval = 3 * time.Second
+
+// DigitalOcean represents a provider for DigitalOcean.
+type DigitalOcean struct {
+}
+
+func init() {
+	provisioners.Register("digitalocean", func() (provisioners.Provisioner, error) {
+		return NewDigitalOcean()
+	})
+}
+
+// NewDigitalOcean returns an instance of the DigitalOcean provisioner
+func NewDigitalOcean() (*DigitalOcean, error) {
+	return &DigitalOcean{}, nil
+}
+
+// Create creates a droplet from the custom Talos image, assigning a reserved IP to masters.
+func (do *DigitalOcean) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	doConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(ctx, cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	droplet, err := createDroplet(ctx, client, utils.MachineResourceName(cluster, machine), clusterTag(cluster), doConfig, string(udSecret.Data["userdata"]))
+	if err != nil {
+		return err
+	}
+
+	providerID := utils.ProviderID("digitalocean", strconv.Itoa(droplet.ID))
+	machine.Spec.ProviderID = &providerID
+
+	//Wait for masters to be active, assign reserved ip
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+
+	var reservedIP string
+	if utils.IsControlPlane(role) {
+		status, err := utils.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+		if err != nil {
+			return err
+		}
+		if index >= len(status.Status.ControlPlaneIPs) {
+			return fmt.Errorf("[DigitalOcean] No reserved IP allocated for control plane index %d", index)
+		}
+		reservedIP = status.Status.ControlPlaneIPs[index]
+
+		if droplet, err = waitForStatus(ctx, client, droplet.ID, "active"); err != nil {
+			return err
+		}
+		if _, _, err = client.FloatingIPActions.Assign(ctx, reservedIP, droplet.ID); err != nil {
+			return err
+		}
+	}
+
+	log.Println("[DigitalOcean] Droplet created with id: " + strconv.Itoa(droplet.ID))
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, droplet)
+		if reservedIP != "" {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: reservedIP})
+		}
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+}
+
+// Update refreshes the provider ID and status of a droplet.
+func (do *DigitalOcean) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	client, err := newClient(ctx, cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	droplet, err := fetchDroplet(ctx, client, cluster, machine)
+	if err != nil {
+		return err
+	}
+	if droplet == nil {
+		return nil
+	}
+
+	providerID := utils.ProviderID("digitalocean", strconv.Itoa(droplet.ID))
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, droplet)
+	})
+}
+
+// Delete deletes a droplet, which also unassigns its reserved IP.
+func (do *DigitalOcean) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	client, err := newClient(ctx, cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	droplet, err := fetchDroplet(ctx, client, cluster, machine)
+	if err != nil {
+		return err
+	}
+	if droplet == nil {
+		return nil
+	}
+
+	resp, err := client.Droplets.Delete(ctx, droplet.ID)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// Exists returns whether or not a droplet is present.
+func (do *DigitalOcean) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	client, err := newClient(ctx, cluster, clientset)
+	if err != nil {
+		return false, err
+	}
+
+	droplet, err := fetchDroplet(ctx, client, cluster, machine)
+	if err != nil {
+		return false, err
+	}
+	return droplet != nil, nil
+}
+
+// AllocateExternalIPs creates reserved IPs for the control plane nodes.
+// The ones recorded in the cluster status are kept, so growing the control plane only creates the missing IPs.
+func (do *DigitalOcean) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := utils.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return nil, err
+	}
+	existing := status.Status.ControlPlaneIPs
+	if len(existing) >= clusterSpec.ControlPlane.Count {
+		return existing[:clusterSpec.ControlPlane.Count], nil
+	}
+
+	doConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	client, err := newClient(ctx, cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := allocateReservedIPs(ctx, client, doConfig.Region, clusterSpec.ControlPlane.Count-len(existing))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]string{}, existing...), ips...), nil
+}
+
+// ReleaseExternalIP releases the reserved IP of a control plane node removed by scaling down
+func (do *DigitalOcean) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	ctx := context.Background()
+	client, err := newClient(ctx, cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	return releaseReservedIPs(ctx, client, []string{ip})
+}
+
+// DeAllocateExternalIPs releases the reserved IPs recorded in the cluster status
+// Note: Reserved IPs can't be labelled, so the cluster status is the only record of which ones belong to the cluster.
+func (do *DigitalOcean) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	status, err := utils.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client, err := newClient(ctx, cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	return releaseReservedIPs(ctx, client, status.Status.ControlPlaneIPs)
+}
+
+// createDroplet creates a droplet with the userdata and cluster tag, from the custom image given by ID or name
+func createDroplet(ctx context.Context, client *godo.Client, name string, tag string, config *talosv1.DigitalOceanMachineConfig, userdata string) (*godo.Droplet, error) {
+	image, err := resolveImage(ctx, client, config.Instances.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := append([]string{tag}, config.Instances.Tags...)
+
+	droplet, _, err := client.Droplets.Create(ctx, &godo.DropletCreateRequest{
+		Name:     name,
+		Region:   config.Region,
+		Size:     config.Instances.Size,
+		Image:    image,
+		UserData: userdata,
+		Tags:     tags,
+		VPCUUID:  config.Instances.VPC,
+	})
+	return droplet, err
+}
+
+// resolveImage returns the custom image with the given ID, or the one with the given name
+func resolveImage(ctx context.Context, client *godo.Client, image string) (godo.DropletCreateImage, error) {
+	if id, err := strconv.Atoi(image); err == nil {
+		return godo.DropletCreateImage{ID: id}, nil
+	}
+
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		images, resp, err := client.Images.ListUser(ctx, opt)
+		if err != nil {
+			return godo.DropletCreateImage{}, err
+		}
+		for _, candidate := range images {
+			if candidate.Name == image {
+				return godo.DropletCreateImage{ID: candidate.ID}, nil
+			}
+		}
+		if opt.Page, err = nextPage(resp); err != nil || opt.Page == 0 {
+			if err == nil {
+				err = fmt.Errorf("[DigitalOcean] No custom image named %q", image)
+			}
+			return godo.DropletCreateImage{}, err
+		}
+	}
+}
+
+// fetchDroplet looks up the droplet of a machine, by its provider ID if set, and by name among the droplets tagged with its cluster otherwise
+func fetchDroplet(ctx context.Context, client *godo.Client, cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*godo.Droplet, error) {
+	providerID, err := utils.ParseProviderID(machine, "digitalocean")
+	if err != nil {
+		return nil, err
+	}
+	if providerID != "" {
+		id, err := strconv.Atoi(providerID)
+		if err != nil {
+			return nil, fmt.Errorf("[DigitalOcean] Invalid droplet ID %q: %v", providerID, err)
+		}
+		droplet, resp, err := client.Droplets.Get(ctx, id)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return droplet, err
+	}
+
+	name := utils.MachineResourceName(cluster, machine)
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		droplets, resp, err := client.Droplets.ListByTag(ctx, clusterTag(cluster), opt)
+		if err != nil {
+			return nil, err
+		}
+		for i := range droplets {
+			if droplets[i].Name == name {
+				return &droplets[i], nil
+			}
+		}
+		if opt.Page, err = nextPage(resp); err != nil || opt.Page == 0 {
+			return nil, err
+		}
+	}
+}
+
+// clusterTag returns the tag of the droplets of a cluster
+func clusterTag(cluster *clusterv1.Cluster) string {
+	return "talos-cluster:" + utils.ClusterResourceName(cluster)
+}
+
+// waitForStatus polls the DigitalOcean api until a droplet has the given status, and returns it
+func waitForStatus(ctx context.Context, client *godo.Client, id int, desiredStatus string) (*godo.Droplet, error) {
+	deadline := time.Now().Add(activeTimeout)
+	for {
+		droplet, _, err := client.Droplets.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if droplet.Status == desiredStatus {
+			return droplet, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("[DigitalOcean] Timed out waiting for active droplet")
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// allocateReservedIPs creates one reserved IP per control plane node in the region, deleting them again if one fails
+func allocateReservedIPs(ctx context.Context, client *godo.Client, region string, count int) ([]string, error) {
+	ips := []string{}
+	for index := 0; index < count; index++ {
+		fip, _, err := client.FloatingIPs.Create(ctx, &godo.FloatingIPCreateRequest{Region: region})
+		if err != nil {
+			if releaseErr := releaseReservedIPs(ctx, client, ips); releaseErr != nil {
+				log.Printf("[DigitalOcean] Unable to release reserved IPs %v: %v", ips, releaseErr)
+			}
+			return nil, err
+		}
+		ips = append(ips, fip.IP)
+	}
+	return ips, nil
+}
+
+// releaseReservedIPs deletes the given reserved IPs, skipping the ones already gone
+func releaseReservedIPs(ctx context.Context, client *godo.Client, ips []string) error {
+	for _, ip := range ips {
+		resp, err := client.FloatingIPs.Delete(ctx, ip)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextPage returns the page following the one of the response, or 0 on the last page
+func nextPage(resp *godo.Response) (int, error) {
+	if resp == nil || resp.Links == nil || resp.Links.IsLastPage() {
+		return 0, nil
+	}
+	page, err := resp.Links.CurrentPage()
+	if err != nil {
+		return 0, err
+	}
+	return page + 1, nil
+}
+
+// setStatus records the ID, status, addresses and region of a droplet in the machine provider status
+func setStatus(status *talosv1.TalosMachineProviderStatusStatus, droplet *godo.Droplet) {
+	status.InstanceID = strconv.Itoa(droplet.ID)
+	status.InstanceState = droplet.Status
+	if droplet.Region != nil {
+		status.Zone = droplet.Region.Slug
+	}
+
+	status.Addresses = nil
+	if droplet.Networks == nil {
+		return
+	}
+	for _, ip := range droplet.Networks.V4 {
+		addressType := corev1.NodeInternalIP
+		if ip.Type == "public" {
+			addressType = corev1.NodeExternalIP
+		}
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: addressType, Address: ip.IPAddress})
+	}
+}
+
+// newClient creates a DigitalOcean API client, with the token referenced by the cluster if any and DIGITALOCEAN_ACCESS_TOKEN otherwise
+func newClient(ctx context.Context, cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) (*godo.Client, error) {
+	creds, err := utils.FetchCredentials(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	token := os.Getenv("DIGITALOCEAN_ACCESS_TOKEN")
+	if creds != nil {
+		value, err := utils.CredentialsValue(creds, "access-token")
+		if err != nil {
+			return nil, err
+		}
+		token = string(value)
+	}
+	if token == "" {
+		return nil, errors.New("[DigitalOcean] DIGITALOCEAN_ACCESS_TOKEN is not set")
+	}
+
+	return godo.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))), nil
+}
+
+// clusterConfig returns the DigitalOcean config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.DigitalOceanClusterConfig, error) {
+	if clusterSpec.Platform.DigitalOcean != nil {
+		return clusterSpec.Platform.DigitalOcean, nil
+	}
+
+	config := &talosv1.DigitalOceanClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the DigitalOcean config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.DigitalOceanMachineConfig, error) {
+	if machineSpec.Platform.DigitalOcean != nil {
+		return machineSpec.Platform.DigitalOcean, nil
+	}
+
+	config := &talosv1.DigitalOceanMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,206 @@
+package digitalocean
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// newTestClient points a DigitalOcean client at a fake API served by mux
+func newTestClient(t *testing.T, mux *http.ServeMux) (*godo.Client, func()) {
+	server := httptest.NewServer(mux)
+	client := godo.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = baseURL
+	return client, server.Close
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint: errcheck
+}
+
+func TestReservedIPs(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	created := []string{}
+	deleted := []string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/floating_ips", func(w http.ResponseWriter, r *http.Request) {
+		req := &godo.FloatingIPCreateRequest{}
+		g.Expect(json.NewDecoder(r.Body).Decode(req)).To(gomega.Succeed())
+		g.Expect(req.Region).To(gomega.Equal("ams3"))
+
+		//The account only has room for three reserved IPs
+		if len(created) == 3 {
+			reply(w, http.StatusUnprocessableEntity, map[string]string{"id": "unprocessable_entity", "message": "reserved IP limit reached"})
+			return
+		}
+		ip := fmt.Sprintf("203.0.113.%d", 10+len(created))
+		created = append(created, ip)
+		reply(w, http.StatusAccepted, map[string]interface{}{"floating_ip": map[string]interface{}{"ip": ip}})
+	})
+	mux.HandleFunc("/v2/floating_ips/", func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).To(gomega.Equal(http.MethodDelete))
+		ip := r.URL.Path[len("/v2/floating_ips/"):]
+		if ip == "203.0.113.99" {
+			reply(w, http.StatusNotFound, map[string]string{"id": "not_found"})
+			return
+		}
+		deleted = append(deleted, ip)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	client, done := newTestClient(t, mux)
+	defer done()
+	ctx := context.Background()
+
+	ips, err := allocateReservedIPs(ctx, client, "ams3", 2)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"203.0.113.10", "203.0.113.11"}))
+
+	//Released IPs that are already gone are skipped
+	g.Expect(releaseReservedIPs(ctx, client, append(ips, "203.0.113.99"))).To(gomega.Succeed())
+	g.Expect(deleted).To(gomega.Equal([]string{"203.0.113.10", "203.0.113.11"}))
+
+	//A failed allocation releases the IPs created so far
+	deleted = nil
+	_, err = allocateReservedIPs(ctx, client, "ams3", 2)
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(deleted).To(gomega.Equal([]string{"203.0.113.12"}))
+}
+
+func TestFetchDroplet(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	name := utils.MachineResourceName(cluster, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde"}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/droplets", func(w http.ResponseWriter, r *http.Request) {
+		//Only the droplets of the cluster, on two pages, the machine being on the second one
+		if r.URL.Query().Get("tag_name") != clusterTag(cluster) {
+			reply(w, http.StatusOK, map[string]interface{}{"droplets": []map[string]interface{}{}})
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			reply(w, http.StatusOK, map[string]interface{}{
+				"droplets": []map[string]interface{}{{"id": 2, "name": name}},
+				"links":    map[string]interface{}{"pages": map[string]string{"prev": "/v2/droplets?page=1&per_page=200"}},
+			})
+			return
+		}
+		reply(w, http.StatusOK, map[string]interface{}{
+			"droplets": []map[string]interface{}{{"id": 1, "name": "test-master-0"}},
+			"links":    map[string]interface{}{"pages": map[string]string{"next": "/v2/droplets?page=2&per_page=200", "last": "/v2/droplets?page=2&per_page=200"}},
+		})
+	})
+	mux.HandleFunc("/v2/droplets/2", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]interface{}{"droplet": map[string]interface{}{"id": 2, "name": name, "status": "active"}})
+	})
+	mux.HandleFunc("/v2/droplets/3", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusNotFound, map[string]string{"id": "not_found"})
+	})
+
+	client, done := newTestClient(t, mux)
+	defer done()
+	ctx := context.Background()
+
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde", Namespace: "default"}}
+	droplet, err := fetchDroplet(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(droplet.ID).To(gomega.Equal(2))
+
+	machine.ObjectMeta.Name = "test-workers-fghij"
+	droplet, err = fetchDroplet(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(droplet).To(gomega.BeNil())
+
+	//Not the droplet of a namesake cluster in another namespace
+	namesake := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "other"}}
+	droplet, err = fetchDroplet(ctx, client, namesake, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde", Namespace: "other"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(droplet).To(gomega.BeNil())
+
+	providerID := utils.ProviderID("digitalocean", "2")
+	machine.Spec.ProviderID = &providerID
+	droplet, err = fetchDroplet(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(droplet.Name).To(gomega.Equal(name))
+
+	droplet, err = waitForStatus(ctx, client, 2, "active")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(droplet.Status).To(gomega.Equal("active"))
+
+	//A droplet deleted out of band is gone
+	providerID = utils.ProviderID("digitalocean", "3")
+	droplet, err = fetchDroplet(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(droplet).To(gomega.BeNil())
+}
+
+func TestResolveImage(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/images", func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.URL.Query().Get("private")).To(gomega.Equal("true"))
+		reply(w, http.StatusOK, map[string]interface{}{
+			"images": []map[string]interface{}{{"id": 5001, "name": "talos-v0.3.0"}, {"id": 5002, "name": "talos-v0.4.0"}},
+		})
+	})
+
+	client, done := newTestClient(t, mux)
+	defer done()
+	ctx := context.Background()
+
+	image, err := resolveImage(ctx, client, "talos-v0.4.0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(image).To(gomega.Equal(godo.DropletCreateImage{ID: 5002}))
+
+	image, err = resolveImage(ctx, client, "5001")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(image).To(gomega.Equal(godo.DropletCreateImage{ID: 5001}))
+
+	_, err = resolveImage(ctx, client, "talos-v0.5.0")
+	g.Expect(err).To(gomega.MatchError(`[DigitalOcean] No custom image named "talos-v0.5.0"`))
+}
+
+func TestSetStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	status := &talosv1.TalosMachineProviderStatusStatus{}
+	setStatus(status, &godo.Droplet{
+		ID:     3164444,
+		Status: "active",
+		Region: &godo.Region{Slug: "ams3"},
+		Networks: &godo.Networks{V4: []godo.NetworkV4{
+			{IPAddress: "10.110.0.2", Type: "private"},
+			{IPAddress: "198.51.100.4", Type: "public"},
+		}},
+	})
+
+	g.Expect(status.InstanceID).To(gomega.Equal("3164444"))
+	g.Expect(status.InstanceState).To(gomega.Equal("active"))
+	g.Expect(status.Zone).To(gomega.Equal("ams3"))
+	g.Expect(status.Addresses).To(gomega.Equal([]corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.110.0.2"},
+		{Type: corev1.NodeExternalIP, Address: "198.51.100.4"},
+	}))
+}
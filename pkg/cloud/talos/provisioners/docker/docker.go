@@ -0,0 +1,505 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// ownedLabel marks the containers of Talos clusters, the same way osctl cluster does
+	ownedLabel = "talos.owned"
+	// clusterLabel holds the name of the cluster a container belongs to
+	clusterLabel = "talos.cluster.name"
+	// clusterNamespaceLabel holds the namespace of the cluster a container belongs to
+	clusterNamespaceLabel = "talos.cluster.namespace"
+)
+
+// Docker represents a provider for Talos in Docker.
+type Docker struct {
+}
+
+func init() {
+	provisioners.Register("docker", func() (provisioners.Provisioner, error) {
+		return NewDocker()
+	})
+}
+
+// NewDocker returns an instance of the Docker provisioner
+func NewDocker() (*Docker, error) {
+	return &Docker{}, nil
+}
+
+// Create runs a privileged Talos container, passing the userdata through its environment.
+func (docker *Docker) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	dockerConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	//Control plane nodes get the address allocated to them for the cluster
+	address, err := controlPlaneAddress(cluster, machine)
+	if err != nil {
+		return err
+	}
+
+	cli, err := newClient(dockerConfig.Host)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	id, err := runContainer(ctx, cli, cluster, utils.MachineResourceName(cluster, machine), dockerConfig, string(udSecret.Data["userdata"]), address)
+	if err != nil {
+		return err
+	}
+
+	if err = setStatus(ctx, cli, machine, id); err != nil {
+		return err
+	}
+
+	log.Println("[Docker] Container created with id: " + id)
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+}
+
+// Update refreshes the provider ID and status of a container.
+func (docker *Docker) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	dockerConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	cli, err := newClient(dockerConfig.Host)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	id, err := containerID(cluster, machine)
+	if err != nil {
+		return err
+	}
+	if err = setStatus(ctx, cli, machine, id); client.IsErrContainerNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Delete removes a container along with its anonymous volumes.
+func (docker *Docker) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	dockerConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	cli, err := newClient(dockerConfig.Host)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	id, err := containerID(cluster, machine)
+	if err != nil {
+		return err
+	}
+	info, err := cli.ContainerInspect(ctx, id)
+	if client.IsErrContainerNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return cli.ContainerRemove(ctx, info.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+}
+
+// Exists returns whether or not a container is present.
+func (docker *Docker) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+
+	dockerConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return false, err
+	}
+
+	cli, err := newClient(dockerConfig.Host)
+	if err != nil {
+		return false, err
+	}
+	defer cli.Close()
+
+	id, err := containerID(cluster, machine)
+	if err != nil {
+		return false, err
+	}
+	_, err = cli.ContainerInspect(ctx, id)
+	if client.IsErrContainerNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AllocateExternalIPs picks a static address per control plane node from the IPAM config of the network.
+// Note: Docker has no reservations, the addresses are taken from the top of the subnet, away from the ones it hands out dynamically
+// and from the ones recorded in the status of the other clusters on the network.
+func (docker *Docker) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved, err := reservedAddresses(clientset, cluster, dockerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := newClient(dockerConfig.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	return allocateAddresses(context.Background(), cli, cluster, dockerConfig.Network, clusterSpec.ControlPlane.Count, reserved)
+}
+
+// DeAllocateExternalIPs is a no-op, the addresses are freed along with the containers
+func (docker *Docker) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	return nil
+}
+
+// runContainer creates and starts a Talos container the way osctl cluster does, pulling the image if needed
+func runContainer(ctx context.Context, cli *client.Client, cluster *clusterv1.Cluster, name string, config *talosv1.DockerMachineConfig, userdata, address string) (string, error) {
+	nanoCPUs := int64(0)
+	if config.Instances.CPUs != "" {
+		cpus, err := strconv.ParseFloat(config.Instances.CPUs, 64)
+		if err != nil {
+			return "", err
+		}
+		nanoCPUs = int64(cpus * 1e9)
+	}
+
+	containerConfig := &container.Config{
+		Hostname: name,
+		Image:    config.Instances.Image,
+		Env:      []string{"PLATFORM=container", "USERDATA=" + base64.StdEncoding.EncodeToString([]byte(userdata))},
+		Labels: map[string]string{
+			ownedLabel:            "true",
+			clusterLabel:          cluster.ObjectMeta.Name,
+			clusterNamespaceLabel: cluster.ObjectMeta.Namespace,
+		},
+		Volumes: map[string]struct{}{
+			"/var/lib/containerd": {},
+			"/var/lib/kubelet":    {},
+			"/etc/cni":            {},
+			"/run":                {},
+		},
+	}
+	hostConfig := &container.HostConfig{
+		Privileged:  true,
+		SecurityOpt: []string{"seccomp:unconfined"},
+		Resources: container.Resources{
+			NanoCPUs: nanoCPUs,
+			Memory:   config.Instances.MemoryMiB << 20,
+		},
+	}
+	endpoint := &network.EndpointSettings{}
+	if address != "" {
+		containerConfig.Volumes["/var/lib/etcd"] = struct{}{}
+		endpoint.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: address}
+	}
+	networkConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{config.Network: endpoint},
+	}
+
+	resp, err := cli.ContainerCreate(ctx, containerConfig, hostConfig, networkConfig, name)
+	if client.IsErrImageNotFound(err) {
+		if err = pullImage(ctx, cli, config.Instances.Image); err != nil {
+			return "", err
+		}
+		resp, err = cli.ContainerCreate(ctx, containerConfig, hostConfig, networkConfig, name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+}
+
+// pullImage pulls an image and waits for the pull to complete
+func pullImage(ctx context.Context, cli *client.Client, image string) error {
+	log.Printf("[Docker] Pulling image %s", image)
+
+	reader, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(ioutil.Discard, reader)
+	return err
+}
+
+// setStatus records the provider ID, state and addresses of a container in the machine
+func setStatus(ctx context.Context, cli *client.Client, machine *clusterv1.Machine, id string) error {
+	info, err := cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	providerID := utils.ProviderID("docker", info.ID)
+	machine.Spec.ProviderID = &providerID
+
+	addresses := []string{}
+	if info.NetworkSettings != nil {
+		for _, endpoint := range info.NetworkSettings.Networks {
+			if endpoint != nil && endpoint.IPAddress != "" {
+				addresses = append(addresses, endpoint.IPAddress)
+			}
+		}
+	}
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = info.ID
+		if info.State != nil {
+			status.InstanceState = info.State.Status
+		}
+		status.Addresses = nil
+		for _, ip := range addresses {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip})
+		}
+	})
+}
+
+// reservedAddresses returns the control plane addresses recorded in the status of the other clusters on the same Docker network.
+// Unlike the addresses of containers, they are known before the containers of a cluster are created.
+func reservedAddresses(clientset kubernetes.Interface, cluster *clusterv1.Cluster, config *talosv1.DockerClusterConfig) ([]string, error) {
+	//The clientset has no typed client for clusters, list them through the API paths of cluster-api
+	gv := clusterv1.SchemeGroupVersion
+	raw, err := clientset.CoreV1().RESTClient().Get().AbsPath("/apis", gv.Group, gv.Version, "clusters").DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	clusters := &clusterv1.ClusterList{}
+	if err = json.Unmarshal(raw, clusters); err != nil {
+		return nil, err
+	}
+
+	reserved := []string{}
+	for _, other := range clusters.Items {
+		if other.ObjectMeta.Name == cluster.ObjectMeta.Name && other.ObjectMeta.Namespace == cluster.ObjectMeta.Namespace {
+			continue
+		}
+		//Clusters that can't be decoded can't be on the network either
+		spec, err := utils.ClusterProviderFromSpec(other.Spec.ProviderSpec)
+		if err != nil || spec.Platform.Type != "docker" {
+			continue
+		}
+		otherConfig, err := clusterConfig(spec)
+		if err != nil || otherConfig.Host != config.Host || otherConfig.Network != config.Network {
+			continue
+		}
+		status, err := utils.ClusterStatusFromProviderStatus(other.Status.ProviderStatus)
+		if err != nil {
+			continue
+		}
+		reserved = append(reserved, status.Status.ControlPlaneIPs...)
+	}
+	return reserved, nil
+}
+
+// allocateAddresses returns the highest addresses of the IPv4 subnet of the network that are outside its dynamic range,
+// skipping the gateway, the reserved addresses and the addresses of containers that belong to other clusters
+func allocateAddresses(ctx context.Context, cli *client.Client, cluster *clusterv1.Cluster, networkName string, count int, reserved []string) ([]string, error) {
+	resource, err := cli.NetworkInspect(ctx, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ipam *network.IPAMConfig
+	for i := range resource.IPAM.Config {
+		if _, subnet, err := net.ParseCIDR(resource.IPAM.Config[i].Subnet); err == nil && subnet.IP.To4() != nil {
+			ipam = &resource.IPAM.Config[i]
+			break
+		}
+	}
+	if ipam == nil {
+		return nil, fmt.Errorf("[Docker] Network %s has no IPv4 subnet", networkName)
+	}
+	_, subnet, _ := net.ParseCIDR(ipam.Subnet)
+
+	var dynamic *net.IPNet
+	if ipam.IPRange != "" {
+		if _, dynamic, err = net.ParseCIDR(ipam.IPRange); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := filters.NewArgs()
+	filter.Add("network", resource.Name)
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: filter})
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{ipam.Gateway: true}
+	for _, ip := range reserved {
+		used[ip] = true
+	}
+	for _, c := range containers {
+		if ownedBy(c.Labels, cluster) || c.NetworkSettings == nil {
+			continue
+		}
+		for _, endpoint := range c.NetworkSettings.Networks {
+			if endpoint != nil {
+				used[endpoint.IPAddress] = true
+			}
+		}
+	}
+
+	ones, bits := subnet.Mask.Size()
+	base := ipToUint32(subnet.IP)
+	ips := []string{}
+	//Skip the broadcast address, and never go down to the network address
+	for n := base + (1 << uint(bits-ones)) - 2; n > base && len(ips) < count; n-- {
+		ip := uint32ToIP(n)
+		if used[ip.String()] || (dynamic != nil && dynamic.Contains(ip)) {
+			continue
+		}
+		ips = append(ips, ip.String())
+	}
+	if len(ips) < count {
+		return nil, fmt.Errorf("[Docker] Network %s has no room for %d control plane addresses", networkName, count)
+	}
+	return ips, nil
+}
+
+// controlPlaneAddress returns the address allocated to a control plane machine, or an empty string for workers
+func controlPlaneAddress(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (string, error) {
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return "", err
+	}
+	if !utils.IsControlPlane(role) {
+		return "", nil
+	}
+
+	status, err := utils.ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return "", err
+	}
+	if index >= len(status.Status.ControlPlaneIPs) {
+		return "", fmt.Errorf("[Docker] No control plane IP allocated for index %d", index)
+	}
+	return status.Status.ControlPlaneIPs[index], nil
+}
+
+// containerID returns the container ID of the provider ID of a machine, or the cluster-keyed name of its container if it has none
+func containerID(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (string, error) {
+	id, err := utils.ParseProviderID(machine, "docker")
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return utils.MachineResourceName(cluster, machine), nil
+	}
+	return id, nil
+}
+
+// newClient connects to the given Docker host, or to the one of the Docker environment variables
+func newClient(host string) (*client.Client, error) {
+	if host == "" {
+		return client.NewEnvClient()
+	}
+	return client.NewClient(host, client.DefaultVersion, nil, nil)
+}
+
+// clusterConfig returns the Docker config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.DockerClusterConfig, error) {
+	if clusterSpec.Platform.Docker != nil {
+		return clusterSpec.Platform.Docker, nil
+	}
+
+	config := &talosv1.DockerClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the Docker config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.DockerMachineConfig, error) {
+	if machineSpec.Platform.Docker != nil {
+		return machineSpec.Platform.Docker, nil
+	}
+
+	config := &talosv1.DockerMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIP(n uint32) net.IP {
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// ownedBy returns whether the labels of a container mark it as part of the cluster.
+// Containers created by earlier versions have no namespace label, and are matched by the cluster name only.
+func ownedBy(labels map[string]string, cluster *clusterv1.Cluster) bool {
+	namespace, ok := labels[clusterNamespaceLabel]
+	return labels[clusterLabel] == cluster.ObjectMeta.Name && (!ok || namespace == cluster.ObjectMeta.Namespace)
+}
@@ -0,0 +1,361 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// fakeDaemon serves the parts of the Docker API used by the provisioner, with one network and its containers in memory
+type fakeDaemon struct {
+	network    types.NetworkResource
+	images     map[string]bool
+	containers map[string]*types.ContainerJSON
+	labels     map[string]map[string]string
+	env        map[string][]string
+}
+
+func newFakeDaemon() *fakeDaemon {
+	return &fakeDaemon{
+		network: types.NetworkResource{
+			Name: "talos",
+			ID:   "f00d",
+			IPAM: network.IPAM{Config: []network.IPAMConfig{
+				{Subnet: "fd00::/64"},
+				{Subnet: "10.5.0.0/24", IPRange: "10.5.0.0/25", Gateway: "10.5.0.1"},
+			}},
+		},
+		images:     map[string]bool{},
+		containers: map[string]*types.ContainerJSON{},
+		labels:     map[string]map[string]string{},
+		env:        map[string][]string{},
+	}
+}
+
+func (f *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && path == "/networks/"+f.network.Name:
+		f.reply(w, f.network)
+	case r.Method == http.MethodPost && path == "/images/create":
+		f.images[r.URL.Query().Get("fromImage")+":"+r.URL.Query().Get("tag")] = true
+		f.reply(w, map[string]string{"status": "Downloaded newer image"})
+	case r.Method == http.MethodPost && path == "/containers/create":
+		f.create(w, r)
+	case r.Method == http.MethodGet && path == "/containers/json":
+		list := []types.Container{}
+		for id, c := range f.containers {
+			list = append(list, types.Container{
+				ID:              id,
+				Labels:          f.labels[id],
+				NetworkSettings: &types.SummaryNetworkSettings{Networks: c.NetworkSettings.Networks},
+			})
+		}
+		f.reply(w, list)
+	case len(parts) >= 2 && parts[0] == "containers":
+		c := f.lookup(parts[1])
+		if c == nil {
+			w.WriteHeader(http.StatusNotFound)
+			f.reply(w, map[string]string{"message": "No such container: " + parts[1]})
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "start":
+			c.State.Status = "running"
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && len(parts) == 3 && parts[2] == "json":
+			f.reply(w, c)
+		case r.Method == http.MethodDelete && len(parts) == 2:
+			delete(f.containers, c.ID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeDaemon) create(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		*container.Config
+		HostConfig       *container.HostConfig
+		NetworkingConfig *network.NetworkingConfig
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !f.images[body.Image] {
+		w.WriteHeader(http.StatusNotFound)
+		f.reply(w, map[string]string{"message": "No such image: " + body.Image})
+		return
+	}
+
+	//Addresses are taken from the bottom of the dynamic range unless one is requested
+	networks := map[string]*network.EndpointSettings{}
+	for name, endpoint := range body.NetworkingConfig.EndpointsConfig {
+		ip := "10.5.0." + string('2'+rune(len(f.containers)))
+		if endpoint.IPAMConfig != nil && endpoint.IPAMConfig.IPv4Address != "" {
+			ip = endpoint.IPAMConfig.IPv4Address
+		}
+		networks[name] = &network.EndpointSettings{IPAddress: ip}
+	}
+
+	id := strings.Repeat(string('a'+rune(len(f.containers))), 64)
+	f.containers[id] = &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + r.URL.Query().Get("name"),
+			State:      &types.ContainerState{Status: "created"},
+			HostConfig: body.HostConfig,
+		},
+		Config:          body.Config,
+		NetworkSettings: &types.NetworkSettings{Networks: networks},
+	}
+	f.labels[id] = body.Labels
+	f.env[id] = body.Env
+
+	w.WriteHeader(http.StatusCreated)
+	f.reply(w, container.ContainerCreateCreatedBody{ID: id})
+}
+
+func (f *fakeDaemon) lookup(idOrName string) *types.ContainerJSON {
+	for id, c := range f.containers {
+		if id == idOrName || c.Name == "/"+idOrName {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeDaemon) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v) //nolint: errcheck
+}
+
+// newTestClient serves the fake daemon, returning its Docker host and a client for it
+func newTestClient(t *testing.T, f *fakeDaemon) (string, *client.Client, func()) {
+	server := httptest.NewServer(f)
+	host := "tcp://" + server.Listener.Addr().String()
+	cli, err := newClient(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, cli, server.Close
+}
+
+func TestAllocateAddresses(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	f := newFakeDaemon()
+	f.images["talos"] = true
+	_, cli, done := newTestClient(t, f)
+	defer done()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	//Another cluster holds the top address, while this cluster's own containers are ignored
+	other := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	config := &talosv1.DockerMachineConfig{Network: "talos", Instances: talosv1.DockerInstanceSpec{Image: "talos"}}
+	_, err := runContainer(context.Background(), cli, other, "other-master-0", config, "", "10.5.0.254")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	_, err = runContainer(context.Background(), cli, cluster, "test-master-0", config, "", "10.5.0.253")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	ips, err := allocateAddresses(context.Background(), cli, cluster, "talos", 3, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"10.5.0.253", "10.5.0.252", "10.5.0.251"}))
+
+	//Reserved addresses are skipped whether or not a container holds them
+	ips, err = allocateAddresses(context.Background(), cli, cluster, "talos", 3, []string{"10.5.0.252"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"10.5.0.253", "10.5.0.251", "10.5.0.250"}))
+
+	//The dynamic range leaves room for 126 static addresses, minus the one in use
+	_, err = allocateAddresses(context.Background(), cli, cluster, "talos", 127, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, err = allocateAddresses(context.Background(), cli, cluster, "missing", 1, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestAllocateExternalIPsOfTwoClusters(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	f := newFakeDaemon()
+	host, _, done := newTestClient(t, f)
+	defer done()
+
+	newCluster := func(name, network string) clusterv1.Cluster {
+		spec, err := utils.EncodeProviderObject(&talosv1.TalosClusterProviderSpec{
+			Platform:     talosv1.TalosClusterPlatformSpec{Type: "docker", Docker: &talosv1.DockerClusterConfig{Host: host, Network: network}},
+			ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+		})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		return clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: spec}},
+		}
+	}
+	clusters := &clusterv1.ClusterList{Items: []clusterv1.Cluster{newCluster("first", "talos"), newCluster("second", "talos"), newCluster("elsewhere", "other")}}
+
+	//Neither cluster has containers yet, only the status of the first one tells its addresses apart
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.URL.Path).To(gomega.Equal("/apis/cluster.k8s.io/v1alpha1/clusters"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clusters) //nolint: errcheck
+	}))
+	defer api.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: api.URL})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	docker := &Docker{}
+	first, err := docker.AllocateExternalIPs(&clusters.Items[0], clientset)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(first).To(gomega.Equal([]string{"10.5.0.254", "10.5.0.253", "10.5.0.252"}))
+	g.Expect(utils.UpdateClusterProviderStatus(&clusters.Items[0], func(status *talosv1.TalosClusterProviderStatusStatus) {
+		status.ControlPlaneIPs = first
+	})).To(gomega.Succeed())
+
+	second, err := docker.AllocateExternalIPs(&clusters.Items[1], clientset)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(second).To(gomega.Equal([]string{"10.5.0.251", "10.5.0.250", "10.5.0.249"}))
+
+	//A cluster keeps its own addresses, and clusters on other networks don't get in the way
+	again, err := docker.AllocateExternalIPs(&clusters.Items[0], clientset)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(again).To(gomega.Equal(first))
+	reserved, err := reservedAddresses(clientset, &clusters.Items[2], &talosv1.DockerClusterConfig{Host: host, Network: "other"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(reserved).To(gomega.BeEmpty())
+}
+
+func TestRunContainer(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	f := newFakeDaemon()
+	_, cli, done := newTestClient(t, f)
+	defer done()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	config := &talosv1.DockerMachineConfig{
+		Network: "talos",
+		Instances: talosv1.DockerInstanceSpec{
+			Image:     "docker.io/autonomy/talos:latest",
+			CPUs:      "1.5",
+			MemoryMiB: 2048,
+		},
+	}
+
+	//The image is missing at first, so it gets pulled
+	id, err := runContainer(context.Background(), cli, cluster, "test-master-0", config, "machine: {}\n", "10.5.0.254")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(f.images).To(gomega.HaveKey("docker.io/autonomy/talos:latest"))
+
+	c := f.containers[id]
+	g.Expect(c.State.Status).To(gomega.Equal("running"))
+	g.Expect(c.HostConfig.Privileged).To(gomega.BeTrue())
+	g.Expect(c.HostConfig.NanoCPUs).To(gomega.Equal(int64(1500000000)))
+	g.Expect(c.HostConfig.Memory).To(gomega.Equal(int64(2048 << 20)))
+	g.Expect(c.Config.Volumes).To(gomega.HaveKey("/var/lib/etcd"))
+	g.Expect(f.labels[id]).To(gomega.Equal(map[string]string{ownedLabel: "true", clusterLabel: "test", clusterNamespaceLabel: "default"}))
+	g.Expect(f.env[id]).To(gomega.ConsistOf("PLATFORM=container", "USERDATA="+base64.StdEncoding.EncodeToString([]byte("machine: {}\n"))))
+
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-master-0"}}
+	g.Expect(setStatus(context.Background(), cli, machine, "test-master-0")).To(gomega.Succeed())
+	g.Expect(*machine.Spec.ProviderID).To(gomega.Equal("docker://" + id))
+
+	status, err := utils.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Status.InstanceID).To(gomega.Equal(id))
+	g.Expect(status.Status.InstanceState).To(gomega.Equal("running"))
+	g.Expect(status.Status.Addresses).To(gomega.ConsistOf(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.5.0.254"}))
+
+	//Workers get a dynamic address and no etcd volume
+	id, err = runContainer(context.Background(), cli, cluster, "test-worker-0", config, "machine: {}\n", "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(f.containers[id].Config.Volumes).NotTo(gomega.HaveKey("/var/lib/etcd"))
+	g.Expect(f.containers[id].NetworkSettings.Networks["talos"].IPAddress).To(gomega.Equal("10.5.0.3"))
+}
+
+func TestExistsAndDelete(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	f := newFakeDaemon()
+	f.images["talos"] = true
+	host, cli, done := newTestClient(t, f)
+	defer done()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	config := &talosv1.DockerMachineConfig{
+		Host:      host,
+		Network:   "talos",
+		Instances: talosv1.DockerInstanceSpec{Image: "talos"},
+	}
+	machineSpec, err := utils.EncodeProviderObject(&talosv1.TalosMachineProviderSpec{
+		Platform: talosv1.TalosMachinePlatformSpec{Type: "docker", Docker: config},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-worker-0", Namespace: "default"},
+		Spec:       clusterv1.MachineSpec{ProviderSpec: clusterv1.ProviderSpec{Value: machineSpec}},
+	}
+
+	d, err := NewDocker()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//Before a provider ID is recorded, the container is found by its cluster-keyed name, not the bare machine name
+	_, err = runContainer(context.Background(), cli, cluster, "test-worker-0", config, "", "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	exists, err := d.Exists(context.Background(), cluster, machine, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeFalse())
+	f.containers = map[string]*types.ContainerJSON{}
+
+	_, err = runContainer(context.Background(), cli, cluster, utils.MachineResourceName(cluster, machine), config, "", "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	exists, err = d.Exists(context.Background(), cluster, machine, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeTrue())
+
+	g.Expect(d.Update(context.Background(), cluster, machine, nil)).To(gomega.Succeed())
+	g.Expect(*machine.Spec.ProviderID).To(gomega.HavePrefix("docker://"))
+
+	g.Expect(d.Delete(context.Background(), cluster, machine, nil)).To(gomega.Succeed())
+	g.Expect(f.containers).To(gomega.BeEmpty())
+	g.Expect(d.Delete(context.Background(), cluster, machine, nil)).To(gomega.Succeed())
+
+	exists, err = d.Exists(context.Background(), cluster, machine, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeFalse())
+}
+
+func TestOwnedBy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	g.Expect(ownedBy(map[string]string{clusterLabel: "test", clusterNamespaceLabel: "default"}, cluster)).To(gomega.BeTrue())
+	g.Expect(ownedBy(map[string]string{clusterLabel: "test", clusterNamespaceLabel: "tenant"}, cluster)).To(gomega.BeFalse())
+	g.Expect(ownedBy(map[string]string{clusterLabel: "other", clusterNamespaceLabel: "default"}, cluster)).To(gomega.BeFalse())
+	//Containers of earlier versions
+	g.Expect(ownedBy(map[string]string{clusterLabel: "test"}, cluster)).To(gomega.BeTrue())
+}
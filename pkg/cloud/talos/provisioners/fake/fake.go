@@ -0,0 +1,330 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// FailOnAnnotation lists the operations that fail for a cluster or machine, e.g. "create,delete"
+	FailOnAnnotation = "fake.talos.cluster.k8s.io/fail-on"
+	// LatencyAnnotation is the delay added to every operation for a cluster or machine, e.g. "2s"
+	LatencyAnnotation = "fake.talos.cluster.k8s.io/latency"
+)
+
+// Operations that failures can be injected into
+const (
+	OpCreate     = "create"
+	OpUpdate     = "update"
+	OpDelete     = "delete"
+	OpExists     = "exists"
+	OpAllocate   = "allocate"
+	OpDeallocate = "deallocate"
+	OpRelease    = "release"
+)
+
+// Config is the free-form platform config understood by the fake provisioner.
+// It applies to every machine of the cluster, the annotations to a single object.
+type Config struct {
+	// FailOn lists the operations that fail
+	FailOn []string `json:"failOn,omitempty"`
+	// Latency is the delay added to every operation, e.g. "2s"
+	Latency string `json:"latency,omitempty"`
+}
+
+// Fake is a provisioner that keeps its instances and IPs in memory, for tests and demos.
+type Fake struct {
+	mu        sync.Mutex
+	instances map[string]*instance
+	ips       map[string][]string
+	lastID    int
+	lastIP    int
+	// lastExternalIP is the host part of the last control plane IP handed out, control plane IPs are never reused
+	lastExternalIP int
+}
+
+// maxExternalIP is the host part of the last usable address of 203.0.113.0/24
+const maxExternalIP = 254
+
+type instance struct {
+	id         string
+	internalIP string
+	externalIP string
+}
+
+// shared is handed out by the factory, so that state survives across reconciles
+var shared = New()
+
+func init() {
+	provisioners.Register("fake", func() (provisioners.Provisioner, error) {
+		return shared, nil
+	})
+}
+
+// New returns an empty fake provisioner
+func New() *Fake {
+	return &Fake{
+		instances: map[string]*instance{},
+		ips:       map[string][]string{},
+	}
+}
+
+// Shared returns the fake provisioner registered for the "fake" platform
+func Shared() *Fake {
+	return shared
+}
+
+// Create creates an instance in memory.
+func (fake *Fake) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	if err := inject(ctx, OpCreate, cluster, machine); err != nil {
+		return err
+	}
+
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	key := instanceKey(machine)
+	if _, ok := fake.instances[key]; ok {
+		return fmt.Errorf("[Fake] Instance %s already exists", key)
+	}
+
+	fake.lastID++
+	fake.lastIP++
+	inst := &instance{
+		id:         "fake-" + strconv.Itoa(fake.lastID),
+		internalIP: "10.0." + strconv.Itoa(fake.lastIP/256) + "." + strconv.Itoa(fake.lastIP%256),
+	}
+	if utils.IsControlPlane(role) {
+		ips := fake.ips[clusterKey(cluster)]
+		if index >= len(ips) {
+			return fmt.Errorf("[Fake] No IP allocated for control plane index %d", index)
+		}
+		inst.externalIP = ips[index]
+	}
+	fake.instances[key] = inst
+
+	return setStatus(machine, inst)
+}
+
+// Update refreshes the status of an instance.
+func (fake *Fake) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	if err := inject(ctx, OpUpdate, cluster, machine); err != nil {
+		return err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	inst, ok := fake.instances[instanceKey(machine)]
+	if !ok {
+		return nil
+	}
+
+	return setStatus(machine, inst)
+}
+
+// Delete deletes an instance.
+func (fake *Fake) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	if err := inject(ctx, OpDelete, cluster, machine); err != nil {
+		return err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	delete(fake.instances, instanceKey(machine))
+	return nil
+}
+
+// Exists returns whether or not an instance is present.
+func (fake *Fake) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	if err := inject(ctx, OpExists, cluster, machine); err != nil {
+		return false, err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	_, ok := fake.instances[instanceKey(machine)]
+	return ok, nil
+}
+
+// AllocateExternalIPs hands out IPs from 203.0.113.0/24 for the control plane nodes.
+// IPs already allocated to the cluster are kept, so growing the control plane only adds IPs.
+// It fails once all 254 addresses of the block have been handed out.
+func (fake *Fake) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	if err := inject(context.Background(), OpAllocate, cluster, nil); err != nil {
+		return nil, err
+	}
+
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	key := clusterKey(cluster)
+	ips := fake.ips[key]
+	for len(ips) < clusterSpec.ControlPlane.Count {
+		if fake.lastExternalIP >= maxExternalIP {
+			return nil, fmt.Errorf("[Fake] No control plane IPs left in 203.0.113.0/24 for cluster %s", key)
+		}
+		fake.lastExternalIP++
+		ips = append(ips, "203.0.113."+strconv.Itoa(fake.lastExternalIP))
+	}
+	fake.ips[key] = ips
+
+	return append([]string{}, ips[:clusterSpec.ControlPlane.Count]...), nil
+}
+
+// DeAllocateExternalIPs releases the IPs of the control plane nodes
+func (fake *Fake) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	if err := inject(context.Background(), OpDeallocate, cluster, nil); err != nil {
+		return err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	delete(fake.ips, clusterKey(cluster))
+	return nil
+}
+
+// ReleaseExternalIP releases the IP of a removed control plane node, along with any allocated after it
+func (fake *Fake) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	if err := inject(context.Background(), OpRelease, cluster, nil); err != nil {
+		return err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	key := clusterKey(cluster)
+	if index < len(fake.ips[key]) {
+		fake.ips[key] = fake.ips[key][:index]
+	}
+	return nil
+}
+
+// IPs returns the IPs allocated to a cluster
+func (fake *Fake) IPs(cluster *clusterv1.Cluster) []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]string{}, fake.ips[clusterKey(cluster)]...)
+}
+
+// Instances returns the sorted namespace/name keys of the machines that have an instance
+func (fake *Fake) Instances() []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	keys := make([]string, 0, len(fake.instances))
+	for key := range fake.instances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reset drops all instances and IPs
+func (fake *Fake) Reset() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.instances = map[string]*instance{}
+	fake.ips = map[string][]string{}
+	fake.lastExternalIP = 0
+}
+
+// setStatus records the provider ID and status of an instance in the machine
+func setStatus(machine *clusterv1.Machine, inst *instance) error {
+	providerID := utils.ProviderID("fake", inst.id)
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = inst.id
+		status.InstanceState = "running"
+		status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: inst.internalIP}}
+		if inst.externalIP != "" {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: inst.externalIP})
+		}
+	})
+}
+
+// inject sleeps for the configured latency, then fails if op is one of the configured failures.
+// Settings come from the cluster platform config and the annotations of the cluster and machine, machine is optional.
+func inject(ctx context.Context, op string, cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
+	config := &Config{}
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	if clusterSpec.Platform.Config != "" {
+		if err = utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+			return err
+		}
+	}
+
+	latency := config.Latency
+	failOn := config.FailOn
+	annotations := []map[string]string{cluster.ObjectMeta.Annotations}
+	if machine != nil {
+		annotations = append(annotations, machine.ObjectMeta.Annotations)
+	}
+	for _, a := range annotations {
+		if value, ok := a[LatencyAnnotation]; ok {
+			latency = value
+		}
+		if value, ok := a[FailOnAnnotation]; ok {
+			failOn = append(failOn, strings.Split(value, ",")...)
+		}
+	}
+
+	if latency != "" {
+		d, err := time.ParseDuration(latency)
+		if err != nil {
+			return fmt.Errorf("[Fake] Invalid latency %q: %v", latency, err)
+		}
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, failure := range failOn {
+		if strings.TrimSpace(failure) == op {
+			return fmt.Errorf("[Fake] Injected %s failure", op)
+		}
+	}
+	return nil
+}
+
+func clusterKey(cluster *clusterv1.Cluster) string {
+	return cluster.ObjectMeta.Namespace + "/" + cluster.ObjectMeta.Name
+}
+
+func instanceKey(machine *clusterv1.Machine) string {
+	return machine.ObjectMeta.Namespace + "/" + machine.ObjectMeta.Name
+}
@@ -0,0 +1,133 @@
+package fake
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func testCluster(g *gomega.GomegaWithT, config string) *clusterv1.Cluster {
+	value, err := utils.EncodeProviderObject(&talosv1.TalosClusterProviderSpec{
+		ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+		Platform:     talosv1.TalosClusterPlatformSpec{Type: "fake", Config: config},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: value}},
+	}
+}
+
+func testMachine(g *gomega.GomegaWithT, name string, role talosv1.MachineRole, index int) *clusterv1.Machine {
+	value, err := utils.EncodeProviderObject(&talosv1.TalosMachineProviderSpec{
+		Role:              role,
+		ControlPlaneIndex: index,
+		Platform:          talosv1.TalosMachinePlatformSpec{Type: "fake"},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       clusterv1.MachineSpec{ProviderSpec: clusterv1.ProviderSpec{Value: value}},
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx := context.Background()
+	fake := New()
+	cluster := testCluster(g, "")
+
+	ips, err := fake.AllocateExternalIPs(cluster, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.HaveLen(3))
+
+	again, err := fake.AllocateExternalIPs(cluster, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(again).To(gomega.Equal(ips))
+
+	machine := testMachine(g, "test-master-1", talosv1.MachineRoleControlPlane, 1)
+	exists, err := fake.Exists(ctx, cluster, machine, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeFalse())
+
+	g.Expect(fake.Create(ctx, cluster, machine, nil)).To(gomega.Succeed())
+	g.Expect(fake.Instances()).To(gomega.ConsistOf("default/test-master-1"))
+	g.Expect(*machine.Spec.ProviderID).To(gomega.HavePrefix("fake://"))
+
+	status, err := utils.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Status.Addresses).To(gomega.ContainElement(corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: ips[1]}))
+
+	exists, err = fake.Exists(ctx, cluster, machine, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeTrue())
+
+	g.Expect(fake.Delete(ctx, cluster, machine, nil)).To(gomega.Succeed())
+	g.Expect(fake.Instances()).To(gomega.BeEmpty())
+
+	//Releasing the IP of a removed node frees its index for a new IP
+	g.Expect(fake.ReleaseExternalIP(cluster, nil, 2, ips[2])).To(gomega.Succeed())
+	g.Expect(fake.IPs(cluster)).To(gomega.Equal(ips[:2]))
+	regrown, err := fake.AllocateExternalIPs(cluster, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(regrown[:2]).To(gomega.Equal(ips[:2]))
+	g.Expect(regrown[2]).NotTo(gomega.Equal(ips[2]))
+
+	g.Expect(fake.DeAllocateExternalIPs(cluster, nil)).To(gomega.Succeed())
+	g.Expect(fake.IPs(cluster)).To(gomega.BeEmpty())
+}
+
+func TestExternalIPsExhausted(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx := context.Background()
+	fake := New()
+
+	//Worker instances don't use up control plane IPs
+	worker := testMachine(g, "test-workers-abcde", talosv1.MachineRoleWorker, 0)
+	g.Expect(fake.Create(ctx, testCluster(g, ""), worker, nil)).To(gomega.Succeed())
+
+	//84 clusters of 3 control plane nodes leave 2 of the 254 addresses
+	var ips []string
+	for i := 0; i < 84; i++ {
+		cluster := testCluster(g, "")
+		cluster.ObjectMeta.Name = "test-" + strconv.Itoa(i)
+		allocated, err := fake.AllocateExternalIPs(cluster, nil)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		ips = append(ips, allocated...)
+	}
+	g.Expect(ips[0]).To(gomega.Equal("203.0.113.1"))
+	g.Expect(ips[len(ips)-1]).To(gomega.Equal("203.0.113.252"))
+
+	cluster := testCluster(g, "")
+	_, err := fake.AllocateExternalIPs(cluster, nil)
+	g.Expect(err).To(gomega.MatchError("[Fake] No control plane IPs left in 203.0.113.0/24 for cluster default/test"))
+}
+
+func TestInjectedFailures(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx := context.Background()
+	fake := New()
+
+	cluster := testCluster(g, "failOn: [allocate]")
+	_, err := fake.AllocateExternalIPs(cluster, nil)
+	g.Expect(err).To(gomega.MatchError("[Fake] Injected allocate failure"))
+
+	cluster = testCluster(g, "latency: 10ms")
+	worker := testMachine(g, "test-worker", talosv1.MachineRoleWorker, 0)
+	worker.Annotations = map[string]string{FailOnAnnotation: "update, create"}
+	g.Expect(fake.Create(ctx, cluster, worker, nil)).To(gomega.MatchError("[Fake] Injected create failure"))
+	g.Expect(fake.Instances()).To(gomega.BeEmpty())
+
+	cluster.Annotations = map[string]string{LatencyAnnotation: "soon"}
+	_, err = fake.Exists(ctx, cluster, worker, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -2,18 +2,18 @@ package gce
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
-	"gopkg.in/yaml.v2"
-
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/option"
-	v1 "k8s.io/api/core/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
@@ -23,29 +23,10 @@ import (
 type GCE struct {
 }
 
-// ClusterInfo holds data about desired config in cluster object
-type ClusterInfo struct {
-	Region  string
-	Project string
-}
-
-// MachineInfo holds data about desired config in machine object
-type MachineInfo struct {
-	Zone      string
-	Project   string
-	Instances InstanceInfo
-}
-
-// InstanceInfo holds data about the instances we'll create
-type InstanceInfo struct {
-	Type  string
-	Image string
-	Disks DiskInfo
-}
-
-// DiskInfo holds disk info data
-type DiskInfo struct {
-	Size int
+func init() {
+	provisioners.Register("gce", func() (provisioners.Provisioner, error) {
+		return NewGCE()
+	})
 }
 
 //NewGCE returns an instance of the GCE provisioner
@@ -61,45 +42,50 @@ func (gce *GCE) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return err
 	}
 
-	gceConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), gceConfig)
+	gceConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
 
-	computeService, err := client(clientset)
+	computeService, err := client(cluster, clientset)
 	if err != nil {
 		return err
 	}
 
-	//fetch userdata based on machine name
-	udConfigMap := &v1.ConfigMap{}
-	natIP := ""
-	if strings.Contains(machine.ObjectMeta.Name, "worker") {
-		udConfigMap, err = clientset.CoreV1().ConfigMaps("cluster-api-provider-talos-system").Get(cluster.ObjectMeta.Name+"-workers", metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			return err
-		}
-	} else {
-		udConfigMap, err = clientset.CoreV1().ConfigMaps("cluster-api-provider-talos-system").Get(machine.ObjectMeta.Name, metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			return err
-		}
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
 
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	//fetch floating IP for control plane machines
+	natIP := ""
+	if utils.IsControlPlane(role) {
 		// Parse the region out of zone
 		zoneSlice := strings.Split(gceConfig.Zone, "-")
 		regionSlice := zoneSlice[:len(zoneSlice)-1]
 		region := strings.Join(regionSlice, "-")
 
 		// Find public ip
-		address, err := getPublicIPByName(computeService, machine.ObjectMeta.Name+"-ip", gceConfig.Project, region)
+		address, err := getControlPlaneIP(computeService, cluster, index, gceConfig.Project, region)
 		if err != nil {
 			return err
 		}
+		if address == nil {
+			return errors.New("IP not ready")
+		}
 		natIP = address.Address
 	}
-	ud := udConfigMap.Data["userdata"]
+	ud := string(udSecret.Data["userdata"])
 
 	//create instance with userdata
-	_, err = computeService.Instances.Insert(gceConfig.Project, gceConfig.Zone, &compute.Instance{
-		Name:         machine.ObjectMeta.Name,
+	name := utils.MachineResourceName(cluster, machine)
+	op, err := computeService.Instances.Insert(gceConfig.Project, gceConfig.Zone, &compute.Instance{
+		Name:         name,
 		MachineType:  fmt.Sprintf("zones/%s/machineTypes/%s", gceConfig.Zone, gceConfig.Instances.Type),
 		CanIpForward: true,
 		NetworkInterfaces: []*compute.NetworkInterface{
@@ -132,19 +118,74 @@ func (gce *GCE) Create(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return err
 	}
 
-	return nil
+	providerID := utils.ProviderID("gce", gceConfig.Project+"/"+gceConfig.Zone+"/"+name)
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = strconv.FormatUint(op.TargetId, 10)
+		status.InstanceState = "PROVISIONING"
+		status.Zone = gceConfig.Zone
+		status.Addresses = nil
+		if natIP != "" {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: natIP})
+		}
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
 }
 
 //Update updates a given GCE instance.
 func (gce *GCE) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	gceConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	computeService, err := client(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	project, zone, name, err := instanceLocation(computeService, cluster, machine, gceConfig)
+	if err != nil {
+		return err
+	}
+
+	instance, err := computeService.Instances.Get(project, zone, name).Do()
+	if err != nil {
+		return err
+	}
+
+	providerID := utils.ProviderID("gce", project+"/"+zone+"/"+name)
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = strconv.FormatUint(instance.Id, 10)
+		status.InstanceState = instance.Status
+		status.Zone = zone
+
+		status.Addresses = nil
+		for _, iface := range instance.NetworkInterfaces {
+			if iface.NetworkIP != "" {
+				status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: iface.NetworkIP})
+			}
+			for _, accessConfig := range iface.AccessConfigs {
+				if accessConfig.NatIP != "" {
+					status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: accessConfig.NatIP})
+				}
+			}
+		}
+	})
 }
 
 // Delete deletes a GCE instance.
 func (gce *GCE) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
 
-	// If instance isn't found by name, assume we no longer need to delete
+	// If instance isn't found, assume we no longer need to delete
 	exists, err := gce.Exists(ctx, cluster, machine, clientset)
 	if err != nil {
 		return err
@@ -158,15 +199,22 @@ func (gce *GCE) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return err
 	}
 
-	gceConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), gceConfig)
+	gceConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
 
-	computeService, err := client(clientset)
+	computeService, err := client(cluster, clientset)
 	if err != nil {
 		return err
 	}
 
-	_, err = computeService.Instances.Delete(gceConfig.Project, gceConfig.Zone, machine.ObjectMeta.Name).Do()
+	project, zone, name, err := instanceLocation(computeService, cluster, machine, gceConfig)
+	if err != nil {
+		return err
+	}
+
+	_, err = computeService.Instances.Delete(project, zone, name).Do()
 	if err != nil {
 		return err
 	}
@@ -180,15 +228,22 @@ func (gce *GCE) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine
 		return false, err
 	}
 
-	gceConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), gceConfig)
+	gceConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return false, err
+	}
 
-	computeService, err := client(clientset)
+	computeService, err := client(cluster, clientset)
 	if err != nil {
 		return true, err
 	}
 
-	_, err = computeService.Instances.Get(gceConfig.Project, gceConfig.Zone, machine.ObjectMeta.Name).Do()
+	project, zone, name, err := instanceLocation(computeService, cluster, machine, gceConfig)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = computeService.Instances.Get(project, zone, name).Do()
 	if err != nil && strings.Contains(err.Error(), "notFound") {
 		return false, nil
 	} else if err != nil {
@@ -205,10 +260,12 @@ func (gce *GCE) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kuber
 		return nil, err
 	}
 
-	gceConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), gceConfig)
+	gceConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
 
-	computeService, err := client(clientset)
+	computeService, err := client(cluster, clientset)
 	if err != nil {
 		return nil, err
 	}
@@ -217,7 +274,7 @@ func (gce *GCE) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kuber
 	for i := 0; i < clusterSpec.ControlPlane.Count; i++ {
 
 		//Check for address existence and return early if we can
-		address, err := getPublicIPByName(computeService, cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip", gceConfig.Project, gceConfig.Region)
+		address, err := getControlPlaneIP(computeService, cluster, i, gceConfig.Project, gceConfig.Region)
 		if err != nil {
 			return nil, err
 		}
@@ -227,7 +284,7 @@ func (gce *GCE) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kuber
 		}
 
 		// Insert the address and wait for it to be ready
-		op, err := computeService.Addresses.Insert(gceConfig.Project, gceConfig.Region, &compute.Address{Name: cluster.ObjectMeta.Name + "-master-" + strconv.Itoa(i) + "-ip"}).Do()
+		op, err := computeService.Addresses.Insert(gceConfig.Project, gceConfig.Region, &compute.Address{Name: utils.ControlPlaneIPName(cluster, i)}).Do()
 		if err != nil {
 			return nil, err
 		}
@@ -238,7 +295,7 @@ func (gce *GCE) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kuber
 			time.Sleep(5 * time.Second)
 		}
 
-		address, err = getPublicIPByName(computeService, cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip", gceConfig.Project, gceConfig.Region)
+		address, err = getPublicIPByName(computeService, utils.ControlPlaneIPName(cluster, i), gceConfig.Project, gceConfig.Region)
 		if err != nil {
 			return nil, err
 		}
@@ -256,16 +313,26 @@ func (gce *GCE) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kub
 		return err
 	}
 
-	gceConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), gceConfig)
+	gceConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
 
-	computeService, err := client(clientset)
+	computeService, err := client(cluster, clientset)
 	if err != nil {
 		return err
 	}
 
 	for i := 0; i < clusterSpec.ControlPlane.Count; i++ {
-		_, err := computeService.Addresses.Delete(gceConfig.Project, gceConfig.Region, cluster.ObjectMeta.Name+"-master-"+strconv.Itoa(i)+"-ip").Do()
+		address, err := getControlPlaneIP(computeService, cluster, i, gceConfig.Project, gceConfig.Region)
+		if err != nil {
+			return err
+		}
+		if address == nil {
+			continue
+		}
+
+		_, err = computeService.Addresses.Delete(gceConfig.Project, gceConfig.Region, address.Name).Do()
 		if err != nil && !strings.Contains(err.Error(), "notFound") {
 			return err
 		}
@@ -274,6 +341,90 @@ func (gce *GCE) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kub
 	return nil
 }
 
+// ReleaseExternalIP deletes the address of a control plane node removed by scaling down
+func (gce *GCE) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	gceConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
+
+	computeService, err := client(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	address, err := getControlPlaneIP(computeService, cluster, index, gceConfig.Project, gceConfig.Region)
+	if err != nil {
+		return err
+	}
+	if address == nil || address.Address != ip {
+		return nil
+	}
+
+	_, err = computeService.Addresses.Delete(gceConfig.Project, gceConfig.Region, address.Name).Do()
+	if err != nil && !strings.Contains(err.Error(), "notFound") {
+		return err
+	}
+	return nil
+}
+
+// instanceLocation returns the project, zone and name of the instance of a machine.
+// They are taken from the provider ID gce://<project>/<zone>/<name> if set, and from the machine config otherwise.
+// Instances are then named after the machine and its cluster, or after the machine only for clusters created by earlier versions.
+func instanceLocation(computeService *compute.Service, cluster *clusterv1.Cluster, machine *clusterv1.Machine, gceConfig *talosv1.GCEMachineConfig) (string, string, string, error) {
+	providerID, err := utils.ParseProviderID(machine, "gce")
+	if err != nil {
+		return "", "", "", err
+	}
+	if providerID == "" {
+		name := utils.MachineResourceName(cluster, machine)
+		legacy, err := utils.HasLegacyResourceNames(cluster)
+		if err != nil {
+			return "", "", "", err
+		}
+		if legacy {
+			_, err = computeService.Instances.Get(gceConfig.Project, gceConfig.Zone, name).Do()
+			if err != nil && strings.Contains(err.Error(), "notFound") {
+				name = machine.ObjectMeta.Name
+			} else if err != nil {
+				return "", "", "", err
+			}
+		}
+		return gceConfig.Project, gceConfig.Zone, name, nil
+	}
+
+	parts := strings.Split(providerID, "/")
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("machine %q: malformed provider ID %q", machine.ObjectMeta.Name, *machine.Spec.ProviderID)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// getControlPlaneIP finds the address of a control plane machine.
+// Addresses can't be renamed, so the ones of clusters created by earlier versions are found by their legacy name, as long as they belong to the cluster.
+func getControlPlaneIP(computeService *compute.Service, cluster *clusterv1.Cluster, index int, project string, region string) (*compute.Address, error) {
+	address, err := getPublicIPByName(computeService, utils.ControlPlaneIPName(cluster, index), project, region)
+	if err != nil || address != nil {
+		return address, err
+	}
+
+	address, err = getPublicIPByName(computeService, utils.LegacyControlPlaneIPName(cluster, index), project, region)
+	if err != nil || address == nil {
+		return nil, err
+	}
+
+	owned, err := utils.OwnsLegacyControlPlaneIP(cluster, address.Address)
+	if err != nil || !owned {
+		return nil, err
+	}
+	return address, nil
+}
+
 func getPublicIPByName(computeService *compute.Service, name string, project string, region string) (*compute.Address, error) {
 	addressList, err := computeService.Addresses.List(project, region).Do()
 	if err != nil {
@@ -291,13 +442,52 @@ func getPublicIPByName(computeService *compute.Service, name string, project str
 
 }
 
-func client(clientset *kubernetes.Clientset) (*compute.Service, error) {
-	creds, err := clientset.CoreV1().Secrets("cluster-api-provider-talos-system").Get("gce-credentials", metav1.GetOptions{})
-	if k8serrors.IsNotFound(err) {
+// client creates a compute client, with the credentials referenced by the cluster if any and those of the manager otherwise
+func client(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) (*compute.Service, error) {
+	creds, err := utils.FetchCredentials(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		secret, err := clientset.CoreV1().Secrets("cluster-api-provider-talos-system").Get("gce-credentials", metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		creds = secret.Data
+	}
+
+	serviceAccount, err := utils.CredentialsValue(creds, "service-account.json")
+	if err != nil {
 		return nil, err
 	}
 
 	//create client
 	ctx := context.Background()
-	return compute.NewService(ctx, option.WithCredentialsJSON(creds.Data["service-account.json"]))
+	return compute.NewService(ctx, option.WithCredentialsJSON(serviceAccount))
+}
+
+// clusterConfig returns the GCE config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.GCEClusterConfig, error) {
+	if clusterSpec.Platform.GCE != nil {
+		return clusterSpec.Platform.GCE, nil
+	}
+
+	config := &talosv1.GCEClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the GCE config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.GCEMachineConfig, error) {
+	if machineSpec.Platform.GCE != nil {
+		return machineSpec.Platform.GCE, nil
+	}
+
+	config := &talosv1.GCEMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
@@ -0,0 +1,556 @@
+package hcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	hcloudpkg "github.com/hetznercloud/hcloud-go/hcloud"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	talosconfig "github.com/talos-systems/talos/pkg/config/machine"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// Labels tying servers and floating IPs to their cluster
+const (
+	clusterLabel           = "talos-cluster"
+	clusterNamespaceLabel  = "talos-cluster-namespace"
+	controlPlaneIndexLabel = "talos-control-plane-index"
+)
+
+// Hcloud represents a provider for Hetzner Cloud.
+type Hcloud struct {
+}
+
+func init() {
+	provisioners.Register("hcloud", func() (provisioners.Provisioner, error) {
+		return NewHcloud()
+	})
+}
+
+// NewHcloud returns an instance of the Hetzner Cloud provisioner
+func NewHcloud() (*Hcloud, error) {
+	return &Hcloud{}, nil
+}
+
+// Create creates a server from the Talos snapshot, assigning a floating IP to masters.
+func (hc *Hcloud) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	hcConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+
+	//Look up the floating ip first, so that a missing one fails before the server exists
+	userdata := string(udSecret.Data["userdata"])
+	var fip *hcloudpkg.FloatingIP
+	if utils.IsControlPlane(role) {
+		if fip, err = floatingIP(ctx, client, cluster, index); err != nil {
+			return err
+		}
+		if userdata, err = withFloatingIP(userdata, fip.IP.String()); err != nil {
+			return err
+		}
+	}
+
+	opts, err := serverCreateOpts(ctx, client, cluster, utils.MachineResourceName(cluster, machine), hcConfig, userdata)
+	if err != nil {
+		return err
+	}
+
+	result, _, err := client.Server.Create(ctx, opts)
+	if err != nil {
+		return err
+	}
+	server := result.Server
+
+	providerID := utils.ProviderID("hcloud", strconv.Itoa(server.ID))
+	machine.Spec.ProviderID = &providerID
+
+	//Wait for the server to be created, assign floating ip to masters
+	if fip != nil {
+		if err = waitForAction(ctx, client, result.Action); err != nil {
+			return err
+		}
+		if err = assignFloatingIP(ctx, client, fip, server); err != nil {
+			return err
+		}
+	}
+
+	log.Println("[Hcloud] Server created with id: " + strconv.Itoa(server.ID))
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, server)
+		if fip != nil {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: fip.IP.String()})
+		}
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+}
+
+// Update refreshes the provider ID and status of a server, assigning the floating IP of masters if it isn't yet.
+func (hc *Hcloud) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	client, err := newClient(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	server, err := fetchServer(ctx, client, cluster, machine)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return nil
+	}
+
+	providerID := utils.ProviderID("hcloud", strconv.Itoa(server.ID))
+	machine.Spec.ProviderID = &providerID
+
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+
+	//Create may have failed between creating the server and assigning its floating ip
+	var fip *hcloudpkg.FloatingIP
+	if utils.IsControlPlane(role) {
+		if fip, err = floatingIP(ctx, client, cluster, index); err != nil {
+			return err
+		}
+		if err = assignFloatingIP(ctx, client, fip, server); err != nil {
+			return err
+		}
+	}
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, server)
+		if fip != nil {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: fip.IP.String()})
+		}
+	})
+}
+
+// Delete deletes a server, which also unassigns its floating IP.
+func (hc *Hcloud) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	client, err := newClient(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	server, err := fetchServer(ctx, client, cluster, machine)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return nil
+	}
+
+	_, err = client.Server.Delete(ctx, server)
+	if hcloudpkg.IsError(err, hcloudpkg.ErrorCodeNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Exists returns whether or not a server is present.
+func (hc *Hcloud) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	client, err := newClient(cluster, clientset)
+	if err != nil {
+		return false, err
+	}
+
+	server, err := fetchServer(ctx, client, cluster, machine)
+	if err != nil {
+		return false, err
+	}
+	return server != nil, nil
+}
+
+// AllocateExternalIPs creates floating IPs for the control plane nodes, reusing the ones labelled with the cluster
+func (hc *Hcloud) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	hcConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	return allocateFloatingIPs(context.Background(), client, cluster, hcConfig.Location, clusterSpec.ControlPlane.Count)
+}
+
+// DeAllocateExternalIPs deletes the floating IPs labelled with the cluster
+func (hc *Hcloud) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	client, err := newClient(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	return releaseFloatingIPs(context.Background(), client, cluster)
+}
+
+// ReleaseExternalIP deletes the floating IP of a control plane node removed by scaling down
+func (hc *Hcloud) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	client, err := newClient(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	return releaseFloatingIP(context.Background(), client, cluster, index)
+}
+
+// serverCreateOpts resolves the image, networks and placement group of a server
+func serverCreateOpts(ctx context.Context, client *hcloudpkg.Client, cluster *clusterv1.Cluster, name string, config *talosv1.HcloudMachineConfig, userdata string) (hcloudpkg.ServerCreateOpts, error) {
+	image, err := resolveImage(ctx, client, config.Instances.Image)
+	if err != nil {
+		return hcloudpkg.ServerCreateOpts{}, err
+	}
+
+	labels := map[string]string{}
+	for key, value := range config.Instances.Labels {
+		labels[key] = value
+	}
+	labels[clusterLabel] = cluster.ObjectMeta.Name
+	labels[clusterNamespaceLabel] = cluster.ObjectMeta.Namespace
+
+	opts := hcloudpkg.ServerCreateOpts{
+		Name:       name,
+		ServerType: &hcloudpkg.ServerType{Name: config.Instances.Type},
+		Image:      image,
+		Location:   &hcloudpkg.Location{Name: config.Location},
+		UserData:   userdata,
+		Labels:     labels,
+	}
+
+	for _, idOrName := range config.Instances.Networks {
+		network, _, err := client.Network.Get(ctx, idOrName)
+		if err != nil {
+			return hcloudpkg.ServerCreateOpts{}, err
+		}
+		if network == nil {
+			return hcloudpkg.ServerCreateOpts{}, fmt.Errorf("[Hcloud] No network %q", idOrName)
+		}
+		opts.Networks = append(opts.Networks, network)
+	}
+
+	if idOrName := config.Instances.PlacementGroup; idOrName != "" {
+		group, _, err := client.PlacementGroup.Get(ctx, idOrName)
+		if err != nil {
+			return hcloudpkg.ServerCreateOpts{}, err
+		}
+		if group == nil {
+			return hcloudpkg.ServerCreateOpts{}, fmt.Errorf("[Hcloud] No placement group %q", idOrName)
+		}
+		opts.PlacementGroup = group
+	}
+
+	return opts, nil
+}
+
+// resolveImage returns the image with the given ID or name, falling back to the snapshot with the given description
+// Note: Snapshots have no name, so they are usually given by ID or description.
+func resolveImage(ctx context.Context, client *hcloudpkg.Client, idOrName string) (*hcloudpkg.Image, error) {
+	image, _, err := client.Image.Get(ctx, idOrName)
+	if err != nil || image != nil {
+		return image, err
+	}
+
+	snapshots, err := client.Image.AllWithOpts(ctx, hcloudpkg.ImageListOpts{Type: []hcloudpkg.ImageType{hcloudpkg.ImageTypeSnapshot}})
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Description == idOrName {
+			return snapshot, nil
+		}
+	}
+	return nil, fmt.Errorf("[Hcloud] No image or snapshot %q", idOrName)
+}
+
+// fetchServer looks up the server of a machine, by the ID recorded in its status or provider ID if set, and by name and cluster labels otherwise
+func fetchServer(ctx context.Context, client *hcloudpkg.Client, cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*hcloudpkg.Server, error) {
+	id, err := serverID(machine)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0 {
+		server, _, err := client.Server.GetByID(ctx, id)
+		return server, err
+	}
+
+	servers, err := client.Server.AllWithOpts(ctx, hcloudpkg.ServerListOpts{
+		ListOpts: hcloudpkg.ListOpts{LabelSelector: clusterSelector(cluster)},
+		Name:     utils.MachineResourceName(cluster, machine),
+	})
+	if err != nil || len(servers) == 0 {
+		return nil, err
+	}
+	return servers[0], nil
+}
+
+// serverID returns the ID of the server of a machine, or 0 if it isn't known yet
+func serverID(machine *clusterv1.Machine) (int, error) {
+	status, err := utils.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return 0, err
+	}
+
+	id := status.Status.InstanceID
+	if id == "" {
+		if id, err = utils.ParseProviderID(machine, "hcloud"); err != nil || id == "" {
+			return 0, err
+		}
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("[Hcloud] Invalid server ID %q: %v", id, err)
+	}
+	return n, nil
+}
+
+// waitForAction waits for an action to complete, returning its error if it failed
+func waitForAction(ctx context.Context, client *hcloudpkg.Client, action *hcloudpkg.Action) error {
+	if action == nil {
+		return nil
+	}
+	_, errCh := client.Action.WatchProgress(ctx, action)
+	return <-errCh
+}
+
+// clusterSelector returns the label selector of the servers and floating IPs of a cluster
+func clusterSelector(cluster *clusterv1.Cluster) string {
+	return clusterLabel + "=" + cluster.ObjectMeta.Name + "," + clusterNamespaceLabel + "=" + cluster.ObjectMeta.Namespace
+}
+
+// listFloatingIPs returns the floating IPs of a cluster by control plane index
+func listFloatingIPs(ctx context.Context, client *hcloudpkg.Client, cluster *clusterv1.Cluster) (map[string]*hcloudpkg.FloatingIP, error) {
+	fips, err := client.FloatingIP.AllWithOpts(ctx, hcloudpkg.FloatingIPListOpts{ListOpts: hcloudpkg.ListOpts{LabelSelector: clusterSelector(cluster)}})
+	if err != nil {
+		return nil, err
+	}
+
+	byIndex := map[string]*hcloudpkg.FloatingIP{}
+	for _, fip := range fips {
+		byIndex[fip.Labels[controlPlaneIndexLabel]] = fip
+	}
+	return byIndex, nil
+}
+
+// floatingIP returns the floating IP of a control plane index
+func floatingIP(ctx context.Context, client *hcloudpkg.Client, cluster *clusterv1.Cluster, index int) (*hcloudpkg.FloatingIP, error) {
+	fips, err := listFloatingIPs(ctx, client, cluster)
+	if err != nil {
+		return nil, err
+	}
+	fip := fips[strconv.Itoa(index)]
+	if fip == nil {
+		return nil, fmt.Errorf("[Hcloud] No floating IP allocated for control plane index %d", index)
+	}
+	return fip, nil
+}
+
+// assignFloatingIP assigns a floating IP to a server and waits for it, unless it is assigned to the server already
+func assignFloatingIP(ctx context.Context, client *hcloudpkg.Client, fip *hcloudpkg.FloatingIP, server *hcloudpkg.Server) error {
+	if fip.Server != nil && fip.Server.ID == server.ID {
+		return nil
+	}
+
+	action, _, err := client.FloatingIP.Assign(ctx, fip, server)
+	if err != nil {
+		return err
+	}
+	return waitForAction(ctx, client, action)
+}
+
+// withFloatingIP adds the floating IP to the addresses of the loopback interface in the network section of the userdata.
+// Hetzner routes floating IPs to their server without configuring them, and a static address on eth0 would replace its DHCP config.
+func withFloatingIP(userdata, ip string) (string, error) {
+	ud := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(userdata), &ud); err != nil {
+		return "", err
+	}
+	machineSection, ok := ud["machine"].(map[interface{}]interface{})
+	if !ok {
+		return "", errors.New("[Hcloud] Userdata has no machine section")
+	}
+	network, ok := machineSection["network"].(map[interface{}]interface{})
+	if !ok {
+		network = map[interface{}]interface{}{}
+		machineSection["network"] = network
+	}
+	interfaces, _ := network["interfaces"].([]interface{})
+
+	device := talosconfig.Device{Interface: "lo", CIDR: ip + "/32"}
+	network["interfaces"] = append(interfaces, device)
+
+	out, err := yaml.Marshal(ud)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// allocateFloatingIPs creates the floating IPs missing for the control plane nodes
+func allocateFloatingIPs(ctx context.Context, client *hcloudpkg.Client, cluster *clusterv1.Cluster, location string, count int) ([]string, error) {
+	existing, err := listFloatingIPs(ctx, client, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []string{}
+	for index := 0; index < count; index++ {
+		if fip, ok := existing[strconv.Itoa(index)]; ok {
+			ips = append(ips, fip.IP.String())
+			continue
+		}
+
+		description := "talos cluster " + cluster.ObjectMeta.Namespace + "/" + cluster.ObjectMeta.Name + " master " + strconv.Itoa(index)
+		result, _, err := client.FloatingIP.Create(ctx, hcloudpkg.FloatingIPCreateOpts{
+			Type:         hcloudpkg.FloatingIPTypeIPv4,
+			HomeLocation: &hcloudpkg.Location{Name: location},
+			Description:  &description,
+			Labels: map[string]string{
+				clusterLabel:           cluster.ObjectMeta.Name,
+				clusterNamespaceLabel:  cluster.ObjectMeta.Namespace,
+				controlPlaneIndexLabel: strconv.Itoa(index),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, result.FloatingIP.IP.String())
+	}
+	return ips, nil
+}
+
+// releaseFloatingIPs deletes the floating IPs of a cluster
+func releaseFloatingIPs(ctx context.Context, client *hcloudpkg.Client, cluster *clusterv1.Cluster) error {
+	fips, err := listFloatingIPs(ctx, client, cluster)
+	if err != nil {
+		return err
+	}
+
+	for _, fip := range fips {
+		if _, err := client.FloatingIP.Delete(ctx, fip); err != nil && !hcloudpkg.IsError(err, hcloudpkg.ErrorCodeNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseFloatingIP deletes the floating IP of a single control plane node of a cluster, if any
+func releaseFloatingIP(ctx context.Context, client *hcloudpkg.Client, cluster *clusterv1.Cluster, index int) error {
+	fips, err := listFloatingIPs(ctx, client, cluster)
+	if err != nil {
+		return err
+	}
+
+	fip, ok := fips[strconv.Itoa(index)]
+	if !ok {
+		return nil
+	}
+	if _, err := client.FloatingIP.Delete(ctx, fip); err != nil && !hcloudpkg.IsError(err, hcloudpkg.ErrorCodeNotFound) {
+		return err
+	}
+	return nil
+}
+
+// setStatus records the ID, status, addresses and location of a server in the machine provider status
+func setStatus(status *talosv1.TalosMachineProviderStatusStatus, server *hcloudpkg.Server) {
+	status.InstanceID = strconv.Itoa(server.ID)
+	status.InstanceState = string(server.Status)
+	if server.Datacenter != nil && server.Datacenter.Location != nil {
+		status.Zone = server.Datacenter.Location.Name
+	}
+
+	status.Addresses = nil
+	for _, private := range server.PrivateNet {
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: private.IP.String()})
+	}
+	if ip := server.PublicNet.IPv4.IP; ip != nil {
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: ip.String()})
+	}
+}
+
+// newClient creates a Hetzner Cloud API client, with the token referenced by the cluster if any and HCLOUD_TOKEN otherwise
+func newClient(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) (*hcloudpkg.Client, error) {
+	creds, err := utils.FetchCredentials(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	token := os.Getenv("HCLOUD_TOKEN")
+	if creds != nil {
+		value, err := utils.CredentialsValue(creds, "token")
+		if err != nil {
+			return nil, err
+		}
+		token = string(value)
+	}
+	if token == "" {
+		return nil, errors.New("[Hcloud] HCLOUD_TOKEN is not set")
+	}
+
+	return hcloudpkg.NewClient(hcloudpkg.WithToken(token), hcloudpkg.WithApplication("cluster-api-provider-talos", "")), nil
+}
+
+// clusterConfig returns the Hetzner Cloud config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.HcloudClusterConfig, error) {
+	if clusterSpec.Platform.Hcloud != nil {
+		return clusterSpec.Platform.Hcloud, nil
+	}
+
+	config := &talosv1.HcloudClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the Hetzner Cloud config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.HcloudMachineConfig, error) {
+	if machineSpec.Platform.Hcloud != nil {
+		return machineSpec.Platform.Hcloud, nil
+	}
+
+	config := &talosv1.HcloudMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
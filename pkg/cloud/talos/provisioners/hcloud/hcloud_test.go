@@ -0,0 +1,283 @@
+package hcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	hcloudpkg "github.com/hetznercloud/hcloud-go/hcloud"
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// newTestClient points a Hetzner Cloud client at a fake API served by mux
+func newTestClient(mux *http.ServeMux) (*hcloudpkg.Client, func()) {
+	server := httptest.NewServer(mux)
+	return hcloudpkg.NewClient(hcloudpkg.WithEndpoint(server.URL), hcloudpkg.WithToken("token"), hcloudpkg.WithPollInterval(time.Millisecond)), server.Close
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint: errcheck
+}
+
+func notFound(w http.ResponseWriter) {
+	reply(w, http.StatusNotFound, map[string]interface{}{"error": map[string]string{"code": "not_found", "message": "not found"}})
+}
+
+func TestFloatingIPs(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	fips := []map[string]interface{}{
+		{"id": 1, "ip": "203.0.113.10", "type": "ipv4", "labels": map[string]string{"talos-cluster": "test", "talos-cluster-namespace": "default", "talos-control-plane-index": "0"}},
+	}
+	deleted := []string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/floating_ips", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			req := map[string]interface{}{}
+			g.Expect(json.NewDecoder(r.Body).Decode(&req)).To(gomega.Succeed())
+			g.Expect(req["home_location"]).To(gomega.Equal("nbg1"))
+
+			fip := map[string]interface{}{
+				"id":     len(fips) + 1,
+				"ip":     fmt.Sprintf("203.0.113.%d", 10+len(fips)),
+				"type":   "ipv4",
+				"labels": req["labels"],
+			}
+			fips = append(fips, fip)
+			reply(w, http.StatusCreated, map[string]interface{}{"floating_ip": fip})
+			return
+		}
+		g.Expect(r.URL.Query().Get("label_selector")).To(gomega.Equal("talos-cluster=test,talos-cluster-namespace=default"))
+		reply(w, http.StatusOK, map[string]interface{}{"floating_ips": fips})
+	})
+	mux.HandleFunc("/floating_ips/", func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).To(gomega.Equal(http.MethodDelete))
+		deleted = append(deleted, r.URL.Path[len("/floating_ips/"):])
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	client, done := newTestClient(mux)
+	defer done()
+	ctx := context.Background()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	ips, err := allocateFloatingIPs(ctx, client, cluster, "nbg1", 3)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"203.0.113.10", "203.0.113.11", "203.0.113.12"}))
+	g.Expect(fips).To(gomega.HaveLen(3))
+
+	again, err := allocateFloatingIPs(ctx, client, cluster, "nbg1", 3)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(again).To(gomega.Equal(ips))
+
+	g.Expect(releaseFloatingIP(ctx, client, cluster, 2)).To(gomega.Succeed())
+	g.Expect(releaseFloatingIP(ctx, client, cluster, 5)).To(gomega.Succeed())
+	g.Expect(deleted).To(gomega.Equal([]string{"3"}))
+
+	deleted = []string{}
+	g.Expect(releaseFloatingIPs(ctx, client, cluster)).To(gomega.Succeed())
+	g.Expect(deleted).To(gomega.ConsistOf("1", "2", "3"))
+}
+
+func TestAssignFloatingIP(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	assigned := []int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/floating_ips/1/actions/assign", func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]int{}
+		g.Expect(json.NewDecoder(r.Body).Decode(&req)).To(gomega.Succeed())
+		assigned = append(assigned, req["server"])
+		reply(w, http.StatusCreated, map[string]interface{}{"action": map[string]interface{}{"id": 7, "status": "running"}})
+	})
+	mux.HandleFunc("/actions/7", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]interface{}{"action": map[string]interface{}{"id": 7, "status": "success"}})
+	})
+
+	client, done := newTestClient(mux)
+	defer done()
+	ctx := context.Background()
+
+	fip := &hcloudpkg.FloatingIP{ID: 1, IP: net.ParseIP("203.0.113.10")}
+	g.Expect(assignFloatingIP(ctx, client, fip, &hcloudpkg.Server{ID: 42})).To(gomega.Succeed())
+	g.Expect(assigned).To(gomega.Equal([]int{42}))
+
+	//Left alone once assigned to the server, moved off any other one
+	fip.Server = &hcloudpkg.Server{ID: 42}
+	g.Expect(assignFloatingIP(ctx, client, fip, &hcloudpkg.Server{ID: 42})).To(gomega.Succeed())
+	g.Expect(assigned).To(gomega.Equal([]int{42}))
+	g.Expect(assignFloatingIP(ctx, client, fip, &hcloudpkg.Server{ID: 43})).To(gomega.Succeed())
+	g.Expect(assigned).To(gomega.Equal([]int{42, 43}))
+}
+
+func TestWithFloatingIP(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	input, err := generate.NewInput("test", []string{"203.0.113.10"}, "1.16.0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	userdata, err := generate.Config(generate.TypeInit, input)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	userdata, err = withFloatingIP(userdata, "203.0.113.10")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	type device struct {
+		Interface string
+		CIDR      string
+	}
+	ud := struct {
+		Machine struct {
+			Network struct {
+				Interfaces []device
+			}
+			CA interface{}
+		}
+	}{}
+	g.Expect(yaml.Unmarshal([]byte(userdata), &ud)).To(gomega.Succeed())
+	g.Expect(ud.Machine.CA).NotTo(gomega.BeNil())
+	g.Expect(ud.Machine.Network.Interfaces).To(gomega.Equal([]device{{Interface: "lo", CIDR: "203.0.113.10/32"}}))
+
+	//Interfaces configured already are kept
+	userdata, err = withFloatingIP("machine:\n  network:\n    interfaces:\n    - interface: eth1\n      cidr: 10.0.0.2/24\n", "203.0.113.10")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(yaml.Unmarshal([]byte(userdata), &ud)).To(gomega.Succeed())
+	g.Expect(ud.Machine.Network.Interfaces).To(gomega.Equal([]device{
+		{Interface: "eth1", CIDR: "10.0.0.2/24"},
+		{Interface: "lo", CIDR: "203.0.113.10/32"},
+	}))
+
+	_, err = withFloatingIP("version: v1alpha1\n", "203.0.113.10")
+	g.Expect(err).To(gomega.MatchError("[Hcloud] Userdata has no machine section"))
+}
+
+func TestFetchServer(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	name := utils.MachineResourceName(cluster, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde"}})
+	server := map[string]interface{}{"id": 42, "name": name, "status": "running"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/servers", func(w http.ResponseWriter, r *http.Request) {
+		servers := []interface{}{}
+		if r.URL.Query().Get("name") == name && r.URL.Query().Get("label_selector") == clusterSelector(cluster) {
+			servers = append(servers, server)
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"servers": servers})
+	})
+	mux.HandleFunc("/servers/42", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]interface{}{"server": server})
+	})
+	mux.HandleFunc("/servers/43", func(w http.ResponseWriter, r *http.Request) {
+		notFound(w)
+	})
+
+	client, done := newTestClient(mux)
+	defer done()
+	ctx := context.Background()
+
+	//By name and cluster labels until the ID is known
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde", Namespace: "default"}}
+	found, err := fetchServer(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found.ID).To(gomega.Equal(42))
+
+	machine.ObjectMeta.Name = "test-workers-fghij"
+	found, err = fetchServer(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeNil())
+
+	//Not the server of a namesake cluster in another namespace
+	namesake := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "other"}}
+	found, err = fetchServer(ctx, client, namesake, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-workers-abcde", Namespace: "other"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeNil())
+
+	//The ID recorded in the status wins over the name
+	g.Expect(utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = "42"
+	})).To(gomega.Succeed())
+	found, err = fetchServer(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found.Name).To(gomega.Equal(name))
+
+	//A server deleted out of band is gone
+	g.Expect(utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = "43"
+	})).To(gomega.Succeed())
+	found, err = fetchServer(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeNil())
+
+	//The provider ID is used when the status is empty
+	providerID := utils.ProviderID("hcloud", "42")
+	machine = &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-workers-fghij"}, Spec: clusterv1.MachineSpec{ProviderID: &providerID}}
+	found, err = fetchServer(ctx, client, cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found.ID).To(gomega.Equal(42))
+}
+
+func TestResolveImage(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+		images := []interface{}{}
+		switch {
+		case r.URL.Query().Get("name") == "debian-10":
+			images = append(images, map[string]interface{}{"id": 1, "name": "debian-10", "type": "system"})
+		case r.URL.Query().Get("type") == "snapshot":
+			images = append(images, map[string]interface{}{"id": 7001, "description": "talos v0.4.0", "type": "snapshot"})
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"images": images})
+	})
+
+	client, done := newTestClient(mux)
+	defer done()
+	ctx := context.Background()
+
+	image, err := resolveImage(ctx, client, "debian-10")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(image.ID).To(gomega.Equal(1))
+
+	image, err = resolveImage(ctx, client, "talos v0.4.0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(image.ID).To(gomega.Equal(7001))
+
+	_, err = resolveImage(ctx, client, "talos v0.5.0")
+	g.Expect(err).To(gomega.MatchError(`[Hcloud] No image or snapshot "talos v0.5.0"`))
+}
+
+func TestSetStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	status := &talosv1.TalosMachineProviderStatusStatus{}
+	setStatus(status, &hcloudpkg.Server{
+		ID:         42,
+		Status:     hcloudpkg.ServerStatusRunning,
+		Datacenter: &hcloudpkg.Datacenter{Location: &hcloudpkg.Location{Name: "nbg1"}},
+		PublicNet:  hcloudpkg.ServerPublicNet{IPv4: hcloudpkg.ServerPublicNetIPv4{IP: net.ParseIP("198.51.100.4")}},
+		PrivateNet: []hcloudpkg.ServerPrivateNet{{IP: net.ParseIP("10.0.0.2")}},
+	})
+
+	g.Expect(status.InstanceID).To(gomega.Equal("42"))
+	g.Expect(status.InstanceState).To(gomega.Equal("running"))
+	g.Expect(status.Zone).To(gomega.Equal("nbg1"))
+	g.Expect(status.Addresses).To(gomega.Equal([]corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.2"},
+		{Type: corev1.NodeExternalIP, Address: "198.51.100.4"},
+	}))
+}
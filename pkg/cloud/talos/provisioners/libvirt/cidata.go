@@ -0,0 +1,136 @@
+package libvirt
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/talos-systems/talos/pkg/constants"
+)
+
+// sectorSize is the logical block size of the ISO 9660 images built for config drives
+const sectorSize = 2048
+
+// isoFile is a file in the root directory of an ISO 9660 image
+type isoFile struct {
+	// name is the ISO 9660 file identifier, e.g. USER-DATA.;1
+	name string
+	data []byte
+}
+
+// configDrive builds a NoCloud config drive, an ISO 9660 image labelled cidata holding the user-data and meta-data files.
+// Talos reads its config from the user-data file when booted with talos.platform=metal talos.config=cidata.
+func configDrive(hostname, userdata string) []byte {
+	return buildISO(constants.UserDataCIData, []isoFile{
+		{name: "META-DATA.;1", data: []byte(fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", hostname, hostname))},
+		{name: "USER-DATA.;1", data: []byte(userdata)},
+	})
+}
+
+// buildISO lays out a minimal ISO 9660 image with a single root directory.
+// Files must be sorted by name and their directory records must fit in one sector.
+func buildISO(label string, files []isoFile) []byte {
+	const (
+		pvdSector        = 16
+		terminatorSector = 17
+		lPathSector      = 18
+		mPathSector      = 19
+		rootSector       = 20
+	)
+
+	extents := make([]uint32, len(files))
+	next := uint32(rootSector + 1)
+	for i, f := range files {
+		extents[i] = next
+		next += uint32((len(f.data) + sectorSize - 1) / sectorSize)
+	}
+	image := make([]byte, int(next)*sectorSize)
+
+	root := directoryRecord(rootSector, sectorSize, true, "\x00")
+	dir := append([]byte{}, root...)
+	dir = append(dir, directoryRecord(rootSector, sectorSize, true, "\x01")...)
+	for i, f := range files {
+		dir = append(dir, directoryRecord(extents[i], uint32(len(f.data)), false, f.name)...)
+		copy(image[int(extents[i])*sectorSize:], f.data)
+	}
+	copy(image[rootSector*sectorSize:], dir)
+
+	lPath := pathTableRecord(rootSector, binary.LittleEndian)
+	copy(image[lPathSector*sectorSize:], lPath)
+	copy(image[mPathSector*sectorSize:], pathTableRecord(rootSector, binary.BigEndian))
+
+	pvd := image[pvdSector*sectorSize : (pvdSector+1)*sectorSize]
+	pvd[0] = 1
+	copy(pvd[1:6], "CD001")
+	pvd[6] = 1
+	fill(pvd[8:72], ' ')
+	copy(pvd[40:72], label)
+	putBothEndian32(pvd[80:88], next)
+	putBothEndian16(pvd[120:124], 1)
+	putBothEndian16(pvd[124:128], 1)
+	putBothEndian16(pvd[128:132], sectorSize)
+	putBothEndian32(pvd[132:140], uint32(len(lPath)))
+	binary.LittleEndian.PutUint32(pvd[140:144], lPathSector)
+	binary.BigEndian.PutUint32(pvd[148:152], mPathSector)
+	copy(pvd[156:190], root)
+	//Volume set, publisher, preparer, application and file identifiers are left blank
+	fill(pvd[190:813], ' ')
+	//Creation, modification, expiration and effective dates are left unspecified
+	for _, offset := range []int{813, 830, 847, 864} {
+		fill(pvd[offset:offset+16], '0')
+	}
+	pvd[881] = 1
+
+	terminator := image[terminatorSector*sectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:6], "CD001")
+	terminator[6] = 1
+
+	return image
+}
+
+// directoryRecord encodes the directory record of a file, or of a directory when dir is set
+func directoryRecord(extent, size uint32, dir bool, name string) []byte {
+	length := 33 + len(name)
+	if length%2 == 1 {
+		length++
+	}
+
+	record := make([]byte, length)
+	record[0] = byte(length)
+	putBothEndian32(record[2:10], extent)
+	putBothEndian32(record[10:18], size)
+	//Recorded on 1970-01-01
+	record[18], record[19], record[20] = 70, 1, 1
+	if dir {
+		record[25] = 2
+	}
+	putBothEndian16(record[28:32], 1)
+	record[32] = byte(len(name))
+	copy(record[33:], name)
+	return record
+}
+
+// pathTableRecord encodes the path table entry of the root directory
+func pathTableRecord(extent uint32, order binary.ByteOrder) []byte {
+	record := make([]byte, 10)
+	record[0] = 1
+	order.PutUint32(record[2:6], extent)
+	order.PutUint16(record[6:8], 1)
+	return record
+}
+
+func putBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b[0:2], v)
+	binary.BigEndian.PutUint16(b[2:4], v)
+}
+
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:4], v)
+	binary.BigEndian.PutUint32(b[4:8], v)
+}
+
+func fill(b []byte, c byte) {
+	for i := range b {
+		b[i] = c
+	}
+}
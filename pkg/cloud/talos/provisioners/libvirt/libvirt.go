@@ -0,0 +1,799 @@
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// dialTimeout bounds the time spent connecting to the libvirt daemon
+const dialTimeout = 10 * time.Second
+
+// Libvirt represents a provider for libvirt.
+type Libvirt struct {
+}
+
+// hypervisor is the part of the libvirt RPC API used by the provisioner
+type hypervisor interface {
+	Disconnect() error
+
+	DomainDefineXML(XML string) (golibvirt.Domain, error)
+	DomainCreate(Dom golibvirt.Domain) error
+	DomainLookupByName(Name string) (golibvirt.Domain, error)
+	DomainLookupByUUID(UUID golibvirt.UUID) (golibvirt.Domain, error)
+	DomainGetState(Dom golibvirt.Domain, Flags uint32) (int32, int32, error)
+	DomainInterfaceAddresses(Dom golibvirt.Domain, Source uint32, Flags uint32) ([]golibvirt.DomainInterface, error)
+	DomainDestroy(Dom golibvirt.Domain) error
+	DomainUndefineFlags(Dom golibvirt.Domain, Flags golibvirt.DomainUndefineFlagsValues) error
+
+	NetworkLookupByName(Name string) (golibvirt.Network, error)
+	NetworkGetXMLDesc(Net golibvirt.Network, Flags uint32) (string, error)
+	NetworkGetDhcpLeases(Net golibvirt.Network, Mac golibvirt.OptString, NeedResults int32, Flags uint32) ([]golibvirt.NetworkDhcpLease, uint32, error)
+	NetworkUpdate(Net golibvirt.Network, Command uint32, Section uint32, ParentIndex int32, XML string, Flags golibvirt.NetworkUpdateFlags) error
+
+	StoragePoolLookupByName(Name string) (golibvirt.StoragePool, error)
+	StoragePoolListAllVolumes(Pool golibvirt.StoragePool, NeedResults int32, Flags uint32) ([]golibvirt.StorageVol, uint32, error)
+	StorageVolLookupByName(Pool golibvirt.StoragePool, Name string) (golibvirt.StorageVol, error)
+	StorageVolGetXMLDesc(Vol golibvirt.StorageVol, Flags uint32) (string, error)
+	StorageVolCreateXML(Pool golibvirt.StoragePool, XML string, Flags golibvirt.StorageVolCreateFlags) (golibvirt.StorageVol, error)
+	StorageVolUpload(Vol golibvirt.StorageVol, outStream io.Reader, Offset uint64, Length uint64, Flags golibvirt.StorageVolUploadFlags) error
+	StorageVolDelete(Vol golibvirt.StorageVol, Flags golibvirt.StorageVolDeleteFlags) error
+}
+
+// session is a connection to a libvirt daemon
+type session struct {
+	client hypervisor
+}
+
+func init() {
+	provisioners.Register("libvirt", func() (provisioners.Provisioner, error) {
+		return NewLibvirt()
+	})
+}
+
+// NewLibvirt returns an instance of the libvirt provisioner
+func NewLibvirt() (*Libvirt, error) {
+	return &Libvirt{}, nil
+}
+
+// Create defines and starts a domain booting from a copy-on-write clone of the Talos disk image, passing the userdata through a config drive.
+func (libvirt *Libvirt) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	libvirtConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	s, err := connect(libvirtConfig.URI)
+	if err != nil {
+		return err
+	}
+	defer s.disconnect()
+
+	//Control plane nodes boot with the MAC of their DHCP reservation
+	mac := ""
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+	if utils.IsControlPlane(role) {
+		host, err := s.reservation(cluster, libvirtConfig.Network, index)
+		if err != nil {
+			return err
+		}
+		if host == nil {
+			return fmt.Errorf("[Libvirt] No DHCP reservation for control plane node %d on network %s", index, libvirtConfig.Network)
+		}
+		mac = host.MAC
+	}
+
+	dom, err := s.createDomain(utils.MachineResourceName(cluster, machine), libvirtConfig, string(udSecret.Data["userdata"]), mac)
+	if err != nil {
+		return err
+	}
+
+	if err = s.setStatus(machine, *dom); err != nil {
+		return err
+	}
+
+	log.Println("[Libvirt] Domain created with uuid: " + uuidString(dom.UUID))
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+}
+
+// Update refreshes the provider ID and status of a domain.
+func (libvirt *Libvirt) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	libvirtConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := connect(libvirtConfig.URI)
+	if err != nil {
+		return err
+	}
+	defer s.disconnect()
+
+	dom, err := s.fetchDomain(cluster, machine)
+	if err != nil {
+		return err
+	}
+	if dom == nil {
+		return nil
+	}
+
+	return s.setStatus(machine, *dom)
+}
+
+// Delete destroys and undefines a domain, then deletes its volumes.
+func (libvirt *Libvirt) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	libvirtConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := connect(libvirtConfig.URI)
+	if err != nil {
+		return err
+	}
+	defer s.disconnect()
+
+	dom, err := s.fetchDomain(cluster, machine)
+	if err != nil {
+		return err
+	}
+	if dom != nil {
+		if err = s.destroy(*dom); err != nil {
+			return err
+		}
+	}
+
+	return s.deleteVolumes(libvirtConfig.Pool, utils.MachineResourceName(cluster, machine))
+}
+
+// Exists returns whether or not a domain is defined.
+func (libvirt *Libvirt) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+
+	libvirtConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return false, err
+	}
+
+	s, err := connect(libvirtConfig.URI)
+	if err != nil {
+		return false, err
+	}
+	defer s.disconnect()
+
+	dom, err := s.fetchDomain(cluster, machine)
+	if err != nil {
+		return false, err
+	}
+	return dom != nil, nil
+}
+
+// AllocateExternalIPs reserves a DHCP lease per control plane node on the network of the cluster.
+// Reservations that already exist are reused.
+func (libvirt *Libvirt) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	libvirtConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := connect(libvirtConfig.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer s.disconnect()
+
+	return s.reserveAddresses(cluster, libvirtConfig.Network, clusterSpec.ControlPlane.Count)
+}
+
+// DeAllocateExternalIPs removes the DHCP reservations of the control plane nodes.
+func (libvirt *Libvirt) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	libvirtConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := connect(libvirtConfig.URI)
+	if err != nil {
+		return err
+	}
+	defer s.disconnect()
+
+	return s.releaseAddresses(cluster, libvirtConfig.Network)
+}
+
+// ReleaseExternalIP removes the DHCP reservation of a control plane node removed by scaling down.
+func (libvirt *Libvirt) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	libvirtConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := connect(libvirtConfig.URI)
+	if err != nil {
+		return err
+	}
+	defer s.disconnect()
+
+	return s.releaseAddress(cluster, libvirtConfig.Network, index)
+}
+
+// createDomain clones the image and uploads the config drive into the pool, then defines and starts a domain using them.
+// Volumes left behind by a failed attempt are replaced.
+func (s *session) createDomain(name string, config *talosv1.LibvirtMachineConfig, userdata, mac string) (*golibvirt.Domain, error) {
+	if err := s.deleteVolumes(config.Pool, name); err != nil {
+		return nil, err
+	}
+
+	pool, err := s.client.StoragePoolLookupByName(config.Pool)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := s.client.StorageVolLookupByName(pool, config.Instances.Image)
+	if err != nil {
+		return nil, err
+	}
+	imageDesc, err := s.client.StorageVolGetXMLDesc(image, 0)
+	if err != nil {
+		return nil, err
+	}
+	imageVol := volumeXML{}
+	if err = xml.Unmarshal([]byte(imageDesc), &imageVol); err != nil {
+		return nil, err
+	}
+
+	//The boot disk may grow beyond the image, never shrink below it
+	capacity := uint64(config.Instances.Disks.Size) << 30
+	if capacity < imageVol.Capacity {
+		capacity = imageVol.Capacity
+	}
+	diskXML, err := xml.Marshal(volumeXML{
+		Name:         diskVolumeName(name),
+		Capacity:     capacity,
+		Target:       volumeTargetXML{Format: formatXML{Type: "qcow2"}},
+		BackingStore: &volumeTargetXML{Path: imageVol.Target.Path, Format: imageVol.Target.Format},
+	})
+	if err != nil {
+		return nil, err
+	}
+	disk, err := s.client.StorageVolCreateXML(pool, string(diskXML), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	iso := configDrive(name, userdata)
+	cidataXML, err := xml.Marshal(volumeXML{
+		Name:     cidataVolumeName(name),
+		Capacity: uint64(len(iso)),
+		Target:   volumeTargetXML{Format: formatXML{Type: "raw"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cidata, err := s.client.StorageVolCreateXML(pool, string(cidataXML), 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.client.StorageVolUpload(cidata, bytes.NewReader(iso), 0, uint64(len(iso)), 0); err != nil {
+		return nil, err
+	}
+
+	desc, err := xml.Marshal(newDomainXML(name, config, disk.Name, cidata.Name, mac))
+	if err != nil {
+		return nil, err
+	}
+	dom, err := s.client.DomainDefineXML(string(desc))
+	if err != nil {
+		return nil, err
+	}
+	if err = s.client.DomainCreate(dom); err != nil {
+		return nil, err
+	}
+
+	return &dom, nil
+}
+
+// destroy stops a domain if needed and undefines it
+func (s *session) destroy(dom golibvirt.Domain) error {
+	state, _, err := s.client.DomainGetState(dom, 0)
+	if err != nil {
+		return err
+	}
+	if golibvirt.DomainState(state) != golibvirt.DomainShutoff {
+		if err = s.client.DomainDestroy(dom); err != nil {
+			return err
+		}
+	}
+
+	return s.client.DomainUndefineFlags(dom, 0)
+}
+
+// deleteVolumes deletes the boot disk and config drive of a machine from the pool
+func (s *session) deleteVolumes(poolName, name string) error {
+	pool, err := s.client.StoragePoolLookupByName(poolName)
+	if err != nil {
+		return err
+	}
+	vols, _, err := s.client.StoragePoolListAllVolumes(pool, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, vol := range vols {
+		if vol.Name != diskVolumeName(name) && vol.Name != cidataVolumeName(name) {
+			continue
+		}
+		if err = s.client.StorageVolDelete(vol, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchDomain looks up the domain of a machine, by the UUID of its provider ID if set, and by its cluster-keyed name otherwise
+func (s *session) fetchDomain(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*golibvirt.Domain, error) {
+	id, err := utils.ParseProviderID(machine, "libvirt")
+	if err != nil {
+		return nil, err
+	}
+
+	var dom golibvirt.Domain
+	if id != "" {
+		uuid, err := parseUUID(id)
+		if err != nil {
+			return nil, err
+		}
+		dom, err = s.client.DomainLookupByUUID(uuid)
+	} else {
+		dom, err = s.client.DomainLookupByName(utils.MachineResourceName(cluster, machine))
+	}
+	if golibvirt.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dom, nil
+}
+
+// setStatus records the provider ID, state and leased addresses of a domain in the machine
+func (s *session) setStatus(machine *clusterv1.Machine, dom golibvirt.Domain) error {
+	state, _, err := s.client.DomainGetState(dom, 0)
+	if err != nil {
+		return err
+	}
+
+	//Leases are only known while the domain runs
+	addresses := []string{}
+	if golibvirt.DomainState(state) == golibvirt.DomainRunning {
+		ifaces, err := s.client.DomainInterfaceAddresses(dom, uint32(golibvirt.DomainInterfaceAddressesSrcLease), 0)
+		if err != nil {
+			return err
+		}
+		for _, iface := range ifaces {
+			for _, addr := range iface.Addrs {
+				if golibvirt.IPAddrType(addr.Type) == golibvirt.IPAddrTypeIpv4 {
+					addresses = append(addresses, addr.Addr)
+				}
+			}
+		}
+	}
+
+	uuid := uuidString(dom.UUID)
+	providerID := utils.ProviderID("libvirt", uuid)
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = uuid
+		status.InstanceState = stateName(state)
+		status.Addresses = nil
+		for _, ip := range addresses {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip})
+		}
+	})
+}
+
+// reserveAddresses makes sure the network has a DHCP reservation for each control plane node of the cluster and returns their IPs.
+// New reservations get deterministic MACs and the highest free addresses of the subnet, preferring those outside the dynamic ranges.
+func (s *session) reserveAddresses(cluster *clusterv1.Cluster, networkName string, count int) ([]string, error) {
+	network, err := s.client.NetworkLookupByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	subnet, err := s.dhcpSubnet(network)
+	if err != nil {
+		return nil, err
+	}
+	leases, _, err := s.client.NetworkGetDhcpLeases(network, nil, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{subnet.Address: true}
+	for _, host := range subnet.DHCP.Hosts {
+		used[host.IP] = true
+	}
+	for _, lease := range leases {
+		used[lease.Ipaddr] = true
+	}
+
+	ips := []string{}
+	for i := 0; i < count; i++ {
+		if host := subnet.reservation(cluster, i); host != nil {
+			ips = append(ips, host.IP)
+			continue
+		}
+
+		ip, err := subnet.freeAddress(used)
+		if err != nil {
+			return nil, err
+		}
+		used[ip] = true
+
+		host := dhcpHostXML{MAC: reservationMAC(cluster, i), Name: reservationName(cluster, i), IP: ip}
+		if err = s.updateHost(network, golibvirt.NetworkUpdateCommandAddLast, host); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// releaseAddresses removes the DHCP reservations of the control plane nodes of the cluster
+func (s *session) releaseAddresses(cluster *clusterv1.Cluster, networkName string) error {
+	network, err := s.client.NetworkLookupByName(networkName)
+	if err != nil {
+		return err
+	}
+	subnet, err := s.dhcpSubnet(network)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range subnet.DHCP.Hosts {
+		if _, ok := reservationIndex(cluster, host); !ok {
+			continue
+		}
+		if err = s.updateHost(network, golibvirt.NetworkUpdateCommandDelete, host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseAddress removes the DHCP reservation of a single control plane node of the cluster, if any
+func (s *session) releaseAddress(cluster *clusterv1.Cluster, networkName string, index int) error {
+	network, err := s.client.NetworkLookupByName(networkName)
+	if err != nil {
+		return err
+	}
+	subnet, err := s.dhcpSubnet(network)
+	if err != nil {
+		return err
+	}
+
+	host := subnet.reservation(cluster, index)
+	if host == nil {
+		return nil
+	}
+	return s.updateHost(network, golibvirt.NetworkUpdateCommandDelete, *host)
+}
+
+// reservation returns the DHCP reservation of a control plane node, or nil if there is none
+func (s *session) reservation(cluster *clusterv1.Cluster, networkName string, index int) (*dhcpHostXML, error) {
+	network, err := s.client.NetworkLookupByName(networkName)
+	if err != nil {
+		return nil, err
+	}
+	subnet, err := s.dhcpSubnet(network)
+	if err != nil {
+		return nil, err
+	}
+	return subnet.reservation(cluster, index), nil
+}
+
+// dhcpSubnet returns the IPv4 subnet of a network that libvirt serves DHCP on
+func (s *session) dhcpSubnet(network golibvirt.Network) (*networkIPXML, error) {
+	desc, err := s.client.NetworkGetXMLDesc(network, 0)
+	if err != nil {
+		return nil, err
+	}
+	parsed := networkXML{}
+	if err = xml.Unmarshal([]byte(desc), &parsed); err != nil {
+		return nil, err
+	}
+
+	for i := range parsed.IPs {
+		subnet := &parsed.IPs[i]
+		if (subnet.Family == "" || subnet.Family == "ipv4") && subnet.DHCP != nil {
+			return subnet, nil
+		}
+	}
+	return nil, fmt.Errorf("[Libvirt] Network %s has no IPv4 subnet with DHCP", network.Name)
+}
+
+// updateHost adds or removes a DHCP reservation, both in the running network and in its persistent config
+func (s *session) updateHost(network golibvirt.Network, command golibvirt.NetworkUpdateCommand, host dhcpHostXML) error {
+	desc, err := xml.Marshal(host)
+	if err != nil {
+		return err
+	}
+	return s.client.NetworkUpdate(network, uint32(command), uint32(golibvirt.NetworkSectionIPDhcpHost), -1, string(desc), golibvirt.NetworkUpdateAffectLive|golibvirt.NetworkUpdateAffectConfig)
+}
+
+func (s *session) disconnect() {
+	if err := s.client.Disconnect(); err != nil {
+		log.Printf("[Libvirt] Failed to disconnect: %v", err)
+	}
+}
+
+// connect opens a connection to the libvirt daemon listening on the unix socket or TCP address of the URI
+func connect(uri string) (*session, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "unix":
+		conn, err = net.DialTimeout("unix", u.Path, dialTimeout)
+	case "tcp":
+		conn, err = net.DialTimeout("tcp", u.Host, dialTimeout)
+	default:
+		return nil, fmt.Errorf("[Libvirt] Unsupported URI %q, use unix:// or tcp://", uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := golibvirt.New(conn)
+	if err = client.Connect(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &session{client: client}, nil
+}
+
+// reservationName is the name of the DHCP reservation of a control plane node
+func reservationName(cluster *clusterv1.Cluster, index int) string {
+	return fmt.Sprintf("%s-master-%d", utils.ClusterResourceName(cluster), index)
+}
+
+// reservationIndex returns the control plane index of a DHCP reservation of the cluster, and false if it isn't one.
+// Reservations of earlier versions are named after the cluster name only, and told apart from those of namesakes by their MAC.
+func reservationIndex(cluster *clusterv1.Cluster, host dhcpHostXML) (int, bool) {
+	for _, prefix := range []string{utils.ClusterResourceName(cluster) + "-master-", cluster.ObjectMeta.Name + "-master-"} {
+		if !strings.HasPrefix(host.Name, prefix) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(host.Name, prefix))
+		if err != nil || index < 0 {
+			continue
+		}
+		if host.Name == reservationName(cluster, index) || host.MAC == reservationMAC(cluster, index) {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// reservationMAC derives a stable MAC in the QEMU range for a control plane node
+func reservationMAC(cluster *clusterv1.Cluster, index int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", cluster.ObjectMeta.Namespace, cluster.ObjectMeta.Name, index)))
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", sum[0], sum[1], sum[2])
+}
+
+func diskVolumeName(name string) string {
+	return name + ".qcow2"
+}
+
+func cidataVolumeName(name string) string {
+	return name + "-cidata.iso"
+}
+
+// uuidString formats a domain UUID the way libvirt prints it
+func uuidString(uuid golibvirt.UUID) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+func parseUUID(s string) (golibvirt.UUID, error) {
+	var uuid golibvirt.UUID
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return uuid, err
+	}
+	if len(b) != len(uuid) {
+		return uuid, fmt.Errorf("[Libvirt] Invalid domain UUID %q", s)
+	}
+	copy(uuid[:], b)
+	return uuid, nil
+}
+
+// stateName returns the name virsh shows for a domain state
+func stateName(state int32) string {
+	switch golibvirt.DomainState(state) {
+	case golibvirt.DomainRunning:
+		return "running"
+	case golibvirt.DomainBlocked:
+		return "blocked"
+	case golibvirt.DomainPaused:
+		return "paused"
+	case golibvirt.DomainShutdown:
+		return "in shutdown"
+	case golibvirt.DomainShutoff:
+		return "shut off"
+	case golibvirt.DomainCrashed:
+		return "crashed"
+	case golibvirt.DomainPmsuspended:
+		return "pmsuspended"
+	default:
+		return "no state"
+	}
+}
+
+// clusterConfig returns the libvirt config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.LibvirtClusterConfig, error) {
+	if clusterSpec.Platform.Libvirt != nil {
+		return clusterSpec.Platform.Libvirt, nil
+	}
+
+	config := &talosv1.LibvirtClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the libvirt config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.LibvirtMachineConfig, error) {
+	if machineSpec.Platform.Libvirt != nil {
+		return machineSpec.Platform.Libvirt, nil
+	}
+
+	config := &talosv1.LibvirtMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// freeAddress returns the highest address of the subnet that is not used, preferring those outside the dynamic DHCP ranges
+func (subnet *networkIPXML) freeAddress(used map[string]bool) (string, error) {
+	ipnet, err := subnet.network()
+	if err != nil {
+		return "", err
+	}
+	ones, bits := ipnet.Mask.Size()
+	first := ipToUint32(ipnet.IP) + 1
+	last := first + (1 << uint(bits-ones)) - 3
+
+	fallback := ""
+	for n := last; n >= first; n-- {
+		ip := uint32ToIP(n).String()
+		if used[ip] {
+			continue
+		}
+		if !subnet.inRange(n) {
+			return ip, nil
+		}
+		if fallback == "" {
+			fallback = ip
+		}
+	}
+	if fallback == "" {
+		return "", errors.New("[Libvirt] No free address left for a DHCP reservation")
+	}
+	return fallback, nil
+}
+
+// network returns the subnet in CIDR form
+func (subnet *networkIPXML) network() (*net.IPNet, error) {
+	ip := net.ParseIP(subnet.Address).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("[Libvirt] Invalid network address %q", subnet.Address)
+	}
+
+	mask := net.CIDRMask(subnet.Prefix, 32)
+	if subnet.Netmask != "" {
+		netmask := net.ParseIP(subnet.Netmask).To4()
+		if netmask == nil {
+			return nil, fmt.Errorf("[Libvirt] Invalid netmask %q", subnet.Netmask)
+		}
+		mask = net.IPMask(netmask)
+	}
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// inRange returns whether an address belongs to a dynamic DHCP range
+func (subnet *networkIPXML) inRange(n uint32) bool {
+	for _, r := range subnet.DHCP.Ranges {
+		start, end := net.ParseIP(r.Start).To4(), net.ParseIP(r.End).To4()
+		if start != nil && end != nil && n >= ipToUint32(start) && n <= ipToUint32(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservation returns the DHCP reservation of a control plane node of the cluster, or nil if there is none
+func (subnet *networkIPXML) reservation(cluster *clusterv1.Cluster, index int) *dhcpHostXML {
+	for i := range subnet.DHCP.Hosts {
+		if found, ok := reservationIndex(cluster, subnet.DHCP.Hosts[i]); ok && found == index {
+			return &subnet.DHCP.Hosts[i]
+		}
+	}
+	return nil
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIP(n uint32) net.IP {
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
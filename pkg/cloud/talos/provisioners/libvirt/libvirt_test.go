@@ -0,0 +1,396 @@
+package libvirt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	golibvirt "github.com/digitalocean/go-libvirt"
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/talos/pkg/blockdevice/filesystem/iso9660"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// fakeHypervisor keeps one network, one pool and its domains in memory
+type fakeHypervisor struct {
+	network golibvirt.Network
+	subnet  networkIPXML
+	leases  []golibvirt.NetworkDhcpLease
+
+	pool    golibvirt.StoragePool
+	volumes map[string]string
+	uploads map[string][]byte
+
+	domains map[string]string
+	states  map[string]golibvirt.DomainState
+}
+
+func newFakeHypervisor() *fakeHypervisor {
+	return &fakeHypervisor{
+		network: golibvirt.Network{Name: "default"},
+		subnet: networkIPXML{
+			Address: "192.168.122.1",
+			Netmask: "255.255.255.0",
+			DHCP: &networkDHCPXML{
+				Ranges: []dhcpRangeXML{{Start: "192.168.122.2", End: "192.168.122.127"}},
+				Hosts:  []dhcpHostXML{{MAC: "52:54:00:00:00:01", Name: "other-master-0", IP: "192.168.122.254"}},
+			},
+		},
+		leases: []golibvirt.NetworkDhcpLease{{Ipaddr: "192.168.122.10"}},
+		pool:   golibvirt.StoragePool{Name: "default"},
+		volumes: map[string]string{
+			"talos.raw": `<volume><name>talos.raw</name><capacity unit="bytes">4294967296</capacity><target><path>/var/lib/libvirt/images/talos.raw</path><format type="raw"/></target></volume>`,
+		},
+		uploads: map[string][]byte{},
+		domains: map[string]string{},
+		states:  map[string]golibvirt.DomainState{},
+	}
+}
+
+func (f *fakeHypervisor) Disconnect() error { return nil }
+
+func (f *fakeHypervisor) DomainDefineXML(desc string) (golibvirt.Domain, error) {
+	dom := domainXML{}
+	if err := xml.Unmarshal([]byte(desc), &dom); err != nil {
+		return golibvirt.Domain{}, err
+	}
+	f.domains[dom.Name] = desc
+	f.states[dom.Name] = golibvirt.DomainShutoff
+	return fakeDomain(dom.Name), nil
+}
+
+func (f *fakeHypervisor) DomainCreate(dom golibvirt.Domain) error {
+	f.states[dom.Name] = golibvirt.DomainRunning
+	return nil
+}
+
+func (f *fakeHypervisor) DomainLookupByName(name string) (golibvirt.Domain, error) {
+	if _, ok := f.domains[name]; !ok {
+		return golibvirt.Domain{}, errors.New("domain not found")
+	}
+	return fakeDomain(name), nil
+}
+
+func (f *fakeHypervisor) DomainLookupByUUID(uuid golibvirt.UUID) (golibvirt.Domain, error) {
+	for name := range f.domains {
+		if dom := fakeDomain(name); dom.UUID == uuid {
+			return dom, nil
+		}
+	}
+	return golibvirt.Domain{}, errors.New("domain not found")
+}
+
+func (f *fakeHypervisor) DomainGetState(dom golibvirt.Domain, flags uint32) (int32, int32, error) {
+	return int32(f.states[dom.Name]), 0, nil
+}
+
+func (f *fakeHypervisor) DomainInterfaceAddresses(dom golibvirt.Domain, source uint32, flags uint32) ([]golibvirt.DomainInterface, error) {
+	return []golibvirt.DomainInterface{{
+		Name: "vnet0",
+		Addrs: []golibvirt.DomainIPAddr{
+			{Type: int32(golibvirt.IPAddrTypeIpv4), Addr: "192.168.122.253", Prefix: 24},
+			{Type: int32(golibvirt.IPAddrTypeIpv6), Addr: "fd00::2", Prefix: 64},
+		},
+	}}, nil
+}
+
+func (f *fakeHypervisor) DomainDestroy(dom golibvirt.Domain) error {
+	f.states[dom.Name] = golibvirt.DomainShutoff
+	return nil
+}
+
+func (f *fakeHypervisor) DomainUndefineFlags(dom golibvirt.Domain, flags golibvirt.DomainUndefineFlagsValues) error {
+	delete(f.domains, dom.Name)
+	delete(f.states, dom.Name)
+	return nil
+}
+
+func (f *fakeHypervisor) NetworkLookupByName(name string) (golibvirt.Network, error) {
+	if name != f.network.Name {
+		return golibvirt.Network{}, errors.New("network not found")
+	}
+	return f.network, nil
+}
+
+func (f *fakeHypervisor) NetworkGetXMLDesc(network golibvirt.Network, flags uint32) (string, error) {
+	desc, err := xml.Marshal(networkXML{IPs: []networkIPXML{{Family: "ipv6", Address: "fd00::1", Prefix: 64}, f.subnet}})
+	return string(desc), err
+}
+
+func (f *fakeHypervisor) NetworkGetDhcpLeases(network golibvirt.Network, mac golibvirt.OptString, needResults int32, flags uint32) ([]golibvirt.NetworkDhcpLease, uint32, error) {
+	return f.leases, uint32(len(f.leases)), nil
+}
+
+func (f *fakeHypervisor) NetworkUpdate(network golibvirt.Network, command uint32, section uint32, parentIndex int32, desc string, flags golibvirt.NetworkUpdateFlags) error {
+	if section != uint32(golibvirt.NetworkSectionIPDhcpHost) {
+		return errors.New("unexpected section")
+	}
+	host := dhcpHostXML{}
+	if err := xml.Unmarshal([]byte(desc), &host); err != nil {
+		return err
+	}
+
+	switch golibvirt.NetworkUpdateCommand(command) {
+	case golibvirt.NetworkUpdateCommandAddLast:
+		f.subnet.DHCP.Hosts = append(f.subnet.DHCP.Hosts, host)
+	case golibvirt.NetworkUpdateCommandDelete:
+		hosts := []dhcpHostXML{}
+		for _, h := range f.subnet.DHCP.Hosts {
+			if h.MAC != host.MAC || h.IP != host.IP {
+				hosts = append(hosts, h)
+			}
+		}
+		f.subnet.DHCP.Hosts = hosts
+	}
+	return nil
+}
+
+func (f *fakeHypervisor) StoragePoolLookupByName(name string) (golibvirt.StoragePool, error) {
+	return f.pool, nil
+}
+
+func (f *fakeHypervisor) StoragePoolListAllVolumes(pool golibvirt.StoragePool, needResults int32, flags uint32) ([]golibvirt.StorageVol, uint32, error) {
+	vols := []golibvirt.StorageVol{}
+	for name := range f.volumes {
+		vols = append(vols, golibvirt.StorageVol{Pool: pool.Name, Name: name})
+	}
+	return vols, uint32(len(vols)), nil
+}
+
+func (f *fakeHypervisor) StorageVolLookupByName(pool golibvirt.StoragePool, name string) (golibvirt.StorageVol, error) {
+	if _, ok := f.volumes[name]; !ok {
+		return golibvirt.StorageVol{}, errors.New("volume not found")
+	}
+	return golibvirt.StorageVol{Pool: pool.Name, Name: name}, nil
+}
+
+func (f *fakeHypervisor) StorageVolGetXMLDesc(vol golibvirt.StorageVol, flags uint32) (string, error) {
+	return f.volumes[vol.Name], nil
+}
+
+func (f *fakeHypervisor) StorageVolCreateXML(pool golibvirt.StoragePool, desc string, flags golibvirt.StorageVolCreateFlags) (golibvirt.StorageVol, error) {
+	vol := volumeXML{}
+	if err := xml.Unmarshal([]byte(desc), &vol); err != nil {
+		return golibvirt.StorageVol{}, err
+	}
+	if _, ok := f.volumes[vol.Name]; ok {
+		return golibvirt.StorageVol{}, errors.New("volume already exists")
+	}
+	f.volumes[vol.Name] = desc
+	return golibvirt.StorageVol{Pool: pool.Name, Name: vol.Name}, nil
+}
+
+func (f *fakeHypervisor) StorageVolUpload(vol golibvirt.StorageVol, r io.Reader, offset uint64, length uint64, flags golibvirt.StorageVolUploadFlags) error {
+	data, err := ioutil.ReadAll(r)
+	f.uploads[vol.Name] = data
+	return err
+}
+
+func (f *fakeHypervisor) StorageVolDelete(vol golibvirt.StorageVol, flags golibvirt.StorageVolDeleteFlags) error {
+	delete(f.volumes, vol.Name)
+	return nil
+}
+
+// fakeDomain derives the UUID of a fake domain from its name
+func fakeDomain(name string) golibvirt.Domain {
+	dom := golibvirt.Domain{Name: name}
+	copy(dom.UUID[:], name)
+	return dom
+}
+
+func TestReserveAddresses(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	f := newFakeHypervisor()
+	s := &session{client: f}
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	ips, err := s.reserveAddresses(cluster, "default", 3)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"192.168.122.253", "192.168.122.252", "192.168.122.251"}))
+	g.Expect(f.subnet.DHCP.Hosts).To(gomega.HaveLen(4))
+	g.Expect(f.subnet.DHCP.Hosts[1]).To(gomega.Equal(dhcpHostXML{
+		XMLName: xml.Name{Local: "host"},
+		MAC:     reservationMAC(cluster, 0),
+		Name:    reservationName(cluster, 0),
+		IP:      "192.168.122.253",
+	}))
+
+	again, err := s.reserveAddresses(cluster, "default", 3)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(again).To(gomega.Equal(ips))
+	g.Expect(f.subnet.DHCP.Hosts).To(gomega.HaveLen(4))
+
+	host, err := s.reservation(cluster, "default", 2)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(host.IP).To(gomega.Equal("192.168.122.251"))
+
+	//A namesake in another namespace gets its own reservations
+	namesake := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant"}}
+	host, err = s.reservation(namesake, "default", 2)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(host).To(gomega.BeNil())
+
+	g.Expect(s.releaseAddresses(cluster, "default")).To(gomega.Succeed())
+	g.Expect(f.subnet.DHCP.Hosts).To(gomega.ConsistOf(dhcpHostXML{MAC: "52:54:00:00:00:01", Name: "other-master-0", IP: "192.168.122.254"}))
+}
+
+func TestReservationIndex(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	namesake := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant"}}
+
+	index, ok := reservationIndex(cluster, dhcpHostXML{Name: reservationName(cluster, 1)})
+	g.Expect(ok).To(gomega.BeTrue())
+	g.Expect(index).To(gomega.Equal(1))
+
+	_, ok = reservationIndex(namesake, dhcpHostXML{Name: reservationName(cluster, 1)})
+	g.Expect(ok).To(gomega.BeFalse())
+
+	//Reservations of earlier versions are matched by their MAC
+	legacy := dhcpHostXML{Name: "test-master-2", MAC: reservationMAC(cluster, 2)}
+	index, ok = reservationIndex(cluster, legacy)
+	g.Expect(ok).To(gomega.BeTrue())
+	g.Expect(index).To(gomega.Equal(2))
+
+	_, ok = reservationIndex(namesake, legacy)
+	g.Expect(ok).To(gomega.BeFalse())
+}
+
+func TestFreeAddress(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	subnet := &networkIPXML{
+		Address: "10.0.0.1",
+		Prefix:  29,
+		DHCP:    &networkDHCPXML{Ranges: []dhcpRangeXML{{Start: "10.0.0.2", End: "10.0.0.6"}}},
+	}
+
+	//Without room outside the dynamic range, the highest unused address inside it is picked
+	ip, err := subnet.freeAddress(map[string]bool{"10.0.0.1": true, "10.0.0.6": true})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ip).To(gomega.Equal("10.0.0.5"))
+
+	_, err = subnet.freeAddress(map[string]bool{"10.0.0.1": true, "10.0.0.2": true, "10.0.0.3": true, "10.0.0.4": true, "10.0.0.5": true, "10.0.0.6": true})
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestCreateDomain(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-master-0"}}
+	name := utils.MachineResourceName(cluster, machine)
+
+	f := newFakeHypervisor()
+	s := &session{client: f}
+	config := &talosv1.LibvirtMachineConfig{
+		Network: "default",
+		Pool:    "default",
+		Instances: talosv1.LibvirtInstanceSpec{
+			Image:     "talos.raw",
+			CPUs:      2,
+			MemoryMiB: 2048,
+			Disks:     talosv1.DiskSpec{Size: 10},
+		},
+	}
+
+	//A volume left behind by an earlier attempt is replaced
+	f.volumes[name+"-cidata.iso"] = "<volume><name>" + name + "-cidata.iso</name></volume>"
+
+	dom, err := s.createDomain(name, config, "machine: {}\n", "52:54:00:aa:bb:cc")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(f.states[name]).To(gomega.Equal(golibvirt.DomainRunning))
+
+	disk := volumeXML{}
+	g.Expect(xml.Unmarshal([]byte(f.volumes[name+".qcow2"]), &disk)).To(gomega.Succeed())
+	g.Expect(disk.Capacity).To(gomega.Equal(uint64(10 << 30)))
+	g.Expect(disk.Target.Format.Type).To(gomega.Equal("qcow2"))
+	g.Expect(disk.BackingStore).To(gomega.Equal(&volumeTargetXML{Path: "/var/lib/libvirt/images/talos.raw", Format: formatXML{Type: "raw"}}))
+	g.Expect(f.uploads[name+"-cidata.iso"]).To(gomega.Equal(configDrive(name, "machine: {}\n")))
+
+	defined := domainXML{}
+	g.Expect(xml.Unmarshal([]byte(f.domains[name]), &defined)).To(gomega.Succeed())
+	g.Expect(defined.Devices.Disks).To(gomega.HaveLen(2))
+	g.Expect(defined.Devices.Disks[0].Source).To(gomega.Equal(domainDiskSourceXML{Pool: "default", Volume: name + ".qcow2"}))
+	g.Expect(defined.Devices.Disks[1].Source).To(gomega.Equal(domainDiskSourceXML{Pool: "default", Volume: name + "-cidata.iso"}))
+	g.Expect(defined.Devices.Interfaces[0].MAC).To(gomega.Equal(&domainInterfaceMACXML{Address: "52:54:00:aa:bb:cc"}))
+	g.Expect(defined.Memory).To(gomega.Equal(memoryXML{Unit: "MiB", Value: 2048}))
+
+	g.Expect(s.setStatus(machine, *dom)).To(gomega.Succeed())
+	g.Expect(*machine.Spec.ProviderID).To(gomega.Equal("libvirt://" + uuidString(dom.UUID)))
+
+	status, err := utils.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Status.InstanceState).To(gomega.Equal("running"))
+	g.Expect(status.Status.Addresses).To(gomega.ConsistOf(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.122.253"}))
+
+	fetched, err := s.fetchDomain(cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fetched).To(gomega.Equal(dom))
+
+	//Without a provider ID, domains are looked up by their cluster-keyed name
+	fetched, err = s.fetchDomain(cluster, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-master-0"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fetched).To(gomega.Equal(dom))
+
+	g.Expect(s.destroy(*dom)).To(gomega.Succeed())
+	g.Expect(s.deleteVolumes("default", name)).To(gomega.Succeed())
+	g.Expect(f.domains).To(gomega.BeEmpty())
+	g.Expect(f.volumes).To(gomega.HaveLen(1))
+	g.Expect(f.volumes).To(gomega.HaveKey("talos.raw"))
+}
+
+func TestConfigDrive(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	image := configDrive("test-master-0", "machine: {}\n")
+	g.Expect(len(image) % sectorSize).To(gomega.BeZero())
+
+	//Talos finds the drive by the label in its superblock
+	sb := &iso9660.SuperBlock{}
+	g.Expect(binary.Read(bytes.NewReader(image[sb.Offset():]), binary.BigEndian, sb)).To(gomega.Succeed())
+	g.Expect(sb.Is()).To(gomega.BeTrue())
+	g.Expect(string(bytes.TrimRight(sb.VolumeID[:], " "))).To(gomega.Equal("cidata"))
+
+	pvd := image[16*sectorSize:]
+	g.Expect(binary.LittleEndian.Uint32(pvd[80:84])).To(gomega.Equal(uint32(len(image) / sectorSize)))
+
+	//Walk the root directory, whose record is embedded in the volume descriptor
+	root := pvd[156:190]
+	extent := binary.LittleEndian.Uint32(root[2:6])
+	size := binary.LittleEndian.Uint32(root[10:14])
+	dir := image[extent*sectorSize : extent*sectorSize+size]
+
+	files := map[string]string{}
+	for offset := 0; offset < len(dir) && dir[offset] != 0; offset += int(dir[offset]) {
+		record := dir[offset:]
+		name := string(record[33 : 33+record[32]])
+		fileExtent := binary.BigEndian.Uint32(record[6:10])
+		fileSize := binary.BigEndian.Uint32(record[14:18])
+		files[name] = string(image[fileExtent*sectorSize : fileExtent*sectorSize+fileSize])
+	}
+	g.Expect(files).To(gomega.HaveKeyWithValue("USER-DATA.;1", "machine: {}\n"))
+	g.Expect(files).To(gomega.HaveKeyWithValue("META-DATA.;1", "instance-id: test-master-0\nlocal-hostname: test-master-0\n"))
+	g.Expect(files).To(gomega.HaveLen(4))
+}
+
+func TestParseUUID(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	uuid, err := parseUUID("dc229f87-d4de-4719-8cfd-2e21c6105b01")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(uuidString(uuid)).To(gomega.Equal("dc229f87-d4de-4719-8cfd-2e21c6105b01"))
+
+	_, err = parseUUID("dc229f87")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
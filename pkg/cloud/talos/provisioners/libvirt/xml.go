@@ -0,0 +1,199 @@
+package libvirt
+
+import (
+	"encoding/xml"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+)
+
+// networkXML is the part of the libvirt network XML describing its subnets
+type networkXML struct {
+	XMLName xml.Name       `xml:"network"`
+	IPs     []networkIPXML `xml:"ip"`
+}
+
+type networkIPXML struct {
+	Family  string          `xml:"family,attr"`
+	Address string          `xml:"address,attr"`
+	Netmask string          `xml:"netmask,attr"`
+	Prefix  int             `xml:"prefix,attr"`
+	DHCP    *networkDHCPXML `xml:"dhcp"`
+}
+
+type networkDHCPXML struct {
+	Ranges []dhcpRangeXML `xml:"range"`
+	Hosts  []dhcpHostXML  `xml:"host"`
+}
+
+type dhcpRangeXML struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// dhcpHostXML is a DHCP reservation, the unit of network updates
+type dhcpHostXML struct {
+	XMLName xml.Name `xml:"host"`
+	MAC     string   `xml:"mac,attr,omitempty"`
+	Name    string   `xml:"name,attr,omitempty"`
+	IP      string   `xml:"ip,attr,omitempty"`
+}
+
+// volumeXML is a storage volume, capacities are in bytes
+type volumeXML struct {
+	XMLName      xml.Name         `xml:"volume"`
+	Name         string           `xml:"name"`
+	Capacity     uint64           `xml:"capacity"`
+	Target       volumeTargetXML  `xml:"target"`
+	BackingStore *volumeTargetXML `xml:"backingStore,omitempty"`
+}
+
+type volumeTargetXML struct {
+	Path   string    `xml:"path,omitempty"`
+	Format formatXML `xml:"format"`
+}
+
+type formatXML struct {
+	Type string `xml:"type,attr"`
+}
+
+// domainXML is a KVM domain booting from its first disk
+type domainXML struct {
+	XMLName  xml.Name          `xml:"domain"`
+	Type     string            `xml:"type,attr"`
+	Name     string            `xml:"name"`
+	Memory   memoryXML         `xml:"memory"`
+	VCPU     int32             `xml:"vcpu"`
+	OS       domainOSXML       `xml:"os"`
+	Features domainFeaturesXML `xml:"features"`
+	CPU      domainCPUXML      `xml:"cpu"`
+	Devices  domainDevicesXML  `xml:"devices"`
+}
+
+type memoryXML struct {
+	Unit  string `xml:"unit,attr"`
+	Value int64  `xml:",chardata"`
+}
+
+type domainOSXML struct {
+	Type domainOSTypeXML `xml:"type"`
+	Boot domainBootXML   `xml:"boot"`
+}
+
+type domainOSTypeXML struct {
+	Arch  string `xml:"arch,attr"`
+	Value string `xml:",chardata"`
+}
+
+type domainBootXML struct {
+	Dev string `xml:"dev,attr"`
+}
+
+type domainFeaturesXML struct {
+	ACPI struct{} `xml:"acpi"`
+	APIC struct{} `xml:"apic"`
+}
+
+type domainCPUXML struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type domainDevicesXML struct {
+	Disks      []domainDiskXML      `xml:"disk"`
+	Interfaces []domainInterfaceXML `xml:"interface"`
+	Serial     domainConsoleXML     `xml:"serial"`
+	Console    domainConsoleXML     `xml:"console"`
+}
+
+type domainDiskXML struct {
+	Type     string              `xml:"type,attr"`
+	Device   string              `xml:"device,attr"`
+	Driver   domainDiskDriverXML `xml:"driver"`
+	Source   domainDiskSourceXML `xml:"source"`
+	Target   domainDiskTargetXML `xml:"target"`
+	ReadOnly *struct{}           `xml:"readonly"`
+}
+
+type domainDiskDriverXML struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type domainDiskSourceXML struct {
+	Pool   string `xml:"pool,attr"`
+	Volume string `xml:"volume,attr"`
+}
+
+type domainDiskTargetXML struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr"`
+}
+
+type domainInterfaceXML struct {
+	Type   string                   `xml:"type,attr"`
+	Source domainInterfaceSourceXML `xml:"source"`
+	MAC    *domainInterfaceMACXML   `xml:"mac"`
+	Model  domainInterfaceModelXML  `xml:"model"`
+}
+
+type domainInterfaceSourceXML struct {
+	Network string `xml:"network,attr"`
+}
+
+type domainInterfaceMACXML struct {
+	Address string `xml:"address,attr"`
+}
+
+type domainInterfaceModelXML struct {
+	Type string `xml:"type,attr"`
+}
+
+type domainConsoleXML struct {
+	Type string `xml:"type,attr"`
+}
+
+// newDomainXML describes a domain with a virtio boot disk, the config drive as a CD-ROM and a NIC on the network.
+// An empty MAC lets libvirt pick one.
+func newDomainXML(name string, config *talosv1.LibvirtMachineConfig, disk, cidata, mac string) *domainXML {
+	nic := domainInterfaceXML{
+		Type:   "network",
+		Source: domainInterfaceSourceXML{Network: config.Network},
+		Model:  domainInterfaceModelXML{Type: "virtio"},
+	}
+	if mac != "" {
+		nic.MAC = &domainInterfaceMACXML{Address: mac}
+	}
+
+	return &domainXML{
+		Type:   "kvm",
+		Name:   name,
+		Memory: memoryXML{Unit: "MiB", Value: config.Instances.MemoryMiB},
+		VCPU:   config.Instances.CPUs,
+		OS: domainOSXML{
+			Type: domainOSTypeXML{Arch: "x86_64", Value: "hvm"},
+			Boot: domainBootXML{Dev: "hd"},
+		},
+		CPU: domainCPUXML{Mode: "host-passthrough"},
+		Devices: domainDevicesXML{
+			Disks: []domainDiskXML{
+				{
+					Type:   "volume",
+					Device: "disk",
+					Driver: domainDiskDriverXML{Name: "qemu", Type: "qcow2"},
+					Source: domainDiskSourceXML{Pool: config.Pool, Volume: disk},
+					Target: domainDiskTargetXML{Dev: "vda", Bus: "virtio"},
+				},
+				{
+					Type:     "volume",
+					Device:   "cdrom",
+					Driver:   domainDiskDriverXML{Name: "qemu", Type: "raw"},
+					Source:   domainDiskSourceXML{Pool: config.Pool, Volume: cidata},
+					Target:   domainDiskTargetXML{Dev: "sda", Bus: "sata"},
+					ReadOnly: &struct{}{},
+				},
+			},
+			Interfaces: []domainInterfaceXML{nic},
+			Serial:     domainConsoleXML{Type: "pty"},
+			Console:    domainConsoleXML{Type: "pty"},
+		},
+	}
+}
@@ -0,0 +1,625 @@
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	openstackpkg "github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// activeTimeout is how long to wait in seconds for masters to become active before attaching their floating IP
+const activeTimeout = 600
+
+// OpenStack represents a provider for OpenStack.
+type OpenStack struct {
+}
+
+// session holds the compute and network clients of a region
+type session struct {
+	compute *gophercloud.ServiceClient
+	network *gophercloud.ServiceClient
+}
+
+// serverAddress is an entry of the addresses of a server
+type serverAddress struct {
+	Addr    string `json:"addr"`
+	Type    string `json:"OS-EXT-IPS:type"`
+	Version int    `json:"version"`
+}
+
+func init() {
+	provisioners.Register("openstack", func() (provisioners.Provisioner, error) {
+		return NewOpenStack()
+	})
+}
+
+// NewOpenStack returns an instance of the OpenStack provisioner
+func NewOpenStack() (*OpenStack, error) {
+	return &OpenStack{}, nil
+}
+
+// Create creates a server in OpenStack, attaching a floating IP to masters.
+func (openstack *OpenStack) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	osConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cluster, clientset, osConfig.Region)
+	if err != nil {
+		return err
+	}
+
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	server, err := s.createServer(utils.MachineResourceName(cluster, machine), utils.ClusterLabels(cluster), &osConfig.Instances, udSecret.Data["userdata"])
+	if err != nil {
+		return err
+	}
+
+	providerID := utils.ProviderID("openstack", "/"+server.ID)
+	machine.Spec.ProviderID = &providerID
+
+	//Wait for masters to be active, attach floating ip
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+	if utils.IsControlPlane(role) {
+		clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+		if err != nil {
+			return err
+		}
+		osClusterConfig, err := clusterConfig(clusterSpec)
+		if err != nil {
+			return err
+		}
+
+		if err = servers.WaitForStatus(s.compute, server.ID, "ACTIVE", activeTimeout); err != nil {
+			return err
+		}
+		if err = s.attachFloatingIP(cluster, osClusterConfig, index, server.ID); err != nil {
+			return err
+		}
+		if server, err = servers.Get(s.compute, server.ID).Extract(); err != nil {
+			return err
+		}
+	}
+
+	log.Println("[OpenStack] Instance created with id: " + server.ID)
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, server, osConfig)
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+}
+
+// Update refreshes the provider ID and status of a server.
+func (openstack *OpenStack) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	osConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cluster, clientset, osConfig.Region)
+	if err != nil {
+		return err
+	}
+
+	server, err := s.fetchServer(cluster, machine)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return nil
+	}
+
+	providerID := utils.ProviderID("openstack", "/"+server.ID)
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, server, osConfig)
+	})
+}
+
+// Delete deletes a server. Floating IPs stay allocated to the cluster.
+func (openstack *OpenStack) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	osConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cluster, clientset, osConfig.Region)
+	if err != nil {
+		return err
+	}
+
+	server, err := s.fetchServer(cluster, machine)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return nil
+	}
+
+	err = servers.Delete(s.compute, server.ID).ExtractErr()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
+	}
+	return err
+}
+
+// Exists returns whether or not a server is present in OpenStack.
+func (openstack *OpenStack) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+
+	osConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return false, err
+	}
+
+	s, err := newSession(cluster, clientset, osConfig.Region)
+	if err != nil {
+		return false, err
+	}
+
+	server, err := s.fetchServer(cluster, machine)
+	if err != nil {
+		return false, err
+	}
+	return server != nil, nil
+}
+
+// AllocateExternalIPs creates floating IPs for the control plane nodes.
+// IPs already allocated to the cluster are reused, so allocating twice is safe.
+func (openstack *OpenStack) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	osConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newSession(cluster, clientset, osConfig.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.allocateFloatingIPs(cluster, osConfig, clusterSpec.ControlPlane.Count)
+}
+
+// DeAllocateExternalIPs releases the floating IPs of the control plane nodes
+func (openstack *OpenStack) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	osConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cluster, clientset, osConfig.Region)
+	if err != nil {
+		return err
+	}
+
+	return s.releaseFloatingIPs(cluster, osConfig)
+}
+
+// ReleaseExternalIP deletes the floating IP of a control plane node removed by scaling down
+func (openstack *OpenStack) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	osConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cluster, clientset, osConfig.Region)
+	if err != nil {
+		return err
+	}
+
+	return s.releaseFloatingIP(cluster, osConfig, index)
+}
+
+// createServer boots a server from the image, flavor and networks of the instance spec, resolving their names to IDs.
+// The metadata tells the servers of namesake clusters apart.
+func (s *session) createServer(name string, metadata map[string]string, instances *talosv1.OpenStackInstanceSpec, userdata []byte) (*servers.Server, error) {
+	flavorID, err := resolveID(instances.Flavor, func(name string) (string, error) { return flavors.IDFromName(s.compute, name) })
+	if err != nil {
+		return nil, err
+	}
+	imageID, err := resolveID(instances.Image, func(name string) (string, error) { return images.IDFromName(s.compute, name) })
+	if err != nil {
+		return nil, err
+	}
+
+	nets := []servers.Network{}
+	for _, network := range instances.Networks {
+		networkID, err := resolveID(network, func(name string) (string, error) { return networks.IDFromName(s.network, name) })
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, servers.Network{UUID: networkID})
+	}
+
+	var createOpts servers.CreateOptsBuilder = servers.CreateOpts{
+		Name:             name,
+		FlavorRef:        flavorID,
+		ImageRef:         imageID,
+		Networks:         nets,
+		SecurityGroups:   instances.SecurityGroups,
+		AvailabilityZone: instances.AvailabilityZone,
+		UserData:         userdata,
+		Metadata:         metadata,
+	}
+	if instances.Keypair != "" {
+		createOpts = keypairs.CreateOptsExt{CreateOptsBuilder: createOpts, KeyName: instances.Keypair}
+	}
+
+	return servers.Create(s.compute, createOpts).Extract()
+}
+
+// fetchServer looks up the server of a machine, by its provider ID if set, and by name and cluster metadata otherwise
+func (s *session) fetchServer(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*servers.Server, error) {
+	providerID, err := utils.ParseProviderID(machine, "openstack")
+	if err != nil {
+		return nil, err
+	}
+	if providerID != "" {
+		server, err := servers.Get(s.compute, path.Base(providerID)).Extract()
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil, nil
+		}
+		return server, err
+	}
+
+	//Nova matches names as regular expressions
+	name := utils.MachineResourceName(cluster, machine)
+	pages, err := servers.List(s.compute, servers.ListOpts{Name: "^" + name + "$"}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	list, err := servers.ExtractServers(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, server := range list {
+		if server.Name == name && server.Status != "DELETED" && hasMetadata(server.Metadata, utils.ClusterLabels(cluster)) {
+			return &server, nil
+		}
+	}
+	return nil, nil
+}
+
+// hasMetadata returns whether a server carries all the given metadata
+func hasMetadata(metadata map[string]string, want map[string]string) bool {
+	for key, value := range want {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// allocateFloatingIPs returns one floating IP per control plane node, creating the missing ones
+func (s *session) allocateFloatingIPs(cluster *clusterv1.Cluster, config *talosv1.OpenStackClusterConfig, count int) ([]string, error) {
+	networkID, err := resolveID(config.FloatingIPNetwork, func(name string) (string, error) { return networks.IDFromName(s.network, name) })
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.listFloatingIPs(cluster, networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []string{}
+	for index := 0; index < count; index++ {
+		description := floatingIPDescription(cluster, index)
+		if fip, ok := existing[description]; ok {
+			ips = append(ips, fip.FloatingIP)
+			continue
+		}
+
+		fip, err := floatingips.Create(s.network, floatingips.CreateOpts{
+			Description:       description,
+			FloatingNetworkID: networkID,
+		}).Extract()
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, fip.FloatingIP)
+	}
+	return ips, nil
+}
+
+// releaseFloatingIPs deletes all floating IPs of the cluster
+func (s *session) releaseFloatingIPs(cluster *clusterv1.Cluster, config *talosv1.OpenStackClusterConfig) error {
+	networkID, err := resolveID(config.FloatingIPNetwork, func(name string) (string, error) { return networks.IDFromName(s.network, name) })
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.listFloatingIPs(cluster, networkID)
+	if err != nil {
+		return err
+	}
+
+	for _, fip := range existing {
+		err = floatingips.Delete(s.network, fip.ID).ExtractErr()
+		if _, ok := err.(gophercloud.ErrDefault404); !ok && err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseFloatingIP deletes the floating IP of a control plane index, if any
+func (s *session) releaseFloatingIP(cluster *clusterv1.Cluster, config *talosv1.OpenStackClusterConfig, index int) error {
+	networkID, err := resolveID(config.FloatingIPNetwork, func(name string) (string, error) { return networks.IDFromName(s.network, name) })
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.listFloatingIPs(cluster, networkID)
+	if err != nil {
+		return err
+	}
+	fip, ok := existing[floatingIPDescription(cluster, index)]
+	if !ok {
+		return nil
+	}
+
+	err = floatingips.Delete(s.network, fip.ID).ExtractErr()
+	if _, ok := err.(gophercloud.ErrDefault404); !ok && err != nil {
+		return err
+	}
+	return nil
+}
+
+// attachFloatingIP associates the floating IP of a control plane index with the first port of a server
+func (s *session) attachFloatingIP(cluster *clusterv1.Cluster, config *talosv1.OpenStackClusterConfig, index int, serverID string) error {
+	networkID, err := resolveID(config.FloatingIPNetwork, func(name string) (string, error) { return networks.IDFromName(s.network, name) })
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.listFloatingIPs(cluster, networkID)
+	if err != nil {
+		return err
+	}
+	fip, ok := existing[floatingIPDescription(cluster, index)]
+	if !ok {
+		return fmt.Errorf("[OpenStack] No floating IP allocated for control plane index %d", index)
+	}
+
+	pages, err := ports.List(s.network, ports.ListOpts{DeviceID: serverID}).AllPages()
+	if err != nil {
+		return err
+	}
+	serverPorts, err := ports.ExtractPorts(pages)
+	if err != nil {
+		return err
+	}
+	if len(serverPorts) == 0 {
+		return fmt.Errorf("[OpenStack] Server %s has no port", serverID)
+	}
+
+	_, err = floatingips.Update(s.network, fip.ID, floatingips.UpdateOpts{PortID: &serverPorts[0].ID}).Extract()
+	return err
+}
+
+// listFloatingIPs returns the floating IPs of the cluster in the network, keyed by description
+func (s *session) listFloatingIPs(cluster *clusterv1.Cluster, networkID string) (map[string]floatingips.FloatingIP, error) {
+	pages, err := floatingips.List(s.network, floatingips.ListOpts{FloatingNetworkID: networkID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	list, err := floatingips.ExtractFloatingIPs(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := floatingIPDescription(cluster, -1)
+	fips := map[string]floatingips.FloatingIP{}
+	for _, fip := range list {
+		if strings.HasPrefix(fip.Description, prefix) {
+			fips[fip.Description] = fip
+		}
+	}
+	return fips, nil
+}
+
+// floatingIPDescription identifies the floating IP of a control plane index, or with a negative index the prefix shared by all IPs of the cluster
+func floatingIPDescription(cluster *clusterv1.Cluster, index int) string {
+	prefix := "talos cluster " + cluster.ObjectMeta.Namespace + "/" + cluster.ObjectMeta.Name + " master "
+	if index < 0 {
+		return prefix
+	}
+	return prefix + strconv.Itoa(index)
+}
+
+// resolveID returns the ID of the named resource, or the value itself if no resource has that name
+func resolveID(value string, idFromName func(string) (string, error)) (string, error) {
+	id, err := idFromName(value)
+	if _, ok := err.(gophercloud.ErrResourceNotFound); ok {
+		return value, nil
+	}
+	return id, err
+}
+
+// setStatus records the ID, state, addresses and zone of a server in the machine provider status
+func setStatus(status *talosv1.TalosMachineProviderStatusStatus, server *servers.Server, config *talosv1.OpenStackMachineConfig) {
+	status.InstanceID = server.ID
+	status.InstanceState = server.Status
+	status.Zone = config.Instances.AvailabilityZone
+
+	status.Addresses = nil
+	for _, addr := range serverAddresses(server) {
+		if addr.Version != 4 {
+			continue
+		}
+		addressType := corev1.NodeInternalIP
+		if addr.Type == "floating" {
+			addressType = corev1.NodeExternalIP
+		}
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: addressType, Address: addr.Addr})
+	}
+}
+
+// serverAddresses flattens the addresses of a server, which are grouped by network name
+func serverAddresses(server *servers.Server) []serverAddress {
+	raw, err := json.Marshal(server.Addresses)
+	if err != nil {
+		return nil
+	}
+	byNetwork := map[string][]serverAddress{}
+	if err = json.Unmarshal(raw, &byNetwork); err != nil {
+		return nil
+	}
+
+	addresses := []serverAddress{}
+	for _, addrs := range byNetwork {
+		addresses = append(addresses, addrs...)
+	}
+	return addresses
+}
+
+// newSession authenticates with the OS_* settings referenced by the cluster if any, and the OS_* environment variables otherwise
+func newSession(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, region string) (*session, error) {
+	creds, err := utils.FetchCredentials(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := authOptions(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := openstackpkg.AuthenticatedClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	endpointOpts := gophercloud.EndpointOpts{Region: region}
+	compute, err := openstackpkg.NewComputeV2(provider, endpointOpts)
+	if err != nil {
+		return nil, err
+	}
+	network, err := openstackpkg.NewNetworkV2(provider, endpointOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &session{compute: compute, network: network}, nil
+}
+
+// authOptions reads the auth settings from credentials keyed like the OS_* environment variables of an openrc file
+func authOptions(creds map[string][]byte) (gophercloud.AuthOptions, error) {
+	if creds == nil {
+		return openstackpkg.AuthOptionsFromEnv()
+	}
+
+	authURL, err := utils.CredentialsValue(creds, "OS_AUTH_URL")
+	if err != nil {
+		return gophercloud.AuthOptions{}, err
+	}
+
+	opts := gophercloud.AuthOptions{
+		IdentityEndpoint:            string(authURL),
+		Username:                    string(creds["OS_USERNAME"]),
+		UserID:                      string(creds["OS_USERID"]),
+		Password:                    string(creds["OS_PASSWORD"]),
+		TenantID:                    string(creds["OS_TENANT_ID"]),
+		TenantName:                  string(creds["OS_TENANT_NAME"]),
+		DomainID:                    string(creds["OS_DOMAIN_ID"]),
+		DomainName:                  string(creds["OS_DOMAIN_NAME"]),
+		ApplicationCredentialID:     string(creds["OS_APPLICATION_CREDENTIAL_ID"]),
+		ApplicationCredentialName:   string(creds["OS_APPLICATION_CREDENTIAL_NAME"]),
+		ApplicationCredentialSecret: string(creds["OS_APPLICATION_CREDENTIAL_SECRET"]),
+	}
+	if projectID := creds["OS_PROJECT_ID"]; len(projectID) != 0 {
+		opts.TenantID = string(projectID)
+	}
+	if projectName := creds["OS_PROJECT_NAME"]; len(projectName) != 0 {
+		opts.TenantName = string(projectName)
+	}
+	return opts, nil
+}
+
+// clusterConfig returns the OpenStack config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.OpenStackClusterConfig, error) {
+	if clusterSpec.Platform.OpenStack != nil {
+		return clusterSpec.Platform.OpenStack, nil
+	}
+
+	config := &talosv1.OpenStackClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the OpenStack config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.OpenStackMachineConfig, error) {
+	if machineSpec.Platform.OpenStack != nil {
+		return machineSpec.Platform.OpenStack, nil
+	}
+
+	config := &talosv1.OpenStackMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,151 @@
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestFloatingIPs(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/networks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"networks": [{"id": "ext-net", "name": "public"}]}`)
+	})
+
+	fips := []map[string]string{
+		{"id": "fip-0", "floating_ip_address": "203.0.113.10", "description": "talos cluster default/test master 0"},
+		{"id": "fip-other", "floating_ip_address": "203.0.113.99", "description": "talos cluster default/other master 0"},
+	}
+	deleted := []string{}
+	th.Mux.HandleFunc("/floatingips", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			req := map[string]map[string]string{}
+			g.Expect(json.Unmarshal(body, &req)).To(gomega.Succeed())
+			g.Expect(req["floatingip"]["floating_network_id"]).To(gomega.Equal("ext-net"))
+
+			fip := map[string]string{
+				"id":                  fmt.Sprintf("fip-%d", len(fips)),
+				"floating_ip_address": fmt.Sprintf("203.0.113.%d", 10+len(fips)),
+				"description":         req["floatingip"]["description"],
+			}
+			fips = append(fips, fip)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{"floatingip": fip})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"floatingips": fips})
+	})
+	th.Mux.HandleFunc("/floatingips/", func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).To(gomega.Equal(http.MethodDelete))
+		deleted = append(deleted, r.URL.Path[len("/floatingips/"):])
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s := &session{compute: fakeclient.ServiceClient(), network: fakeclient.ServiceClient()}
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	config := &talosv1.OpenStackClusterConfig{FloatingIPNetwork: "public"}
+
+	ips, err := s.allocateFloatingIPs(cluster, config, 3)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"203.0.113.10", "203.0.113.12", "203.0.113.13"}))
+	g.Expect(fips).To(gomega.HaveLen(4))
+
+	again, err := s.allocateFloatingIPs(cluster, config, 3)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(again).To(gomega.Equal(ips))
+
+	g.Expect(s.releaseFloatingIP(cluster, config, 2)).To(gomega.Succeed())
+	g.Expect(s.releaseFloatingIP(cluster, config, 5)).To(gomega.Succeed())
+	g.Expect(deleted).To(gomega.Equal([]string{"fip-3"}))
+
+	deleted = []string{}
+	g.Expect(s.releaseFloatingIPs(cluster, config)).To(gomega.Succeed())
+	g.Expect(deleted).To(gomega.ConsistOf("fip-0", "fip-2", "fip-3"))
+}
+
+func TestSetStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	server := &servers.Server{
+		ID:     "8c9e0b4d",
+		Status: "ACTIVE",
+		Addresses: map[string]interface{}{
+			"private": []interface{}{
+				map[string]interface{}{"addr": "10.0.0.5", "OS-EXT-IPS:type": "fixed", "version": 4},
+				map[string]interface{}{"addr": "fd00::5", "OS-EXT-IPS:type": "fixed", "version": 6},
+				map[string]interface{}{"addr": "203.0.113.10", "OS-EXT-IPS:type": "floating", "version": 4},
+			},
+		},
+	}
+
+	status := &talosv1.TalosMachineProviderStatusStatus{}
+	setStatus(status, server, &talosv1.OpenStackMachineConfig{Instances: talosv1.OpenStackInstanceSpec{AvailabilityZone: "nova"}})
+	g.Expect(status.InstanceID).To(gomega.Equal("8c9e0b4d"))
+	g.Expect(status.InstanceState).To(gomega.Equal("ACTIVE"))
+	g.Expect(status.Zone).To(gomega.Equal("nova"))
+	g.Expect(status.Addresses).To(gomega.ConsistOf(
+		corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+		corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "203.0.113.10"},
+	))
+}
+
+func TestAuthOptions(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	opts, err := authOptions(map[string][]byte{
+		"OS_AUTH_URL":     []byte("https://keystone.example.com/v3"),
+		"OS_USERNAME":     []byte("talos"),
+		"OS_PASSWORD":     []byte("secret"),
+		"OS_PROJECT_NAME": []byte("clusters"),
+		"OS_DOMAIN_NAME":  []byte("Default"),
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(opts.IdentityEndpoint).To(gomega.Equal("https://keystone.example.com/v3"))
+	g.Expect(opts.TenantName).To(gomega.Equal("clusters"))
+
+	_, err = authOptions(map[string][]byte{"OS_USERNAME": []byte("talos")})
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestFetchServer(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-master-0", Namespace: "default"}}
+	name := utils.MachineResourceName(cluster, machine)
+
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.URL.Query().Get("name")).To(gomega.Equal("^" + name + "$"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"servers": []map[string]interface{}{
+			{"id": "other", "name": name, "status": "ACTIVE", "metadata": map[string]string{utils.ClusterNameLabel: "test", utils.ClusterNamespaceLabel: "other"}},
+			{"id": "mine", "name": name, "status": "ACTIVE", "metadata": utils.ClusterLabels(cluster)},
+		}})
+	})
+
+	s := &session{compute: fakeclient.ServiceClient()}
+	server, err := s.fetchServer(cluster, machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server).NotTo(gomega.BeNil())
+	g.Expect(server.ID).To(gomega.Equal("mine"))
+}
@@ -5,40 +5,22 @@ import (
 	"errors"
 	"log"
 	"net"
+	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/packethost/packngo"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
 	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
 	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
 
 // Packet represents a provider for Packet.
 type Packet struct {
-	client *packngo.Client
-}
-
-// ClusterInfo holds data about desired config in cluster object
-type ClusterInfo struct {
-	ProjectID string
-	IPBlock   string
-}
-
-// MachineInfo holds data about desired config in cluster object
-type MachineInfo struct {
-	ProjectID string
-	Instances InstanceInfo
-}
-
-// InstanceInfo holds data about the instances we'll create
-type InstanceInfo struct {
-	Plan     string
-	Facility string
-	PXEUrl   string
-	Install  map[string]interface{}
 }
 
 // Userdata holds userdata in struct form
@@ -58,14 +40,15 @@ type OS struct {
 	Devices []map[string]interface{} `yaml:"devices,omitempty"`
 }
 
+func init() {
+	provisioners.Register("packet", func() (provisioners.Provisioner, error) {
+		return NewPacket()
+	})
+}
+
 //NewPacket returns an instance of the Packet provisioner
 func NewPacket() (*Packet, error) {
-	c, err := packngo.NewClient()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return &Packet{client: c}, nil
+	return &Packet{}, nil
 }
 
 // Create creates an instance in Packet.
@@ -75,41 +58,53 @@ func (packet *Packet) Create(ctx context.Context, cluster *clusterv1.Cluster, ma
 		return err
 	}
 
-	clusterConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), clusterConfig)
+	packetClusterConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return err
+	}
 
 	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
 	if err != nil {
 		return err
 	}
 
-	packetConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), packetConfig)
+	packetConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	packetClient, err := client(cluster, clientset)
+	if err != nil {
+		return err
+	}
 
 	// Here we pull down the userdata config map and add the install section to the end if it's defined in the machine
-	udConfigMap, err := utils.FetchConfigMap(cluster, machine, clientset)
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
 	if err != nil {
 		return err
 	}
 	udStruct := &Userdata{}
-	yaml.Unmarshal([]byte(udConfigMap.Data["userdata"]), udStruct)
+	yaml.Unmarshal(udSecret.Data["userdata"], udStruct)
 
+	// The install section is kept as raw JSON in the spec, which is also valid YAML
 	if packetConfig.Instances.Install != nil {
-		udStruct.Install = packetConfig.Instances.Install
+		install := map[string]interface{}{}
+		if err = yaml.Unmarshal(packetConfig.Instances.Install.Raw, &install); err != nil {
+			return err
+		}
+		udStruct.Install = install
 	}
 
 	//Add network tweaks for elastic IPs to userdata
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return err
+	}
+
 	var floatingIP string
-	isMaster := strings.Contains(machine.ObjectMeta.Name, "master")
+	isMaster := utils.IsControlPlane(role)
 	if isMaster {
-		nameSlice := strings.Split(machine.ObjectMeta.Name, "-")
-		indexString := nameSlice[len(nameSlice)-1]
-		index, err := strconv.Atoi(indexString)
-		if err != nil {
-			return err
-		}
-
-		ipList, err := getIPList(packet.client, clusterConfig.ProjectID, clusterConfig.IPBlock)
+		ipList, err := getIPList(packetClient, packetClusterConfig.ProjectID, packetClusterConfig.IPBlock)
 		if err != nil {
 			return err
 		}
@@ -132,29 +127,32 @@ func (packet *Packet) Create(ctx context.Context, cluster *clusterv1.Cluster, ma
 	ud := "#!talos\n" + string(udBytes)
 
 	devCreateReq := &packngo.DeviceCreateRequest{
-		Hostname:      machine.ObjectMeta.Name,
+		Hostname:      utils.MachineResourceName(cluster, machine),
 		Plan:          packetConfig.Instances.Plan,
 		Facility:      []string{packetConfig.Instances.Facility},
 		OS:            "custom_ipxe",
 		BillingCycle:  "hourly",
 		ProjectID:     packetConfig.ProjectID,
 		UserData:      ud,
-		IPXEScriptURL: packetConfig.Instances.PXEUrl,
+		IPXEScriptURL: packetConfig.Instances.PXEURL,
 	}
 
-	dev, _, err := packet.client.Devices.Create(devCreateReq)
+	dev, _, err := packetClient.Devices.Create(devCreateReq)
 	if err != nil {
 		return err
 	}
 
+	providerID := utils.ProviderID("packet", dev.ID)
+	machine.Spec.ProviderID = &providerID
+
 	//Wait for masters to be active, attach floating ip
 	if isMaster {
-		err = packet.waitForStatus(machine, "active")
+		err = waitForStatus(packetClient, cluster, machine, "active")
 		if err != nil {
 			return err
 		}
 		ipReq := &packngo.AddressStruct{Address: floatingIP + "/32"}
-		_, _, err = packet.client.DeviceIPs.Assign(dev.ID, ipReq)
+		_, _, err = packetClient.DeviceIPs.Assign(dev.ID, ipReq)
 		if err != nil {
 			return err
 		}
@@ -162,24 +160,52 @@ func (packet *Packet) Create(ctx context.Context, cluster *clusterv1.Cluster, ma
 
 	log.Println("[Packet] Instance created with id: " + dev.ID)
 
-	return nil
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, dev)
+		if floatingIP != "" {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: floatingIP})
+		}
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
 }
 
 //Update updates a given Packet instance.
 func (packet *Packet) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
-	return nil
+	packetClient, err := client(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	dev, err := fetchDevice(packetClient, cluster, machine)
+	if err != nil {
+		return err
+	}
+	if dev == nil {
+		return nil
+	}
+
+	providerID := utils.ProviderID("packet", dev.ID)
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		setStatus(status, dev)
+	})
 }
 
 // Delete deletes a Packet instance.
 func (packet *Packet) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	packetClient, err := client(cluster, clientset)
+	if err != nil {
+		return err
+	}
 
-	dev, err := packet.fetchDevice(machine)
+	dev, err := fetchDevice(packetClient, cluster, machine)
 	if err != nil {
 		return err
 	}
 
 	if dev != nil {
-		_, err = packet.client.Devices.Delete(dev.ID)
+		_, err = packetClient.Devices.Delete(dev.ID)
 		if err != nil {
 			return err
 		}
@@ -189,8 +215,12 @@ func (packet *Packet) Delete(ctx context.Context, cluster *clusterv1.Cluster, ma
 
 // Exists returns whether or not an instance is present in AWS.
 func (packet *Packet) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	packetClient, err := client(cluster, clientset)
+	if err != nil {
+		return false, err
+	}
 
-	dev, err := packet.fetchDevice(machine)
+	dev, err := fetchDevice(packetClient, cluster, machine)
 	if err != nil {
 		return false, err
 	}
@@ -209,10 +239,17 @@ func (packet *Packet) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset
 	if err != nil {
 		return nil, err
 	}
-	packetConfig := &ClusterInfo{}
-	yaml.Unmarshal([]byte(clusterSpec.Platform.Config), packetConfig)
+	packetConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
 
-	ipList, err := getIPList(packet.client, packetConfig.ProjectID, packetConfig.IPBlock)
+	packetClient, err := client(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	ipList, err := getIPList(packetClient, packetConfig.ProjectID, packetConfig.IPBlock)
 	if err != nil {
 		return nil, err
 	}
@@ -227,8 +264,8 @@ func (packet *Packet) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientse
 }
 
 // Returns a full list of available IPs for a given CIDR block
-func getIPList(client *packngo.Client, projectID string, ipBlock string) ([]string, error) {
-	ipBlocks, _, err := client.ProjectIPs.List(projectID)
+func getIPList(packetClient *packngo.Client, projectID string, ipBlock string) ([]string, error) {
+	ipBlocks, _, err := packetClient.ProjectIPs.List(projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -240,7 +277,7 @@ func getIPList(client *packngo.Client, projectID string, ipBlock string) ([]stri
 	}
 
 	if desiredBlock.ID != "" {
-		available, _, err := client.ProjectIPs.AvailableAddresses(desiredBlock.ID, &packngo.AvailableRequest{CIDR: 32})
+		available, _, err := packetClient.ProjectIPs.AvailableAddresses(desiredBlock.ID, &packngo.AvailableRequest{CIDR: 32})
 		if err != nil {
 			return nil, err
 		}
@@ -260,30 +297,81 @@ func getIPList(client *packngo.Client, projectID string, ipBlock string) ([]stri
 	return nil, errors.New("[Packet] Unable to find or parse desired IP block")
 }
 
-func (packet *Packet) fetchDevice(machine *clusterv1.Machine) (*packngo.Device, error) {
+//fetchDevice looks up the device of a machine, by its provider ID if set, and by its cluster-keyed hostname otherwise.
+//Devices of clusters created by earlier versions are also found by the machine name they were given.
+func fetchDevice(packetClient *packngo.Client, cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*packngo.Device, error) {
+	providerID, err := utils.ParseProviderID(machine, "packet")
+	if err != nil {
+		return nil, err
+	}
+	if providerID != "" {
+		dev, resp, err := packetClient.Devices.Get(providerID, nil)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dev, nil
+	}
+
 	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
 	if err != nil {
 		return nil, err
 	}
-	packetConfig := &MachineInfo{}
-	yaml.Unmarshal([]byte(machineSpec.Platform.Config), packetConfig)
+	packetConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return nil, err
+	}
 
-	devList, _, err := packet.client.Devices.List(packetConfig.ProjectID, &packngo.ListOptions{})
+	legacy, err := utils.HasLegacyResourceNames(cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, dev := range devList {
-		if dev.Hostname == machine.ObjectMeta.Name {
-			return &dev, nil
+	devList, _, err := packetClient.Devices.List(packetConfig.ProjectID, &packngo.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	hostnames := []string{utils.MachineResourceName(cluster, machine)}
+	if legacy {
+		hostnames = append(hostnames, machine.ObjectMeta.Name)
+	}
+	for _, hostname := range hostnames {
+		for _, dev := range devList {
+			if dev.Hostname == hostname {
+				return &dev, nil
+			}
 		}
 	}
 	return nil, nil
 }
 
+//setStatus records the ID, state, addresses and facility of a device in the machine provider status
+func setStatus(status *talosv1.TalosMachineProviderStatusStatus, dev *packngo.Device) {
+	status.InstanceID = dev.ID
+	status.InstanceState = dev.State
+	if dev.Facility != nil {
+		status.Zone = dev.Facility.Code
+	}
+
+	status.Addresses = nil
+	for _, ip := range dev.Network {
+		if ip.AddressFamily != 4 {
+			continue
+		}
+		addressType := corev1.NodeInternalIP
+		if ip.Public {
+			addressType = corev1.NodeExternalIP
+		}
+		status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: addressType, Address: ip.Address})
+	}
+}
+
 //waitForStatus polls the Packet api for a certain instance status
 //needed for attaching elastic IP after boot
-func (packet *Packet) waitForStatus(machine *clusterv1.Machine, desiredState string) error {
+func waitForStatus(packetClient *packngo.Client, cluster *clusterv1.Cluster, machine *clusterv1.Machine, desiredState string) error {
 
 	timeout := time.After(600 * time.Second)
 	tick := time.Tick(3 * time.Second)
@@ -293,13 +381,56 @@ func (packet *Packet) waitForStatus(machine *clusterv1.Machine, desiredState str
 		case <-timeout:
 			return errors.New("[Packet] Timed out waiting for running instance")
 		case <-tick:
-			dev, err := packet.fetchDevice(machine)
+			dev, err := fetchDevice(packetClient, cluster, machine)
 			if err != nil {
 				return err
 			}
-			if dev.State == desiredState {
+			if dev != nil && dev.State == desiredState {
 				return nil
 			}
 		}
 	}
 }
+
+// client creates a Packet API client, with the token referenced by the cluster if any and PACKET_AUTH_TOKEN otherwise
+func client(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) (*packngo.Client, error) {
+	creds, err := utils.FetchCredentials(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return packngo.NewClient()
+	}
+
+	token, err := utils.CredentialsValue(creds, "auth-token")
+	if err != nil {
+		return nil, err
+	}
+	return packngo.NewClientWithAuth("cluster-api-provider-talos", string(token), nil), nil
+}
+
+// clusterConfig returns the Packet config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.PacketClusterConfig, error) {
+	if clusterSpec.Platform.Packet != nil {
+		return clusterSpec.Platform.Packet, nil
+	}
+
+	config := &talosv1.PacketClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the Packet config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.PacketMachineConfig, error) {
+	if machineSpec.Platform.Packet != nil {
+		return machineSpec.Platform.Packet, nil
+	}
+
+	config := &talosv1.PacketMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
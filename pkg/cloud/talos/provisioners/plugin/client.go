@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// Client is a provisioner backed by a plugin reachable over gRPC.
+// The clientset handed to its methods is not forwarded, plugins build their own.
+// The plugin protocol only covers provisioners.Provisioner: Client implements neither provisioners.EndpointProvisioner
+// nor provisioners.ExternalIPReleaser, so the control plane of plugin clusters is reached on the IP of its first node,
+// and the IPs of control plane nodes removed by scaling down stay allocated until the cluster is deleted.
+type Client struct {
+	// Timeout bounds each call to the plugin, DefaultTimeout is used when it is zero
+	Timeout time.Duration
+
+	conn   *grpc.ClientConn
+	client proto.ProvisionerClient
+	// restart starts the plugin again if it exited, it is only set for plugins launched by the manager
+	restart func() error
+}
+
+// DefaultTimeout bounds calls to plugins, it leaves room for provisioners waiting on their instances to start
+const DefaultTimeout = 10 * time.Minute
+
+var _ provisioners.Provisioner = &Client{}
+
+// NewClient returns a provisioner that forwards all calls over conn
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn, client: proto.NewProvisionerClient(conn)}
+}
+
+// prepare bounds a call with the timeout of the client, and starts launched plugins again if they exited.
+// Calls wait for the connection to be ready until their deadline instead of failing while the plugin restarts.
+func (c *Client) prepare(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	if c.restart != nil {
+		if err := c.restart(); err != nil {
+			return nil, nil, err
+		}
+		if c.conn.GetState() == connectivity.TransientFailure {
+			c.conn.ResetConnectBackoff()
+		}
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// Close closes the underlying connection to the plugin
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Create creates a machine through the plugin
+func (c *Client) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	req, err := machineRequest(cluster, machine)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	reply, err := c.client.Create(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	return updateMachine(machine, reply)
+}
+
+// Update updates a machine through the plugin
+func (c *Client) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	req, err := machineRequest(cluster, machine)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	reply, err := c.client.Update(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	return updateMachine(machine, reply)
+}
+
+// Delete deletes a machine through the plugin
+func (c *Client) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	req, err := machineRequest(cluster, machine)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	reply, err := c.client.Delete(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	return updateMachine(machine, reply)
+}
+
+// Exists asks the plugin whether a machine exists
+func (c *Client) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	req, err := machineRequest(cluster, machine)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel, err := c.prepare(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+
+	reply, err := c.client.Exists(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return false, fromStatus(err)
+	}
+
+	return reply.Exists, nil
+}
+
+// AllocateExternalIPs allocates control plane IPs through the plugin
+func (c *Client) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	req, err := clusterRequest(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel, err := c.prepare(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	reply, err := c.client.AllocateExternalIPs(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return reply.Ips, nil
+}
+
+// DeAllocateExternalIPs releases control plane IPs through the plugin
+func (c *Client) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	req, err := clusterRequest(cluster)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := c.prepare(context.Background())
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = c.client.DeAllocateExternalIPs(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	return nil
+}
+
+func machineRequest(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*proto.MachineRequest, error) {
+	clusterBytes, err := json.Marshal(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	machineBytes, err := json.Marshal(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.MachineRequest{Cluster: clusterBytes, Machine: machineBytes}, nil
+}
+
+func clusterRequest(cluster *clusterv1.Cluster) (*proto.ClusterRequest, error) {
+	clusterBytes, err := json.Marshal(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.ClusterRequest{Cluster: clusterBytes}, nil
+}
+
+// updateMachine replaces machine with the copy returned by the plugin
+func updateMachine(machine *clusterv1.Machine, reply *proto.MachineReply) error {
+	if len(reply.Machine) == 0 {
+		return nil
+	}
+
+	updated := &clusterv1.Machine{}
+	if err := json.Unmarshal(reply.Machine, updated); err != nil {
+		return err
+	}
+	*machine = *updated
+
+	return nil
+}
+
+// fromStatus strips the gRPC status wrapping so plugin errors read like in-tree ones
+func fromStatus(err error) error {
+	if s, ok := status.FromError(err); ok {
+		return errors.New(s.Message())
+	}
+	return err
+}
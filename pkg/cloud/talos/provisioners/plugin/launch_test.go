@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+func TestLaunchRestart(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "plugin-launch")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	//The plugin listens, counts its runs and exits shortly after
+	script := filepath.Join(dir, "plugin.sh")
+	runs := filepath.Join(dir, "runs")
+	body := "#!/bin/sh\necho run >> " + runs + "\ntouch \"$" + ListenAddressEnv + "\"\nsleep 1\n"
+	g.Expect(ioutil.WriteFile(script, []byte(body), 0755)).To(gomega.Succeed())
+
+	p, err := launch(script)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(filepath.Dir(p.socket))
+	g.Expect(p.target()).To(gomega.Equal("unix://" + p.socket))
+
+	count := func() int {
+		data, _ := ioutil.ReadFile(runs)
+		return len(data) / len("run\n")
+	}
+	g.Expect(count()).To(gomega.Equal(1))
+
+	//Once it exited, the next call starts it again
+	g.Eventually(func() bool {
+		select {
+		case <-p.exited:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second).Should(gomega.BeTrue())
+	g.Expect(p.ensure()).To(gomega.Succeed())
+	g.Expect(count()).To(gomega.Equal(2))
+}
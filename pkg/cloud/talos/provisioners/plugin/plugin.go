@@ -0,0 +1,189 @@
+// Package plugin serves and consumes provisioners over gRPC so that
+// platforms can live outside of the manager binary.
+//
+// The service definition lives in proto/provisioner.proto. Regenerate the
+// bindings with `protoc --go_out=plugins=grpc:. provisioner.proto` from the
+// proto directory.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"google.golang.org/grpc"
+)
+
+const (
+	// ExecPrefix marks a plugin target as a binary that the manager launches itself
+	ExecPrefix = "exec://"
+
+	// ListenAddressEnv is set for launched plugin binaries to the unix socket they must serve on
+	ListenAddressEnv = "TALOS_PROVISIONER_PLUGIN_LISTEN_ADDRESS"
+
+	launchTimeout = 30 * time.Second
+)
+
+// ParseSpec splits a "<platform>=<target>" plugin specification
+func ParseSpec(spec string) (name string, target string, err error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid plugin specification %q, expected <platform>=<target>", spec)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// Register makes the plugin at target available as the provisioner for the given platform.
+// The target is either the gRPC dial target of a running plugin, e.g. a sidecar on
+// "unix:///var/run/talos/plugin.sock" or "localhost:9090", or "exec://<path>" to have
+// the manager launch the plugin binary and talk to it over a private unix socket.
+// Arguments for the binary follow its path, separated by spaces.
+// Launched plugins that exit are started again by the next call made to them.
+func Register(name string, target string) error {
+	for _, platform := range provisioners.Platforms() {
+		if platform == name {
+			return fmt.Errorf("platform %q is already registered", name)
+		}
+	}
+
+	var p *process
+	if strings.HasPrefix(target, ExecPrefix) {
+		var err error
+		if p, err = launch(strings.TrimPrefix(target, ExecPrefix)); err != nil {
+			return err
+		}
+		target = p.target()
+	}
+
+	conn, err := grpc.Dial(target, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+
+	client := NewClient(conn)
+	if p != nil {
+		client.restart = p.ensure
+	}
+	provisioners.Register(name, func() (provisioners.Provisioner, error) {
+		return client, nil
+	})
+
+	log.Printf("Registered provisioner plugin %q at %s", name, target)
+
+	return nil
+}
+
+// process is a plugin binary launched by the manager, which is started again whenever it exits
+type process struct {
+	path   string
+	args   []string
+	socket string
+
+	mu     sync.Mutex
+	exited chan struct{}
+}
+
+// launch starts the plugin binary described by command on a private unix socket
+func launch(command string) (*process, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("missing provisioner plugin binary")
+	}
+
+	dir, err := ioutil.TempDir("", "talos-provisioner-plugin")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &process{path: args[0], args: args[1:], socket: filepath.Join(dir, "plugin.sock")}
+	if err = p.start(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// target returns the dial target of the socket of the plugin
+func (p *process) target() string {
+	return "unix://" + p.socket
+}
+
+// ensure starts the plugin again if it exited since it was last started
+func (p *process) ensure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.exited:
+		log.Printf("Restarting provisioner plugin %s", p.path)
+		return p.start()
+	default:
+		return nil
+	}
+}
+
+// start runs the plugin binary and waits for it to listen on its socket
+func (p *process) start() error {
+	//A socket left behind by the previous run would pass for the new one listening
+	if err := os.Remove(p.socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	cmd := exec.Command(p.path, p.args...)
+	cmd.Env = append(os.Environ(), ListenAddressEnv+"="+p.socket)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	p.exited = exited
+	var waitErr error
+	go func() {
+		waitErr = cmd.Wait()
+		log.Printf("Provisioner plugin %s exited: %v", p.path, waitErr)
+		close(exited)
+	}()
+
+	timeout := time.After(launchTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timeout:
+			return errors.New("timed out waiting for provisioner plugin " + p.path + " to listen")
+		case <-exited:
+			return fmt.Errorf("provisioner plugin %s exited before listening: %v", p.path, waitErr)
+		case <-ticker.C:
+			if _, err := os.Stat(p.socket); err == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// Listen opens the listener a plugin serves on. Addresses prefixed with "unix://",
+// as well as the bare socket path handed to launched plugins, are unix sockets.
+func Listen(address string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return net.Listen("unix", strings.TrimPrefix(address, "unix://"))
+	case strings.HasPrefix(address, "/"):
+		return net.Listen("unix", address)
+	default:
+		return net.Listen("tcp", address)
+	}
+}
@@ -0,0 +1,150 @@
+package plugin_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin/plugintest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// recorder is a provisioner that remembers what it was asked to do
+type recorder struct {
+	created []string
+	deleted []string
+}
+
+func (r *recorder) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	if machine.Name == "broken" {
+		return errors.New("instance quota exceeded")
+	}
+	if machine.Name == "stuck" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	r.created = append(r.created, cluster.Name+"/"+machine.Name)
+	machine.Annotations = map[string]string{"created-by": "plugin"}
+	return nil
+}
+
+func (r *recorder) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	return nil
+}
+
+func (r *recorder) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	r.deleted = append(r.deleted, cluster.Name+"/"+machine.Name)
+	return nil
+}
+
+func (r *recorder) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	for _, name := range r.created {
+		if name == cluster.Name+"/"+machine.Name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *recorder) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	return []string{"192.0.2.10", "192.0.2.11"}, nil
+}
+
+func (r *recorder) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	return errors.New("ips still in use")
+}
+
+func TestPluginRoundTrip(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	r := &recorder{}
+	client, stop, err := plugintest.Start(r)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer stop()
+
+	ctx := context.Background()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-master-0"}}
+
+	exists, err := client.Exists(ctx, cluster, machine, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeFalse())
+
+	g.Expect(client.Create(ctx, cluster, machine, nil)).To(gomega.Succeed())
+	g.Expect(r.created).To(gomega.Equal([]string{"test/test-master-0"}))
+	g.Expect(machine.Annotations).To(gomega.HaveKeyWithValue("created-by", "plugin"))
+
+	exists, err = client.Exists(ctx, cluster, machine, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(exists).To(gomega.BeTrue())
+
+	g.Expect(client.Delete(ctx, cluster, machine, nil)).To(gomega.Succeed())
+	g.Expect(r.deleted).To(gomega.Equal([]string{"test/test-master-0"}))
+
+	ips, err := client.AllocateExternalIPs(cluster, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"192.0.2.10", "192.0.2.11"}))
+
+	err = client.DeAllocateExternalIPs(cluster, nil)
+	g.Expect(err).To(gomega.MatchError("ips still in use"))
+
+	err = client.Create(ctx, cluster, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "broken"}}, nil)
+	g.Expect(err).To(gomega.MatchError("instance quota exceeded"))
+
+	//Calls to plugins that don't answer give up at the timeout of the client
+	client.Timeout = 100 * time.Millisecond
+	err = client.Create(ctx, cluster, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "stuck"}}, nil)
+	g.Expect(err).To(gomega.MatchError("context deadline exceeded"))
+}
+
+// endpointReleaser is a provisioner with a control plane endpoint of its own, which releases single IPs
+type endpointReleaser struct {
+	recorder
+}
+
+func (e *endpointReleaser) ControlPlaneEndpoint(cluster *clusterv1.Cluster) (string, error) {
+	return "192.0.2.100", nil
+}
+
+func (e *endpointReleaser) ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error {
+	return nil
+}
+
+func TestPluginOptionalInterfaces(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	var p provisioners.Provisioner = &endpointReleaser{}
+	_, ok := p.(provisioners.EndpointProvisioner)
+	g.Expect(ok).To(gomega.BeTrue())
+
+	//The plugin protocol doesn't carry the optional interfaces, clusters fall back to the first control plane IP and release IPs with the cluster
+	client, stop, err := plugintest.Start(p)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer stop()
+
+	p = client
+	_, ok = p.(provisioners.EndpointProvisioner)
+	g.Expect(ok).To(gomega.BeFalse())
+	_, ok = p.(provisioners.ExternalIPReleaser)
+	g.Expect(ok).To(gomega.BeFalse())
+}
+
+func TestParseSpec(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	name, target, err := plugin.ParseSpec("internal=unix:///var/run/internal.sock")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(name).To(gomega.Equal("internal"))
+	g.Expect(target).To(gomega.Equal("unix:///var/run/internal.sock"))
+
+	for _, spec := range []string{"internal", "=localhost:9090", "internal="} {
+		_, _, err = plugin.ParseSpec(spec)
+		g.Expect(err).To(gomega.HaveOccurred(), spec)
+	}
+}
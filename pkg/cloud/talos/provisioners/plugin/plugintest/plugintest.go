@@ -0,0 +1,44 @@
+// Package plugintest runs provisioner plugins in-process for tests.
+package plugintest
+
+import (
+	"context"
+	"net"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1024 * 1024
+
+// Start serves p over an in-memory gRPC connection and returns a client for it.
+// Calls made through the client take the same path as calls to an external plugin.
+// The returned function closes the client and stops the server.
+func Start(p provisioners.Provisioner) (*plugin.Client, func(), error) {
+	lis := bufconn.Listen(bufSize)
+
+	s := grpc.NewServer()
+	proto.RegisterProvisionerServer(s, plugin.NewServer(p, nil))
+	go s.Serve(lis)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	)
+	if err != nil {
+		s.Stop()
+		return nil, nil, err
+	}
+
+	client := plugin.NewClient(conn)
+
+	return client, func() {
+		client.Close()
+		s.Stop()
+	}, nil
+}
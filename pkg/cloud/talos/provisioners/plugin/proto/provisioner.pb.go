@@ -0,0 +1,554 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: provisioner.proto
+
+package proto
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type MachineRequest struct {
+	Cluster              []byte   `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Machine              []byte   `protobuf:"bytes,2,opt,name=machine,proto3" json:"machine,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MachineRequest) Reset()         { *m = MachineRequest{} }
+func (m *MachineRequest) String() string { return proto.CompactTextString(m) }
+func (*MachineRequest) ProtoMessage()    {}
+func (*MachineRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f14edf1aa1948a86, []int{0}
+}
+
+func (m *MachineRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MachineRequest.Unmarshal(m, b)
+}
+func (m *MachineRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MachineRequest.Marshal(b, m, deterministic)
+}
+func (m *MachineRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MachineRequest.Merge(m, src)
+}
+func (m *MachineRequest) XXX_Size() int {
+	return xxx_messageInfo_MachineRequest.Size(m)
+}
+func (m *MachineRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MachineRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MachineRequest proto.InternalMessageInfo
+
+func (m *MachineRequest) GetCluster() []byte {
+	if m != nil {
+		return m.Cluster
+	}
+	return nil
+}
+
+func (m *MachineRequest) GetMachine() []byte {
+	if m != nil {
+		return m.Machine
+	}
+	return nil
+}
+
+// MachineReply returns the machine as left by the provisioner so that
+// changes made to it are visible to the caller.
+type MachineReply struct {
+	Machine              []byte   `protobuf:"bytes,1,opt,name=machine,proto3" json:"machine,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MachineReply) Reset()         { *m = MachineReply{} }
+func (m *MachineReply) String() string { return proto.CompactTextString(m) }
+func (*MachineReply) ProtoMessage()    {}
+func (*MachineReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f14edf1aa1948a86, []int{1}
+}
+
+func (m *MachineReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MachineReply.Unmarshal(m, b)
+}
+func (m *MachineReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MachineReply.Marshal(b, m, deterministic)
+}
+func (m *MachineReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MachineReply.Merge(m, src)
+}
+func (m *MachineReply) XXX_Size() int {
+	return xxx_messageInfo_MachineReply.Size(m)
+}
+func (m *MachineReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_MachineReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MachineReply proto.InternalMessageInfo
+
+func (m *MachineReply) GetMachine() []byte {
+	if m != nil {
+		return m.Machine
+	}
+	return nil
+}
+
+type ExistsReply struct {
+	Exists               bool     `protobuf:"varint,1,opt,name=exists,proto3" json:"exists,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ExistsReply) Reset()         { *m = ExistsReply{} }
+func (m *ExistsReply) String() string { return proto.CompactTextString(m) }
+func (*ExistsReply) ProtoMessage()    {}
+func (*ExistsReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f14edf1aa1948a86, []int{2}
+}
+
+func (m *ExistsReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExistsReply.Unmarshal(m, b)
+}
+func (m *ExistsReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExistsReply.Marshal(b, m, deterministic)
+}
+func (m *ExistsReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExistsReply.Merge(m, src)
+}
+func (m *ExistsReply) XXX_Size() int {
+	return xxx_messageInfo_ExistsReply.Size(m)
+}
+func (m *ExistsReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExistsReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExistsReply proto.InternalMessageInfo
+
+func (m *ExistsReply) GetExists() bool {
+	if m != nil {
+		return m.Exists
+	}
+	return false
+}
+
+type ClusterRequest struct {
+	Cluster              []byte   `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ClusterRequest) Reset()         { *m = ClusterRequest{} }
+func (m *ClusterRequest) String() string { return proto.CompactTextString(m) }
+func (*ClusterRequest) ProtoMessage()    {}
+func (*ClusterRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f14edf1aa1948a86, []int{3}
+}
+
+func (m *ClusterRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ClusterRequest.Unmarshal(m, b)
+}
+func (m *ClusterRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ClusterRequest.Marshal(b, m, deterministic)
+}
+func (m *ClusterRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ClusterRequest.Merge(m, src)
+}
+func (m *ClusterRequest) XXX_Size() int {
+	return xxx_messageInfo_ClusterRequest.Size(m)
+}
+func (m *ClusterRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ClusterRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ClusterRequest proto.InternalMessageInfo
+
+func (m *ClusterRequest) GetCluster() []byte {
+	if m != nil {
+		return m.Cluster
+	}
+	return nil
+}
+
+type AllocateExternalIPsReply struct {
+	Ips                  []string `protobuf:"bytes,1,rep,name=ips,proto3" json:"ips,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AllocateExternalIPsReply) Reset()         { *m = AllocateExternalIPsReply{} }
+func (m *AllocateExternalIPsReply) String() string { return proto.CompactTextString(m) }
+func (*AllocateExternalIPsReply) ProtoMessage()    {}
+func (*AllocateExternalIPsReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f14edf1aa1948a86, []int{4}
+}
+
+func (m *AllocateExternalIPsReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocateExternalIPsReply.Unmarshal(m, b)
+}
+func (m *AllocateExternalIPsReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocateExternalIPsReply.Marshal(b, m, deterministic)
+}
+func (m *AllocateExternalIPsReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocateExternalIPsReply.Merge(m, src)
+}
+func (m *AllocateExternalIPsReply) XXX_Size() int {
+	return xxx_messageInfo_AllocateExternalIPsReply.Size(m)
+}
+func (m *AllocateExternalIPsReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocateExternalIPsReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocateExternalIPsReply proto.InternalMessageInfo
+
+func (m *AllocateExternalIPsReply) GetIps() []string {
+	if m != nil {
+		return m.Ips
+	}
+	return nil
+}
+
+type DeAllocateExternalIPsReply struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeAllocateExternalIPsReply) Reset()         { *m = DeAllocateExternalIPsReply{} }
+func (m *DeAllocateExternalIPsReply) String() string { return proto.CompactTextString(m) }
+func (*DeAllocateExternalIPsReply) ProtoMessage()    {}
+func (*DeAllocateExternalIPsReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f14edf1aa1948a86, []int{5}
+}
+
+func (m *DeAllocateExternalIPsReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeAllocateExternalIPsReply.Unmarshal(m, b)
+}
+func (m *DeAllocateExternalIPsReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeAllocateExternalIPsReply.Marshal(b, m, deterministic)
+}
+func (m *DeAllocateExternalIPsReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeAllocateExternalIPsReply.Merge(m, src)
+}
+func (m *DeAllocateExternalIPsReply) XXX_Size() int {
+	return xxx_messageInfo_DeAllocateExternalIPsReply.Size(m)
+}
+func (m *DeAllocateExternalIPsReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeAllocateExternalIPsReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeAllocateExternalIPsReply proto.InternalMessageInfo
+
+func init() {
+	proto.RegisterType((*MachineRequest)(nil), "proto.MachineRequest")
+	proto.RegisterType((*MachineReply)(nil), "proto.MachineReply")
+	proto.RegisterType((*ExistsReply)(nil), "proto.ExistsReply")
+	proto.RegisterType((*ClusterRequest)(nil), "proto.ClusterRequest")
+	proto.RegisterType((*AllocateExternalIPsReply)(nil), "proto.AllocateExternalIPsReply")
+	proto.RegisterType((*DeAllocateExternalIPsReply)(nil), "proto.DeAllocateExternalIPsReply")
+}
+
+func init() { proto.RegisterFile("provisioner.proto", fileDescriptor_f14edf1aa1948a86) }
+
+var fileDescriptor_f14edf1aa1948a86 = []byte{
+	// 284 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x2c, 0x28, 0xca, 0x2f,
+	0xcb, 0x2c, 0xce, 0xcc, 0xcf, 0x4b, 0x2d, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x05,
+	0x53, 0x4a, 0x2e, 0x5c, 0x7c, 0xbe, 0x89, 0xc9, 0x19, 0x99, 0x79, 0xa9, 0x41, 0xa9, 0x85, 0xa5,
+	0xa9, 0xc5, 0x25, 0x42, 0x12, 0x5c, 0xec, 0xc9, 0x39, 0xa5, 0xc5, 0x25, 0xa9, 0x45, 0x12, 0x8c,
+	0x0a, 0x8c, 0x1a, 0x3c, 0x41, 0x30, 0x2e, 0x48, 0x26, 0x17, 0xa2, 0x56, 0x82, 0x09, 0x22, 0x03,
+	0xe5, 0x2a, 0x69, 0x70, 0xf1, 0xc0, 0x4d, 0x29, 0xc8, 0xa9, 0x44, 0x56, 0xc9, 0x88, 0xaa, 0x52,
+	0x95, 0x8b, 0xdb, 0xb5, 0x22, 0xb3, 0xb8, 0xa4, 0x18, 0xa2, 0x50, 0x8c, 0x8b, 0x2d, 0x15, 0xcc,
+	0x05, 0xab, 0xe3, 0x08, 0x82, 0xf2, 0x94, 0xb4, 0xb8, 0xf8, 0x9c, 0x21, 0xb6, 0x12, 0x74, 0x96,
+	0x92, 0x0e, 0x97, 0x84, 0x63, 0x4e, 0x4e, 0x7e, 0x72, 0x62, 0x49, 0xaa, 0x6b, 0x45, 0x49, 0x6a,
+	0x51, 0x5e, 0x62, 0x8e, 0x67, 0x00, 0xd4, 0x7c, 0x01, 0x2e, 0xe6, 0xcc, 0x02, 0x90, 0xe1, 0xcc,
+	0x1a, 0x9c, 0x41, 0x20, 0xa6, 0x92, 0x0c, 0x97, 0x94, 0x4b, 0x2a, 0x2e, 0xf5, 0x46, 0x6d, 0xcc,
+	0x5c, 0xdc, 0x01, 0x88, 0xb0, 0x12, 0x32, 0xe1, 0x62, 0x73, 0x2e, 0x4a, 0x4d, 0x2c, 0x49, 0x15,
+	0x12, 0x85, 0x84, 0x9b, 0x1e, 0x6a, 0x68, 0x49, 0x09, 0xa3, 0x0b, 0x83, 0x6c, 0x35, 0xe1, 0x62,
+	0x0b, 0x2d, 0x48, 0x21, 0x43, 0x97, 0x4b, 0x6a, 0x4e, 0x2a, 0x89, 0xba, 0x8c, 0xb9, 0xd8, 0x20,
+	0x01, 0x8a, 0x4b, 0x97, 0x10, 0x54, 0x18, 0x39, 0xd8, 0x7d, 0xb9, 0x84, 0xb1, 0x04, 0x01, 0xdc,
+	0x04, 0xd4, 0xa0, 0x97, 0x92, 0x87, 0x0a, 0xe3, 0x0c, 0xe5, 0x40, 0x2e, 0x51, 0x97, 0x54, 0x12,
+	0x0c, 0x54, 0x84, 0x0a, 0xe3, 0x8e, 0x08, 0x27, 0xf6, 0x28, 0x48, 0x02, 0x4d, 0x62, 0x03, 0x53,
+	0xc6, 0x80, 0x01, 0x00, 0x3e, 0xe3, 0x68, 0x47, 0xc3, 0x02, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ProvisionerClient is the client API for Provisioner service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ProvisionerClient interface {
+	Create(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*MachineReply, error)
+	Update(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*MachineReply, error)
+	Delete(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*MachineReply, error)
+	Exists(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*ExistsReply, error)
+	AllocateExternalIPs(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*AllocateExternalIPsReply, error)
+	DeAllocateExternalIPs(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*DeAllocateExternalIPsReply, error)
+}
+
+type provisionerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewProvisionerClient(cc *grpc.ClientConn) ProvisionerClient {
+	return &provisionerClient{cc}
+}
+
+func (c *provisionerClient) Create(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*MachineReply, error) {
+	out := new(MachineReply)
+	err := c.cc.Invoke(ctx, "/proto.Provisioner/Create", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *provisionerClient) Update(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*MachineReply, error) {
+	out := new(MachineReply)
+	err := c.cc.Invoke(ctx, "/proto.Provisioner/Update", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *provisionerClient) Delete(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*MachineReply, error) {
+	out := new(MachineReply)
+	err := c.cc.Invoke(ctx, "/proto.Provisioner/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *provisionerClient) Exists(ctx context.Context, in *MachineRequest, opts ...grpc.CallOption) (*ExistsReply, error) {
+	out := new(ExistsReply)
+	err := c.cc.Invoke(ctx, "/proto.Provisioner/Exists", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *provisionerClient) AllocateExternalIPs(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*AllocateExternalIPsReply, error) {
+	out := new(AllocateExternalIPsReply)
+	err := c.cc.Invoke(ctx, "/proto.Provisioner/AllocateExternalIPs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *provisionerClient) DeAllocateExternalIPs(ctx context.Context, in *ClusterRequest, opts ...grpc.CallOption) (*DeAllocateExternalIPsReply, error) {
+	out := new(DeAllocateExternalIPsReply)
+	err := c.cc.Invoke(ctx, "/proto.Provisioner/DeAllocateExternalIPs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ProvisionerServer is the server API for Provisioner service.
+type ProvisionerServer interface {
+	Create(context.Context, *MachineRequest) (*MachineReply, error)
+	Update(context.Context, *MachineRequest) (*MachineReply, error)
+	Delete(context.Context, *MachineRequest) (*MachineReply, error)
+	Exists(context.Context, *MachineRequest) (*ExistsReply, error)
+	AllocateExternalIPs(context.Context, *ClusterRequest) (*AllocateExternalIPsReply, error)
+	DeAllocateExternalIPs(context.Context, *ClusterRequest) (*DeAllocateExternalIPsReply, error)
+}
+
+// UnimplementedProvisionerServer can be embedded to have forward compatible implementations.
+type UnimplementedProvisionerServer struct {
+}
+
+func (*UnimplementedProvisionerServer) Create(ctx context.Context, req *MachineRequest) (*MachineReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Create not implemented")
+}
+func (*UnimplementedProvisionerServer) Update(ctx context.Context, req *MachineRequest) (*MachineReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (*UnimplementedProvisionerServer) Delete(ctx context.Context, req *MachineRequest) (*MachineReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (*UnimplementedProvisionerServer) Exists(ctx context.Context, req *MachineRequest) (*ExistsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Exists not implemented")
+}
+func (*UnimplementedProvisionerServer) AllocateExternalIPs(ctx context.Context, req *ClusterRequest) (*AllocateExternalIPsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AllocateExternalIPs not implemented")
+}
+func (*UnimplementedProvisionerServer) DeAllocateExternalIPs(ctx context.Context, req *ClusterRequest) (*DeAllocateExternalIPsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeAllocateExternalIPs not implemented")
+}
+
+func RegisterProvisionerServer(s *grpc.Server, srv ProvisionerServer) {
+	s.RegisterService(&_Provisioner_serviceDesc, srv)
+}
+
+func _Provisioner_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MachineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisionerServer).Create(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Provisioner/Create",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisionerServer).Create(ctx, req.(*MachineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Provisioner_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MachineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisionerServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Provisioner/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisionerServer).Update(ctx, req.(*MachineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Provisioner_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MachineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisionerServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Provisioner/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisionerServer).Delete(ctx, req.(*MachineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Provisioner_Exists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MachineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisionerServer).Exists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Provisioner/Exists",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisionerServer).Exists(ctx, req.(*MachineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Provisioner_AllocateExternalIPs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisionerServer).AllocateExternalIPs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Provisioner/AllocateExternalIPs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisionerServer).AllocateExternalIPs(ctx, req.(*ClusterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Provisioner_DeAllocateExternalIPs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisionerServer).DeAllocateExternalIPs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Provisioner/DeAllocateExternalIPs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisionerServer).DeAllocateExternalIPs(ctx, req.(*ClusterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Provisioner_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Provisioner",
+	HandlerType: (*ProvisionerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Create",
+			Handler:    _Provisioner_Create_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _Provisioner_Update_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Provisioner_Delete_Handler,
+		},
+		{
+			MethodName: "Exists",
+			Handler:    _Provisioner_Exists_Handler,
+		},
+		{
+			MethodName: "AllocateExternalIPs",
+			Handler:    _Provisioner_AllocateExternalIPs_Handler,
+		},
+		{
+			MethodName: "DeAllocateExternalIPs",
+			Handler:    _Provisioner_DeAllocateExternalIPs_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "provisioner.proto",
+}
@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/plugin/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// Server exposes a provisioner as a gRPC service
+type Server struct {
+	provisioner provisioners.Provisioner
+	clientset   *kubernetes.Clientset
+}
+
+var _ proto.ProvisionerServer = &Server{}
+
+// NewServer returns a server that forwards requests to p.
+// The clientset is handed to p on every call and may be nil if p does not need one.
+func NewServer(p provisioners.Provisioner, clientset *kubernetes.Clientset) *Server {
+	return &Server{provisioner: p, clientset: clientset}
+}
+
+// Serve registers a server for p on a new gRPC server and serves on lis until it fails
+func Serve(lis net.Listener, p provisioners.Provisioner, clientset *kubernetes.Clientset) error {
+	if _, ok := p.(provisioners.EndpointProvisioner); ok {
+		log.Println("Provisioner plugins can't serve control plane endpoints, clusters will use the IP of their first control plane node")
+	}
+	if _, ok := p.(provisioners.ExternalIPReleaser); ok {
+		log.Println("Provisioner plugins can't release single external IPs, those of removed control plane nodes are released with their cluster")
+	}
+
+	s := grpc.NewServer()
+	proto.RegisterProvisionerServer(s, NewServer(p, clientset))
+
+	return s.Serve(lis)
+}
+
+// Create implements proto.ProvisionerServer
+func (s *Server) Create(ctx context.Context, req *proto.MachineRequest) (*proto.MachineReply, error) {
+	cluster, machine, err := decodeMachineRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.provisioner.Create(ctx, cluster, machine, s.clientset); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return machineReply(machine)
+}
+
+// Update implements proto.ProvisionerServer
+func (s *Server) Update(ctx context.Context, req *proto.MachineRequest) (*proto.MachineReply, error) {
+	cluster, machine, err := decodeMachineRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.provisioner.Update(ctx, cluster, machine, s.clientset); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return machineReply(machine)
+}
+
+// Delete implements proto.ProvisionerServer
+func (s *Server) Delete(ctx context.Context, req *proto.MachineRequest) (*proto.MachineReply, error) {
+	cluster, machine, err := decodeMachineRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.provisioner.Delete(ctx, cluster, machine, s.clientset); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return machineReply(machine)
+}
+
+// Exists implements proto.ProvisionerServer
+func (s *Server) Exists(ctx context.Context, req *proto.MachineRequest) (*proto.ExistsReply, error) {
+	cluster, machine, err := decodeMachineRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := s.provisioner.Exists(ctx, cluster, machine, s.clientset)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &proto.ExistsReply{Exists: exists}, nil
+}
+
+// AllocateExternalIPs implements proto.ProvisionerServer
+func (s *Server) AllocateExternalIPs(ctx context.Context, req *proto.ClusterRequest) (*proto.AllocateExternalIPsReply, error) {
+	cluster, err := decodeCluster(req.Cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := s.provisioner.AllocateExternalIPs(cluster, s.clientset)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &proto.AllocateExternalIPsReply{Ips: ips}, nil
+}
+
+// DeAllocateExternalIPs implements proto.ProvisionerServer
+func (s *Server) DeAllocateExternalIPs(ctx context.Context, req *proto.ClusterRequest) (*proto.DeAllocateExternalIPsReply, error) {
+	cluster, err := decodeCluster(req.Cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.provisioner.DeAllocateExternalIPs(cluster, s.clientset); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &proto.DeAllocateExternalIPsReply{}, nil
+}
+
+func decodeMachineRequest(req *proto.MachineRequest) (*clusterv1.Cluster, *clusterv1.Machine, error) {
+	cluster, err := decodeCluster(req.Cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	machine := &clusterv1.Machine{}
+	if err = json.Unmarshal(req.Machine, machine); err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "failed to decode machine: %v", err)
+	}
+
+	return cluster, machine, nil
+}
+
+func decodeCluster(b []byte) (*clusterv1.Cluster, error) {
+	cluster := &clusterv1.Cluster{}
+	if err := json.Unmarshal(b, cluster); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to decode cluster: %v", err)
+	}
+
+	return cluster, nil
+}
+
+func machineReply(machine *clusterv1.Machine) (*proto.MachineReply, error) {
+	b, err := json.Marshal(machine)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode machine: %v", err)
+	}
+
+	return &proto.MachineReply{Machine: b}, nil
+}
+
+func toStatus(err error) error {
+	return status.Error(codes.Unknown, err.Error())
+}
@@ -2,12 +2,11 @@ package provisioners
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
-	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/aws"
-	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/azure"
-	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/gce"
-	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners/packet"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
@@ -23,16 +22,66 @@ type Provisioner interface {
 	DeAllocateExternalIPs(*clusterv1.Cluster, *kubernetes.Clientset) error
 }
 
+// EndpointProvisioner is implemented by provisioners that put the control plane behind an address of its own, such as a VIP.
+// Clusters on other platforms reach their API through the first control plane IP.
+type EndpointProvisioner interface {
+	ControlPlaneEndpoint(*clusterv1.Cluster) (string, error)
+}
+
+// ExternalIPReleaser is implemented by provisioners that allocate an IP per control plane node.
+// It releases the IP of a single node when the control plane is scaled down, after its machine is gone.
+// Provisioners without it have nothing to release per node, e.g. because their IPs come from a pre-created block.
+type ExternalIPReleaser interface {
+	ReleaseExternalIP(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, index int, ip string) error
+}
+
+// Factory returns a new instance of a provisioner
+type Factory func() (Provisioner, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a provisioner available under the given platform name.
+// Platform packages call it from their init function.
+// Registering the same name twice, or a nil factory, panics.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("provisioners: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("provisioners: Register called twice for platform " + name)
+	}
+	factories[name] = factory
+}
+
+// Platforms returns the sorted names of all registered platforms
+func Platforms() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewProvisioner returns the provisioner registered for the given platform
 func NewProvisioner(id string) (Provisioner, error) {
-	switch id {
-	case "aws":
-		return aws.NewAWS()
-	case "azure":
-		return azure.NewAz()
-	case "gce":
-		return gce.NewGCE()
-	case "packet":
-		return packet.NewPacket()
+	factoriesMu.RLock()
+	factory, ok := factories[id]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown provisioner %q, available platforms: [%s]", id, strings.Join(Platforms(), ", "))
 	}
-	return nil, errors.New("Unknown provisioner")
+
+	return factory()
 }
@@ -0,0 +1,74 @@
+package provisioners
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+type nopProvisioner struct{}
+
+func (nopProvisioner) Create(context.Context, *clusterv1.Cluster, *clusterv1.Machine, *kubernetes.Clientset) error {
+	return nil
+}
+
+func (nopProvisioner) Update(context.Context, *clusterv1.Cluster, *clusterv1.Machine, *kubernetes.Clientset) error {
+	return nil
+}
+
+func (nopProvisioner) Delete(context.Context, *clusterv1.Cluster, *clusterv1.Machine, *kubernetes.Clientset) error {
+	return nil
+}
+
+func (nopProvisioner) Exists(context.Context, *clusterv1.Cluster, *clusterv1.Machine, *kubernetes.Clientset) (bool, error) {
+	return false, nil
+}
+
+func (nopProvisioner) AllocateExternalIPs(*clusterv1.Cluster, *kubernetes.Clientset) ([]string, error) {
+	return nil, nil
+}
+
+func (nopProvisioner) DeAllocateExternalIPs(*clusterv1.Cluster, *kubernetes.Clientset) error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	Register("registry-test-b", func() (Provisioner, error) { return nopProvisioner{}, nil })
+	Register("registry-test-a", func() (Provisioner, error) { return nopProvisioner{}, nil })
+
+	g.Expect(Platforms()).To(gomega.ContainElement("registry-test-a"))
+	g.Expect(Platforms()).To(gomega.ContainElement("registry-test-b"))
+	g.Expect(Platforms()).To(gomega.BeEquivalentTo(sortedCopy(Platforms())))
+
+	p, err := NewProvisioner("registry-test-a")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(p).To(gomega.Equal(nopProvisioner{}))
+
+	// Unknown platforms name the available ones
+	_, err = NewProvisioner("registry-test-missing")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring("registry-test-a, registry-test-b"))
+
+	// Duplicate registrations are programming errors
+	g.Expect(func() {
+		Register("registry-test-a", func() (Provisioner, error) { return nopProvisioner{}, nil })
+	}).To(gomega.Panic())
+	g.Expect(func() { Register("registry-test-nil", nil) }).To(gomega.Panic())
+}
+
+func sortedCopy(in []string) []string {
+	out := append([]string(nil), in...)
+	for i := range out {
+		for j := i + 1; j < len(out); j++ {
+			if out[j] < out[i] {
+				out[i], out[j] = out[j], out[i]
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,235 @@
+package redfish
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// odataID is a link to another Redfish resource
+type odataID struct {
+	ID string `json:"@odata.id"`
+}
+
+type collection struct {
+	Members []odataID `json:"Members"`
+}
+
+type action struct {
+	Target string `json:"target"`
+}
+
+// computerSystem is the part of a Redfish ComputerSystem used to drive a host
+type computerSystem struct {
+	ODataID    string `json:"@odata.id"`
+	ID         string `json:"Id"`
+	PowerState string `json:"PowerState"`
+	AssetTag   string `json:"AssetTag"`
+	Links      struct {
+		ManagedBy []odataID `json:"ManagedBy"`
+	} `json:"Links"`
+	Actions struct {
+		Reset action `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+type manager struct {
+	VirtualMedia odataID `json:"VirtualMedia"`
+}
+
+type virtualMedia struct {
+	ODataID    string   `json:"@odata.id"`
+	MediaTypes []string `json:"MediaTypes"`
+	Image      string   `json:"Image"`
+	Inserted   bool     `json:"Inserted"`
+	Actions    struct {
+		InsertMedia action `json:"#VirtualMedia.InsertMedia"`
+		EjectMedia  action `json:"#VirtualMedia.EjectMedia"`
+	} `json:"Actions"`
+}
+
+// bmc is a minimal Redfish client for the BMC of one host
+type bmc struct {
+	endpoint string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newBMC(endpoint, username, password string, insecure bool) *bmc {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+	}
+	return &bmc{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		username: username,
+		password: password,
+		client:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// system returns the computer system with the given ID, or the first one of the BMC if the ID is empty
+func (b *bmc) system(id string) (*computerSystem, error) {
+	path := "/redfish/v1/Systems/" + id
+	if id == "" {
+		systems := &collection{}
+		if err := b.do(http.MethodGet, "/redfish/v1/Systems", nil, systems); err != nil {
+			return nil, err
+		}
+		if len(systems.Members) == 0 {
+			return nil, fmt.Errorf("[Redfish] BMC %s manages no computer system", b.endpoint)
+		}
+		path = systems.Members[0].ID
+	}
+
+	system := &computerSystem{}
+	if err := b.do(http.MethodGet, path, nil, system); err != nil {
+		return nil, err
+	}
+	if system.ODataID == "" {
+		system.ODataID = path
+	}
+	return system, nil
+}
+
+// setAssetTag records the claim on a host, an empty tag releases it
+func (b *bmc) setAssetTag(system *computerSystem, tag string) error {
+	return b.do(http.MethodPatch, system.ODataID, map[string]string{"AssetTag": tag}, nil)
+}
+
+// bootOnce overrides the boot source of the next boot only, e.g. with Pxe or Cd
+func (b *bmc) bootOnce(system *computerSystem, target string) error {
+	return b.do(http.MethodPatch, system.ODataID, map[string]interface{}{
+		"Boot": map[string]string{
+			"BootSourceOverrideEnabled": "Once",
+			"BootSourceOverrideTarget":  target,
+		},
+	}, nil)
+}
+
+// reset changes the power state of a host, e.g. with On, ForceOff or ForceRestart
+func (b *bmc) reset(system *computerSystem, resetType string) error {
+	target := system.Actions.Reset.Target
+	if target == "" {
+		target = system.ODataID + "/Actions/ComputerSystem.Reset"
+	}
+	return b.do(http.MethodPost, target, map[string]string{"ResetType": resetType}, nil)
+}
+
+// drives returns the virtual drives of the managers of a host
+func (b *bmc) drives(system *computerSystem) ([]*virtualMedia, error) {
+	drives := []*virtualMedia{}
+	for _, link := range system.Links.ManagedBy {
+		mgr := &manager{}
+		if err := b.do(http.MethodGet, link.ID, nil, mgr); err != nil {
+			return nil, err
+		}
+		if mgr.VirtualMedia.ID == "" {
+			continue
+		}
+
+		media := &collection{}
+		if err := b.do(http.MethodGet, mgr.VirtualMedia.ID, nil, media); err != nil {
+			return nil, err
+		}
+		for _, member := range media.Members {
+			drive := &virtualMedia{}
+			if err := b.do(http.MethodGet, member.ID, nil, drive); err != nil {
+				return nil, err
+			}
+			if drive.ODataID == "" {
+				drive.ODataID = member.ID
+			}
+			drives = append(drives, drive)
+		}
+	}
+	return drives, nil
+}
+
+// pickDrive returns a drive taking the first media type possible, skipping the drive already in use
+func pickDrive(drives []*virtualMedia, inUse *virtualMedia, mediaTypes ...string) *virtualMedia {
+	for _, mediaType := range mediaTypes {
+		for _, drive := range drives {
+			if drive == inUse {
+				continue
+			}
+			for _, t := range drive.MediaTypes {
+				if t == mediaType {
+					return drive
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// insertMedia inserts an image into a virtual drive, falling back to a PATCH on BMCs without the InsertMedia action
+func (b *bmc) insertMedia(drive *virtualMedia, image string) error {
+	if drive.Inserted {
+		if drive.Image == image {
+			return nil
+		}
+		if err := b.ejectMedia(drive); err != nil {
+			return err
+		}
+	}
+
+	body := map[string]interface{}{"Image": image, "Inserted": true, "WriteProtected": true}
+	if target := drive.Actions.InsertMedia.Target; target != "" {
+		return b.do(http.MethodPost, target, body, nil)
+	}
+	return b.do(http.MethodPatch, drive.ODataID, body, nil)
+}
+
+// ejectMedia empties a virtual drive, falling back to a PATCH on BMCs without the EjectMedia action
+func (b *bmc) ejectMedia(drive *virtualMedia) error {
+	if target := drive.Actions.EjectMedia.Target; target != "" {
+		return b.do(http.MethodPost, target, map[string]interface{}{}, nil)
+	}
+	return b.do(http.MethodPatch, drive.ODataID, map[string]interface{}{"Image": nil, "Inserted": false}, nil)
+}
+
+// do sends a request to the BMC, encoding the body and decoding the response into out when they are set
+func (b *bmc) do(method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, b.endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.username, b.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("[Redfish] %s %s%s: %s: %s", method, b.endpoint, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,113 @@
+package redfish
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	// sectorSize is the logical block size of ISO 9660 images
+	sectorSize = 2048
+	// paddingSectors end the images like genisoimage does, so that drives reading ahead don't fail on the last file
+	paddingSectors = 150
+)
+
+// Layout of the config images: the volume descriptors follow the 16 sectors of the system area,
+// then come the path tables, the root directory and the file data.
+const (
+	pvdSector = iota + 16
+	terminatorSector
+	lPathTableSector
+	mPathTableSector
+	rootSector
+	fileSector
+)
+
+// isoImage returns an ISO 9660 image holding a single file in its root directory.
+// Talos booted with talos.config=cidata reads its config from the user-data file of the volume labelled cidata,
+// the Linux driver lowercases the plain ISO 9660 names so there is no need for Rock Ridge or Joliet extensions.
+func isoImage(volumeID, name string, data []byte) []byte {
+	sectors := fileSector + (len(data)+sectorSize-1)/sectorSize + paddingSectors
+	image := make([]byte, sectors*sectorSize)
+
+	pvd := image[pvdSector*sectorSize:]
+	pvd[0] = 1
+	copy(pvd[1:6], "CD001")
+	pvd[6] = 1
+	padded(pvd[8:40], "")
+	padded(pvd[40:72], volumeID)
+	bothEndian32(pvd[80:88], uint32(sectors))
+	bothEndian16(pvd[120:124], 1)
+	bothEndian16(pvd[124:128], 1)
+	bothEndian16(pvd[128:132], sectorSize)
+	bothEndian32(pvd[132:140], 10)
+	binary.LittleEndian.PutUint32(pvd[140:144], lPathTableSector)
+	binary.BigEndian.PutUint32(pvd[148:152], mPathTableSector)
+	directoryRecord(pvd[156:190], "\x00", rootSector, sectorSize, true)
+	padded(pvd[190:813], "")
+	for _, date := range [][]byte{pvd[813:830], pvd[830:847], pvd[847:864], pvd[864:881]} {
+		copy(date, "0000000000000000")
+	}
+	pvd[881] = 1
+
+	terminator := image[terminatorSector*sectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:6], "CD001")
+	terminator[6] = 1
+
+	//The root directory is the only entry of the path tables, and its own parent
+	lPathTable := image[lPathTableSector*sectorSize:]
+	lPathTable[0] = 1
+	binary.LittleEndian.PutUint32(lPathTable[2:6], rootSector)
+	binary.LittleEndian.PutUint16(lPathTable[6:8], 1)
+	mPathTable := image[mPathTableSector*sectorSize:]
+	mPathTable[0] = 1
+	binary.BigEndian.PutUint32(mPathTable[2:6], rootSector)
+	binary.BigEndian.PutUint16(mPathTable[6:8], 1)
+
+	root := image[rootSector*sectorSize:]
+	offset := directoryRecord(root, "\x00", rootSector, sectorSize, true)
+	offset += directoryRecord(root[offset:], "\x01", rootSector, sectorSize, true)
+	directoryRecord(root[offset:], strings.ToUpper(name)+";1", fileSector, uint32(len(data)), false)
+
+	copy(image[fileSector*sectorSize:], data)
+	return image
+}
+
+// directoryRecord writes the record of a file or directory and returns its length
+func directoryRecord(b []byte, id string, sector, size uint32, directory bool) int {
+	length := 33 + len(id)
+	if length%2 != 0 {
+		length++
+	}
+
+	b[0] = byte(length)
+	bothEndian32(b[2:10], sector)
+	bothEndian32(b[10:18], size)
+	//Recorded on 1970-01-01, the images are rebuilt from the userdata on every download
+	b[18], b[19], b[20] = 70, 1, 1
+	if directory {
+		b[25] = 2
+	}
+	bothEndian16(b[28:32], 1)
+	b[32] = byte(len(id))
+	copy(b[33:], id)
+	return length
+}
+
+func padded(b []byte, s string) {
+	copy(b, s)
+	for i := len(s); i < len(b); i++ {
+		b[i] = ' '
+	}
+}
+
+func bothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b[0:2], v)
+	binary.BigEndian.PutUint16(b[2:4], v)
+}
+
+func bothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:4], v)
+	binary.BigEndian.PutUint32(b[4:8], v)
+}
@@ -0,0 +1,88 @@
+package redfish
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// MediaURLEnv is the base URL the BMCs download the config images from the manager at, e.g. http://10.0.0.1:8090
+	MediaURLEnv = "REDFISH_MEDIA_URL"
+	// MediaAddressEnv is the address the manager serves the config images on, :8090 by default
+	MediaAddressEnv = "REDFISH_MEDIA_ADDRESS"
+
+	mediaPathPrefix = "/userdata/"
+)
+
+// configImageURL returns the URL of the config image of a userdata secret.
+// The digest of the userdata authorizes the download, only the BMCs given the URL know it.
+func configImageURL(secret *corev1.Secret) (string, error) {
+	base := strings.TrimSuffix(os.Getenv(MediaURLEnv), "/")
+	if base == "" {
+		return "", fmt.Errorf("[Redfish] %s is not set, the hosts can't be given their userdata", MediaURLEnv)
+	}
+
+	sum := sha256.Sum256(secret.Data["userdata"])
+	return base + mediaPathPrefix + secret.ObjectMeta.Namespace + "/" + secret.ObjectMeta.Name + "/" + hex.EncodeToString(sum[:]) + ".iso", nil
+}
+
+// MediaHandler serves the userdata secrets as config images, ISOs with the volume label Talos reads its config from when booted with talos.config=cidata
+func MediaHandler(clientset kubernetes.Interface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, mediaPathPrefix), "/")
+		if !strings.HasPrefix(r.URL.Path, mediaPathPrefix) || len(parts) != 3 || !strings.HasSuffix(parts[2], ".iso") {
+			http.NotFound(w, r)
+			return
+		}
+		namespace, name, digest := parts[0], parts[1], strings.TrimSuffix(parts[2], ".iso")
+
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil || secret.Type != utils.UserdataSecretType {
+			http.NotFound(w, r)
+			return
+		}
+		sum := sha256.Sum256(secret.Data["userdata"])
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(hex.EncodeToString(sum[:]))) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		//BMCs streaming virtual media read the image in ranges
+		image := isoImage("cidata", "user-data", secret.Data["userdata"])
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, name+".iso", time.Time{}, bytes.NewReader(image))
+	})
+}
+
+// ServeMedia serves the config images on the address of MediaAddressEnv until stop is closed
+func ServeMedia(clientset kubernetes.Interface, stop <-chan struct{}) error {
+	addr := os.Getenv(MediaAddressEnv)
+	if addr == "" {
+		addr = ":8090"
+	}
+
+	server := &http.Server{Addr: addr, Handler: MediaHandler(clientset)}
+	go func() {
+		<-stop
+		server.Close() //nolint: errcheck
+	}()
+
+	log.Printf("[Redfish] Serving config images on %s", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
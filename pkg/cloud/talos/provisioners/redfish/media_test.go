@@ -0,0 +1,99 @@
+package redfish
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestISOImage(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	data := []byte(strings.Repeat("version: v1alpha1\n", 200))
+	image := isoImage("cidata", "user-data", data)
+	g.Expect(len(image) % sectorSize).To(gomega.Equal(0))
+
+	pvd := image[pvdSector*sectorSize:]
+	g.Expect(string(pvd[1:6])).To(gomega.Equal("CD001"))
+	g.Expect(strings.TrimRight(string(pvd[40:72]), " ")).To(gomega.Equal("cidata"))
+	g.Expect(binary.LittleEndian.Uint32(pvd[80:84])).To(gomega.Equal(uint32(len(image) / sectorSize)))
+	g.Expect(image[terminatorSector*sectorSize]).To(gomega.Equal(byte(255)))
+
+	//The third record of the root directory is the file
+	root := image[rootSector*sectorSize:]
+	offset := int(root[0]) + int(root[root[0]])
+	record := root[offset:]
+	g.Expect(string(record[33 : 33+record[32]])).To(gomega.Equal("USER-DATA;1"))
+	sector := binary.LittleEndian.Uint32(record[2:6])
+	size := binary.LittleEndian.Uint32(record[10:14])
+	g.Expect(image[sector*sectorSize : sector*sectorSize+size]).To(gomega.Equal(data))
+}
+
+func TestMediaHandler(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-master-0", Namespace: "default"},
+		Type:       utils.UserdataSecretType,
+		Data:       map[string][]byte{"userdata": []byte("version: v1alpha1\n")},
+	}
+	other := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bmc", Namespace: "default"},
+		Data:       map[string][]byte{"userdata": []byte("version: v1alpha1\n")},
+	}
+	server := httptest.NewServer(MediaHandler(fake.NewSimpleClientset(secret, other)))
+	defer server.Close()
+
+	os.Setenv(MediaURLEnv, server.URL+"/")
+	defer os.Unsetenv(MediaURLEnv)
+
+	url, err := configImageURL(secret)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(url).To(gomega.HavePrefix(server.URL + "/userdata/default/test-master-0/"))
+
+	resp, err := http.Get(url)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	image, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close() //nolint: errcheck
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(resp.StatusCode).To(gomega.Equal(http.StatusOK))
+	g.Expect(image).To(gomega.Equal(isoImage("cidata", "user-data", secret.Data["userdata"])))
+
+	//BMCs may read the image in ranges
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	req.Header.Set("Range", "bytes=32768-32773")
+	resp, err = http.DefaultClient.Do(req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	chunk, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close() //nolint: errcheck
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(resp.StatusCode).To(gomega.Equal(http.StatusPartialContent))
+	g.Expect(string(chunk)).To(gomega.Equal("\x01CD001"))
+
+	//Neither a wrong digest nor another secret gives the userdata away
+	for _, path := range []string{
+		"/userdata/default/test-master-0/0123.iso",
+		strings.Replace(strings.TrimPrefix(url, server.URL), "test-master-0", "bmc", 1),
+		"/userdata/default/test-master-0",
+	} {
+		resp, err = http.Get(server.URL + path)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		resp.Body.Close() //nolint: errcheck
+		g.Expect(resp.StatusCode).To(gomega.Equal(http.StatusNotFound), path)
+	}
+
+	os.Unsetenv(MediaURLEnv)
+	_, err = configImageURL(secret)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
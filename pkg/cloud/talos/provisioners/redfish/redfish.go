@@ -0,0 +1,434 @@
+package redfish
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// claimPrefix starts the asset tag of the hosts claimed by a machine
+const claimPrefix = "talos:"
+
+// Redfish represents a provider for bare-metal hosts managed through Redfish BMCs.
+type Redfish struct {
+}
+
+func init() {
+	provisioners.Register("redfish", func() (provisioners.Provisioner, error) {
+		return NewRedfish()
+	})
+}
+
+// NewRedfish returns an instance of the Redfish provisioner
+func NewRedfish() (*Redfish, error) {
+	return &Redfish{}, nil
+}
+
+// pool is the set of hosts of a cluster, along with the way to reach their BMCs
+type pool struct {
+	hosts             []talosv1.RedfishHost
+	controlPlaneCount int
+	connect           func(*talosv1.RedfishHost) (*bmc, error)
+}
+
+// claimedHost is a host of the pool with its computer system
+type claimedHost struct {
+	host   *talosv1.RedfishHost
+	bmc    *bmc
+	system *computerSystem
+}
+
+// Create claims a free host, inserts the config image with its userdata, sets its one-time boot source and powers it on.
+func (redfish *Redfish) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	redfishConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPool(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	//The userdata is handed to the host as a config image, which the BMC downloads from the manager
+	configImage, err := configImageURL(udSecret)
+	if err != nil {
+		return err
+	}
+
+	claimed, err := p.claim(machine)
+	if err != nil {
+		return err
+	}
+
+	if err = provision(claimed, redfishConfig, configImage); err != nil {
+		return err
+	}
+
+	log.Println("[Redfish] Host claimed: " + claimed.host.Name)
+
+	return setStatus(machine, claimed, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+}
+
+// Update refreshes the provider ID and power state of the host of a machine.
+func (redfish *Redfish) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	p, err := newPool(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	claimed, err := p.find(machine)
+	if err != nil {
+		return err
+	}
+	if claimed == nil {
+		return nil
+	}
+
+	return setStatus(machine, claimed, nil)
+}
+
+// Delete powers off the host of a machine and releases it to the pool.
+func (redfish *Redfish) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	p, err := newPool(cluster, clientset)
+	if err != nil {
+		return err
+	}
+
+	claimed, err := p.find(machine)
+	if err != nil {
+		return err
+	}
+	if claimed == nil {
+		return nil
+	}
+
+	if err = release(claimed); err != nil {
+		return err
+	}
+
+	log.Println("[Redfish] Host released: " + claimed.host.Name)
+	return nil
+}
+
+// Exists returns whether or not a host is claimed by the machine.
+func (redfish *Redfish) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	p, err := newPool(cluster, clientset)
+	if err != nil {
+		return false, err
+	}
+
+	claimed, err := p.find(machine)
+	if err != nil {
+		return false, err
+	}
+	return claimed != nil, nil
+}
+
+// AllocateExternalIPs returns the addresses of the hosts reserved for the control plane
+// Note: There is nothing to allocate on bare metal, the first hosts of the pool are the control plane hosts.
+func (redfish *Redfish) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	redfishConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	return controlPlaneAddresses(redfishConfig.Hosts, clusterSpec.ControlPlane.Count)
+}
+
+// DeAllocateExternalIPs is a no-op, the addresses belong to the hosts
+func (redfish *Redfish) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	return nil
+}
+
+// newPool returns the host pool of a cluster, reaching the BMCs with the credentials of their secrets
+func newPool(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) (*pool, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	redfishConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pool{
+		hosts:             redfishConfig.Hosts,
+		controlPlaneCount: clusterSpec.ControlPlane.Count,
+		connect: func(host *talosv1.RedfishHost) (*bmc, error) {
+			return connect(cluster, host, clientset)
+		},
+	}, nil
+}
+
+// connect returns a client for the BMC of a host, with the username and password of its credentials secret
+func connect(cluster *clusterv1.Cluster, host *talosv1.RedfishHost, clientset *kubernetes.Clientset) (*bmc, error) {
+	ref := host.CredentialsSecretRef
+	if ref == nil {
+		return nil, fmt.Errorf("[Redfish] Host %s has no credentials secret", host.Name)
+	}
+
+	namespace, err := utils.CredentialsNamespace(cluster, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("[Redfish] Unable to fetch the credentials secret %s/%s of host %s: %v", namespace, ref.Name, host.Name, err)
+	}
+
+	username, err := utils.CredentialsValue(secret.Data, "username")
+	if err != nil {
+		return nil, err
+	}
+	password, err := utils.CredentialsValue(secret.Data, "password")
+	if err != nil {
+		return nil, err
+	}
+	return newBMC(host.Endpoint, string(username), string(password), host.Insecure), nil
+}
+
+// claim returns the host claimed by the machine, claiming a free one if there is none.
+// Control plane machines get the host reserved for their index, workers the first free host after those.
+func (p *pool) claim(machine *clusterv1.Machine) (*claimedHost, error) {
+	claimed, err := p.find(machine)
+	if err != nil || claimed != nil {
+		return claimed, err
+	}
+
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []talosv1.RedfishHost
+	switch {
+	case utils.IsControlPlane(role):
+		if index >= len(p.hosts) || index >= p.controlPlaneCount {
+			return nil, fmt.Errorf("[Redfish] No host reserved for control plane index %d", index)
+		}
+		candidates = p.hosts[index : index+1]
+	case p.controlPlaneCount < len(p.hosts):
+		candidates = p.hosts[p.controlPlaneCount:]
+	}
+
+	tag := claimTag(machine)
+	for i := range candidates {
+		candidate, err := p.lookup(&candidates[i])
+		if err != nil {
+			return nil, err
+		}
+		if candidate.system.AssetTag != "" {
+			continue
+		}
+
+		if err = candidate.bmc.setAssetTag(candidate.system, tag); err != nil {
+			return nil, err
+		}
+		//Read the tag back, another machine may have claimed the host in the meantime
+		if candidate, err = p.lookup(candidate.host); err != nil {
+			return nil, err
+		}
+		if candidate.system.AssetTag == tag {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("[Redfish] No free host left for machine %s", machine.ObjectMeta.Name)
+}
+
+// find returns the host claimed by the machine, or nil if it has none.
+// The host named by the provider ID is checked first, the whole pool is searched otherwise.
+func (p *pool) find(machine *clusterv1.Machine) (*claimedHost, error) {
+	name, err := utils.ParseProviderID(machine, "redfish")
+	if err != nil {
+		return nil, err
+	}
+
+	tag := claimTag(machine)
+	for i := range p.hosts {
+		if name != "" && p.hosts[i].Name != name {
+			continue
+		}
+		claimed, err := p.lookup(&p.hosts[i])
+		if err != nil {
+			return nil, err
+		}
+		if claimed.system.AssetTag == tag {
+			return claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+// lookup connects to the BMC of a host and fetches its computer system
+func (p *pool) lookup(host *talosv1.RedfishHost) (*claimedHost, error) {
+	b, err := p.connect(host)
+	if err != nil {
+		return nil, err
+	}
+	system, err := b.system(host.SystemID)
+	if err != nil {
+		return nil, err
+	}
+	return &claimedHost{host: host, bmc: b, system: system}, nil
+}
+
+// provision inserts the config image into a virtual drive of a claimed host, sets its one-time boot source and (re)starts it.
+// Hosts booting from virtual media need a second drive for the config image, e.g. a virtual USB stick.
+func provision(claimed *claimedHost, config *talosv1.RedfishMachineConfig, configImage string) error {
+	drives, err := claimed.bmc.drives(claimed.system)
+	if err != nil {
+		return err
+	}
+
+	target := "Pxe"
+	var bootDrive *virtualMedia
+	if config.Boot == "virtualMedia" {
+		if bootDrive = pickDrive(drives, nil, "CD", "DVD"); bootDrive == nil {
+			return fmt.Errorf("[Redfish] BMC of host %s has no virtual CD drive", claimed.host.Name)
+		}
+		if err = claimed.bmc.insertMedia(bootDrive, config.ImageURL); err != nil {
+			return err
+		}
+		target = "Cd"
+	}
+
+	configDrive := pickDrive(drives, bootDrive, "CD", "DVD", "USBStick")
+	if configDrive == nil {
+		return fmt.Errorf("[Redfish] BMC of host %s has no virtual drive left for the config image", claimed.host.Name)
+	}
+	if err = claimed.bmc.insertMedia(configDrive, configImage); err != nil {
+		return err
+	}
+
+	if err = claimed.bmc.bootOnce(claimed.system, target); err != nil {
+		return err
+	}
+
+	resetType := "On"
+	if claimed.system.PowerState != "Off" {
+		resetType = "ForceRestart"
+	}
+	return claimed.bmc.reset(claimed.system, resetType)
+}
+
+// release powers off a claimed host, ejects its virtual media and clears the claim
+func release(claimed *claimedHost) error {
+	if claimed.system.PowerState != "Off" {
+		if err := claimed.bmc.reset(claimed.system, "ForceOff"); err != nil {
+			return err
+		}
+	}
+
+	drives, err := claimed.bmc.drives(claimed.system)
+	if err != nil {
+		return err
+	}
+	for _, drive := range drives {
+		if !drive.Inserted {
+			continue
+		}
+		if err = claimed.bmc.ejectMedia(drive); err != nil {
+			return err
+		}
+	}
+
+	return claimed.bmc.setAssetTag(claimed.system, "")
+}
+
+// setStatus records the provider ID, power state and address of the claimed host in the machine
+func setStatus(machine *clusterv1.Machine, claimed *claimedHost, update func(*talosv1.TalosMachineProviderStatusStatus)) error {
+	system, err := claimed.bmc.system(claimed.host.SystemID)
+	if err != nil {
+		return err
+	}
+
+	providerID := utils.ProviderID("redfish", claimed.host.Name)
+	machine.Spec.ProviderID = &providerID
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = claimed.host.Name
+		status.InstanceState = system.PowerState
+		status.Addresses = nil
+		if claimed.host.Address != "" {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: claimed.host.Address})
+		}
+		if update != nil {
+			update(status)
+		}
+	})
+}
+
+// controlPlaneAddresses returns the addresses of the hosts reserved for the control plane
+func controlPlaneAddresses(hosts []talosv1.RedfishHost, count int) ([]string, error) {
+	if len(hosts) < count {
+		return nil, fmt.Errorf("[Redfish] The pool has %d hosts, %d are needed for the control plane", len(hosts), count)
+	}
+
+	ips := []string{}
+	for _, host := range hosts[:count] {
+		if host.Address == "" {
+			return nil, fmt.Errorf("[Redfish] Control plane host %s has no address", host.Name)
+		}
+		ips = append(ips, host.Address)
+	}
+	return ips, nil
+}
+
+// claimTag returns the asset tag marking the host of a machine
+func claimTag(machine *clusterv1.Machine) string {
+	return claimPrefix + machine.ObjectMeta.Namespace + "/" + machine.ObjectMeta.Name
+}
+
+// clusterConfig returns the Redfish config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.RedfishClusterConfig, error) {
+	if clusterSpec.Platform.Redfish != nil {
+		return clusterSpec.Platform.Redfish, nil
+	}
+
+	config := &talosv1.RedfishClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the Redfish config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.RedfishMachineConfig, error) {
+	if machineSpec.Platform.Redfish != nil {
+		return machineSpec.Platform.Redfish, nil
+	}
+
+	config := &talosv1.RedfishMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,280 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// fakeBMC serves a single system laid out like the DMTF public-rackmount1 mockup.
+// Its virtual drives have no InsertMedia action, like the sushy-tools emulator, so media are inserted with a PATCH.
+type fakeBMC struct {
+	system map[string]interface{}
+	floppy map[string]interface{}
+	cd     map[string]interface{}
+	resets []string
+}
+
+func newFakeBMC() *fakeBMC {
+	return &fakeBMC{
+		system: map[string]interface{}{
+			"@odata.id":  "/redfish/v1/Systems/437XR1138R2",
+			"Id":         "437XR1138R2",
+			"PowerState": "Off",
+			"AssetTag":   "",
+			"Boot":       map[string]interface{}{"BootSourceOverrideEnabled": "Disabled", "BootSourceOverrideTarget": "None"},
+			"Links":      map[string]interface{}{"ManagedBy": []interface{}{map[string]interface{}{"@odata.id": "/redfish/v1/Managers/BMC"}}},
+			"Actions": map[string]interface{}{
+				"#ComputerSystem.Reset": map[string]interface{}{"target": "/redfish/v1/Systems/437XR1138R2/Actions/ComputerSystem.Reset"},
+			},
+		},
+		floppy: map[string]interface{}{
+			"@odata.id":  "/redfish/v1/Managers/BMC/VirtualMedia/Floppy1",
+			"MediaTypes": []interface{}{"Floppy", "USBStick"},
+			"Image":      nil,
+			"Inserted":   false,
+		},
+		cd: map[string]interface{}{
+			"@odata.id":  "/redfish/v1/Managers/BMC/VirtualMedia/CD1",
+			"MediaTypes": []interface{}{"CD", "DVD"},
+			"Image":      nil,
+			"Inserted":   false,
+		},
+	}
+}
+
+func (f *fakeBMC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != "root" || password != "calvin" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /redfish/v1/Systems":
+		f.reply(w, map[string]interface{}{"Members": []interface{}{map[string]interface{}{"@odata.id": "/redfish/v1/Systems/437XR1138R2"}}})
+	case "GET /redfish/v1/Systems/437XR1138R2":
+		f.reply(w, f.system)
+	case "PATCH /redfish/v1/Systems/437XR1138R2":
+		f.patch(w, r, f.system)
+	case "POST /redfish/v1/Systems/437XR1138R2/Actions/ComputerSystem.Reset":
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body) //nolint: errcheck
+		f.resets = append(f.resets, body["ResetType"])
+		f.system["PowerState"] = "On"
+		if body["ResetType"] == "ForceOff" {
+			f.system["PowerState"] = "Off"
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "GET /redfish/v1/Managers/BMC":
+		f.reply(w, map[string]interface{}{"VirtualMedia": map[string]interface{}{"@odata.id": "/redfish/v1/Managers/BMC/VirtualMedia"}})
+	case "GET /redfish/v1/Managers/BMC/VirtualMedia":
+		f.reply(w, map[string]interface{}{"Members": []interface{}{
+			map[string]interface{}{"@odata.id": "/redfish/v1/Managers/BMC/VirtualMedia/Floppy1"},
+			map[string]interface{}{"@odata.id": "/redfish/v1/Managers/BMC/VirtualMedia/CD1"},
+		}})
+	case "GET /redfish/v1/Managers/BMC/VirtualMedia/Floppy1":
+		f.reply(w, f.floppy)
+	case "PATCH /redfish/v1/Managers/BMC/VirtualMedia/Floppy1":
+		f.patch(w, r, f.floppy)
+	case "GET /redfish/v1/Managers/BMC/VirtualMedia/CD1":
+		f.reply(w, f.cd)
+	case "PATCH /redfish/v1/Managers/BMC/VirtualMedia/CD1":
+		f.patch(w, r, f.cd)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeBMC) patch(w http.ResponseWriter, r *http.Request, resource map[string]interface{}) {
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for key, value := range body {
+		resource[key] = value
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeBMC) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v) //nolint: errcheck
+}
+
+// newTestPool serves one fake BMC per host, the first hosts being the control plane ones
+func newTestPool(count, controlPlaneCount int) (*pool, []*fakeBMC, func()) {
+	p := &pool{controlPlaneCount: controlPlaneCount}
+	bmcs := []*fakeBMC{}
+	servers := []*httptest.Server{}
+	endpoints := map[string]string{}
+
+	for i := 0; i < count; i++ {
+		f := newFakeBMC()
+		server := httptest.NewServer(f)
+		name := "node-" + string('a'+rune(i))
+		p.hosts = append(p.hosts, talosv1.RedfishHost{Name: name, Endpoint: server.URL, Address: "10.0.0." + string('1'+rune(i))})
+		bmcs = append(bmcs, f)
+		servers = append(servers, server)
+		endpoints[name] = server.URL
+	}
+	p.connect = func(host *talosv1.RedfishHost) (*bmc, error) {
+		return newBMC(endpoints[host.Name], "root", "calvin", false), nil
+	}
+
+	return p, bmcs, func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}
+}
+
+func testMachine(t *testing.T, name string, role talosv1.MachineRole, index int) *clusterv1.Machine {
+	spec, err := utils.EncodeProviderObject(&talosv1.TalosMachineProviderSpec{
+		Role:              role,
+		ControlPlaneIndex: index,
+		Platform:          talosv1.TalosMachinePlatformSpec{Type: "redfish", Redfish: &talosv1.RedfishMachineConfig{Boot: "pxe"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       clusterv1.MachineSpec{ProviderSpec: clusterv1.ProviderSpec{Value: spec}},
+	}
+}
+
+func TestClaim(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	p, bmcs, done := newTestPool(4, 1)
+	defer done()
+
+	master := testMachine(t, "test-master-0", talosv1.MachineRoleInit, 0)
+	claimed, err := p.claim(master)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(claimed.host.Name).To(gomega.Equal("node-a"))
+	g.Expect(bmcs[0].system["AssetTag"]).To(gomega.Equal("talos:default/test-master-0"))
+
+	//Workers skip the control plane hosts and the hosts in use
+	bmcs[1].system["AssetTag"] = "rack 4"
+	worker := testMachine(t, "test-workers-abcde", talosv1.MachineRoleWorker, 0)
+	claimed, err = p.claim(worker)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(claimed.host.Name).To(gomega.Equal("node-c"))
+
+	//Claiming again returns the same host
+	claimed, err = p.claim(worker)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(claimed.host.Name).To(gomega.Equal("node-c"))
+
+	_, err = p.claim(testMachine(t, "test-workers-fghij", talosv1.MachineRoleWorker, 0))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	_, err = p.claim(testMachine(t, "test-workers-klmno", talosv1.MachineRoleWorker, 0))
+	g.Expect(err).To(gomega.MatchError("[Redfish] No free host left for machine test-workers-klmno"))
+
+	_, err = p.claim(testMachine(t, "test-master-1", talosv1.MachineRoleControlPlane, 1))
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestProvisionAndRelease(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	p, bmcs, done := newTestPool(1, 1)
+	defer done()
+	f := bmcs[0]
+
+	machine := testMachine(t, "test-master-0", talosv1.MachineRoleInit, 0)
+	claimed, err := p.claim(machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//The Talos ISO takes the CD drive, so the config image goes to the USB stick
+	config := &talosv1.RedfishMachineConfig{Boot: "virtualMedia", ImageURL: "http://10.0.0.100/talos.iso"}
+	g.Expect(provision(claimed, config, "http://10.0.0.1:8090/userdata/default/test-master-0/0123.iso")).To(gomega.Succeed())
+	g.Expect(f.resets).To(gomega.Equal([]string{"On"}))
+	g.Expect(f.system["Boot"]).To(gomega.Equal(map[string]interface{}{"BootSourceOverrideEnabled": "Once", "BootSourceOverrideTarget": "Cd"}))
+	g.Expect(f.cd["Image"]).To(gomega.Equal("http://10.0.0.100/talos.iso"))
+	g.Expect(f.cd["Inserted"]).To(gomega.BeTrue())
+	g.Expect(f.floppy["Image"]).To(gomega.Equal("http://10.0.0.1:8090/userdata/default/test-master-0/0123.iso"))
+	g.Expect(f.floppy["Inserted"]).To(gomega.BeTrue())
+
+	g.Expect(setStatus(machine, claimed, nil)).To(gomega.Succeed())
+	g.Expect(*machine.Spec.ProviderID).To(gomega.Equal("redfish://node-a"))
+
+	status, err := utils.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Status.InstanceID).To(gomega.Equal("node-a"))
+	g.Expect(status.Status.InstanceState).To(gomega.Equal("On"))
+	g.Expect(status.Status.Addresses).To(gomega.ConsistOf(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}))
+
+	//The provider ID points straight at the host
+	claimed, err = p.find(machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(claimed.system.PowerState).To(gomega.Equal("On"))
+
+	g.Expect(release(claimed)).To(gomega.Succeed())
+	g.Expect(f.resets).To(gomega.Equal([]string{"On", "ForceOff"}))
+	g.Expect(f.cd["Inserted"]).To(gomega.BeFalse())
+	g.Expect(f.floppy["Inserted"]).To(gomega.BeFalse())
+	g.Expect(f.system["AssetTag"]).To(gomega.BeEmpty())
+
+	claimed, err = p.find(machine)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(claimed).To(gomega.BeNil())
+}
+
+func TestProvisionFromPXE(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	p, bmcs, done := newTestPool(1, 1)
+	defer done()
+	f := bmcs[0]
+
+	claimed, err := p.claim(testMachine(t, "test-master-0", talosv1.MachineRoleInit, 0))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	//Hosts booting from the network get the config image in the CD drive
+	g.Expect(provision(claimed, &talosv1.RedfishMachineConfig{Boot: "pxe"}, "http://10.0.0.1:8090/userdata/default/test-master-0/0123.iso")).To(gomega.Succeed())
+	g.Expect(f.system["Boot"]).To(gomega.Equal(map[string]interface{}{"BootSourceOverrideEnabled": "Once", "BootSourceOverrideTarget": "Pxe"}))
+	g.Expect(f.cd["Image"]).To(gomega.Equal("http://10.0.0.1:8090/userdata/default/test-master-0/0123.iso"))
+	g.Expect(f.floppy["Inserted"]).To(gomega.BeFalse())
+}
+
+func TestBMCErrors(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	server := httptest.NewServer(newFakeBMC())
+	defer server.Close()
+
+	_, err := newBMC(server.URL, "root", "wrong", false).system("")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring("401 Unauthorized"))
+
+	_, err = newBMC(server.URL, "root", "calvin", false).system("missing")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring("/redfish/v1/Systems/missing"))
+}
+
+func TestControlPlaneAddresses(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	hosts := []talosv1.RedfishHost{{Name: "node-a", Address: "10.0.0.1"}, {Name: "node-b"}, {Name: "node-c", Address: "10.0.0.3"}}
+
+	ips, err := controlPlaneAddresses(hosts, 1)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"10.0.0.1"}))
+
+	_, err = controlPlaneAddresses(hosts, 3)
+	g.Expect(err).To(gomega.MatchError("[Redfish] Control plane host node-b has no address"))
+
+	_, err = controlPlaneAddresses(hosts, 5)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
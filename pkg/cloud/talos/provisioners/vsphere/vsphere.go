@@ -0,0 +1,569 @@
+package vsphere
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	talosconfig "github.com/talos-systems/talos/pkg/config/machine"
+	"github.com/talos-systems/talos/pkg/constants"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// guestInfoConfigKey is the VM property Talos reads its base64 encoded config from
+const guestInfoConfigKey = "guestinfo." + constants.VMwareGuestInfoConfigKey
+
+// VSphere represents a provider for vSphere.
+type VSphere struct {
+}
+
+// session is a logged in vSphere client, scoped to a datacenter
+type session struct {
+	client     *govmomi.Client
+	finder     *find.Finder
+	datacenter *object.Datacenter
+}
+
+func init() {
+	provisioners.Register("vsphere", func() (provisioners.Provisioner, error) {
+		return NewVSphere()
+	})
+}
+
+// NewVSphere returns an instance of the vSphere provisioner
+func NewVSphere() (*VSphere, error) {
+	return &VSphere{}, nil
+}
+
+// Create clones a VM from the Talos template, passing the userdata through guestinfo.
+func (vsphere *VSphere) Create(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	vsphereConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	udSecret, err := utils.FetchUserdataSecret(cluster, machine, clientset)
+	if err != nil {
+		return err
+	}
+
+	//Control plane nodes get their static address written into the userdata
+	userdata := string(udSecret.Data["userdata"])
+	address, gateway, err := staticAddress(cluster, machine)
+	if err != nil {
+		return err
+	}
+	if address != "" {
+		userdata, err = withStaticAddress(userdata, machine.ObjectMeta.Name, address, gateway)
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := newSession(ctx, cluster, clientset, vsphereConfig.Server, vsphereConfig.Insecure, vsphereConfig.Datacenter)
+	if err != nil {
+		return err
+	}
+	defer s.logout(ctx)
+
+	vm, err := s.clone(ctx, utils.MachineResourceName(cluster, machine), vsphereConfig, userdata)
+	if err != nil {
+		return err
+	}
+
+	if err = s.setStatus(ctx, machine, vm, address); err != nil {
+		return err
+	}
+
+	log.Println("[vSphere] Instance created with uuid: " + path.Base(*machine.Spec.ProviderID))
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.ConfigVersion = udSecret.ObjectMeta.ResourceVersion
+	})
+}
+
+// Update refreshes the provider ID and status of a VM.
+func (vsphere *VSphere) Update(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	vsphereConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	address, _, err := staticAddress(cluster, machine)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(ctx, cluster, clientset, vsphereConfig.Server, vsphereConfig.Insecure, vsphereConfig.Datacenter)
+	if err != nil {
+		return err
+	}
+	defer s.logout(ctx)
+
+	vm, err := s.fetchVM(ctx, cluster, machine, vsphereConfig)
+	if err != nil {
+		return err
+	}
+	if vm == nil {
+		return nil
+	}
+
+	return s.setStatus(ctx, machine, vm, address)
+}
+
+// Delete powers off and destroys a VM.
+func (vsphere *VSphere) Delete(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) error {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+
+	vsphereConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(ctx, cluster, clientset, vsphereConfig.Server, vsphereConfig.Insecure, vsphereConfig.Datacenter)
+	if err != nil {
+		return err
+	}
+	defer s.logout(ctx)
+
+	vm, err := s.fetchVM(ctx, cluster, machine, vsphereConfig)
+	if err != nil {
+		return err
+	}
+	if vm == nil {
+		return nil
+	}
+
+	return s.destroy(ctx, vm)
+}
+
+// Exists returns whether or not a VM is present in vSphere.
+func (vsphere *VSphere) Exists(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (bool, error) {
+	machineSpec, err := utils.MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+
+	vsphereConfig, err := machineConfig(machineSpec)
+	if err != nil {
+		return false, err
+	}
+
+	s, err := newSession(ctx, cluster, clientset, vsphereConfig.Server, vsphereConfig.Insecure, vsphereConfig.Datacenter)
+	if err != nil {
+		return false, err
+	}
+	defer s.logout(ctx)
+
+	vm, err := s.fetchVM(ctx, cluster, machine, vsphereConfig)
+	if err != nil {
+		return false, err
+	}
+	return vm != nil, nil
+}
+
+// AllocateExternalIPs returns the static addresses of the control plane nodes.
+// Note: Nothing is allocated on vSphere, the addresses are taken from the cluster spec.
+func (vsphere *VSphere) AllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) ([]string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	vsphereConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vsphereConfig.ControlPlaneIPs) < clusterSpec.ControlPlane.Count {
+		return nil, fmt.Errorf("[vSphere] %d control plane IPs are needed, %d are configured", clusterSpec.ControlPlane.Count, len(vsphereConfig.ControlPlaneIPs))
+	}
+
+	ips := []string{}
+	for _, cidr := range vsphereConfig.ControlPlaneIPs[:clusterSpec.ControlPlane.Count] {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips, nil
+}
+
+// DeAllocateExternalIPs is a no-op, the static addresses belong to the cluster spec
+func (vsphere *VSphere) DeAllocateExternalIPs(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) error {
+	return nil
+}
+
+// ControlPlaneEndpoint returns the VIP of the control plane, if one is configured
+func (vsphere *VSphere) ControlPlaneEndpoint(cluster *clusterv1.Cluster) (string, error) {
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return "", err
+	}
+
+	vsphereConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return "", err
+	}
+	return vsphereConfig.VIP, nil
+}
+
+// clone creates a VM from the template in the folder, pool, datastore and network of the config, then sizes and powers it on
+func (s *session) clone(ctx context.Context, name string, config *talosv1.VSphereMachineConfig, userdata string) (*object.VirtualMachine, error) {
+	template, err := s.finder.VirtualMachine(ctx, config.Instances.Template)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := s.finder.FolderOrDefault(ctx, config.Folder)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := s.finder.ResourcePoolOrDefault(ctx, config.ResourcePool)
+	if err != nil {
+		return nil, err
+	}
+	datastore, err := s.finder.DatastoreOrDefault(ctx, config.Datastore)
+	if err != nil {
+		return nil, err
+	}
+
+	poolRef := pool.Reference()
+	datastoreRef := datastore.Reference()
+	spec := types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{
+			Pool:      &poolRef,
+			Datastore: &datastoreRef,
+		},
+	}
+
+	//Attach the first NIC of the template to the configured network
+	if config.Network != "" {
+		change, err := s.networkChange(ctx, template, config.Network)
+		if err != nil {
+			return nil, err
+		}
+		spec.Location.DeviceChange = append(spec.Location.DeviceChange, change)
+	}
+
+	task, err := template.Clone(ctx, folder, name, spec)
+	if err != nil {
+		return nil, err
+	}
+	info, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		return nil, errors.New("[vSphere] Clone did not return a VM")
+	}
+	vm := object.NewVirtualMachine(s.client.Client, ref)
+
+	//Size the clone and hand it the userdata before its first boot
+	task, err = vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+		NumCPUs:  config.Instances.CPUs,
+		MemoryMB: config.Instances.MemoryMiB,
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: guestInfoConfigKey, Value: base64.StdEncoding.EncodeToString([]byte(userdata))},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = task.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	task, err = vm.PowerOn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = task.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+// networkChange moves the first NIC of the template to the given network
+func (s *session) networkChange(ctx context.Context, template *object.VirtualMachine, name string) (types.BaseVirtualDeviceConfigSpec, error) {
+	network, err := s.finder.Network(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	backing, err := network.EthernetCardBackingInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := template.Device(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nics := devices.SelectByType((*types.VirtualEthernetCard)(nil))
+	if len(nics) == 0 {
+		return nil, fmt.Errorf("[vSphere] Template %s has no network adapter", template.Name())
+	}
+
+	nic := nics[0]
+	nic.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard().Backing = backing
+	return &types.VirtualDeviceConfigSpec{Operation: types.VirtualDeviceConfigSpecOperationEdit, Device: nic}, nil
+}
+
+// destroy powers off a VM if needed and deletes it
+func (s *session) destroy(ctx context.Context, vm *object.VirtualMachine) error {
+	state, err := vm.PowerState(ctx)
+	if err != nil {
+		return err
+	}
+	if state == types.VirtualMachinePowerStatePoweredOn {
+		task, err := vm.PowerOff(ctx)
+		if err != nil {
+			return err
+		}
+		if err = task.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	task, err := vm.Destroy(ctx)
+	if err != nil {
+		return err
+	}
+	return task.Wait(ctx)
+}
+
+// fetchVM looks up the VM of a machine, by the BIOS UUID of its provider ID if set, and by its cluster-keyed name in the configured folder otherwise
+func (s *session) fetchVM(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine, config *talosv1.VSphereMachineConfig) (*object.VirtualMachine, error) {
+	uuid, err := utils.ParseProviderID(machine, "vsphere")
+	if err != nil {
+		return nil, err
+	}
+	if uuid != "" {
+		ref, err := object.NewSearchIndex(s.client.Client).FindByUuid(ctx, s.datacenter, uuid, true, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ref == nil {
+			return nil, nil
+		}
+		return object.NewVirtualMachine(s.client.Client, ref.Reference()), nil
+	}
+
+	folder, err := s.finder.FolderOrDefault(ctx, config.Folder)
+	if err != nil {
+		return nil, err
+	}
+	vm, err := s.finder.VirtualMachine(ctx, path.Join(folder.InventoryPath, utils.MachineResourceName(cluster, machine)))
+	if _, ok := err.(*find.NotFoundError); ok {
+		return nil, nil
+	}
+	return vm, err
+}
+
+// setStatus records the provider ID, power state and addresses of a VM in the machine.
+// Talos does not run VMware tools, so the static address of control plane nodes is recorded as well.
+func (s *session) setStatus(ctx context.Context, machine *clusterv1.Machine, vm *object.VirtualMachine, address string) error {
+	var mvm mo.VirtualMachine
+	if err := vm.Properties(ctx, vm.Reference(), []string{"summary", "guest"}, &mvm); err != nil {
+		return err
+	}
+
+	uuid := mvm.Summary.Config.Uuid
+	providerID := utils.ProviderID("vsphere", uuid)
+	machine.Spec.ProviderID = &providerID
+
+	addresses := []string{}
+	if address != "" {
+		addresses = append(addresses, address)
+	}
+	if mvm.Guest != nil {
+		for _, nic := range mvm.Guest.Net {
+			for _, ip := range nic.IpAddress {
+				if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil && ip != address {
+					addresses = append(addresses, ip)
+				}
+			}
+		}
+	}
+
+	return utils.UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = uuid
+		status.InstanceState = string(mvm.Summary.Runtime.PowerState)
+		status.Zone = s.datacenter.Name()
+		status.Addresses = nil
+		for _, ip := range addresses {
+			status.Addresses = append(status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip})
+		}
+	})
+}
+
+func (s *session) logout(ctx context.Context) {
+	if err := s.client.Logout(ctx); err != nil {
+		log.Printf("[vSphere] Failed to log out: %v", err)
+	}
+}
+
+// newSession logs in to vSphere with the username and password referenced by the cluster if any,
+// and VSPHERE_USERNAME and VSPHERE_PASSWORD otherwise
+func newSession(ctx context.Context, cluster *clusterv1.Cluster, clientset *kubernetes.Clientset, server string, insecure bool, datacenter string) (*session, error) {
+	creds, err := utils.FetchCredentials(cluster, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *url.Userinfo
+	if creds == nil {
+		user = url.UserPassword(os.Getenv("VSPHERE_USERNAME"), os.Getenv("VSPHERE_PASSWORD"))
+	} else {
+		username, err := utils.CredentialsValue(creds, "username")
+		if err != nil {
+			return nil, err
+		}
+		password, err := utils.CredentialsValue(creds, "password")
+		if err != nil {
+			return nil, err
+		}
+		user = url.UserPassword(string(username), string(password))
+	}
+
+	return connect(ctx, server, insecure, user, datacenter)
+}
+
+// connect logs in to the server, which is either an address or the URL of the SDK endpoint
+func connect(ctx context.Context, server string, insecure bool, user *url.Userinfo, datacenter string) (*session, error) {
+	u, err := soap.ParseURL(server)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("[vSphere] No server configured")
+	}
+	u.User = user
+
+	client, err := govmomi.NewClient(ctx, u, insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	finder := find.NewFinder(client.Client, true)
+	dc, err := finder.DatacenterOrDefault(ctx, datacenter)
+	if err != nil {
+		client.Logout(ctx)
+		return nil, err
+	}
+	finder.SetDatacenter(dc)
+
+	return &session{client: client, finder: finder, datacenter: dc}, nil
+}
+
+// staticAddress returns the address in CIDR form and the gateway of a control plane machine, or empty strings for workers
+func staticAddress(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (string, string, error) {
+	role, index, err := utils.MachineRole(machine)
+	if err != nil {
+		return "", "", err
+	}
+	if !utils.IsControlPlane(role) {
+		return "", "", nil
+	}
+
+	clusterSpec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return "", "", err
+	}
+	vsphereConfig, err := clusterConfig(clusterSpec)
+	if err != nil {
+		return "", "", err
+	}
+	if index >= len(vsphereConfig.ControlPlaneIPs) {
+		return "", "", fmt.Errorf("[vSphere] No control plane IP configured for index %d", index)
+	}
+
+	return vsphereConfig.ControlPlaneIPs[index], vsphereConfig.Gateway, nil
+}
+
+// withStaticAddress replaces the network section of the userdata with a static config for eth0
+func withStaticAddress(userdata, hostname, cidr, gateway string) (string, error) {
+	ud := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(userdata), &ud); err != nil {
+		return "", err
+	}
+	machineSection, ok := ud["machine"].(map[interface{}]interface{})
+	if !ok {
+		return "", errors.New("[vSphere] Userdata has no machine section")
+	}
+
+	device := talosconfig.Device{Interface: "eth0", CIDR: cidr}
+	if gateway != "" {
+		device.Routes = []talosconfig.Route{{Network: "0.0.0.0/0", Gateway: gateway}}
+	}
+	machineSection["network"] = map[string]interface{}{
+		"hostname":   hostname,
+		"interfaces": []talosconfig.Device{device},
+	}
+
+	out, err := yaml.Marshal(ud)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// clusterConfig returns the vSphere config of a cluster, decoding the free-form config if the typed one is missing
+func clusterConfig(clusterSpec *talosv1.TalosClusterProviderSpec) (*talosv1.VSphereClusterConfig, error) {
+	if clusterSpec.Platform.VSphere != nil {
+		return clusterSpec.Platform.VSphere, nil
+	}
+
+	config := &talosv1.VSphereClusterConfig{}
+	if err := utils.DecodePlatformConfig(clusterSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// machineConfig returns the vSphere config of a machine, decoding the free-form config if the typed one is missing
+func machineConfig(machineSpec *talosv1.TalosMachineProviderSpec) (*talosv1.VSphereMachineConfig, error) {
+	if machineSpec.Platform.VSphere != nil {
+		return machineSpec.Platform.VSphere, nil
+	}
+
+	config := &talosv1.VSphereMachineConfig{}
+	if err := utils.DecodePlatformConfig(machineSpec.Platform.Config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
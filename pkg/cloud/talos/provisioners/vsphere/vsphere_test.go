@@ -0,0 +1,142 @@
+package vsphere
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/talos/pkg/config/types/v1alpha1/generate"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestVMLifecycle(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	defer model.Remove()
+	g.Expect(model.Create()).To(gomega.Succeed())
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	s, err := connect(ctx, server.URL.String(), true, server.URL.User, "DC0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer s.logout(ctx)
+
+	config := &talosv1.VSphereMachineConfig{
+		ResourcePool: "DC0_C0/Resources",
+		Network:      "DC0_DVPG0",
+		Instances:    talosv1.VSphereInstanceSpec{Template: "DC0_H0_VM0", CPUs: 2, MemoryMiB: 2048},
+	}
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-worker", Namespace: "default"}}
+
+	vm, err := s.fetchVM(ctx, cluster, machine, config)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vm).To(gomega.BeNil())
+
+	vm, err = s.clone(ctx, utils.MachineResourceName(cluster, machine), config, "version: v1alpha1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	var mvm mo.VirtualMachine
+	g.Expect(vm.Properties(ctx, vm.Reference(), []string{"config"}, &mvm)).To(gomega.Succeed())
+	g.Expect(mvm.Config.Hardware.NumCPU).To(gomega.BeEquivalentTo(2))
+	userdata := ""
+	for _, option := range mvm.Config.ExtraConfig {
+		if value := option.GetOptionValue(); value.Key == guestInfoConfigKey {
+			userdata = value.Value.(string)
+		}
+	}
+	g.Expect(userdata).To(gomega.Equal(base64.StdEncoding.EncodeToString([]byte("version: v1alpha1"))))
+
+	found, err := s.fetchVM(ctx, cluster, machine, config)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found.Reference()).To(gomega.Equal(vm.Reference()))
+
+	//Not the VM of a namesake cluster in another namespace
+	namesake := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "other"}}
+	found, err = s.fetchVM(ctx, namesake, &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test-worker", Namespace: "other"}}, config)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeNil())
+
+	g.Expect(s.setStatus(ctx, machine, vm, "192.168.1.10")).To(gomega.Succeed())
+	g.Expect(*machine.Spec.ProviderID).To(gomega.Equal("vsphere://" + mvm.Config.Uuid))
+	status, err := utils.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Status.InstanceState).To(gomega.Equal("poweredOn"))
+	g.Expect(status.Status.Zone).To(gomega.Equal("DC0"))
+	g.Expect(status.Status.Addresses[0].Address).To(gomega.Equal("192.168.1.10"))
+
+	found, err = s.fetchVM(ctx, cluster, machine, config)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found.Reference()).To(gomega.Equal(vm.Reference()))
+
+	g.Expect(s.destroy(ctx, vm)).To(gomega.Succeed())
+	found, err = s.fetchVM(ctx, cluster, machine, config)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeNil())
+}
+
+func TestWithStaticAddress(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	input, err := generate.NewInput("test", []string{"192.168.1.10"}, "1.16.0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	userdata, err := generate.Config(generate.TypeInit, input)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	userdata, err = withStaticAddress(userdata, "test-master-0", "192.168.1.10/24", "192.168.1.1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	ud := struct {
+		Machine struct {
+			Network struct {
+				Hostname   string
+				Interfaces []struct {
+					Interface string
+					CIDR      string
+					Routes    []struct{ Network, Gateway string }
+				}
+			}
+			CA interface{}
+		}
+	}{}
+	g.Expect(yaml.Unmarshal([]byte(userdata), &ud)).To(gomega.Succeed())
+	g.Expect(ud.Machine.CA).NotTo(gomega.BeNil())
+	g.Expect(ud.Machine.Network.Hostname).To(gomega.Equal("test-master-0"))
+	g.Expect(ud.Machine.Network.Interfaces).To(gomega.HaveLen(1))
+	g.Expect(ud.Machine.Network.Interfaces[0].CIDR).To(gomega.Equal("192.168.1.10/24"))
+	g.Expect(ud.Machine.Network.Interfaces[0].Routes[0].Gateway).To(gomega.Equal("192.168.1.1"))
+}
+
+func TestAllocateExternalIPs(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	value, err := utils.EncodeProviderObject(&talosv1.TalosClusterProviderSpec{
+		ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1},
+		Platform: talosv1.TalosClusterPlatformSpec{Type: "vsphere", VSphere: &talosv1.VSphereClusterConfig{
+			ControlPlaneIPs: []string{"192.168.1.10/24", "192.168.1.11/24"},
+			VIP:             "192.168.1.100",
+		}},
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cluster := &clusterv1.Cluster{Spec: clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: value}}}
+
+	vsphere, err := NewVSphere()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	ips, err := vsphere.AllocateExternalIPs(cluster, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(ips).To(gomega.Equal([]string{"192.168.1.10"}))
+
+	endpoint, err := vsphere.ControlPlaneEndpoint(cluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(endpoint).To(gomega.Equal("192.168.1.100"))
+}
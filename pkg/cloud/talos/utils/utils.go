@@ -1,17 +1,24 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 
 	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
-	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/yaml"
 )
 
 //RandomString simply returns a string of length n
@@ -26,23 +33,139 @@ func RandomString(n int) string {
 }
 
 //ClusterProviderFromSpec parses out and returns provider specific cluster spec
+//Unknown fields are rejected
 func ClusterProviderFromSpec(providerSpec clusterv1.ProviderSpec) (*talosv1.TalosClusterProviderSpec, error) {
+	if providerSpec.Value == nil {
+		return nil, errors.New("missing cluster provider spec")
+	}
+
 	var config talosv1.TalosClusterProviderSpec
-	if err := yaml.Unmarshal(providerSpec.Value.Raw, &config); err != nil {
+	if err := yaml.UnmarshalStrict(providerSpec.Value.Raw, &config); err != nil {
 		return nil, err
 	}
 	return &config, nil
 }
 
 //MachineProviderFromSpec parses out and returns provider specific machine spec
+//Unknown fields are rejected
 func MachineProviderFromSpec(providerSpec clusterv1.ProviderSpec) (*talosv1.TalosMachineProviderSpec, error) {
+	if providerSpec.Value == nil {
+		return nil, errors.New("missing machine provider spec")
+	}
+
 	var config talosv1.TalosMachineProviderSpec
-	if err := yaml.Unmarshal(providerSpec.Value.Raw, &config); err != nil {
+	if err := yaml.UnmarshalStrict(providerSpec.Value.Raw, &config); err != nil {
 		return nil, err
 	}
 	return &config, nil
 }
 
+//ClusterStatusFromProviderStatus parses out and returns provider specific cluster status
+//A missing provider status results in an empty one
+func ClusterStatusFromProviderStatus(providerStatus *runtime.RawExtension) (*talosv1.TalosClusterProviderStatus, error) {
+	status := &talosv1.TalosClusterProviderStatus{}
+	if providerStatus != nil {
+		if err := yaml.Unmarshal(providerStatus.Raw, status); err != nil {
+			return nil, err
+		}
+	}
+
+	status.APIVersion = talosv1.SchemeGroupVersion.String()
+	status.Kind = "TalosClusterProviderStatus"
+	return status, nil
+}
+
+//UpdateClusterProviderStatus applies update to the provider status of a cluster and stores it back in the cluster
+func UpdateClusterProviderStatus(cluster *clusterv1.Cluster, update func(*talosv1.TalosClusterProviderStatusStatus)) error {
+	status, err := ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+
+	update(&status.Status)
+
+	cluster.Status.ProviderStatus, err = EncodeProviderObject(status)
+	return err
+}
+
+//MachineStatusFromProviderStatus parses out and returns provider specific machine status
+//A missing provider status results in an empty one
+func MachineStatusFromProviderStatus(providerStatus *runtime.RawExtension) (*talosv1.TalosMachineProviderStatus, error) {
+	status := &talosv1.TalosMachineProviderStatus{}
+	if providerStatus != nil {
+		if err := yaml.Unmarshal(providerStatus.Raw, status); err != nil {
+			return nil, err
+		}
+	}
+
+	status.APIVersion = talosv1.SchemeGroupVersion.String()
+	status.Kind = "TalosMachineProviderStatus"
+	return status, nil
+}
+
+//UpdateMachineProviderStatus applies update to the provider status of a machine and stores it back in the machine
+func UpdateMachineProviderStatus(machine *clusterv1.Machine, update func(*talosv1.TalosMachineProviderStatusStatus)) error {
+	status, err := MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+
+	update(&status.Status)
+
+	machine.Status.ProviderStatus, err = EncodeProviderObject(status)
+	return err
+}
+
+//EncodeProviderObject returns the raw value of a Talos provider spec or status
+func EncodeProviderObject(obj runtime.Object) (*runtime.RawExtension, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &runtime.RawExtension{Raw: raw}, nil
+}
+
+//ProviderID builds the canonical provider ID <platform>://<id> of an instance
+func ProviderID(platform string, id string) string {
+	return platform + "://" + id
+}
+
+//ParseProviderID returns the platform specific part of the provider ID of a machine.
+//An empty string is returned if the machine has no provider ID yet.
+func ParseProviderID(machine *clusterv1.Machine, platform string) (string, error) {
+	if machine.Spec.ProviderID == nil || *machine.Spec.ProviderID == "" {
+		return "", nil
+	}
+
+	providerID := *machine.Spec.ProviderID
+	prefix := platform + "://"
+	if !strings.HasPrefix(providerID, prefix) || len(providerID) == len(prefix) {
+		return "", fmt.Errorf("machine %q: provider ID %q does not match the %s platform", machine.ObjectMeta.Name, providerID, platform)
+	}
+	return strings.TrimPrefix(providerID, prefix), nil
+}
+
+//ProviderSpecKind returns the kind of the provider spec, or an empty string if it has none
+func ProviderSpecKind(providerSpec clusterv1.ProviderSpec) string {
+	if providerSpec.Value == nil {
+		return ""
+	}
+
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal(providerSpec.Value.Raw, &typeMeta); err != nil {
+		return ""
+	}
+	return typeMeta.Kind
+}
+
+//DecodePlatformConfig strictly decodes the free-form platform config string into out
+func DecodePlatformConfig(config string, out interface{}) error {
+	if err := yaml.UnmarshalStrict([]byte(config), out); err != nil {
+		return fmt.Errorf("invalid platform config: %v", err)
+	}
+	return nil
+}
+
 //CreateK8sClientSet returns a kube client to use for calls to the api server
 func CreateK8sClientSet() (*kubernetes.Clientset, error) {
 	// creates the in-cluster config
@@ -59,22 +182,218 @@ func CreateK8sClientSet() (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
-// FetchConfigMap grabs the proper cm from kubernetes depending on whether we're worried about our masters or workers
-func FetchConfigMap(cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (*v1.ConfigMap, error) {
-	udConfigMap := &v1.ConfigMap{}
-	var err error
-	if strings.Contains(machine.ObjectMeta.Name, "worker") {
-		udConfigMap, err = clientset.CoreV1().ConfigMaps("cluster-api-provider-talos-system").Get(cluster.ObjectMeta.Name+"-workers", metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			return nil, err
+//MachineRole returns the role and control plane index of a machine.
+//Machines without a role fall back to the deprecated detection from their name:
+//names containing "worker" are workers, others are control plane machines indexed by their last dash-separated element.
+func MachineRole(machine *clusterv1.Machine) (talosv1.MachineRole, int, error) {
+	spec, err := MachineProviderFromSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch spec.Role {
+	case talosv1.MachineRoleInit:
+		return spec.Role, 0, nil
+	case talosv1.MachineRoleControlPlane:
+		if spec.ControlPlaneIndex < 1 {
+			return "", 0, fmt.Errorf("machine %q: controlplane machines need a controlPlaneIndex of at least 1", machine.ObjectMeta.Name)
 		}
-	} else {
-		udConfigMap, err = clientset.CoreV1().ConfigMaps("cluster-api-provider-talos-system").Get(machine.ObjectMeta.Name, metav1.GetOptions{})
-		if k8serrors.IsNotFound(err) {
-			return nil, err
+		return spec.Role, spec.ControlPlaneIndex, nil
+	case talosv1.MachineRoleWorker:
+		return spec.Role, 0, nil
+	case "":
+	default:
+		return "", 0, fmt.Errorf("machine %q: unknown role %q", machine.ObjectMeta.Name, spec.Role)
+	}
+
+	if strings.Contains(machine.ObjectMeta.Name, "worker") {
+		return talosv1.MachineRoleWorker, 0, nil
+	}
+
+	nameSlice := strings.Split(machine.ObjectMeta.Name, "-")
+	index, err := strconv.Atoi(nameSlice[len(nameSlice)-1])
+	if err != nil {
+		return "", 0, fmt.Errorf("machine %q: unable to infer the control plane index from the name, set role and controlPlaneIndex", machine.ObjectMeta.Name)
+	}
+	if index == 0 {
+		return talosv1.MachineRoleInit, 0, nil
+	}
+	return talosv1.MachineRoleControlPlane, index, nil
+}
+
+//IsControlPlane reports whether the role is one of the control plane roles
+func IsControlPlane(role talosv1.MachineRole) bool {
+	return role == talosv1.MachineRoleInit || role == talosv1.MachineRoleControlPlane
+}
+
+//FetchCredentials returns the data of the credentials secret referenced by the cluster platform spec.
+//Nil is returned if the cluster does not reference one, in which case the credentials of the manager should be used.
+func FetchCredentials(cluster *clusterv1.Cluster, clientset *kubernetes.Clientset) (map[string][]byte, error) {
+	spec, err := ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := spec.Platform.CredentialsSecretRef
+	if ref == nil {
+		return nil, nil
+	}
+
+	namespace, err := CredentialsNamespace(cluster, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cluster %q: unable to fetch credentials secret %s/%s: %v", cluster.ObjectMeta.Name, namespace, ref.Name, err)
+	}
+	return secret.Data, nil
+}
+
+//CredentialsNamespace returns the namespace of a credentials secret referenced by a cluster, which is always the namespace of the cluster.
+//Secrets of other namespaces are refused, or anyone allowed to create a cluster could have the manager read them.
+func CredentialsNamespace(cluster *clusterv1.Cluster, ref *v1.SecretReference) (string, error) {
+	namespace := cluster.ObjectMeta.Namespace
+	if ref.Namespace != "" && ref.Namespace != namespace {
+		return "", fmt.Errorf("cluster %q: credentials secret %s/%s is not in the namespace of the cluster", cluster.ObjectMeta.Name, ref.Namespace, ref.Name)
+	}
+	return namespace, nil
+}
+
+//CredentialsValue returns the value of a key of a credentials secret, or an error naming the key if it is missing
+func CredentialsValue(creds map[string][]byte, key string) ([]byte, error) {
+	value, ok := creds[key]
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("credentials secret is missing the %q key", key)
+	}
+	return value, nil
+}
+
+//UserdataSecretType is the type of the secrets holding the userdata of a cluster, and the talosconfig on the master ones
+const UserdataSecretType v1.SecretType = "talos.dev/userdata"
+
+//Labels of the config objects generated for a cluster
+const (
+	ClusterNameLabel       = "talos.dev/cluster-name"
+	ClusterNamespaceLabel  = "talos.dev/cluster-namespace"
+	RoleLabel              = "talos.dev/role"
+	ControlPlaneIndexLabel = "talos.dev/control-plane-index"
+)
+
+//ClusterLabels returns the labels of every config object generated for a cluster
+func ClusterLabels(cluster *clusterv1.Cluster) map[string]string {
+	return map[string]string{
+		ClusterNameLabel:      cluster.ObjectMeta.Name,
+		ClusterNamespaceLabel: cluster.ObjectMeta.Namespace,
+	}
+}
+
+//UserdataLabels returns the labels of the userdata secret of a role: control plane ones also carry their index
+func UserdataLabels(cluster *clusterv1.Cluster, role talosv1.MachineRole, index int) map[string]string {
+	set := ClusterLabels(cluster)
+	set[RoleLabel] = string(role)
+	if IsControlPlane(role) {
+		set[ControlPlaneIndexLabel] = strconv.Itoa(index)
+	}
+	return set
+}
+
+//ClusterOwnerReference returns an owner reference to the cluster, so that the objects generated for it are garbage collected along with it
+func ClusterOwnerReference(cluster *clusterv1.Cluster) metav1.OwnerReference {
+	return *metav1.NewControllerRef(cluster, clusterv1.SchemeGroupVersion.WithKind("Cluster"))
+}
+
+//ClusterResourceName returns the base name of the platform resources of a cluster, e.g. its control plane IPs.
+//It is suffixed with a hash of the namespace and name of the cluster, so that clusters sharing a name in different namespaces don't collide.
+func ClusterResourceName(cluster *clusterv1.Cluster) string {
+	return cluster.ObjectMeta.Name + "-" + clusterHash(cluster)
+}
+
+//MachineResourceName returns the name of the platform resources of a machine, e.g. its instance.
+//Like ClusterResourceName, it is suffixed with the hash of the namespace and name of its cluster.
+func MachineResourceName(cluster *clusterv1.Cluster, machine *clusterv1.Machine) string {
+	return machine.ObjectMeta.Name + "-" + clusterHash(cluster)
+}
+
+func clusterHash(cluster *clusterv1.Cluster) string {
+	sum := sha256.Sum256([]byte(cluster.ObjectMeta.Namespace + "/" + cluster.ObjectMeta.Name))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+//ControlPlaneIPName returns the name of the external IP of a control plane machine
+func ControlPlaneIPName(cluster *clusterv1.Cluster, index int) string {
+	return ClusterResourceName(cluster) + "-master-" + strconv.Itoa(index) + "-ip"
+}
+
+//LegacyControlPlaneIPName returns the name earlier versions gave the external IP of a control plane machine, from the cluster name only.
+//It is only used to find the IPs of existing clusters, which are told apart from those of namesakes by OwnsLegacyControlPlaneIP.
+func LegacyControlPlaneIPName(cluster *clusterv1.Cluster, index int) string {
+	return cluster.ObjectMeta.Name + "-master-" + strconv.Itoa(index) + "-ip"
+}
+
+//HasLegacyResourceNames reports whether the cluster was created by an earlier version, which named platform resources after the cluster and machine names only.
+//Resources found by those names belong to such clusters only, not to their namesakes in other namespaces.
+func HasLegacyResourceNames(cluster *clusterv1.Cluster) (bool, error) {
+	status, err := ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return false, err
+	}
+	return status.Status.LegacyResourceNames, nil
+}
+
+//OwnsLegacyControlPlaneIP reports whether an IP found by its legacy name belongs to the cluster:
+//it is one of the control plane IPs recorded in the cluster status, or the cluster was created by an earlier version.
+func OwnsLegacyControlPlaneIP(cluster *clusterv1.Cluster, ip string) (bool, error) {
+	status, err := ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	if err != nil {
+		return false, err
+	}
+	if status.Status.LegacyResourceNames {
+		return true, nil
+	}
+	for _, controlPlaneIP := range status.Status.ControlPlaneIPs {
+		if controlPlaneIP == ip {
+			return true, nil
 		}
+	}
+	return false, nil
+}
+
+//KubeconfigSecretKey is the key of the admin kubeconfig in the kubeconfig secret of a cluster, as per the Cluster API convention
+const KubeconfigSecretKey = "value"
+
+//KubeconfigSecretName returns the name of the secret holding the admin kubeconfig of a cluster, in the namespace of the cluster
+func KubeconfigSecretName(cluster *clusterv1.Cluster) string {
+	return cluster.ObjectMeta.Name + "-kubeconfig"
+}
+
+//UserdataSecretName returns the name of the userdata secret of a role: masters have one each, workers share one
+func UserdataSecretName(cluster *clusterv1.Cluster, role talosv1.MachineRole, index int) string {
+	if IsControlPlane(role) {
+		return cluster.ObjectMeta.Name + "-master-" + strconv.Itoa(index)
+	}
+	return cluster.ObjectMeta.Name + "-workers"
+}
+
+//FetchUserdataSecret grabs the userdata secret of a machine by its labels, depending on whether it is a master or a worker
+func FetchUserdataSecret(cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset *kubernetes.Clientset) (*v1.Secret, error) {
+	role, index, err := MachineRole(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := labels.SelectorFromSet(UserdataLabels(cluster, role, index)).String()
+	secrets, err := clientset.CoreV1().Secrets(cluster.ObjectMeta.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
 
+	for i := range secrets.Items {
+		if secrets.Items[i].Type == UserdataSecretType {
+			return &secrets.Items[i], nil
+		}
 	}
 
-	return udConfigMap, nil
+	return nil, fmt.Errorf("machine %q: no userdata secret matches %s", machine.ObjectMeta.Name, selector)
 }
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+func TestMachineProviderFromSpec(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	spec, err := MachineProviderFromSpec(clusterv1.ProviderSpec{Value: &runtime.RawExtension{
+		Raw: []byte(`{"apiVersion":"talosproviderconfig/v1alpha1","kind":"TalosMachineProviderSpec","platform":{"type":"aws","aws":{"region":"us-west-2","instances":{"ami":"ami-123"}}}}`),
+	}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(spec.Platform.AWS.Region).To(gomega.Equal("us-west-2"))
+	g.Expect(spec.Platform.AWS.Instances.AMI).To(gomega.Equal("ami-123"))
+
+	_, err = MachineProviderFromSpec(clusterv1.ProviderSpec{Value: &runtime.RawExtension{
+		Raw: []byte(`{"platform":{"type":"aws","aws":{"region":"us-west-2","instance":{"ami":"ami-123"}}}}`),
+	}})
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, err = MachineProviderFromSpec(clusterv1.ProviderSpec{})
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestDecodePlatformConfig(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	config := &talosv1.AzureMachineConfig{}
+	g.Expect(DecodePlatformConfig(`
+location: "centralus"
+resourcegroup: "talos"
+instances:
+  type: "Standard_D2_v3"
+  disks:
+    size: 10
+`, config)).To(gomega.Succeed())
+	g.Expect(config.ResourceGroup).To(gomega.Equal("talos"))
+	g.Expect(config.Instances.Disks.Size).To(gomega.Equal(10))
+
+	// A typo for "instances" must not silently produce an empty instance spec
+	err := DecodePlatformConfig(`
+region: "us-west-2"
+instance:
+  ami: "ami-123"
+`, &talosv1.AWSMachineConfig{})
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring("instance"))
+}
+
+func TestMachineRole(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := func(name, spec string) *clusterv1.Machine {
+		m := &clusterv1.Machine{Spec: clusterv1.MachineSpec{ProviderSpec: clusterv1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(spec)}}}}
+		m.ObjectMeta.Name = name
+		return m
+	}
+
+	for _, tc := range []struct {
+		name  string
+		spec  string
+		role  talosv1.MachineRole
+		index int
+	}{
+		{name: "masterclass-workers-x7f2k", spec: `{"role":"worker"}`, role: talosv1.MachineRoleWorker},
+		{name: "cp-0", spec: `{"role":"init"}`, role: talosv1.MachineRoleInit},
+		{name: "cp-1", spec: `{"role":"controlplane","controlPlaneIndex":2}`, role: talosv1.MachineRoleControlPlane, index: 2},
+		// Deprecated name-based detection
+		{name: "talos-test-cluster-workers-x7f2k", spec: `{}`, role: talosv1.MachineRoleWorker},
+		{name: "talos-test-cluster-master-0", spec: `{}`, role: talosv1.MachineRoleInit},
+		{name: "talos-test-cluster-master-2", spec: `{}`, role: talosv1.MachineRoleControlPlane, index: 2},
+	} {
+		role, index, err := MachineRole(machine(tc.name, tc.spec))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(role).To(gomega.Equal(tc.role), tc.name)
+		g.Expect(index).To(gomega.Equal(tc.index), tc.name)
+	}
+
+	_, _, err := MachineRole(machine("cp-1", `{"role":"controlplane"}`))
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, _, err = MachineRole(machine("cp", `{}`))
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestUpdateMachineProviderStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := &clusterv1.Machine{}
+	g.Expect(UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceID = "i-123"
+		status.ConfigVersion = "42"
+	})).To(gomega.Succeed())
+
+	g.Expect(UpdateMachineProviderStatus(machine, func(status *talosv1.TalosMachineProviderStatusStatus) {
+		status.InstanceState = "running"
+	})).To(gomega.Succeed())
+
+	status, err := MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Kind).To(gomega.Equal("TalosMachineProviderStatus"))
+	g.Expect(status.Status).To(gomega.Equal(talosv1.TalosMachineProviderStatusStatus{
+		InstanceID:    "i-123",
+		InstanceState: "running",
+		ConfigVersion: "42",
+	}))
+}
+
+func TestUpdateClusterProviderStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{}
+	g.Expect(UpdateClusterProviderStatus(cluster, func(status *talosv1.TalosClusterProviderStatusStatus) {
+		status.ControlPlaneIPs = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+		status.Ready = true
+	})).To(gomega.Succeed())
+
+	status, err := ClusterStatusFromProviderStatus(cluster.Status.ProviderStatus)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status.Kind).To(gomega.Equal("TalosClusterProviderStatus"))
+	g.Expect(status.Status.ControlPlaneIPs).To(gomega.Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+	g.Expect(status.Status.Ready).To(gomega.BeTrue())
+}
+
+func TestParseProviderID(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	machine := &clusterv1.Machine{}
+	id, err := ParseProviderID(machine, "aws")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(id).To(gomega.BeEmpty())
+
+	providerID := ProviderID("aws", "/us-west-2a/i-123")
+	g.Expect(providerID).To(gomega.Equal("aws:///us-west-2a/i-123"))
+
+	machine.Spec.ProviderID = &providerID
+	id, err = ParseProviderID(machine, "aws")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(id).To(gomega.Equal("/us-west-2a/i-123"))
+
+	_, err = ParseProviderID(machine, "gce")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestClusterResourceName(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	prod := &clusterv1.Cluster{}
+	prod.ObjectMeta.Name = "prod"
+	prod.ObjectMeta.Namespace = "tenant-a"
+
+	other := prod.DeepCopy()
+	other.ObjectMeta.Namespace = "tenant-b"
+
+	g.Expect(ClusterResourceName(prod)).To(gomega.MatchRegexp(`^prod-[0-9a-f]{8}$`))
+	g.Expect(ClusterResourceName(prod)).To(gomega.Equal(ClusterResourceName(prod.DeepCopy())))
+	g.Expect(ClusterResourceName(prod)).NotTo(gomega.Equal(ClusterResourceName(other)))
+
+	g.Expect(ControlPlaneIPName(prod, 1)).To(gomega.Equal(ClusterResourceName(prod) + "-master-1-ip"))
+	g.Expect(LegacyControlPlaneIPName(prod, 1)).To(gomega.Equal("prod-master-1-ip"))
+
+	machine := &clusterv1.Machine{}
+	machine.ObjectMeta.Name = "prod-master-1"
+	g.Expect(MachineResourceName(prod, machine)).To(gomega.Equal("prod-master-1-" + strings.TrimPrefix(ClusterResourceName(prod), "prod-")))
+	g.Expect(MachineResourceName(prod, machine)).NotTo(gomega.Equal(MachineResourceName(other, machine)))
+}
+
+func TestOwnsLegacyControlPlaneIP(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{}
+	owned, err := OwnsLegacyControlPlaneIP(cluster, "203.0.113.1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(owned).To(gomega.BeFalse())
+
+	g.Expect(UpdateClusterProviderStatus(cluster, func(status *talosv1.TalosClusterProviderStatusStatus) {
+		status.ControlPlaneIPs = []string{"203.0.113.1"}
+	})).To(gomega.Succeed())
+	owned, err = OwnsLegacyControlPlaneIP(cluster, "203.0.113.1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(owned).To(gomega.BeTrue())
+	owned, err = OwnsLegacyControlPlaneIP(cluster, "203.0.113.2")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(owned).To(gomega.BeFalse())
+
+	//Clusters of earlier versions own the IPs named after them before recording any
+	cluster = &clusterv1.Cluster{}
+	g.Expect(UpdateClusterProviderStatus(cluster, func(status *talosv1.TalosClusterProviderStatusStatus) {
+		status.LegacyResourceNames = true
+	})).To(gomega.Succeed())
+	owned, err = OwnsLegacyControlPlaneIP(cluster, "203.0.113.2")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(owned).To(gomega.BeTrue())
+}
+
+func TestCredentialsNamespace(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a"}}
+
+	namespace, err := CredentialsNamespace(cluster, &v1.SecretReference{Name: "creds"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(namespace).To(gomega.Equal("tenant-a"))
+
+	namespace, err = CredentialsNamespace(cluster, &v1.SecretReference{Name: "creds", Namespace: "tenant-a"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(namespace).To(gomega.Equal("tenant-a"))
+
+	_, err = CredentialsNamespace(cluster, &v1.SecretReference{Name: "creds", Namespace: "tenant-b"})
+	g.Expect(err).To(gomega.HaveOccurred())
+}
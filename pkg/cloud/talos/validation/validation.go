@@ -0,0 +1,509 @@
+package validation
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// k8sVersionRegexp matches the versions accepted by the Talos config generator, e.g. 1.16.0 or v1.16.0-rc.1
+var k8sVersionRegexp = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?$`)
+
+// unauthenticatedPlatforms are the platforms whose connections the provider doesn't authenticate, so a credentials secret would be ignored.
+// Redfish hosts take their BMC credentials from a secret each instead.
+var unauthenticatedPlatforms = map[string]bool{"docker": true, "libvirt": true, "redfish": true}
+
+// platformSections are the typed platform sections of the provider specs
+var platformSections = []string{"aws", "azure", "digitalocean", "docker", "gce", "hcloud", "libvirt", "openstack", "packet", "redfish", "vsphere"}
+
+// ValidateClusterSpec validates a Talos cluster provider spec of a cluster in the given namespace
+func ValidateClusterSpec(spec *talosv1.TalosClusterProviderSpec, namespace string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	cpPath := fldPath.Child("controlplane")
+	if count := spec.ControlPlane.Count; count <= 0 || count%2 == 0 {
+		allErrs = append(allErrs, field.Invalid(cpPath.Child("count"), count, "must be a positive, odd number"))
+	}
+	if version := spec.ControlPlane.K8sVersion; version != "" && !k8sVersionRegexp.MatchString(version) {
+		allErrs = append(allErrs, field.Invalid(cpPath.Child("k8sversion"), version, "must be a version of the form 1.16.0"))
+	}
+
+	platform := spec.Platform
+	platformPath := fldPath.Child("platform")
+	allErrs = append(allErrs, validatePlatformType(platform.Type, platformPath)...)
+	allErrs = append(allErrs, validatePlatformSections(platform.Type, map[string]bool{
+		"aws":          platform.AWS != nil,
+		"azure":        platform.Azure != nil,
+		"digitalocean": platform.DigitalOcean != nil,
+		"docker":       platform.Docker != nil,
+		"gce":          platform.GCE != nil,
+		"hcloud":       platform.Hcloud != nil,
+		"libvirt":      platform.Libvirt != nil,
+		"openstack":    platform.OpenStack != nil,
+		"packet":       platform.Packet != nil,
+		"redfish":      platform.Redfish != nil,
+		"vsphere":      platform.VSphere != nil,
+	}, platformPath)...)
+	if ref := platform.CredentialsSecretRef; ref != nil {
+		if unauthenticatedPlatforms[platform.Type] {
+			allErrs = append(allErrs, field.Forbidden(platformPath.Child("credentialsSecretRef"), fmt.Sprintf("the %s platform does not authenticate its connections", platform.Type)))
+		} else {
+			allErrs = append(allErrs, validateSecretRef(ref, namespace, platformPath.Child("credentialsSecretRef"))...)
+		}
+	}
+	if len(allErrs) != 0 {
+		return allErrs
+	}
+
+	configPath := platformPath.Child(platform.Type)
+	switch platform.Type {
+	case "aws":
+		config := platform.AWS
+		if config == nil {
+			config = &talosv1.AWSClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("region"), config.Region)...)
+	case "azure":
+		config := platform.Azure
+		if config == nil {
+			config = &talosv1.AzureClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("location"), config.Location)...)
+		allErrs = append(allErrs, required(configPath.Child("resourceGroup"), config.ResourceGroup)...)
+	case "digitalocean":
+		config := platform.DigitalOcean
+		if config == nil {
+			config = &talosv1.DigitalOceanClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("region"), config.Region)...)
+	case "docker":
+		config := platform.Docker
+		if config == nil {
+			config = &talosv1.DockerClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("network"), config.Network)...)
+	case "gce":
+		config := platform.GCE
+		if config == nil {
+			config = &talosv1.GCEClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("region"), config.Region)...)
+		allErrs = append(allErrs, required(configPath.Child("project"), config.Project)...)
+	case "hcloud":
+		config := platform.Hcloud
+		if config == nil {
+			config = &talosv1.HcloudClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("location"), config.Location)...)
+	case "libvirt":
+		config := platform.Libvirt
+		if config == nil {
+			config = &talosv1.LibvirtClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, validateLibvirtURI(configPath.Child("uri"), config.URI)...)
+		allErrs = append(allErrs, required(configPath.Child("network"), config.Network)...)
+	case "openstack":
+		config := platform.OpenStack
+		if config == nil {
+			config = &talosv1.OpenStackClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("floatingIPNetwork"), config.FloatingIPNetwork)...)
+	case "packet":
+		config := platform.Packet
+		if config == nil {
+			config = &talosv1.PacketClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("projectID"), config.ProjectID)...)
+		allErrs = append(allErrs, required(configPath.Child("ipBlock"), config.IPBlock)...)
+	case "redfish":
+		config := platform.Redfish
+		if config == nil {
+			config = &talosv1.RedfishClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, validateRedfishHosts(config, spec.ControlPlane.Count, namespace, configPath)...)
+	case "vsphere":
+		config := platform.VSphere
+		if config == nil {
+			config = &talosv1.VSphereClusterConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("server"), config.Server)...)
+		allErrs = append(allErrs, required(configPath.Child("datacenter"), config.Datacenter)...)
+		allErrs = append(allErrs, validateControlPlaneIPs(config, spec.ControlPlane.Count, configPath)...)
+	}
+
+	return allErrs
+}
+
+// ValidateMachineSpec validates a Talos machine provider spec.
+// The location settings inherited from the cluster are validated by ValidateMachineLocation, on admission if the cluster exists and again before the machine is provisioned.
+func ValidateMachineSpec(spec *talosv1.TalosMachineProviderSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	indexPath := fldPath.Child("controlPlaneIndex")
+	switch spec.Role {
+	case "":
+	case talosv1.MachineRoleControlPlane:
+		if spec.ControlPlaneIndex < 1 {
+			allErrs = append(allErrs, field.Invalid(indexPath, spec.ControlPlaneIndex, "must be at least 1 for controlplane machines"))
+		}
+	case talosv1.MachineRoleInit, talosv1.MachineRoleWorker:
+		if spec.ControlPlaneIndex != 0 {
+			allErrs = append(allErrs, field.Forbidden(indexPath, fmt.Sprintf("may not be set for %s machines", spec.Role)))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("role"), spec.Role, []string{
+			string(talosv1.MachineRoleInit),
+			string(talosv1.MachineRoleControlPlane),
+			string(talosv1.MachineRoleWorker),
+		}))
+	}
+
+	platform := spec.Platform
+	platformPath := fldPath.Child("platform")
+	allErrs = append(allErrs, validatePlatformType(platform.Type, platformPath)...)
+	allErrs = append(allErrs, validatePlatformSections(platform.Type, map[string]bool{
+		"aws":          platform.AWS != nil,
+		"azure":        platform.Azure != nil,
+		"digitalocean": platform.DigitalOcean != nil,
+		"docker":       platform.Docker != nil,
+		"gce":          platform.GCE != nil,
+		"hcloud":       platform.Hcloud != nil,
+		"libvirt":      platform.Libvirt != nil,
+		"openstack":    platform.OpenStack != nil,
+		"packet":       platform.Packet != nil,
+		"redfish":      platform.Redfish != nil,
+		"vsphere":      platform.VSphere != nil,
+	}, platformPath)...)
+	if len(allErrs) != 0 {
+		return allErrs
+	}
+
+	configPath := platformPath.Child(platform.Type)
+	instancesPath := configPath.Child("instances")
+	switch platform.Type {
+	case "aws":
+		config := platform.AWS
+		if config == nil {
+			config = &talosv1.AWSMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("ami"), config.Instances.AMI)...)
+	case "azure":
+		config := platform.Azure
+		if config == nil {
+			config = &talosv1.AzureMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("image"), config.Instances.Image)...)
+	case "digitalocean":
+		config := platform.DigitalOcean
+		if config == nil {
+			config = &talosv1.DigitalOceanMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("size"), config.Instances.Size)...)
+		allErrs = append(allErrs, required(instancesPath.Child("image"), config.Instances.Image)...)
+	case "docker":
+		config := platform.Docker
+		if config == nil {
+			config = &talosv1.DockerMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("image"), config.Instances.Image)...)
+		if cpus := config.Instances.CPUs; cpus != "" {
+			if n, err := strconv.ParseFloat(cpus, 64); err != nil || n <= 0 {
+				allErrs = append(allErrs, field.Invalid(instancesPath.Child("cpus"), cpus, "must be a positive number, e.g. 1.5"))
+			}
+		}
+	case "gce":
+		config := platform.GCE
+		if config == nil {
+			config = &talosv1.GCEMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("zone"), config.Zone)...)
+		allErrs = append(allErrs, required(instancesPath.Child("image"), config.Instances.Image)...)
+	case "hcloud":
+		config := platform.Hcloud
+		if config == nil {
+			config = &talosv1.HcloudMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("type"), config.Instances.Type)...)
+		allErrs = append(allErrs, required(instancesPath.Child("image"), config.Instances.Image)...)
+	case "libvirt":
+		config := platform.Libvirt
+		if config == nil {
+			config = &talosv1.LibvirtMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, validateLibvirtURI(configPath.Child("uri"), config.URI)...)
+		allErrs = append(allErrs, required(configPath.Child("network"), config.Network)...)
+		allErrs = append(allErrs, required(configPath.Child("pool"), config.Pool)...)
+		allErrs = append(allErrs, required(instancesPath.Child("image"), config.Instances.Image)...)
+	case "openstack":
+		config := platform.OpenStack
+		if config == nil {
+			config = &talosv1.OpenStackMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("flavor"), config.Instances.Flavor)...)
+		allErrs = append(allErrs, required(instancesPath.Child("image"), config.Instances.Image)...)
+	case "packet":
+		config := platform.Packet
+		if config == nil {
+			config = &talosv1.PacketMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("plan"), config.Instances.Plan)...)
+		allErrs = append(allErrs, required(instancesPath.Child("facility"), config.Instances.Facility)...)
+		allErrs = append(allErrs, required(instancesPath.Child("pxeURL"), config.Instances.PXEURL)...)
+	case "redfish":
+		config := platform.Redfish
+		if config == nil {
+			config = &talosv1.RedfishMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		switch config.Boot {
+		case "pxe":
+		case "virtualMedia":
+			allErrs = append(allErrs, required(configPath.Child("imageURL"), config.ImageURL)...)
+		default:
+			allErrs = append(allErrs, field.NotSupported(configPath.Child("boot"), config.Boot, []string{"pxe", "virtualMedia"}))
+		}
+	case "vsphere":
+		config := platform.VSphere
+		if config == nil {
+			config = &talosv1.VSphereMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(instancesPath.Child("template"), config.Instances.Template)...)
+	}
+
+	return allErrs
+}
+
+// ValidateMachineLocation validates the location settings of a Talos machine provider spec, e.g. its region.
+// Machines may leave them out to inherit them from the spec of their cluster, so they are only required once the cluster is known.
+func ValidateMachineLocation(spec *talosv1.TalosMachineProviderSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	platform := spec.Platform
+	platformPath := fldPath.Child("platform")
+	configPath := platformPath.Child(platform.Type)
+	switch platform.Type {
+	case "aws":
+		config := platform.AWS
+		if config == nil {
+			config = &talosv1.AWSMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("region"), config.Region)...)
+	case "azure":
+		config := platform.Azure
+		if config == nil {
+			config = &talosv1.AzureMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("location"), config.Location)...)
+		allErrs = append(allErrs, required(configPath.Child("resourceGroup"), config.ResourceGroup)...)
+	case "digitalocean":
+		config := platform.DigitalOcean
+		if config == nil {
+			config = &talosv1.DigitalOceanMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("region"), config.Region)...)
+	case "docker":
+		config := platform.Docker
+		if config == nil {
+			config = &talosv1.DockerMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("network"), config.Network)...)
+	case "gce":
+		config := platform.GCE
+		if config == nil {
+			config = &talosv1.GCEMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("project"), config.Project)...)
+	case "hcloud":
+		config := platform.Hcloud
+		if config == nil {
+			config = &talosv1.HcloudMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("location"), config.Location)...)
+	case "packet":
+		config := platform.Packet
+		if config == nil {
+			config = &talosv1.PacketMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("projectID"), config.ProjectID)...)
+	case "vsphere":
+		config := platform.VSphere
+		if config == nil {
+			config = &talosv1.VSphereMachineConfig{}
+			allErrs = append(allErrs, decodeConfig(platform.Config, config, platformPath)...)
+		}
+		allErrs = append(allErrs, required(configPath.Child("server"), config.Server)...)
+		allErrs = append(allErrs, required(configPath.Child("datacenter"), config.Datacenter)...)
+	}
+
+	return allErrs
+}
+
+// ValidatePlatformTypeUpdate rejects changes to the platform type of an existing object
+func ValidatePlatformTypeUpdate(newType, oldType string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if newType != oldType {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("platform", "type"), newType, fmt.Sprintf("field is immutable, was %q", oldType)))
+	}
+	return allErrs
+}
+
+// validatePlatformType checks that the platform type is registered with the manager
+func validatePlatformType(platformType string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	typePath := fldPath.Child("type")
+
+	if platformType == "" {
+		return append(allErrs, field.Required(typePath, ""))
+	}
+
+	platforms := provisioners.Platforms()
+	for _, name := range platforms {
+		if name == platformType {
+			return allErrs
+		}
+	}
+	return append(allErrs, field.NotSupported(typePath, platformType, platforms))
+}
+
+// validatePlatformSections checks that only the typed section of the selected platform is set
+func validatePlatformSections(platformType string, set map[string]bool, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, name := range platformSections {
+		if set[name] && name != platformType {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child(name), fmt.Sprintf("may not be set when platform type is %q", platformType)))
+		}
+	}
+	return allErrs
+}
+
+// validateControlPlaneIPs checks that there is one static address per control plane node, and that the VIP and gateway are IPs
+func validateControlPlaneIPs(config *talosv1.VSphereClusterConfig, count int, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	ipsPath := fldPath.Child("controlPlaneIPs")
+	if len(config.ControlPlaneIPs) < count {
+		allErrs = append(allErrs, field.Invalid(ipsPath, config.ControlPlaneIPs, fmt.Sprintf("must list at least %d addresses, one per control plane node", count)))
+	}
+	for i, cidr := range config.ControlPlaneIPs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			allErrs = append(allErrs, field.Invalid(ipsPath.Index(i), cidr, "must be an address in CIDR form, e.g. 192.168.1.10/24"))
+		}
+	}
+	if config.Gateway != "" && net.ParseIP(config.Gateway) == nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("gateway"), config.Gateway, "must be an IP address"))
+	}
+	if config.VIP != "" && net.ParseIP(config.VIP) == nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("vip"), config.VIP, "must be an IP address"))
+	}
+	return allErrs
+}
+
+// validateLibvirtURI checks that the libvirt daemon is addressed by a unix socket or a TCP address
+func validateLibvirtURI(fldPath *field.Path, uri string) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if uri == "" {
+		return append(allErrs, field.Required(fldPath, ""))
+	}
+	if u, err := url.Parse(uri); err != nil || (u.Scheme != "unix" && u.Scheme != "tcp") {
+		allErrs = append(allErrs, field.Invalid(fldPath, uri, "must be a unix:// or tcp:// URI"))
+	}
+	return allErrs
+}
+
+// validateRedfishHosts checks that the hosts of the pool are unique and reachable with credentials of the namespace of the cluster,
+// and that there is one with an address per control plane node
+func validateRedfishHosts(config *talosv1.RedfishClusterConfig, count int, namespace string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	hostsPath := fldPath.Child("hosts")
+	if len(config.Hosts) < count {
+		allErrs = append(allErrs, field.Invalid(hostsPath, len(config.Hosts), fmt.Sprintf("must list at least %d hosts, one per control plane node", count)))
+	}
+	names := map[string]bool{}
+	for i, host := range config.Hosts {
+		hostPath := hostsPath.Index(i)
+		if host.Name == "" {
+			allErrs = append(allErrs, field.Required(hostPath.Child("name"), ""))
+		} else if names[host.Name] {
+			allErrs = append(allErrs, field.Duplicate(hostPath.Child("name"), host.Name))
+		}
+		names[host.Name] = true
+
+		if u, err := url.Parse(host.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(hostPath.Child("endpoint"), host.Endpoint, "must be an http:// or https:// URL"))
+		}
+		if host.CredentialsSecretRef == nil {
+			allErrs = append(allErrs, field.Required(hostPath.Child("credentialsSecretRef"), ""))
+		} else {
+			allErrs = append(allErrs, validateSecretRef(host.CredentialsSecretRef, namespace, hostPath.Child("credentialsSecretRef"))...)
+		}
+
+		if host.Address != "" && net.ParseIP(host.Address) == nil {
+			allErrs = append(allErrs, field.Invalid(hostPath.Child("address"), host.Address, "must be an IP address"))
+		} else if host.Address == "" && i < count {
+			allErrs = append(allErrs, field.Required(hostPath.Child("address"), "control plane hosts need an address"))
+		}
+	}
+	return allErrs
+}
+
+func decodeConfig(config string, out interface{}, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if err := utils.DecodePlatformConfig(config, out); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("config"), config, err.Error()))
+	}
+	return allErrs
+}
+
+// validateSecretRef checks that a secret reference names a secret in the namespace of the cluster, the only namespace secrets are read from
+func validateSecretRef(ref *corev1.SecretReference, namespace string, fldPath *field.Path) field.ErrorList {
+	allErrs := required(fldPath.Child("name"), ref.Name)
+	if ref.Namespace != "" && ref.Namespace != namespace {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("namespace"), ref.Namespace, "must be empty or the namespace of the cluster"))
+	}
+	return allErrs
+}
+
+func required(fldPath *field.Path, value string) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if value == "" {
+		allErrs = append(allErrs, field.Required(fldPath, ""))
+	}
+	return allErrs
+}
@@ -0,0 +1,328 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func init() {
+	for _, name := range []string{"aws", "digitalocean", "docker", "gce", "hcloud", "libvirt", "openstack", "redfish", "vsphere"} {
+		provisioners.Register(name, func() (provisioners.Provisioner, error) { return nil, nil })
+	}
+}
+
+func TestValidateClusterSpec(t *testing.T) {
+	fldPath := field.NewPath("spec", "providerSpec", "value")
+
+	for _, tc := range []struct {
+		name   string
+		spec   talosv1.TalosClusterProviderSpec
+		fields []string
+	}{
+		{
+			name: "valid",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3, K8sVersion: "1.16.0"},
+				Platform:     talosv1.TalosClusterPlatformSpec{Type: "aws", AWS: &talosv1.AWSClusterConfig{Region: "us-west-2"}},
+			},
+		},
+		{
+			name: "free-form config",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1},
+				Platform:     talosv1.TalosClusterPlatformSpec{Type: "gce", Config: "region: us-central1\nproject: talos"},
+			},
+		},
+		{
+			name: "even control plane",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 2, K8sVersion: "v1.16"},
+				Platform:     talosv1.TalosClusterPlatformSpec{Type: "aws", AWS: &talosv1.AWSClusterConfig{Region: "us-west-2"}},
+			},
+			fields: []string{"spec.providerSpec.value.controlplane.count", "spec.providerSpec.value.controlplane.k8sversion"},
+		},
+		{
+			name: "unknown platform",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+				Platform:     talosv1.TalosClusterPlatformSpec{Type: "ovirt"},
+			},
+			fields: []string{"spec.providerSpec.value.platform.type"},
+		},
+		{
+			name: "missing project",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+				Platform:     talosv1.TalosClusterPlatformSpec{Type: "gce", GCE: &talosv1.GCEClusterConfig{Region: "us-central1"}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.gce.project"},
+		},
+		{
+			name: "section of another platform",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+				Platform:     talosv1.TalosClusterPlatformSpec{Type: "aws", GCE: &talosv1.GCEClusterConfig{Region: "us-central1"}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.gce"},
+		},
+		{
+			name: "too few vsphere control plane IPs",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+				Platform: talosv1.TalosClusterPlatformSpec{Type: "vsphere", VSphere: &talosv1.VSphereClusterConfig{
+					Server:          "vcenter.example.com",
+					Datacenter:      "dc1",
+					ControlPlaneIPs: []string{"192.168.1.10/24", "192.168.1.11"},
+					VIP:             "vip.example.com",
+				}},
+			},
+			fields: []string{
+				"spec.providerSpec.value.platform.vsphere.controlPlaneIPs",
+				"spec.providerSpec.value.platform.vsphere.controlPlaneIPs[1]",
+				"spec.providerSpec.value.platform.vsphere.vip",
+			},
+		},
+		{
+			name: "libvirt connection URI",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1},
+				Platform:     talosv1.TalosClusterPlatformSpec{Type: "libvirt", Libvirt: &talosv1.LibvirtClusterConfig{URI: "qemu:///system"}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.libvirt.uri", "spec.providerSpec.value.platform.libvirt.network"},
+		},
+		{
+			name: "redfish host pool",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1},
+				Platform: talosv1.TalosClusterPlatformSpec{Type: "redfish", Redfish: &talosv1.RedfishClusterConfig{Hosts: []talosv1.RedfishHost{
+					{Name: "node-1", Endpoint: "10.0.0.10", CredentialsSecretRef: &corev1.SecretReference{Name: "bmc"}},
+					{Name: "node-1", Endpoint: "https://10.0.0.11", Address: "10.0.1.11"},
+					{Name: "node-2", Endpoint: "https://10.0.0.12", CredentialsSecretRef: &corev1.SecretReference{Name: "bmc", Namespace: "kube-system"}},
+				}}},
+			},
+			fields: []string{
+				"spec.providerSpec.value.platform.redfish.hosts[0].endpoint",
+				"spec.providerSpec.value.platform.redfish.hosts[0].address",
+				"spec.providerSpec.value.platform.redfish.hosts[1].name",
+				"spec.providerSpec.value.platform.redfish.hosts[1].credentialsSecretRef",
+				"spec.providerSpec.value.platform.redfish.hosts[2].credentialsSecretRef.namespace",
+			},
+		},
+		{
+			name: "unnamed credentials secret",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+				Platform: talosv1.TalosClusterPlatformSpec{
+					Type:                 "aws",
+					CredentialsSecretRef: &corev1.SecretReference{Namespace: "default"},
+					AWS:                  &talosv1.AWSClusterConfig{Region: "us-west-2"},
+				},
+			},
+			fields: []string{"spec.providerSpec.value.platform.credentialsSecretRef.name"},
+		},
+		{
+			name: "credentials secret of another namespace",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 3},
+				Platform: talosv1.TalosClusterPlatformSpec{
+					Type:                 "aws",
+					CredentialsSecretRef: &corev1.SecretReference{Name: "aws", Namespace: "kube-system"},
+					AWS:                  &talosv1.AWSClusterConfig{Region: "us-west-2"},
+				},
+			},
+			fields: []string{"spec.providerSpec.value.platform.credentialsSecretRef.namespace"},
+		},
+		{
+			name: "credentials secret of an unauthenticated platform",
+			spec: talosv1.TalosClusterProviderSpec{
+				ControlPlane: talosv1.TalosClusterControlPlaneSpec{Count: 1},
+				Platform: talosv1.TalosClusterPlatformSpec{
+					Type:                 "docker",
+					CredentialsSecretRef: &corev1.SecretReference{Name: "docker"},
+					Docker:               &talosv1.DockerClusterConfig{},
+				},
+			},
+			fields: []string{"spec.providerSpec.value.platform.credentialsSecretRef"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			g.Expect(fields(ValidateClusterSpec(&tc.spec, "default", fldPath))).To(gomega.ConsistOf(tc.fields))
+		})
+	}
+}
+
+func TestValidateMachineSpec(t *testing.T) {
+	fldPath := field.NewPath("spec", "providerSpec", "value")
+
+	for _, tc := range []struct {
+		name   string
+		spec   talosv1.TalosMachineProviderSpec
+		fields []string
+	}{
+		{
+			name: "valid",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "aws", AWS: &talosv1.AWSMachineConfig{
+					Region:    "us-west-2",
+					Instances: talosv1.AWSInstanceSpec{AMI: "ami-123"},
+				}},
+			},
+		},
+		{
+			name: "missing zone and image",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "gce", GCE: &talosv1.GCEMachineConfig{Project: "talos"}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.gce.zone", "spec.providerSpec.value.platform.gce.instances.image"},
+		},
+		{
+			name: "missing flavor",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "openstack", OpenStack: &talosv1.OpenStackMachineConfig{
+					Instances: talosv1.OpenStackInstanceSpec{Image: "talos", Networks: []string{"private"}},
+				}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.openstack.instances.flavor"},
+		},
+		{
+			name: "invalid docker cpu share",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "docker", Docker: &talosv1.DockerMachineConfig{
+					Network:   "talos",
+					Instances: talosv1.DockerInstanceSpec{Image: "docker.io/autonomy/talos:v0.3.0-alpha.0", CPUs: "1.5 cores"},
+				}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.docker.instances.cpus"},
+		},
+		{
+			name: "digitalocean without image",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "digitalocean", DigitalOcean: &talosv1.DigitalOceanMachineConfig{
+					Region:    "nyc3",
+					Instances: talosv1.DigitalOceanInstanceSpec{Size: "s-2vcpu-4gb"},
+				}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.digitalocean.instances.image"},
+		},
+		{
+			name: "hcloud inheriting its location",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "hcloud", Hcloud: &talosv1.HcloudMachineConfig{
+					Instances: talosv1.HcloudInstanceSpec{Type: "cx21", Image: "talos"},
+				}},
+			},
+		},
+		{
+			name: "redfish virtual media without image",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "redfish", Redfish: &talosv1.RedfishMachineConfig{Boot: "virtualMedia"}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.redfish.imageURL"},
+		},
+		{
+			name: "malformed config",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "aws", Config: "regoin: us-west-2"},
+			},
+			fields: []string{"spec.providerSpec.value.platform.config", "spec.providerSpec.value.platform.aws.instances.ami"},
+		},
+		{
+			name:   "missing platform",
+			fields: []string{"spec.providerSpec.value.platform.type"},
+		},
+		{
+			name: "controlplane without index",
+			spec: talosv1.TalosMachineProviderSpec{
+				Role: talosv1.MachineRoleControlPlane,
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "aws", AWS: &talosv1.AWSMachineConfig{
+					Region:    "us-west-2",
+					Instances: talosv1.AWSInstanceSpec{AMI: "ami-123"},
+				}},
+			},
+			fields: []string{"spec.providerSpec.value.controlPlaneIndex"},
+		},
+		{
+			name: "unknown role",
+			spec: talosv1.TalosMachineProviderSpec{
+				Role: "master",
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "aws", AWS: &talosv1.AWSMachineConfig{
+					Region:    "us-west-2",
+					Instances: talosv1.AWSInstanceSpec{AMI: "ami-123"},
+				}},
+			},
+			fields: []string{"spec.providerSpec.value.role"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			g.Expect(fields(ValidateMachineSpec(&tc.spec, fldPath))).To(gomega.ConsistOf(tc.fields))
+		})
+	}
+}
+
+func TestValidateMachineLocation(t *testing.T) {
+	fldPath := field.NewPath("spec", "providerSpec", "value")
+
+	for _, tc := range []struct {
+		name   string
+		spec   talosv1.TalosMachineProviderSpec
+		fields []string
+	}{
+		{
+			name: "valid",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "aws", AWS: &talosv1.AWSMachineConfig{Region: "us-west-2"}},
+			},
+		},
+		{
+			name: "hcloud without location",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "hcloud", Hcloud: &talosv1.HcloudMachineConfig{
+					Instances: talosv1.HcloudInstanceSpec{Type: "cx21", Image: "talos"},
+				}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.hcloud.location"},
+		},
+		{
+			name: "vsphere without server and datacenter",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "vsphere", VSphere: &talosv1.VSphereMachineConfig{}},
+			},
+			fields: []string{"spec.providerSpec.value.platform.vsphere.server", "spec.providerSpec.value.platform.vsphere.datacenter"},
+		},
+		{
+			name: "malformed config",
+			spec: talosv1.TalosMachineProviderSpec{
+				Platform: talosv1.TalosMachinePlatformSpec{Type: "aws", Config: "regoin: us-west-2"},
+			},
+			fields: []string{"spec.providerSpec.value.platform.config", "spec.providerSpec.value.platform.aws.region"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			g.Expect(fields(ValidateMachineLocation(&tc.spec, fldPath))).To(gomega.ConsistOf(tc.fields))
+		})
+	}
+}
+
+func TestValidatePlatformTypeUpdate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	fldPath := field.NewPath("spec", "providerSpec", "value")
+
+	g.Expect(ValidatePlatformTypeUpdate("aws", "aws", fldPath)).To(gomega.BeEmpty())
+	g.Expect(fields(ValidatePlatformTypeUpdate("gce", "aws", fldPath))).To(gomega.ConsistOf("spec.providerSpec.value.platform.type"))
+}
+
+func fields(errs field.ErrorList) []string {
+	fields := []string{}
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutating
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/defaults"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"k8s.io/apimachinery/pkg/api/equality"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// clusterProviderSpecKind is the kind of the provider specs handled by this webhook
+const clusterProviderSpecKind = "TalosClusterProviderSpec"
+
+func init() {
+	webhookName := "mutating-create-update-cluster"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &ClusterCreateUpdateHandler{})
+}
+
+// ClusterCreateUpdateHandler fills in defaults for the Talos provider spec of Clusters
+type ClusterCreateUpdateHandler struct {
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+func (h *ClusterCreateUpdateHandler) mutatingClusterFn(ctx context.Context, obj *clusterv1.Cluster) error {
+	if utils.ProviderSpecKind(obj.Spec.ProviderSpec) != clusterProviderSpecKind {
+		return nil
+	}
+
+	spec, err := utils.ClusterProviderFromSpec(obj.Spec.ProviderSpec)
+	if err != nil {
+		// Leave it to the validating webhook to reject the spec
+		return nil
+	}
+
+	defaulted := spec.DeepCopy()
+	defaults.SetClusterSpecDefaults(defaulted)
+	if equality.Semantic.DeepEqual(spec, defaulted) {
+		return nil
+	}
+
+	obj.Spec.ProviderSpec.Value, err = utils.EncodeProviderObject(defaulted)
+	return err
+}
+
+var _ admission.Handler = &ClusterCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *ClusterCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	obj := &clusterv1.Cluster{}
+
+	err := h.Decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	copy := obj.DeepCopy()
+
+	err = h.mutatingClusterFn(ctx, copy)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponse(obj, copy)
+}
+
+var _ inject.Decoder = &ClusterCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the ClusterCreateUpdateHandler
+func (h *ClusterCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"net/http"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/validation"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// clusterProviderSpecKind is the kind of the provider specs handled by this webhook
+const clusterProviderSpecKind = "TalosClusterProviderSpec"
+
+func init() {
+	webhookName := "validating-create-update-cluster"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &ClusterCreateUpdateHandler{})
+}
+
+// ClusterCreateUpdateHandler validates the Talos provider spec of Clusters
+type ClusterCreateUpdateHandler struct {
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+// validatingClusterFn validates the Talos provider spec of a cluster, and that its platform type is unchanged on updates.
+// Updates of clusters being deleted, or leaving the provider spec as it is, are only checked for the platform type,
+// so that specs admitted by earlier versions don't keep finalizers from being removed or status from being updated.
+func (h *ClusterCreateUpdateHandler) validatingClusterFn(ctx context.Context, obj, old *clusterv1.Cluster) (bool, string, error) {
+	if utils.ProviderSpecKind(obj.Spec.ProviderSpec) != clusterProviderSpecKind {
+		return true, "not a talos cluster", nil
+	}
+
+	fldPath := field.NewPath("spec", "providerSpec", "value")
+	spec, err := utils.ClusterProviderFromSpec(obj.Spec.ProviderSpec)
+
+	if old != nil && (obj.ObjectMeta.DeletionTimestamp != nil || equality.Semantic.DeepEqual(obj.Spec.ProviderSpec, old.Spec.ProviderSpec)) {
+		if err != nil {
+			return true, "allowed to be admitted", nil
+		}
+		if allErrs := validatePlatformTypeUpdate(spec, old, fldPath); len(allErrs) != 0 {
+			return false, allErrs.ToAggregate().Error(), nil
+		}
+		return true, "allowed to be admitted", nil
+	}
+
+	if err != nil {
+		return false, field.Invalid(fldPath, string(obj.Spec.ProviderSpec.Value.Raw), err.Error()).Error(), nil
+	}
+
+	allErrs := validation.ValidateClusterSpec(spec, obj.ObjectMeta.Namespace, fldPath)
+	allErrs = append(allErrs, validatePlatformTypeUpdate(spec, old, fldPath)...)
+
+	if len(allErrs) != 0 {
+		return false, allErrs.ToAggregate().Error(), nil
+	}
+	return true, "allowed to be admitted", nil
+}
+
+// validatePlatformTypeUpdate checks that the platform type of an updated Talos cluster provider spec is unchanged
+func validatePlatformTypeUpdate(spec *talosv1.TalosClusterProviderSpec, old *clusterv1.Cluster, fldPath *field.Path) field.ErrorList {
+	if old == nil || utils.ProviderSpecKind(old.Spec.ProviderSpec) != clusterProviderSpecKind {
+		return nil
+	}
+	oldSpec, err := utils.ClusterProviderFromSpec(old.Spec.ProviderSpec)
+	if err != nil {
+		return nil
+	}
+	return validation.ValidatePlatformTypeUpdate(spec.Platform.Type, oldSpec.Platform.Type, fldPath)
+}
+
+var _ admission.Handler = &ClusterCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *ClusterCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	obj := &clusterv1.Cluster{}
+
+	err := h.Decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	// Objects created without a namespace get the one of the request
+	if obj.ObjectMeta.Namespace == "" {
+		obj.ObjectMeta.Namespace = req.AdmissionRequest.Namespace
+	}
+
+	var old *clusterv1.Cluster
+	if req.AdmissionRequest.Operation == admissionv1beta1.Update {
+		old = &clusterv1.Cluster{}
+		oldReq := types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{Object: req.AdmissionRequest.OldObject}}
+		if err = h.Decoder.Decode(oldReq, old); err != nil {
+			return admission.ErrorResponse(http.StatusBadRequest, err)
+		}
+	}
+
+	allowed, reason, err := h.validatingClusterFn(ctx, obj, old)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	return admission.ValidationResponse(allowed, reason)
+}
+
+var _ inject.Decoder = &ClusterCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the ClusterCreateUpdateHandler
+func (h *ClusterCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
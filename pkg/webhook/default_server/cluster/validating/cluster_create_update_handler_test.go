@@ -0,0 +1,71 @@
+package validating
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	for _, name := range []string{"aws", "gce"} {
+		provisioners.Register(name, func() (provisioners.Provisioner, error) { return nil, nil })
+	}
+}
+
+func cluster(metadata, spec string) runtime.RawExtension {
+	return runtime.RawExtension{Raw: []byte(`{"apiVersion":"cluster.k8s.io/v1alpha1","kind":"Cluster","metadata":` + metadata + `,` +
+		`"spec":{"providerSpec":{"value":{"apiVersion":"talosproviderconfig/v1alpha1","kind":"TalosClusterProviderSpec",` + spec + `}}}}`)}
+}
+
+func TestClusterCreateUpdateHandler(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+	decoder, err := admission.NewDecoder(scheme)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	h := &ClusterCreateUpdateHandler{}
+	g.Expect(h.InjectDecoder(decoder)).To(gomega.Succeed())
+
+	metadata := `{"name":"talos-test-cluster"}`
+	deleting := `{"name":"talos-test-cluster","deletionTimestamp":"2019-10-01T00:00:00Z"}`
+	invalid := cluster(metadata, `"platform":{"type":"aws"}`)
+
+	resp := h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Object:    invalid,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeFalse())
+
+	//Specs admitted by earlier versions don't block updates leaving them as they are, nor the removal of finalizers
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Update,
+		Object:    invalid,
+		OldObject: invalid,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeTrue())
+
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Update,
+		Object:    cluster(deleting, `"platform":{"type":"aws","aws":{}}`),
+		OldObject: invalid,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeTrue())
+
+	//The platform type stays immutable
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Update,
+		Object:    cluster(deleting, `"platform":{"type":"gce"}`),
+		OldObject: invalid,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeFalse())
+	g.Expect(string(resp.Response.Result.Reason)).To(gomega.ContainSubstring("field is immutable"))
+}
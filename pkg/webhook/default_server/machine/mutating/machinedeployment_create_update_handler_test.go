@@ -0,0 +1,53 @@
+package mutating
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/defaults"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func TestMachineDeploymentCreateUpdateHandler(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+	decoder, err := admission.NewDecoder(scheme)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "talos-test-cluster", Namespace: "default"},
+		Spec: clusterv1.ClusterSpec{ProviderSpec: clusterv1.ProviderSpec{Value: &runtime.RawExtension{
+			Raw: []byte(`{"apiVersion":"talosproviderconfig/v1alpha1","kind":"TalosClusterProviderSpec","platform":{"type":"aws","aws":{"region":"us-west-2"}}}`),
+		}}},
+	}
+
+	h := &MachineDeploymentCreateUpdateHandler{}
+	g.Expect(h.InjectDecoder(decoder)).To(gomega.Succeed())
+	g.Expect(h.InjectClient(fake.NewFakeClientWithScheme(scheme, cluster))).To(gomega.Succeed())
+
+	resp := h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Namespace: "default",
+		Object: runtime.RawExtension{Raw: []byte(`{"apiVersion":"cluster.k8s.io/v1alpha1","kind":"MachineDeployment","metadata":{"name":"talos-test-cluster-workers"},` +
+			`"spec":{"template":{"metadata":{"labels":{"cluster.k8s.io/cluster-name":"talos-test-cluster"}},"spec":{"providerSpec":{"value":` +
+			`{"apiVersion":"talosproviderconfig/v1alpha1","kind":"TalosMachineProviderSpec","platform":{"type":"aws","aws":{"instances":{"ami":"ami-123"}}}}}}}}}`)},
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeTrue())
+
+	values := map[string]interface{}{}
+	for _, patch := range resp.Patches {
+		values[patch.Path] = patch.Value
+	}
+	g.Expect(values).To(gomega.HaveKeyWithValue("/spec/template/spec/providerSpec/value/platform/aws/region", "us-west-2"))
+	g.Expect(values).To(gomega.HaveKeyWithValue("/spec/template/spec/providerSpec/value/platform/aws/instances/type", defaults.AWSInstanceType))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutating
+
+import (
+	"context"
+	"net/http"
+
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	webhookName := "mutating-create-update-machineset"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &MachineSetCreateUpdateHandler{})
+}
+
+// MachineSetCreateUpdateHandler fills in defaults for the Talos provider spec of MachineSets
+type MachineSetCreateUpdateHandler struct {
+	Client client.Client
+
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+func (h *MachineSetCreateUpdateHandler) mutatingMachineSetFn(ctx context.Context, obj *clusterv1.MachineSet, namespace string) error {
+	namespace, clusterName := namespaceAndClusterName(obj.ObjectMeta, obj.Spec.Template.Labels, namespace)
+	return defaultProviderSpec(ctx, h.Client, namespace, clusterName, &obj.Spec.Template.Spec.ProviderSpec)
+}
+
+var _ admission.Handler = &MachineSetCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *MachineSetCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	obj := &clusterv1.MachineSet{}
+
+	err := h.Decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	copy := obj.DeepCopy()
+
+	err = h.mutatingMachineSetFn(ctx, copy, req.AdmissionRequest.Namespace)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponse(obj, copy)
+}
+
+var _ inject.Client = &MachineSetCreateUpdateHandler{}
+
+// InjectClient injects the client into the MachineSetCreateUpdateHandler
+func (h *MachineSetCreateUpdateHandler) InjectClient(c client.Client) error {
+	h.Client = c
+	return nil
+}
+
+var _ inject.Decoder = &MachineSetCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the MachineSetCreateUpdateHandler
+func (h *MachineSetCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
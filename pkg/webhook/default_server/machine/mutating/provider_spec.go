@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutating
+
+import (
+	"context"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/defaults"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// machineProviderSpecKind is the kind of the provider specs handled by these webhooks
+const machineProviderSpecKind = "TalosMachineProviderSpec"
+
+// defaultProviderSpec fills in defaults for a Talos machine provider spec in place.
+// Settings missing from the machine are inherited from the named Cluster.
+func defaultProviderSpec(ctx context.Context, c client.Client, namespace, clusterName string, providerSpec *clusterv1.ProviderSpec) error {
+	if utils.ProviderSpecKind(*providerSpec) != machineProviderSpecKind {
+		return nil
+	}
+
+	spec, err := utils.MachineProviderFromSpec(*providerSpec)
+	if err != nil {
+		// Leave it to the validating webhook to reject the spec
+		return nil
+	}
+
+	clusterSpec, err := clusterSpec(ctx, c, namespace, clusterName)
+	if err != nil {
+		return err
+	}
+
+	defaulted := spec.DeepCopy()
+	defaults.SetMachineSpecDefaults(defaulted, clusterSpec)
+	if equality.Semantic.DeepEqual(spec, defaulted) {
+		return nil
+	}
+
+	providerSpec.Value, err = utils.EncodeProviderObject(defaulted)
+	return err
+}
+
+// clusterSpec returns the Talos spec of the named Cluster, or nil if there is none
+func clusterSpec(ctx context.Context, c client.Client, namespace, name string) (*talosv1.TalosClusterProviderSpec, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	cluster := &clusterv1.Cluster{}
+	if err := c.Get(ctx, k8stypes.NamespacedName{Namespace: namespace, Name: name}, cluster); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	spec, err := utils.ClusterProviderFromSpec(cluster.Spec.ProviderSpec)
+	if err != nil {
+		return nil, nil
+	}
+	return spec, nil
+}
+
+// namespaceAndClusterName returns the namespace of an object and the name of the Cluster it belongs to.
+// The namespace falls back to the one of the admission request, as it may be left out of created objects.
+func namespaceAndClusterName(meta metav1.ObjectMeta, labels map[string]string, namespace string) (string, string) {
+	if meta.Namespace != "" {
+		namespace = meta.Namespace
+	}
+	clusterName := meta.Labels[clusterv1.MachineClusterLabelName]
+	if clusterName == "" {
+		clusterName = labels[clusterv1.MachineClusterLabelName]
+	}
+	return namespace, clusterName
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/builder"
+)
+
+func init() {
+	for builderName, obj := range map[string]runtime.Object{
+		"validating-create-update-machine":           &clusterv1.Machine{},
+		"validating-create-update-machineset":        &clusterv1.MachineSet{},
+		"validating-create-update-machinedeployment": &clusterv1.MachineDeployment{},
+	} {
+		Builders[builderName] = builder.
+			NewWebhookBuilder().
+			Name(builderName+".talos.cluster.k8s.io").
+			Path("/"+builderName).
+			Validating().
+			Operations(admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update).
+			FailurePolicy(admissionregistrationv1beta1.Fail).
+			ForType(obj)
+	}
+}
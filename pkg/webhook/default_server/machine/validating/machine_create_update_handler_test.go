@@ -0,0 +1,113 @@
+package validating
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/provisioners"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterapis "sigs.k8s.io/cluster-api/pkg/apis"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	for _, name := range []string{"aws", "gce"} {
+		provisioners.Register(name, func() (provisioners.Provisioner, error) { return nil, nil })
+	}
+}
+
+func machine(platform string) runtime.RawExtension {
+	return machineWithMetadata(`{"name":"talos-test-cluster-master-0"}`, platform)
+}
+
+func machineWithMetadata(metadata, platform string) runtime.RawExtension {
+	return runtime.RawExtension{Raw: []byte(`{"apiVersion":"cluster.k8s.io/v1alpha1","kind":"Machine","metadata":` + metadata + `,` +
+		`"spec":{"providerSpec":{"value":{"apiVersion":"talosproviderconfig/v1alpha1","kind":"TalosMachineProviderSpec","platform":` + platform + `}}}}`)}
+}
+
+func TestMachineCreateUpdateHandler(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(clusterapis.AddToScheme(scheme)).To(gomega.Succeed())
+	decoder, err := admission.NewDecoder(scheme)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "talos-test-cluster", Namespace: "default"}}
+
+	h := &MachineCreateUpdateHandler{}
+	g.Expect(h.InjectDecoder(decoder)).To(gomega.Succeed())
+	g.Expect(h.InjectClient(fake.NewFakeClientWithScheme(scheme, cluster))).To(gomega.Succeed())
+
+	aws := machine(`{"type":"aws","aws":{"region":"us-west-2","instances":{"ami":"ami-123"}}}`)
+	gce := machine(`{"type":"gce","gce":{"zone":"us-central1-c","project":"talos","instances":{"image":"talos"}}}`)
+
+	resp := h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Object:    aws,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeTrue())
+
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Object:    machine(`{"type":"aws","aws":{"region":"us-west-2"}}`),
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeFalse())
+	g.Expect(string(resp.Response.Result.Reason)).To(gomega.ContainSubstring("spec.providerSpec.value.platform.aws.instances.ami"))
+
+	//The location is required once the cluster exists, as the mutating webhook had it to inherit from
+	noRegion := `{"type":"aws","aws":{"instances":{"ami":"ami-123"}}}`
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Namespace: "default",
+		Object:    machineWithMetadata(`{"name":"talos-test-cluster-workers-0","labels":{"cluster.k8s.io/cluster-name":"talos-test-cluster"}}`, noRegion),
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeFalse())
+	g.Expect(string(resp.Response.Result.Reason)).To(gomega.ContainSubstring("spec.providerSpec.value.platform.aws.region"))
+
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Namespace: "default",
+		Object:    machineWithMetadata(`{"name":"other-cluster-workers-0","labels":{"cluster.k8s.io/cluster-name":"other-cluster"}}`, noRegion),
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeTrue())
+
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Update,
+		Object:    gce,
+		OldObject: aws,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeFalse())
+	g.Expect(string(resp.Response.Result.Reason)).To(gomega.ContainSubstring("field is immutable"))
+
+	//Specs admitted by earlier versions don't block updates leaving them as they are, nor the removal of finalizers
+	invalid := machine(`{"type":"aws","aws":{"region":"us-west-2"}}`)
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Update,
+		Object:    invalid,
+		OldObject: invalid,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeTrue())
+
+	deleting := `{"name":"talos-test-cluster-master-0","deletionTimestamp":"2019-10-01T00:00:00Z"}`
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Update,
+		Object:    machineWithMetadata(deleting, `{"type":"aws","aws":{}}`),
+		OldObject: invalid,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeTrue())
+
+	resp = h.Handle(context.TODO(), types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Update,
+		Object:    machineWithMetadata(deleting, `{"type":"gce","gce":{}}`),
+		OldObject: invalid,
+	}})
+	g.Expect(resp.Response.Allowed).To(gomega.BeFalse())
+	g.Expect(string(resp.Response.Result.Reason)).To(gomega.ContainSubstring("field is immutable"))
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	webhookName := "validating-create-update-machinedeployment"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &MachineDeploymentCreateUpdateHandler{})
+}
+
+// MachineDeploymentCreateUpdateHandler validates the Talos provider spec of MachineDeployments
+type MachineDeploymentCreateUpdateHandler struct {
+	Client client.Client
+
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+func (h *MachineDeploymentCreateUpdateHandler) validatingMachineDeploymentFn(ctx context.Context, obj, old *clusterv1.MachineDeployment, namespace string) (bool, string, error) {
+	var oldProviderSpec *clusterv1.ProviderSpec
+	if old != nil {
+		oldProviderSpec = &old.Spec.Template.Spec.ProviderSpec
+	}
+
+	namespace, clusterName := namespaceAndClusterName(obj.ObjectMeta, obj.Spec.Template.Labels, namespace)
+	exists, err := clusterExists(ctx, h.Client, namespace, clusterName)
+	if err != nil {
+		return false, "", err
+	}
+
+	allowed, reason := validateProviderSpec(obj.Spec.Template.Spec.ProviderSpec, oldProviderSpec, obj.ObjectMeta.DeletionTimestamp != nil, exists, field.NewPath("spec", "template", "spec", "providerSpec", "value"))
+	return allowed, reason, nil
+}
+
+var _ admission.Handler = &MachineDeploymentCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *MachineDeploymentCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	obj := &clusterv1.MachineDeployment{}
+
+	err := h.Decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+
+	old := &clusterv1.MachineDeployment{}
+	found, err := decodeOld(h.Decoder, req, old)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	if !found {
+		old = nil
+	}
+
+	allowed, reason, err := h.validatingMachineDeploymentFn(ctx, obj, old, req.AdmissionRequest.Namespace)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	return admission.ValidationResponse(allowed, reason)
+}
+
+var _ inject.Client = &MachineDeploymentCreateUpdateHandler{}
+
+// InjectClient injects the client into the MachineDeploymentCreateUpdateHandler
+func (h *MachineDeploymentCreateUpdateHandler) InjectClient(c client.Client) error {
+	h.Client = c
+	return nil
+}
+
+var _ inject.Decoder = &MachineDeploymentCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the MachineDeploymentCreateUpdateHandler
+func (h *MachineDeploymentCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
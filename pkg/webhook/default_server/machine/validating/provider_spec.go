@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+
+	talosv1 "github.com/talos-systems/cluster-api-provider-talos/pkg/apis/talos/v1alpha1"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/utils"
+	"github.com/talos-systems/cluster-api-provider-talos/pkg/cloud/talos/validation"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// machineProviderSpecKind is the kind of the provider specs handled by these webhooks
+const machineProviderSpecKind = "TalosMachineProviderSpec"
+
+// validateProviderSpec validates a Talos machine provider spec, and that its platform type is unchanged on updates.
+// Updates of objects being deleted, or leaving the provider spec as it is, are only checked for the platform type,
+// so that specs admitted by earlier versions don't keep finalizers from being removed or status from being updated.
+// The location settings are only checked when the Cluster exists, machines applied before it inherit them at reconcile time.
+func validateProviderSpec(providerSpec clusterv1.ProviderSpec, oldProviderSpec *clusterv1.ProviderSpec, deleting, clusterExists bool, fldPath *field.Path) (bool, string) {
+	if utils.ProviderSpecKind(providerSpec) != machineProviderSpecKind {
+		return true, "not a talos machine"
+	}
+
+	if oldProviderSpec != nil && (deleting || equality.Semantic.DeepEqual(providerSpec, *oldProviderSpec)) {
+		spec, err := utils.MachineProviderFromSpec(providerSpec)
+		if err != nil {
+			return true, "allowed to be admitted"
+		}
+		if allErrs := validatePlatformTypeUpdate(spec, oldProviderSpec, fldPath); len(allErrs) != 0 {
+			return false, allErrs.ToAggregate().Error()
+		}
+		return true, "allowed to be admitted"
+	}
+
+	spec, err := utils.MachineProviderFromSpec(providerSpec)
+	if err != nil {
+		return false, field.Invalid(fldPath, string(providerSpec.Value.Raw), err.Error()).Error()
+	}
+
+	allErrs := validation.ValidateMachineSpec(spec, fldPath)
+	if clusterExists {
+		allErrs = append(allErrs, validation.ValidateMachineLocation(spec, fldPath)...)
+	}
+	allErrs = append(allErrs, validatePlatformTypeUpdate(spec, oldProviderSpec, fldPath)...)
+
+	if len(allErrs) != 0 {
+		return false, allErrs.ToAggregate().Error()
+	}
+	return true, "allowed to be admitted"
+}
+
+// validatePlatformTypeUpdate checks that the platform type of an updated Talos machine provider spec is unchanged
+func validatePlatformTypeUpdate(spec *talosv1.TalosMachineProviderSpec, oldProviderSpec *clusterv1.ProviderSpec, fldPath *field.Path) field.ErrorList {
+	if oldProviderSpec == nil || utils.ProviderSpecKind(*oldProviderSpec) != machineProviderSpecKind {
+		return nil
+	}
+	oldSpec, err := utils.MachineProviderFromSpec(*oldProviderSpec)
+	if err != nil {
+		return nil
+	}
+	return validation.ValidatePlatformTypeUpdate(spec.Platform.Type, oldSpec.Platform.Type, fldPath)
+}
+
+// clusterExists returns whether the named Cluster exists, the mutating webhook has filled in the settings it provides then
+func clusterExists(ctx context.Context, c client.Client, namespace, name string) (bool, error) {
+	if name == "" {
+		return false, nil
+	}
+
+	cluster := &clusterv1.Cluster{}
+	if err := c.Get(ctx, k8stypes.NamespacedName{Namespace: namespace, Name: name}, cluster); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// namespaceAndClusterName returns the namespace of an object and the name of the Cluster it belongs to.
+// The namespace falls back to the one of the admission request, as it may be left out of created objects.
+func namespaceAndClusterName(meta metav1.ObjectMeta, labels map[string]string, namespace string) (string, string) {
+	if meta.Namespace != "" {
+		namespace = meta.Namespace
+	}
+	clusterName := meta.Labels[clusterv1.MachineClusterLabelName]
+	if clusterName == "" {
+		clusterName = labels[clusterv1.MachineClusterLabelName]
+	}
+	return namespace, clusterName
+}
+
+// decodeOld decodes the old object of an update request into obj, and reports whether there was one
+func decodeOld(decoder types.Decoder, req types.Request, obj runtime.Object) (bool, error) {
+	if req.AdmissionRequest.Operation != admissionv1beta1.Update {
+		return false, nil
+	}
+
+	oldReq := types.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{Object: req.AdmissionRequest.OldObject}}
+	if err := decoder.Decode(oldReq, obj); err != nil {
+		return false, err
+	}
+	return true, nil
+}